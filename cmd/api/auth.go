@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yay14/pulse/internal/auth"
+)
+
+// authPolicy is the role every RPC requires, admins may call all of them
+var authPolicy = auth.Policy{
+	"/IngestionService/IngestData":                                    auth.RoleWriter,
+	"/IngestionService/IngestExposition":                              auth.RoleWriter,
+	"/IngestionService/ValidateMetrics":                               auth.RoleWriter,
+	"/MetricsService/WriteMetrics":                                    auth.RoleWriter,
+	"/MetricsService/ImportSeries":                                    auth.RoleWriter,
+	"/opentelemetry.proto.collector.metrics.v1.MetricsService/Export": auth.RoleWriter,
+
+	"/IngestionService/GetMetricMetadata":     auth.RoleReader,
+	"/IngestionService/ListMetricMetadata":    auth.RoleReader,
+	"/IngestionService/ListRetentionPolicies": auth.RoleReader,
+	"/IngestionService/GetCardinalityReport":  auth.RoleReader,
+	"/MetricsService/InstantQueryMetrics":     auth.RoleReader,
+	"/MetricsService/RangeQueryMetrics":       auth.RoleReader,
+	"/MetricsService/StreamRangeQuery":        auth.RoleReader,
+	"/MetricsService/ExportSeries":            auth.RoleReader,
+	"/MetricsService/QueryExemplars":          auth.RoleReader,
+	"/MetricsService/ReadRaw":                 auth.RoleReader,
+	"/MetricsService/GetQueryCacheStats":      auth.RoleReader,
+
+	"/IngestionService/AddMetricValidation":    auth.RoleAdmin,
+	"/IngestionService/RegisterMetricMetadata": auth.RoleAdmin,
+	"/IngestionService/SetRetentionPolicy":     auth.RoleAdmin,
+	"/IngestionService/DeleteRetentionPolicy":  auth.RoleAdmin,
+}
+
+// authFromEnv creates the authenticator of the API keys in AUTH_API_KEYS_FILE
+// and of the JWTs signed with the secret in AUTH_JWT_SECRET_FILE or the keys
+// in AUTH_JWT_JWKS_FILE, checked against AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE.
+// Both files are reloaded every AUTH_RELOAD_INTERVAL until ctx is done. It
+// returns nil when neither is configured.
+func authFromEnv(ctx context.Context) (auth.Authenticator, error) {
+	var reloadInterval time.Duration
+	if interval := os.Getenv("AUTH_RELOAD_INTERVAL"); interval != "" {
+		var err error
+		if reloadInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("invalid AUTH_RELOAD_INTERVAL: %w", err)
+		}
+	}
+
+	var chain auth.Chain
+	if path := os.Getenv("AUTH_API_KEYS_FILE"); path != "" {
+		keys, err := auth.LoadAPIKeysFile(path)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys, err := auth.NewAPIKeys(keys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API keys: %w", err)
+		}
+		go apiKeys.Watch(ctx, path, reloadInterval)
+		chain = append(chain, apiKeys)
+	}
+
+	jwtConfig := auth.JWTConfig{
+		JWKSFile:    os.Getenv("AUTH_JWT_JWKS_FILE"),
+		Issuer:      os.Getenv("AUTH_JWT_ISSUER"),
+		Audience:    os.Getenv("AUTH_JWT_AUDIENCE"),
+		RolesClaim:  os.Getenv("AUTH_JWT_ROLES_CLAIM"),
+		TenantClaim: os.Getenv("AUTH_JWT_TENANT_CLAIM"),
+	}
+	if path := os.Getenv("AUTH_JWT_SECRET_FILE"); path != "" {
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret: %w", err)
+		}
+		jwtConfig.HMACSecret = []byte(strings.TrimSpace(string(secret)))
+	}
+	if len(jwtConfig.HMACSecret) > 0 || jwtConfig.JWKSFile != "" {
+		jwt, err := auth.NewJWT(jwtConfig)
+		if err != nil {
+			return nil, err
+		}
+		go jwt.Watch(ctx, reloadInterval)
+		chain = append(chain, jwt)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// tlsFromEnv loads the certificate of the servers from TLS_CERT_FILE and
+// TLS_KEY_FILE, and requires client certificates signed by TLS_CLIENT_CA_FILE
+// if set. It returns nil when no certificate is configured.
+func tlsFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in client CA %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yay14/pulse/internal/cardinality"
+)
+
+// cardinalityFromEnv reads the series limits from CARDINALITY_MAX_SERIES_PER_SOURCE,
+// CARDINALITY_MAX_SERIES_PER_METRIC and CARDINALITY_SOURCE_LIMITS, a list of
+// source_id=limit overriding the limit of individual sources, and the window
+// series stay active from CARDINALITY_WINDOW
+func cardinalityFromEnv() (cardinality.Limits, error) {
+	var limits cardinality.Limits
+	for name, limit := range map[string]*int{
+		"CARDINALITY_MAX_SERIES_PER_SOURCE": &limits.MaxSeriesPerSource,
+		"CARDINALITY_MAX_SERIES_PER_METRIC": &limits.MaxSeriesPerMetric,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return limits, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*limit = n
+		}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("CARDINALITY_SOURCE_LIMITS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		source, value, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || source == "" || err != nil {
+			return limits, fmt.Errorf("invalid CARDINALITY_SOURCE_LIMITS entry %q, expected source_id=limit", entry)
+		}
+		if limits.SourceOverrides == nil {
+			limits.SourceOverrides = make(map[string]int)
+		}
+		limits.SourceOverrides[source] = n
+	}
+
+	if window := os.Getenv("CARDINALITY_WINDOW"); window != "" {
+		var err error
+		if limits.Window, err = time.ParseDuration(window); err != nil {
+			return limits, fmt.Errorf("invalid CARDINALITY_WINDOW: %w", err)
+		}
+	}
+	return limits, nil
+}
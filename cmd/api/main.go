@@ -1,21 +1,47 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/auth"
+	"github.com/yay14/pulse/internal/cardinality"
 	"github.com/yay14/pulse/internal/cassandra"
+	"github.com/yay14/pulse/internal/graphite"
 	"github.com/yay14/pulse/internal/kafka"
+	"github.com/yay14/pulse/internal/otlp"
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/internal/retention"
+	"github.com/yay14/pulse/internal/rollup"
+	"github.com/yay14/pulse/internal/scrape"
 	ingestionSvc "github.com/yay14/pulse/internal/service/ingestion"
 	metricsSvc "github.com/yay14/pulse/internal/service/metrics"
+	"github.com/yay14/pulse/internal/statsd"
+	"github.com/yay14/pulse/internal/tenant"
 	"github.com/yay14/pulse/metrics"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
+	skipDDL := flag.Bool("skip-ddl", os.Getenv("CASSANDRA_SKIP_DDL") == "true", "do not create or migrate the Cassandra schema at startup")
+	flag.Parse()
+
+	replication, err := replicationFromEnv()
+	if err != nil {
+		log.Fatalf("invalid Cassandra replication: %v", err)
+	}
+
 	// Connect to Cassandra
 	cluster := gocql.NewCluster("cassandra")
 	cluster.Consistency = gocql.Quorum
@@ -27,26 +53,281 @@ func main() {
 	}
 	defer repo.Close()
 
+	// Apply or inspect the schema migrations and exit
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(context.Background(), repo, replication, flag.Args()[1:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	if *skipDDL {
+		// The schema is migrated separately by a user with schema rights
+		pending, err := repo.PendingMigrations(context.Background())
+		if err != nil {
+			log.Fatalf("failed to check schema migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("%d schema migrations are pending, run the migrate up command first", len(pending))
+		}
+	} else if err := repo.Migrate(context.Background(), replication); err != nil {
+		log.Fatalf("failed to migrate Cassandra schema: %v", err)
+	}
+
 	// Initialize gRPC server
 	lis, err := net.Listen("tcp", ":9400")
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	ingestionService := ingestionSvc.NewIngestionService(repo)
-	metricsService := metricsSvc.NewMetricsService()
+	metadataPolicy, err := ingestionSvc.ParseMetadataPolicy(os.Getenv("METADATA_POLICY"))
+	if err != nil {
+		log.Fatalf("invalid METADATA_POLICY: %v", err)
+	}
+
+	// Ingested data is written to VictoriaMetrics through a metrics service of its own
+	sinkOptions, writesVM, err := sinksFromEnv(metricsSvc.NewMetricsService())
+	if err != nil {
+		log.Fatalf("invalid sinks: %v", err)
+	}
+
+	// Accepted writes are buffered on disk while their sinks are unavailable
+	ingestWAL, writeWAL, err := walsFromEnv()
+	if err != nil {
+		log.Fatalf("failed to open WAL: %v", err)
+	}
+	// Relabel rules apply to every incoming metric and are reloaded when their file changes
+	relabelRules := relabel.NewRuleSet(nil)
+	if path := os.Getenv("RELABEL_CONFIG_FILE"); path != "" {
+		rules, err := relabel.LoadFile(path)
+		if err != nil {
+			log.Fatalf("failed to load relabel config: %v", err)
+		}
+		relabelRules.Store(rules)
+
+		var reloadInterval time.Duration
+		if interval := os.Getenv("RELABEL_RELOAD_INTERVAL"); interval != "" {
+			if reloadInterval, err = time.ParseDuration(interval); err != nil {
+				log.Fatalf("invalid RELABEL_RELOAD_INTERVAL: %v", err)
+			}
+		}
+		go relabelRules.Watch(context.Background(), path, reloadInterval)
+	}
+
+	// Active series are tracked for the cardinality report and limited per source
+	cardinalityLimits, err := cardinalityFromEnv()
+	if err != nil {
+		log.Fatalf("invalid cardinality limits: %v", err)
+	}
+	cardinalityTracker := cardinality.NewTracker(cardinalityLimits)
+	go cardinalityTracker.Run(context.Background())
+
+	// Samples and bytes per second are limited per source, the limits are
+	// reloaded when their file changes
+	var rateLimits ratelimit.Config
+	rateLimitPath := os.Getenv("RATE_LIMIT_CONFIG_FILE")
+	if rateLimitPath != "" {
+		if rateLimits, err = ratelimit.LoadFile(rateLimitPath); err != nil {
+			log.Fatalf("failed to load rate limits: %v", err)
+		}
+	}
+	ingestLimiter, writeLimiter := ratelimit.NewLimiter(rateLimits), ratelimit.NewLimiter(rateLimits)
+	if rateLimitPath != "" {
+		var reloadInterval time.Duration
+		if interval := os.Getenv("RATE_LIMIT_RELOAD_INTERVAL"); interval != "" {
+			if reloadInterval, err = time.ParseDuration(interval); err != nil {
+				log.Fatalf("invalid RATE_LIMIT_RELOAD_INTERVAL: %v", err)
+			}
+		}
+		go ingestLimiter.Watch(context.Background(), rateLimitPath, reloadInterval)
+		go writeLimiter.Watch(context.Background(), rateLimitPath, reloadInterval)
+	}
+
+	ingestionOptions := append(sinkOptions,
+		ingestionSvc.WithMetadataPolicy(metadataPolicy),
+		ingestionSvc.WithRelabeling(relabelRules),
+		ingestionSvc.WithCardinalityTracker(cardinalityTracker),
+		ingestionSvc.WithRateLimiter(ingestLimiter),
+	)
+	if ingestWAL != nil {
+		ingestionOptions = append(ingestionOptions, ingestionSvc.WithWAL(ingestWAL))
+	}
+
+	// Every request belongs to the tenant of its x-scope-orgid metadata or
+	// header, requests without one to the default tenant unless required
+	requireTenant := os.Getenv("REQUIRE_TENANT") == "true"
+	unaryInterceptors := []grpc.UnaryServerInterceptor{tenant.UnaryServerInterceptor(requireTenant)}
+	streamInterceptors := []grpc.StreamServerInterceptor{tenant.StreamServerInterceptor(requireTenant)}
+
+	// Requests are authenticated with API keys or JWTs and need the role of
+	// their RPC. Principals bound to a tenant act for it, so their requests
+	// are authenticated before the tenant is read.
+	authn, err := authFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("invalid auth config: %v", err)
+	}
+	if authn != nil {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{auth.UnaryServerInterceptor(authn, authPolicy)}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{auth.StreamServerInterceptor(authn, authPolicy)}, streamInterceptors...)
+	} else {
+		log.Println("Authentication is disabled, set AUTH_API_KEYS_FILE, AUTH_JWT_SECRET_FILE or AUTH_JWT_JWKS_FILE to enable it")
+	}
+
+	tlsConfig, err := tlsFromEnv()
+	if err != nil {
+		log.Fatalf("invalid TLS config: %v", err)
+	}
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	ingestionService, err := ingestionSvc.NewIngestionService(repo, ingestionOptions...)
+	if err != nil {
+		log.Fatalf("failed to create ingestion service: %v", err)
+	}
+	metricsOptions := []metricsSvc.Option{
+		metricsSvc.WithMetadataSource(ingestionService),
+		metricsSvc.WithExemplarStore(repo),
+		metricsSvc.WithRawStore(repo),
+		metricsSvc.WithQueryEngine(promql.NewEngine(repo)),
+		metricsSvc.WithRelabeling(relabelRules),
+		metricsSvc.WithRateLimiter(writeLimiter),
+	}
+	if writeWAL != nil {
+		metricsOptions = append(metricsOptions, metricsSvc.WithWAL(writeWAL))
+	}
+	metricsService := metricsSvc.NewMetricsService(metricsOptions...)
 	ingestion.RegisterIngestionServiceServer(grpcServer, ingestionService)
 	metrics.RegisterMetricsServiceServer(grpcServer, metricsService)
 
+	// Receivers writing to both services only write to the ingestion service
+	// when it forwards to VictoriaMetrics itself
+	var otlpWriter otlp.MetricsWriter = metricsService
+	var scrapeWriter scrape.MetricsWriter = metricsService
+	if writesVM {
+		otlpWriter, scrapeWriter = nil, nil
+	}
+	otlpReceiver := otlp.NewReceiver(ingestionService, otlpWriter)
+	colmetricspb.RegisterMetricsServiceServer(grpcServer, otlpReceiver)
+
+	// Start rolling raw samples up into the 1m, 5m and 1h tables
+	var rollupConfig rollup.Config
+	if interval := os.Getenv("ROLLUP_INTERVAL"); interval != "" {
+		if rollupConfig.Interval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("invalid ROLLUP_INTERVAL: %v", err)
+		}
+	}
+	if delay := os.Getenv("ROLLUP_DELAY"); delay != "" {
+		if rollupConfig.Delay, err = time.ParseDuration(delay); err != nil {
+			log.Fatalf("invalid ROLLUP_DELAY: %v", err)
+		}
+	}
+	go rollup.NewWorker(rollupConfig, repo).Run(context.Background())
+
+	// Start sweeping data written before its retention policy was shortened
+	var sweepInterval time.Duration
+	if interval := os.Getenv("RETENTION_SWEEP_INTERVAL"); interval != "" {
+		if sweepInterval, err = time.ParseDuration(interval); err != nil {
+			log.Fatalf("invalid RETENTION_SWEEP_INTERVAL: %v", err)
+		}
+	}
+	go retention.NewSweeper(retention.NewResolver(repo), repo, sweepInterval).Run(context.Background())
+
+	// Start retrying the failed writes of best-effort sinks, or replaying the WAL to them
+	go ingestionService.RunSinkRetries(context.Background())
+	go func() {
+		if err := metricsService.RunWALReplay(context.Background(), 0); err != nil {
+			log.Printf("Stopped replaying the WAL to VictoriaMetrics: %v", err)
+		}
+	}()
+
 	// Start Kafka consumer
 	kafkaConfig := kafka.KafkaConfig{
 		Brokers: []string{"kafka:9092"},
 		GroupID: "pulse",
 		Topic:   "metrics-topic",
+
+		RequireTenant: requireTenant,
 	}
 	go ingestionService.StartKafkaConsumer(kafkaConfig)
 
+	// Start scraping the configured Prometheus endpoints
+	if path := os.Getenv("SCRAPE_CONFIG_FILE"); path != "" {
+		scrapeConfig, err := scrape.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("failed to load scrape config: %v", err)
+		}
+		scraper, err := scrape.NewScraper(scrapeConfig, ingestionService, scrapeWriter)
+		if err != nil {
+			log.Fatalf("failed to create scraper: %v", err)
+		}
+		go scraper.Run(context.Background())
+	}
+
+	// Start StatsD listener, the aggregates are ingested for STATSD_SOURCE_ID
+	if addr := os.Getenv("STATSD_UDP_ADDR"); addr != "" {
+		statsdConfig := statsd.Config{
+			UDPAddr:    addr,
+			TCPAddr:    os.Getenv("STATSD_TCP_ADDR"),
+			SourceID:   os.Getenv("STATSD_SOURCE_ID"),
+			SourceType: "statsd",
+		}
+		if interval := os.Getenv("STATSD_FLUSH_INTERVAL"); interval != "" {
+			if statsdConfig.FlushInterval, err = time.ParseDuration(interval); err != nil {
+				log.Fatalf("invalid STATSD_FLUSH_INTERVAL: %v", err)
+			}
+		}
+		if err := statsd.NewServer(statsdConfig, ingestionService).Start(context.Background()); err != nil {
+			log.Fatalf("failed to start StatsD listener: %v", err)
+		}
+	}
+
+	// Start Graphite listener, the datapoints are ingested for GRAPHITE_SOURCE_ID
+	if addr := os.Getenv("GRAPHITE_ADDR"); addr != "" {
+		graphiteConfig := graphite.Config{
+			Addr:       addr,
+			PickleAddr: os.Getenv("GRAPHITE_PICKLE_ADDR"),
+			SourceID:   os.Getenv("GRAPHITE_SOURCE_ID"),
+			SourceType: "graphite",
+		}
+		if path := os.Getenv("GRAPHITE_TEMPLATES_FILE"); path != "" {
+			if graphiteConfig.Templates, err = graphite.LoadTemplates(path); err != nil {
+				log.Fatalf("failed to load graphite templates: %v", err)
+			}
+		}
+		if err := graphite.NewServer(graphiteConfig, ingestionService).Start(context.Background()); err != nil {
+			log.Fatalf("failed to start Graphite listener: %v", err)
+		}
+	}
+
+	// Start HTTP server for push ingestion
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/ingest/prometheus", ingestionService.HandleExposition)
+	mux.HandleFunc("/api/v2/write", ingestionService.HandleInfluxWrite)
+	mux.Handle("/v1/metrics", otlpReceiver)
+	// Every HTTP route ingests, so it requires the writer role
+	handler := tenant.Middleware(requireTenant, mux)
+	if authn != nil {
+		handler = auth.Middleware(authn, auth.RoleWriter, handler)
+	}
+	httpServer := &http.Server{Addr: ":9401", Handler: handler, TLSConfig: tlsConfig}
+	go func() {
+		log.Println("Starting HTTP server on :9401...")
+		var err error
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil {
+			log.Fatalf("failed to serve HTTP: %v", err)
+		}
+	}()
+
 	log.Println("Starting gRPC server on :9400...")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yay14/pulse/internal/cassandra"
+)
+
+// replicationFromEnv reads the keyspace replication from CASSANDRA_REPLICATION_STRATEGY,
+// CASSANDRA_REPLICATION_FACTOR and CASSANDRA_DATACENTERS (dc1:3,dc2:3)
+func replicationFromEnv() (cassandra.ReplicationConfig, error) {
+	cfg := cassandra.ReplicationConfig{Strategy: os.Getenv("CASSANDRA_REPLICATION_STRATEGY")}
+
+	if rf := os.Getenv("CASSANDRA_REPLICATION_FACTOR"); rf != "" {
+		n, err := strconv.Atoi(rf)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CASSANDRA_REPLICATION_FACTOR: %w", err)
+		}
+		cfg.ReplicationFactor = n
+	}
+
+	if dcs := os.Getenv("CASSANDRA_DATACENTERS"); dcs != "" {
+		datacenters, err := cassandra.ParseDatacenters(dcs)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CASSANDRA_DATACENTERS: %w", err)
+		}
+		cfg.Datacenters = datacenters
+	}
+
+	// Validate the configuration before it is first used
+	if _, err := cfg.CQL(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// runMigrate implements the migrate subcommand: "migrate up" applies the
+// pending migrations, "migrate status" lists every migration and
+// "migrate backfill-labels" converts the JSON labels of rows written before
+// the label_map columns existed, "migrate backfill-series" registers the
+// series of samples written before the series registry existed,
+// "migrate backfill-sources" copies the UUID source ids of rows written
+// before the source columns existed, "migrate backfill-samples" copies the
+// samples written before the samples table existed and
+// "migrate backfill-rollups" copies the rollups written before the rollup
+// tables were partitioned by bucket
+func runMigrate(ctx context.Context, repo *cassandra.Repository, replication cassandra.ReplicationConfig, args []string) error {
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return repo.Migrate(ctx, replication)
+	case "status":
+		statuses, err := repo.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	case "backfill-labels":
+		converted, err := repo.BackfillLabelMaps(ctx)
+		log.Printf("Backfilled the labels of %d rows", converted)
+		return err
+	case "backfill-series":
+		registered, err := repo.BackfillSeries(ctx)
+		log.Printf("Registered %d series", registered)
+		return err
+	case "backfill-sources":
+		converted, err := repo.BackfillSources(ctx)
+		log.Printf("Backfilled the sources of %d rows", converted)
+		return err
+	case "backfill-samples":
+		copied, err := repo.BackfillSamples(ctx)
+		log.Printf("Copied %d samples", copied)
+		return err
+	case "backfill-rollups":
+		copied, err := repo.BackfillRollups(ctx)
+		log.Printf("Copied %d rollups", copied)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, status, backfill-labels, backfill-series, backfill-sources, backfill-samples or backfill-rollups", command)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yay14/pulse/internal/fanout"
+	ingestionSvc "github.com/yay14/pulse/internal/service/ingestion"
+)
+
+// victoriaMetricsSink is the name of the sink writing to VictoriaMetrics
+const victoriaMetricsSink = "victoriametrics"
+
+// sinksFromEnv reads the ingestion sinks from INGEST_SINKS, a list of
+// name[:policy] such as cassandra:required,victoriametrics:best-effort, and
+// the retry queues from SINK_RETRY_QUEUE_SIZE and SINK_RETRY_ATTEMPTS.
+// Cassandra is always written, required unless configured otherwise. It
+// reports whether ingested data is written to VictoriaMetrics.
+func sinksFromEnv(vm fanout.MetricsWriter) ([]ingestionSvc.Option, bool, error) {
+	var base fanout.Config
+	if size := os.Getenv("SINK_RETRY_QUEUE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid SINK_RETRY_QUEUE_SIZE: %w", err)
+		}
+		base.QueueSize = n
+	}
+	if attempts := os.Getenv("SINK_RETRY_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid SINK_RETRY_ATTEMPTS: %w", err)
+		}
+		base.MaxAttempts = n
+	}
+
+	opts := []ingestionSvc.Option{ingestionSvc.WithCassandraSink(base)}
+	writesVM := false
+	for _, entry := range strings.Split(os.Getenv("INGEST_SINKS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, policy, _ := strings.Cut(entry, ":")
+		cfg := base
+		var err error
+		if cfg.Policy, err = fanout.ParsePolicy(policy); err != nil {
+			return nil, false, fmt.Errorf("invalid INGEST_SINKS entry %q: %w", entry, err)
+		}
+
+		switch name {
+		case ingestionSvc.CassandraSinkName:
+			opts = append(opts, ingestionSvc.WithCassandraSink(cfg))
+		case victoriaMetricsSink:
+			if writesVM {
+				return nil, false, fmt.Errorf("sink %s is configured twice in INGEST_SINKS", name)
+			}
+			opts = append(opts, ingestionSvc.WithSink(fanout.Sink{Name: name, Writer: fanout.ToMetrics(vm), Config: cfg}))
+			writesVM = true
+		default:
+			return nil, false, fmt.Errorf("unknown sink %q in INGEST_SINKS, expected %s or %s", name, ingestionSvc.CassandraSinkName, victoriaMetricsSink)
+		}
+	}
+	return opts, writesVM, nil
+}
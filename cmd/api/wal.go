@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yay14/pulse/internal/wal"
+)
+
+// walsFromEnv opens the write-ahead logs in WAL_DIR, one for ingested batches
+// and one for writes to VictoriaMetrics. WAL_SEGMENT_SIZE and WAL_MAX_BYTES
+// size each log in bytes, WAL_FSYNC is always, interval or none and
+// WAL_FSYNC_INTERVAL a duration. Without WAL_DIR no log is used and both are nil.
+func walsFromEnv() (ingest, write *wal.WAL, err error) {
+	dir := os.Getenv("WAL_DIR")
+	if dir == "" {
+		return nil, nil, nil
+	}
+
+	var opts wal.Options
+	if size := os.Getenv("WAL_SEGMENT_SIZE"); size != "" {
+		if opts.SegmentSize, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid WAL_SEGMENT_SIZE: %w", err)
+		}
+	}
+	if size := os.Getenv("WAL_MAX_BYTES"); size != "" {
+		if opts.MaxBytes, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid WAL_MAX_BYTES: %w", err)
+		}
+	}
+	if opts.Sync, err = wal.ParseSyncPolicy(os.Getenv("WAL_FSYNC")); err != nil {
+		return nil, nil, fmt.Errorf("invalid WAL_FSYNC: %w", err)
+	}
+	if interval := os.Getenv("WAL_FSYNC_INTERVAL"); interval != "" {
+		if opts.SyncInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, nil, fmt.Errorf("invalid WAL_FSYNC_INTERVAL: %w", err)
+		}
+	}
+
+	opts.Dir = filepath.Join(dir, "ingest")
+	if ingest, err = wal.Open(opts); err != nil {
+		return nil, nil, err
+	}
+	opts.Dir = filepath.Join(dir, "write")
+	if write, err = wal.Open(opts); err != nil {
+		ingest.Close()
+		return nil, nil, err
+	}
+	return ingest, write, nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/yay14/pulse/internal/filewatch"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// Role grants access to a group of RPCs
+type Role string
+
+const (
+	RoleReader Role = "reader" // Queries metrics and reads configuration
+	RoleWriter Role = "writer" // Writes metrics
+	RoleAdmin  Role = "admin"  // Changes configuration, and may call every RPC
+)
+
+var (
+	// ErrNoCredentials is returned for a request without credentials
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is returned for credentials no authenticator accepts
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// AnyTenant is the tenant of principals that may act for any tenant
+const AnyTenant = "*"
+
+// Principal is the authenticated caller of a request. A principal may only
+// act for its tenant, or for any tenant if its tenant is AnyTenant. Principals
+// without a tenant are rejected.
+type Principal struct {
+	Subject string
+	Roles   []Role
+	Tenant  string
+}
+
+// HasRole reports whether the principal has a role, admins have every role
+func (p *Principal) HasRole(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// validate rejects unknown roles and missing or invalid tenants
+func (p *Principal) validate() error {
+	for _, r := range p.Roles {
+		if r != RoleReader && r != RoleWriter && r != RoleAdmin {
+			return fmt.Errorf("unknown role %q of %s", r, p.Subject)
+		}
+	}
+	switch p.Tenant {
+	case "":
+		return fmt.Errorf("%s has no tenant, %q allows any tenant", p.Subject, AnyTenant)
+	case AnyTenant:
+	default:
+		if err := tenant.Validate(p.Tenant); err != nil {
+			return fmt.Errorf("invalid tenant of %s: %w", p.Subject, err)
+		}
+	}
+	return nil
+}
+
+// Authenticator verifies the bearer token of a request
+type Authenticator interface {
+	// Authenticate returns the principal of a token, or ErrInvalidCredentials
+	// if the token is not one of the authenticator
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// Chain tries the authenticators in order and returns the principal of the
+// first one accepting the token
+type Chain []Authenticator
+
+// Authenticate implements Authenticator, when every authenticator rejects
+// the token the error of the last one is returned
+func (c Chain) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	err := ErrInvalidCredentials
+	for _, a := range c {
+		var principal *Principal
+		principal, err = a.Authenticate(ctx, token)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, err
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the principal of a request
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal of ctx, nil if the request was not authenticated
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
+
+// APIKeyConfig is a static API key and the principal it authenticates. The
+// tenant is required, it is AnyTenant for keys acting for any tenant.
+type APIKeyConfig struct {
+	Key     string `json:"key"`
+	Subject string `json:"subject"`
+	Roles   []Role `json:"roles"`
+	Tenant  string `json:"tenant"`
+}
+
+// APIKeysFile is the layout of an API keys file
+type APIKeysFile struct {
+	APIKeys []APIKeyConfig `json:"api_keys"`
+}
+
+// LoadAPIKeysFile reads the API keys of a JSON file
+func LoadAPIKeysFile(path string) ([]APIKeyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var file APIKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	return file.APIKeys, nil
+}
+
+// APIKeys authenticates static API keys. Keys are held as SHA-256 hashes, so
+// looking one up does not leak its prefix through timing.
+type APIKeys struct {
+	keys atomic.Pointer[map[[sha256.Size]byte]*Principal]
+}
+
+// NewAPIKeys creates a new APIKeys authenticator
+func NewAPIKeys(keys []APIKeyConfig) (*APIKeys, error) {
+	a := &APIKeys{}
+	if err := a.Store(keys); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Store replaces the API keys
+func (a *APIKeys) Store(keys []APIKeyConfig) error {
+	hashed := make(map[[sha256.Size]byte]*Principal, len(keys))
+	for _, key := range keys {
+		if key.Key == "" {
+			return fmt.Errorf("API key of %s is empty", key.Subject)
+		}
+		principal := &Principal{Subject: key.Subject, Roles: key.Roles, Tenant: key.Tenant}
+		if err := principal.validate(); err != nil {
+			return err
+		}
+		hashed[sha256.Sum256([]byte(key.Key))] = principal
+	}
+	a.keys.Store(&hashed)
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeys) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if principal, ok := (*a.keys.Load())[sha256.Sum256([]byte(token))]; ok {
+		return principal, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// Watch reloads the API keys from path whenever the file changes, checking
+// it every interval (10s if zero) until ctx is done. A file that fails to
+// load is logged and the current keys are kept.
+func (a *APIKeys) Watch(ctx context.Context, path string, interval time.Duration) {
+	filewatch.Watch(ctx, path, interval, func() error {
+		keys, err := LoadAPIKeysFile(path)
+		if err != nil {
+			return err
+		}
+		if err := a.Store(keys); err != nil {
+			return err
+		}
+		log.Printf("Reloaded %d API keys from %s", len(keys), path)
+		return nil
+	})
+}
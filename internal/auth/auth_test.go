@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+func testAPIKeys(t *testing.T) *APIKeys {
+	t.Helper()
+	keys, err := NewAPIKeys([]APIKeyConfig{
+		{Key: "reader-key", Subject: "dashboards", Roles: []Role{RoleReader}, Tenant: AnyTenant},
+		{Key: "writer-key", Subject: "agent", Roles: []Role{RoleWriter}, Tenant: AnyTenant},
+		{Key: "admin-key", Subject: "ops", Roles: []Role{RoleAdmin}, Tenant: AnyTenant},
+		{Key: "acme-key", Subject: "acme-agent", Roles: []Role{RoleWriter}, Tenant: "acme"},
+	})
+	if err != nil {
+		t.Fatalf("NewAPIKeys() error = %v", err)
+	}
+	return keys
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys := testAPIKeys(t)
+	principal, err := keys.Authenticate(context.Background(), "writer-key")
+	if err != nil || principal.Subject != "agent" {
+		t.Fatalf("Authenticate(writer-key) = %+v, %v, want agent", principal, err)
+	}
+	if _, err := keys.Authenticate(context.Background(), "writer"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate(writer) error = %v, want ErrInvalidCredentials", err)
+	}
+
+	tests := []struct {
+		name string
+		keys []APIKeyConfig
+	}{
+		{name: "empty key", keys: []APIKeyConfig{{Subject: "agent", Roles: []Role{RoleWriter}, Tenant: AnyTenant}}},
+		{name: "unknown role", keys: []APIKeyConfig{{Key: "k", Subject: "agent", Roles: []Role{"root"}, Tenant: AnyTenant}}},
+		{name: "invalid tenant", keys: []APIKeyConfig{{Key: "k", Subject: "agent", Tenant: "acme/prod"}}},
+		{name: "no tenant", keys: []APIKeyConfig{{Key: "k", Subject: "agent", Roles: []Role{RoleWriter}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := keys.Store(tt.keys); err == nil {
+				t.Error("Store() error = nil, want error")
+			}
+		})
+	}
+	if _, err := keys.Authenticate(context.Background(), "writer-key"); err != nil {
+		t.Errorf("rejected keys replaced the current ones: %v", err)
+	}
+}
+
+func TestPrincipal_HasRole(t *testing.T) {
+	tests := []struct {
+		roles []Role
+		role  Role
+		want  bool
+	}{
+		{roles: []Role{RoleReader}, role: RoleReader, want: true},
+		{roles: []Role{RoleReader}, role: RoleWriter},
+		{roles: []Role{RoleWriter}, role: RoleAdmin},
+		{roles: []Role{RoleAdmin}, role: RoleWriter, want: true},
+		{role: RoleReader},
+	}
+	for _, tt := range tests {
+		if got := (&Principal{Roles: tt.roles}).HasRole(tt.role); got != tt.want {
+			t.Errorf("HasRole(%v, %s) = %v, want %v", tt.roles, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	policy := Policy{
+		"/IngestionService/IngestData":          RoleWriter,
+		"/IngestionService/AddMetricValidation": RoleAdmin,
+		"/MetricsService/InstantQueryMetrics":   RoleReader,
+	}
+	tests := []struct {
+		name       string
+		method     string
+		md         metadata.MD
+		wantCode   codes.Code
+		wantTenant string
+	}{
+		{name: "writer ingests", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer writer-key")},
+		{name: "no credentials", method: "/IngestionService/IngestData", md: metadata.MD{}, wantCode: codes.Unauthenticated},
+		{name: "not a bearer token", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Basic writer-key"), wantCode: codes.Unauthenticated},
+		{name: "unknown key", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer nope"), wantCode: codes.Unauthenticated},
+		{name: "reader cannot ingest", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer reader-key"), wantCode: codes.PermissionDenied},
+		{name: "writer cannot add validations", method: "/IngestionService/AddMetricValidation", md: metadata.Pairs("authorization", "Bearer writer-key"), wantCode: codes.PermissionDenied},
+		{name: "admin adds validations", method: "/IngestionService/AddMetricValidation", md: metadata.Pairs("authorization", "bearer admin-key")},
+		{name: "admin queries", method: "/MetricsService/InstantQueryMetrics", md: metadata.Pairs("authorization", "Bearer admin-key")},
+		{name: "method outside the policy", method: "/MetricsService/DropAll", md: metadata.Pairs("authorization", "Bearer admin-key"), wantCode: codes.PermissionDenied},
+		{name: "bound tenant", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer acme-key"), wantTenant: "acme"},
+		{name: "bound tenant requested", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer acme-key", tenant.MetadataKey, "acme"), wantTenant: "acme"},
+		{name: "other tenant requested", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer acme-key", tenant.MetadataKey, "globex"), wantCode: codes.PermissionDenied},
+		{name: "principal of any tenant picks one", method: "/IngestionService/IngestData", md: metadata.Pairs("authorization", "Bearer writer-key", tenant.MetadataKey, "globex"), wantTenant: "globex"},
+	}
+
+	interceptor := chainedUnary(testAPIKeys(t), policy)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			var gotTenant string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				principal, gotTenant = FromContext(ctx), tenant.FromContext(ctx)
+				return nil, nil
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("interceptor error = %v, want %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if principal == nil {
+				t.Fatal("handler has no principal")
+			}
+			if gotTenant != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", gotTenant, tt.wantTenant)
+			}
+		})
+	}
+}
+
+// chainedUnary runs the auth interceptor before the tenant interceptor, as
+// the API server does
+func chainedUnary(authn Authenticator, policy Policy) grpc.UnaryServerInterceptor {
+	authInterceptor := UnaryServerInterceptor(authn, policy)
+	tenantInterceptor := tenant.UnaryServerInterceptor(false)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return authInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return tenantInterceptor(ctx, req, info, handler)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		tenantHeader  string
+		wantCode      int
+		wantTenant    string
+	}{
+		{name: "writer", authorization: "Bearer writer-key", wantCode: http.StatusOK},
+		{name: "no credentials", wantCode: http.StatusUnauthorized},
+		{name: "unknown key", authorization: "Bearer nope", wantCode: http.StatusUnauthorized},
+		{name: "reader", authorization: "Bearer reader-key", wantCode: http.StatusForbidden},
+		{name: "bound tenant", authorization: "Bearer acme-key", wantCode: http.StatusOK, wantTenant: "acme"},
+		{name: "other tenant", authorization: "Bearer acme-key", tenantHeader: "globex", wantCode: http.StatusForbidden},
+	}
+
+	keys := testAPIKeys(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTenant string
+			handler := Middleware(keys, RoleWriter, tenant.Middleware(false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if FromContext(r.Context()) == nil {
+					t.Error("handler has no principal")
+				}
+				gotTenant = tenant.FromContext(r.Context())
+			})))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v2/write", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.tenantHeader != "" {
+				r.Header.Set(tenant.HeaderName, tt.tenantHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if gotTenant != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", gotTenant, tt.wantTenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yay14/pulse/internal/filewatch"
+)
+
+// clockSkew is tolerated between the issuer of a token and this server
+const clockSkew = 30 * time.Second
+
+var jwtAlgorithms = map[string]struct {
+	hash crypto.Hash
+	rsa  bool
+}{
+	"HS256": {crypto.SHA256, false},
+	"HS384": {crypto.SHA384, false},
+	"HS512": {crypto.SHA512, false},
+	"RS256": {crypto.SHA256, true},
+	"RS384": {crypto.SHA384, true},
+	"RS512": {crypto.SHA512, true},
+}
+
+// JWTConfig configures the verification of JSON Web Tokens. Tokens must be
+// signed with HS256, HS384, HS512, RS256, RS384 or RS512 and carry an exp claim.
+type JWTConfig struct {
+	HMACSecret  []byte // Secret of HMAC signed tokens, in addition to the oct keys of the JWKS file
+	JWKSFile    string // JSON Web Key Set with the RSA and oct keys tokens are signed with
+	Issuer      string // Required iss claim, not checked if empty
+	Audience    string // Required aud claim, not checked if empty
+	RolesClaim  string // Claim holding the roles as an array or space-separated string, roles if empty
+	TenantClaim string // Claim holding the tenant, or * for any tenant, tenant if empty. Tokens without it are rejected.
+}
+
+// JWT authenticates JSON Web Tokens
+type JWT struct {
+	cfg  JWTConfig
+	keys atomic.Pointer[[]verificationKey]
+	now  func() time.Time
+}
+
+// verificationKey is an HMAC secret or an RSA public key
+type verificationKey struct {
+	id     string
+	secret []byte
+	rsa    *rsa.PublicKey
+}
+
+// jwk is a JSON Web Key of a JWKS file
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// NewJWT creates a new JWT authenticator, it fails when the JWKS file cannot be loaded
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "tenant"
+	}
+
+	a := &JWT{cfg: cfg, now: time.Now}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadKeys reads the JWKS file and replaces the verification keys
+func (a *JWT) loadKeys() error {
+	var keys []verificationKey
+	if len(a.cfg.HMACSecret) > 0 {
+		keys = append(keys, verificationKey{secret: a.cfg.HMACSecret})
+	}
+
+	if a.cfg.JWKSFile != "" {
+		data, err := os.ReadFile(a.cfg.JWKSFile)
+		if err != nil {
+			return fmt.Errorf("failed to read JWKS: %w", err)
+		}
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &set); err != nil {
+			return fmt.Errorf("failed to parse JWKS: %w", err)
+		}
+
+		for _, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			key, err := k.verificationKey()
+			if err != nil {
+				return fmt.Errorf("invalid key %q in JWKS: %w", k.Kid, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no JWT verification keys configured")
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+func (k jwk) verificationKey() (verificationKey, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return verificationKey{}, fmt.Errorf("invalid secret")
+		}
+		return verificationKey{id: k.Kid, secret: secret}, nil
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return verificationKey{}, fmt.Errorf("invalid modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return verificationKey{}, fmt.Errorf("invalid exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return verificationKey{id: k.Kid, rsa: pub}, nil
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *JWT) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported JWT algorithm %q", ErrInvalidCredentials, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed JWT signature", ErrInvalidCredentials)
+	}
+	if !a.verify(alg.hash, alg.rsa, header.Kid, parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("%w: JWT signature does not match", ErrInvalidCredentials)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed JWT claims", ErrInvalidCredentials)
+	}
+	principal, err := a.principal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return principal, nil
+}
+
+// verify checks the signature with every key of the algorithm's kind
+// matching the kid of the token. A token without a kid and a key without an
+// ID match any key and token.
+func (a *JWT) verify(hash crypto.Hash, useRSA bool, kid, signed string, signature []byte) bool {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	for _, key := range *a.keys.Load() {
+		if kid != "" && key.id != "" && key.id != kid {
+			continue
+		}
+		switch {
+		case useRSA && key.rsa != nil:
+			if rsa.VerifyPKCS1v15(key.rsa, hash, digest, signature) == nil {
+				return true
+			}
+		case !useRSA && key.secret != nil:
+			mac := hmac.New(hash.New, key.secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// principal checks the registered claims and returns the principal of a token
+func (a *JWT) principal(claims map[string]interface{}) (*Principal, error) {
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if a.cfg.Issuer != "" && claims["iss"] != a.cfg.Issuer {
+		return nil, fmt.Errorf("token has issuer %v, want %s", claims["iss"], a.cfg.Issuer)
+	}
+	if a.cfg.Audience != "" && !containsString(claims["aud"], a.cfg.Audience) {
+		return nil, fmt.Errorf("token is not for audience %s", a.cfg.Audience)
+	}
+
+	principal := &Principal{}
+	principal.Subject, _ = claims["sub"].(string)
+	principal.Tenant, _ = claims[a.cfg.TenantClaim].(string)
+	switch roles := claims[a.cfg.RolesClaim].(type) {
+	case string:
+		for _, role := range strings.Fields(roles) {
+			principal.Roles = append(principal.Roles, Role(role))
+		}
+	case []interface{}:
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, Role(role))
+			}
+		}
+	}
+	if err := principal.validate(); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// Watch reloads the JWKS file whenever it changes, checking it every
+// interval (10s if zero) until ctx is done. A file that fails to load is
+// logged and the current keys are kept.
+func (a *JWT) Watch(ctx context.Context, interval time.Duration) {
+	if a.cfg.JWKSFile == "" {
+		return
+	}
+	filewatch.Watch(ctx, a.cfg.JWKSFile, interval, func() error {
+		if err := a.loadKeys(); err != nil {
+			return err
+		}
+		log.Printf("Reloaded JWKS from %s", a.cfg.JWKSFile)
+		return nil
+	})
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// containsString reports whether a claim is s or an array holding s
+func containsString(claim interface{}, s string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == s
+	case []interface{}:
+		for _, v := range claim {
+			if v == s {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+// signJWT encodes a token of the header and claims, signed with an HMAC
+// secret or an RSA key
+func signJWT(t *testing.T, header, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWT_Authenticate(t *testing.T) {
+	secret := []byte("hmac-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The JWKS holds an RSA public key, an oct key and an encryption key
+	// that must not be used for signatures
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa-1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{"kty": "oct", "kid": "oct-1", "k": base64.RawURLEncoding.EncodeToString([]byte("jwks-secret"))},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	authn, err := NewJWT(JWTConfig{HMACSecret: secret, JWKSFile: jwksFile, Issuer: "https://idp", Audience: "pulse"})
+	if err != nil {
+		t.Fatalf("NewJWT() error = %v", err)
+	}
+	authn.now = func() time.Time { return testNow }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "agent", "iss": "https://idp", "aud": []string{"pulse"},
+			"exp": testNow.Add(time.Hour).Unix(), "roles": []string{"writer"}, "tenant": AnyTenant,
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}
+
+	// Algorithm confusion, an HS256 token using the RSA public key as secret
+	publicKey := rsaKey.PublicKey.N.Bytes()
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *Principal
+		wantErr bool
+	}{
+		{
+			name:  "HS256",
+			token: signJWT(t, hs256, claims(nil), secret),
+			want:  &Principal{Subject: "agent", Roles: []Role{RoleWriter}, Tenant: AnyTenant},
+		},
+		{
+			name:  "HS256 with a JWKS secret",
+			token: signJWT(t, map[string]interface{}{"alg": "HS256", "kid": "oct-1"}, claims(nil), []byte("jwks-secret")),
+			want:  &Principal{Subject: "agent", Roles: []Role{RoleWriter}, Tenant: AnyTenant},
+		},
+		{
+			name:  "RS256",
+			token: signJWT(t, rs256, claims(map[string]interface{}{"roles": "reader writer", "tenant": "acme", "aud": "pulse"}), rsaKey),
+			want:  &Principal{Subject: "agent", Roles: []Role{RoleReader, RoleWriter}, Tenant: "acme"},
+		},
+		{name: "wrong secret", token: signJWT(t, hs256, claims(nil), []byte("other")), wantErr: true},
+		{name: "unknown kid", token: signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, claims(nil), rsaKey), wantErr: true},
+		{name: "RSA key as HMAC secret", token: signJWT(t, hs256, claims(nil), publicKey), wantErr: true},
+		{name: "none algorithm", token: signJWT(t, map[string]interface{}{"alg": "none"}, claims(nil), nil), wantErr: true},
+		{name: "expired", token: signJWT(t, hs256, claims(map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()}), secret), wantErr: true},
+		{name: "expired within the clock skew", token: signJWT(t, hs256, claims(map[string]interface{}{"exp": testNow.Add(-10 * time.Second).Unix()}), secret), want: &Principal{Subject: "agent", Roles: []Role{RoleWriter}, Tenant: AnyTenant}},
+		{name: "no exp", token: signJWT(t, hs256, claims(map[string]interface{}{"exp": nil}), secret), wantErr: true},
+		{name: "not valid yet", token: signJWT(t, hs256, claims(map[string]interface{}{"nbf": testNow.Add(time.Hour).Unix()}), secret), wantErr: true},
+		{name: "wrong issuer", token: signJWT(t, hs256, claims(map[string]interface{}{"iss": "https://other"}), secret), wantErr: true},
+		{name: "wrong audience", token: signJWT(t, hs256, claims(map[string]interface{}{"aud": []string{"grafana"}}), secret), wantErr: true},
+		{name: "unknown role", token: signJWT(t, hs256, claims(map[string]interface{}{"roles": []string{"root"}}), secret), wantErr: true},
+		{name: "invalid tenant", token: signJWT(t, hs256, claims(map[string]interface{}{"tenant": "acme/prod"}), secret), wantErr: true},
+		{name: "no tenant", token: signJWT(t, hs256, claims(map[string]interface{}{"tenant": nil}), secret), wantErr: true},
+		{name: "not a JWT", token: "writer-key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authn.Authenticate(context.Background(), tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got.Subject != tt.want.Subject || got.Tenant != tt.want.Tenant || len(got.Roles) != len(tt.want.Roles) {
+				t.Fatalf("Authenticate() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Roles {
+				if got.Roles[i] != tt.want.Roles[i] {
+					t.Errorf("Authenticate() roles = %v, want %v", got.Roles, tt.want.Roles)
+				}
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	secret := []byte("hmac-secret")
+	jwt, err := NewJWT(JWTConfig{HMACSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{testAPIKeys(t), jwt}
+
+	if p, err := chain.Authenticate(context.Background(), "admin-key"); err != nil || p.Subject != "ops" {
+		t.Errorf("Authenticate(admin-key) = %+v, %v, want ops", p, err)
+	}
+	token := signJWT(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{
+		"sub": "agent", "exp": time.Now().Add(time.Hour).Unix(), "roles": "writer", "tenant": AnyTenant,
+	}, secret)
+	if p, err := chain.Authenticate(context.Background(), token); err != nil || p.Subject != "agent" {
+		t.Errorf("Authenticate(JWT) = %+v, %v, want agent", p, err)
+	}
+	if _, err := chain.Authenticate(context.Background(), "nope"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate(nope) error = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// Policy maps the full method name of every RPC to the role required to
+// call it. RPCs missing from the policy are denied.
+type Policy map[string]Role
+
+// UnaryServerInterceptor authenticates the bearer token in the authorization
+// metadata of a call and checks the role the policy requires for its method.
+// It must run before the tenant interceptor, which then reads the tenant of
+// principals bound to one.
+func UnaryServerInterceptor(authn Authenticator, policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, authn, policy, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates and authorizes a stream like
+// UnaryServerInterceptor does a call
+func StreamServerInterceptor(authn Authenticator, policy Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(stream.Context(), authn, policy, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorize returns ctx with the principal of a call, and the incoming
+// tenant metadata set to the tenant of the principal unless it may act for
+// any tenant
+func authorize(ctx context.Context, authn Authenticator, policy Policy, method string) (context.Context, error) {
+	var header string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	principal, err := authenticate(ctx, authn, header)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	role, ok := policy[method]
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed", method)
+	}
+	if !principal.HasRole(role) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires the %s role", method, role)
+	}
+
+	if principal.Tenant != AnyTenant {
+		var requested string
+		if values := metadata.ValueFromIncomingContext(ctx, tenant.MetadataKey); len(values) > 0 {
+			requested = values[0]
+		}
+		if err := checkTenant(principal, requested); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		md.Set(tenant.MetadataKey, principal.Tenant)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return NewContext(ctx, principal), nil
+}
+
+// authenticate verifies the bearer token of an authorization header
+func authenticate(ctx context.Context, authn Authenticator, header string) (*Principal, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoCredentials
+	}
+	return authn.Authenticate(ctx, token)
+}
+
+// checkTenant rejects a request of a principal bound to a tenant for another tenant
+func checkTenant(principal *Principal, requested string) error {
+	if requested != "" && requested != principal.Tenant {
+		return fmt.Errorf("%s may not act for tenant %s", principal.Subject, requested)
+	}
+	return nil
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// Middleware authenticates the bearer token in the Authorization header of a
+// request and requires a role before calling next. The X-Scope-OrgID header
+// is set to the tenant of principals not allowed AnyTenant, so the middleware
+// must wrap the tenant middleware.
+func Middleware(authn Authenticator, role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r.Context(), authn, r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			if errors.Is(err, ErrNoCredentials) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else {
+				http.Error(w, ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+			}
+			return
+		}
+		if !principal.HasRole(role) {
+			http.Error(w, fmt.Sprintf("the %s role is required", role), http.StatusForbidden)
+			return
+		}
+
+		if principal.Tenant != AnyTenant {
+			if err := checkTenant(principal, r.Header.Get(tenant.HeaderName)); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			r.Header.Set(tenant.HeaderName, principal.Tenant)
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+	})
+}
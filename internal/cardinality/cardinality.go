@@ -0,0 +1,284 @@
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindow      = time.Hour
+	defaultReportLimit = 10
+
+	// pruneFraction of the window passes between two prunes of a source
+	pruneFraction = 60
+)
+
+// ErrLimitExceeded is returned by Admit for a new series of a source or
+// metric that reached its limit
+var ErrLimitExceeded = errors.New("series limit exceeded")
+
+// Limits caps the active series, a series is active while it was written
+// within the window. Zero limits are unlimited.
+type Limits struct {
+	MaxSeriesPerSource int            // Active series of a source
+	MaxSeriesPerMetric int            // Active series of a metric name within a source
+	SourceOverrides    map[string]int // MaxSeriesPerSource of individual sources
+	Window             time.Duration  // 1h if zero
+}
+
+// Tracker tracks the active series of every source and enforces the limits.
+// Sources of different tenants are tracked separately, the limits apply to
+// the sources of every tenant alike.
+type Tracker struct {
+	limits Limits
+	seed   maphash.Seed
+	now    func() time.Time
+
+	mu      sync.Mutex
+	sources map[sourceKey]*source
+}
+
+type sourceKey struct {
+	tenantID, sourceID string
+}
+
+type source struct {
+	series  map[uint64]*series
+	metrics map[string]int // Active series by metric name
+	pruned  time.Time
+}
+
+type series struct {
+	metric   string
+	labels   map[string]string
+	lastSeen time.Time
+}
+
+// NewTracker creates a new Tracker
+func NewTracker(limits Limits) *Tracker {
+	if limits.Window <= 0 {
+		limits.Window = defaultWindow
+	}
+	return &Tracker{limits: limits, seed: maphash.MakeSeed(), now: time.Now, sources: make(map[sourceKey]*source)}
+}
+
+// Admit records a write to a series of a source of a tenant. A series that
+// is not active yet is rejected with ErrLimitExceeded when its source or
+// metric is at its limit. The labels are retained and must not be modified
+// afterwards.
+func (t *Tracker) Admit(tenantID, sourceID, metric string, labels map[string]string) error {
+	key := t.key(metric, labels)
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	src := t.sources[sourceKey{tenantID, sourceID}]
+	if src == nil {
+		src = &source{series: make(map[uint64]*series), metrics: make(map[string]int), pruned: now}
+		t.sources[sourceKey{tenantID, sourceID}] = src
+	}
+	if s, ok := src.series[key]; ok {
+		s.lastSeen = now
+		return nil
+	}
+
+	if err := t.checkLimits(sourceID, src, metric); err != nil {
+		// Series that went inactive make room, but a source at its limit
+		// is only pruned every so often to keep rejections cheap
+		if now.Sub(src.pruned) < t.limits.Window/pruneFraction {
+			return err
+		}
+		t.pruneSource(src, now)
+		if err := t.checkLimits(sourceID, src, metric); err != nil {
+			return err
+		}
+	}
+
+	src.series[key] = &series{metric: metric, labels: labels, lastSeen: now}
+	src.metrics[metric]++
+	return nil
+}
+
+// SourceLimit returns the series limit of a source, 0 if it is unlimited
+func (t *Tracker) SourceLimit(sourceID string) int {
+	if limit, ok := t.limits.SourceOverrides[sourceID]; ok {
+		return limit
+	}
+	return t.limits.MaxSeriesPerSource
+}
+
+func (t *Tracker) checkLimits(sourceID string, src *source, metric string) error {
+	if limit := t.SourceLimit(sourceID); limit > 0 && len(src.series) >= limit {
+		return fmt.Errorf("%w: source %s has %d active series", ErrLimitExceeded, sourceID, limit)
+	}
+	if limit := t.limits.MaxSeriesPerMetric; limit > 0 && src.metrics[metric] >= limit {
+		return fmt.Errorf("%w: metric %s of source %s has %d active series", ErrLimitExceeded, metric, sourceID, limit)
+	}
+	return nil
+}
+
+// key hashes the metric name and labels of a series
+func (t *Tracker) key(metric string, labels map[string]string) uint64 {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var h maphash.Hash
+	h.SetSeed(t.seed)
+	h.WriteString(metric)
+	for _, name := range names {
+		h.WriteByte(0xff)
+		h.WriteString(name)
+		h.WriteByte(0xff)
+		h.WriteString(labels[name])
+	}
+	return h.Sum64()
+}
+
+// pruneSource removes the series of a source that are no longer active
+func (t *Tracker) pruneSource(src *source, now time.Time) {
+	cutoff := now.Add(-t.limits.Window)
+	for key, s := range src.series {
+		if s.lastSeen.Before(cutoff) {
+			delete(src.series, key)
+			if src.metrics[s.metric]--; src.metrics[s.metric] == 0 {
+				delete(src.metrics, s.metric)
+			}
+		}
+	}
+	src.pruned = now
+}
+
+// Prune removes the series that are no longer active
+func (t *Tracker) Prune() {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, src := range t.sources {
+		t.pruneSource(src, now)
+		if len(src.series) == 0 {
+			delete(t.sources, key)
+		}
+	}
+}
+
+// Run prunes inactive series periodically until ctx is done
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.limits.Window / pruneFraction)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Prune()
+		}
+	}
+}
+
+// SourceCount is the number of active series of a source
+type SourceCount struct {
+	SourceID string
+	Series   int
+	Limit    int
+}
+
+// MetricCount is the number of active series of a metric name
+type MetricCount struct {
+	MetricName string
+	Series     int
+}
+
+// LabelCount is the number of active series with a label name and the
+// distinct values of the label
+type LabelCount struct {
+	LabelName string
+	Series    int
+	Values    int
+}
+
+// Report lists the sources, metrics and labels with the most active series
+type Report struct {
+	TotalSeries int
+	Sources     []SourceCount
+	Metrics     []MetricCount
+	Labels      []LabelCount
+}
+
+// Report returns the top limit (10 if zero) entries of the active series of
+// a source of a tenant, or of all sources of the tenant if sourceID is empty
+func (t *Tracker) Report(tenantID, sourceID string, limit int) Report {
+	if limit <= 0 {
+		limit = defaultReportLimit
+	}
+	t.Prune()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var report Report
+	metrics := make(map[string]int)
+	labelSeries := make(map[string]int)
+	labelValues := make(map[string]map[string]struct{})
+	for key, src := range t.sources {
+		if key.tenantID != tenantID || sourceID != "" && key.sourceID != sourceID {
+			continue
+		}
+		report.TotalSeries += len(src.series)
+		report.Sources = append(report.Sources, SourceCount{SourceID: key.sourceID, Series: len(src.series), Limit: t.SourceLimit(key.sourceID)})
+		for metric, n := range src.metrics {
+			metrics[metric] += n
+		}
+		for _, s := range src.series {
+			for name, value := range s.labels {
+				labelSeries[name]++
+				if labelValues[name] == nil {
+					labelValues[name] = make(map[string]struct{})
+				}
+				labelValues[name][value] = struct{}{}
+			}
+		}
+	}
+
+	sort.Slice(report.Sources, func(i, j int) bool {
+		a, b := report.Sources[i], report.Sources[j]
+		return a.Series > b.Series || a.Series == b.Series && a.SourceID < b.SourceID
+	})
+	report.Sources = truncate(report.Sources, limit)
+
+	for name, n := range metrics {
+		report.Metrics = append(report.Metrics, MetricCount{MetricName: name, Series: n})
+	}
+	sort.Slice(report.Metrics, func(i, j int) bool {
+		a, b := report.Metrics[i], report.Metrics[j]
+		return a.Series > b.Series || a.Series == b.Series && a.MetricName < b.MetricName
+	})
+	report.Metrics = truncate(report.Metrics, limit)
+
+	for name, n := range labelSeries {
+		report.Labels = append(report.Labels, LabelCount{LabelName: name, Series: n, Values: len(labelValues[name])})
+	}
+	sort.Slice(report.Labels, func(i, j int) bool {
+		a, b := report.Labels[i], report.Labels[j]
+		return a.Series > b.Series || a.Series == b.Series && a.LabelName < b.LabelName
+	})
+	report.Labels = truncate(report.Labels, limit)
+
+	return report
+}
+
+func truncate[T any](s []T, limit int) []T {
+	if len(s) > limit {
+		return s[:limit]
+	}
+	return s
+}
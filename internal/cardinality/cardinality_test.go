@@ -0,0 +1,149 @@
+package cardinality
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced manually by tests
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestTracker(limits Limits) (*Tracker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tracker := NewTracker(limits)
+	tracker.now = clock.now
+	return tracker, clock
+}
+
+func TestTracker_Admit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		writes  [][2]string // source and pod label of http_requests_total writes
+		wantErr []bool
+	}{
+		{
+			name:    "unlimited",
+			writes:  [][2]string{{"api", "a"}, {"api", "b"}, {"api", "c"}},
+			wantErr: []bool{false, false, false},
+		},
+		{
+			name:    "source limit rejects new series only",
+			limits:  Limits{MaxSeriesPerSource: 2},
+			writes:  [][2]string{{"api", "a"}, {"api", "b"}, {"api", "c"}, {"api", "a"}, {"web", "c"}},
+			wantErr: []bool{false, false, true, false, false},
+		},
+		{
+			name:    "source override",
+			limits:  Limits{MaxSeriesPerSource: 2, SourceOverrides: map[string]int{"api": 1}},
+			writes:  [][2]string{{"api", "a"}, {"api", "b"}, {"web", "a"}, {"web", "b"}},
+			wantErr: []bool{false, true, false, false},
+		},
+		{
+			name:    "metric limit",
+			limits:  Limits{MaxSeriesPerMetric: 1},
+			writes:  [][2]string{{"api", "a"}, {"api", "b"}, {"web", "b"}},
+			wantErr: []bool{false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, _ := newTestTracker(tt.limits)
+			for i, w := range tt.writes {
+				err := tracker.Admit("", w[0], "http_requests_total", map[string]string{"pod": w[1]})
+				if (err != nil) != tt.wantErr[i] {
+					t.Errorf("Admit(%v) error = %v, wantErr %v", w, err, tt.wantErr[i])
+				}
+				if err != nil && !errors.Is(err, ErrLimitExceeded) {
+					t.Errorf("Admit(%v) error = %v, want ErrLimitExceeded", w, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTracker_Window(t *testing.T) {
+	tracker, clock := newTestTracker(Limits{MaxSeriesPerSource: 1, Window: time.Hour})
+	if err := tracker.Admit("", "api", "up", map[string]string{"pod": "a"}); err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+
+	clock.t = clock.t.Add(30 * time.Minute)
+	if err := tracker.Admit("", "api", "up", map[string]string{"pod": "b"}); err == nil {
+		t.Fatal("Admit() admitted a series past the limit")
+	}
+
+	// The first series went inactive and makes room
+	clock.t = clock.t.Add(31 * time.Minute)
+	if err := tracker.Admit("", "api", "up", map[string]string{"pod": "b"}); err != nil {
+		t.Fatalf("Admit() error = %v after the window", err)
+	}
+	if got := tracker.Report("", "", 0).TotalSeries; got != 1 {
+		t.Errorf("Report() counted %d series, want 1", got)
+	}
+}
+
+func TestTracker_Report(t *testing.T) {
+	tracker, _ := newTestTracker(Limits{MaxSeriesPerSource: 100})
+	for _, w := range []struct {
+		source, metric string
+		labels         map[string]string
+	}{
+		{"api", "http_requests_total", map[string]string{"code": "200", "path": "/a"}},
+		{"api", "http_requests_total", map[string]string{"code": "500", "path": "/a"}},
+		{"api", "http_requests_total", map[string]string{"code": "200", "path": "/b"}},
+		{"api", "up", nil},
+		{"web", "http_requests_total", map[string]string{"code": "200"}},
+	} {
+		if err := tracker.Admit("", w.source, w.metric, w.labels); err != nil {
+			t.Fatalf("Admit() error = %v", err)
+		}
+	}
+
+	got := tracker.Report("", "", 1)
+	want := Report{
+		TotalSeries: 5,
+		Sources:     []SourceCount{{SourceID: "api", Series: 4, Limit: 100}},
+		Metrics:     []MetricCount{{MetricName: "http_requests_total", Series: 4}},
+		Labels:      []LabelCount{{LabelName: "code", Series: 4, Values: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Report() = %+v, want %+v", got, want)
+	}
+
+	got = tracker.Report("", "web", 0)
+	want = Report{
+		TotalSeries: 1,
+		Sources:     []SourceCount{{SourceID: "web", Series: 1, Limit: 100}},
+		Metrics:     []MetricCount{{MetricName: "http_requests_total", Series: 1}},
+		Labels:      []LabelCount{{LabelName: "code", Series: 1, Values: 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Report(web) = %+v, want %+v", got, want)
+	}
+}
+
+func TestTracker_Tenants(t *testing.T) {
+	tracker, _ := newTestTracker(Limits{MaxSeriesPerSource: 1})
+	if err := tracker.Admit("acme", "api", "up", map[string]string{"pod": "a"}); err != nil {
+		t.Fatalf("Admit(acme) error = %v", err)
+	}
+	// The same source of another tenant has a limit of its own
+	if err := tracker.Admit("globex", "api", "up", map[string]string{"pod": "b"}); err != nil {
+		t.Fatalf("Admit(globex) error = %v", err)
+	}
+	if err := tracker.Admit("acme", "api", "up", map[string]string{"pod": "b"}); err == nil {
+		t.Fatal("Admit(acme) admitted a series past the limit")
+	}
+
+	if got := tracker.Report("acme", "", 0); got.TotalSeries != 1 || len(got.Sources) != 1 {
+		t.Errorf("Report(acme) = %+v, want the single series of acme", got)
+	}
+	if got := tracker.Report("", "", 0); got.TotalSeries != 0 {
+		t.Errorf("Report() of the default tenant = %+v, want no series", got)
+	}
+}
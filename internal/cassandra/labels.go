@@ -0,0 +1,117 @@
+package cassandra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/promql"
+)
+
+// labelTables are the tables storing labels in a label_map column, rows
+// written before it was added hold JSON in the labels column
+var labelTables = []string{"metrics", "histograms", "summaries"}
+
+// SelectSamples returns the raw samples between start, inclusive, and end,
+// exclusive, of the series of the tenant of ctx matching every matcher. The
+// matchers must select a metric name with an equality matcher on __name__,
+// the samples of the series found through its postings are read.
+func (r *Repository) SelectSamples(ctx context.Context, matchers []*promql.Matcher, start, end time.Time) ([]*ingestion.MetricData, error) {
+	metricName, ok := promql.MetricName(matchers)
+	if !ok {
+		return nil, fmt.Errorf("matchers must select a metric name")
+	}
+	series, err := r.LookupSeries(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ingestion.MetricData
+	err = r.scanSamples(ctx, series, start, end, func(s Series, timestamp time.Time, value float64) error {
+		result = append(result, &ingestion.MetricData{Name: metricName, Labels: s.Labels, Value: value, Timestamp: timestamp.UnixMilli()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select samples: %w", err)
+	}
+
+	return result, nil
+}
+
+// rowLabels returns the labels of a row, from the JSON of rows that were not backfilled yet
+func rowLabels(labelsJSON string, labelMap map[string]string) (map[string]string, error) {
+	if labelsJSON == "" {
+		if labelMap == nil {
+			labelMap = map[string]string{}
+		}
+		return labelMap, nil
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return labels, nil
+}
+
+// canonicalLabels encodes labels as JSON with sorted keys
+func canonicalLabels(labels map[string]string) (string, error) {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	return string(data), nil
+}
+
+// BackfillLabelMaps moves the JSON labels of rows written before the
+// label_map column existed into label_map, keeping the remaining TTL of the
+// rows, and returns how many rows were converted
+func (r *Repository) BackfillLabelMaps(ctx context.Context) (int, error) {
+	converted := 0
+	for _, table := range labelTables {
+		// Every table has a column that is set on insert, its TTL is the TTL of the row
+		ttlColumn := "sample_count"
+		if table == "metrics" {
+			ttlColumn = "metric_value"
+		}
+
+		iter := r.session.Query(fmt.Sprintf(`SELECT id, labels, TTL(%s) FROM metrics_keyspace.%s`, ttlColumn, table)).WithContext(ctx).Iter()
+		update := fmt.Sprintf(`UPDATE metrics_keyspace.%s USING TTL ? SET label_map = ?, labels = null WHERE id = ?`, table)
+
+		var id gocql.UUID
+		var labelsJSON string
+		var ttl int
+		for iter.Scan(&id, &labelsJSON, &ttl) {
+			if labelsJSON == "" {
+				continue
+			}
+
+			labels, err := rowLabels(labelsJSON, nil)
+			if err != nil {
+				log.Printf("Skipping row %s of %s with invalid labels: %v", id, table, err)
+				continue
+			}
+			if err := r.session.Query(update, ttl, labels, id).WithContext(ctx).Exec(); err != nil {
+				iter.Close()
+				return converted, fmt.Errorf("failed to backfill labels of %s: %w", table, err)
+			}
+			converted++
+		}
+		if err := iter.Close(); err != nil {
+			return converted, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+	}
+
+	return converted, nil
+}
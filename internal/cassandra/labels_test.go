@@ -0,0 +1,52 @@
+package cassandra
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_rowLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		labelsJSON string
+		labelMap   map[string]string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{name: "label map", labelMap: map[string]string{"job": "api"}, want: map[string]string{"job": "api"}},
+		{name: "no labels", want: map[string]string{}},
+		{name: "JSON of a row that was not backfilled", labelsJSON: `{"job":"api"}`, want: map[string]string{"job": "api"}},
+		{name: "JSON of nil labels", labelsJSON: `null`, want: map[string]string{}},
+		{name: "invalid JSON", labelsJSON: `{"job":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rowLabels(tt.labelsJSON, tt.labelMap)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rowLabels() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rowLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canonicalLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "sorted keys", labels: map[string]string{"zone": "a", "job": "api"}, want: `{"job":"api","zone":"a"}`},
+		{name: "nil labels", want: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := canonicalLabels(tt.labels); err != nil || got != tt.want {
+				t.Errorf("canonicalLabels() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
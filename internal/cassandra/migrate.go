@@ -0,0 +1,259 @@
+package cassandra
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Keyspace is the keyspace holding every table of the repository
+const Keyspace = "metrics_keyspace"
+
+//go:embed migrations/*.cql
+var migrationFiles embed.FS
+
+// Migration is a versioned set of CQL statements. Cassandra cannot apply a
+// migration atomically so a failed migration is retried from its first
+// statement, migrations with several statements must be idempotent. ALTER
+// TABLE ... ADD has no IF NOT EXISTS, a column that already exists is
+// taken as added by an earlier attempt.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// MigrationStatus is a migration and whether it was applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time // Zero if the migration is pending
+}
+
+// ReplicationConfig represents the replication of the keyspace
+type ReplicationConfig struct {
+	Strategy          string         // SimpleStrategy or NetworkTopologyStrategy
+	ReplicationFactor int            // Replication factor of SimpleStrategy
+	Datacenters       map[string]int // Replication factor per datacenter of NetworkTopologyStrategy
+}
+
+// CQL returns the replication map of a CREATE KEYSPACE statement
+func (c ReplicationConfig) CQL() (string, error) {
+	switch c.Strategy {
+	case "", "SimpleStrategy":
+		rf := c.ReplicationFactor
+		if rf == 0 {
+			rf = 1
+		}
+		if rf < 0 {
+			return "", fmt.Errorf("invalid replication factor %d", rf)
+		}
+		return fmt.Sprintf("{'class': 'SimpleStrategy', 'replication_factor': %d}", rf), nil
+	case "NetworkTopologyStrategy":
+		if len(c.Datacenters) == 0 {
+			return "", fmt.Errorf("NetworkTopologyStrategy requires at least one datacenter")
+		}
+		dcs := make([]string, 0, len(c.Datacenters))
+		for dc, rf := range c.Datacenters {
+			if rf <= 0 {
+				return "", fmt.Errorf("invalid replication factor %d for datacenter %s", rf, dc)
+			}
+			dcs = append(dcs, fmt.Sprintf("'%s': %d", strings.ReplaceAll(dc, "'", "''"), rf))
+		}
+		sort.Strings(dcs)
+		return fmt.Sprintf("{'class': 'NetworkTopologyStrategy', %s}", strings.Join(dcs, ", ")), nil
+	default:
+		return "", fmt.Errorf("unknown replication strategy %q", c.Strategy)
+	}
+}
+
+// ParseDatacenters parses datacenter replication factors such as dc1:3,dc2:2
+func ParseDatacenters(s string) (map[string]int, error) {
+	dcs := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dc, rf, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("datacenter %q has no replication factor", entry)
+		}
+		n, err := strconv.Atoi(rf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replication factor of datacenter %s: %w", dc, err)
+		}
+		dcs[dc] = n
+	}
+	return dcs, nil
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".cql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.cql", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, Statements: splitStatements(string(data))})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits CQL at semicolons, dropping -- comments and empty statements
+func splitStatements(cql string) []string {
+	var lines []string
+	for _, line := range strings.Split(cql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// Migrate creates the keyspace with the configured replication and applies
+// the pending migrations in order, recording each in schema_migrations
+func (r *Repository) Migrate(ctx context.Context, replication ReplicationConfig) error {
+	replicationCQL, err := replication.CQL()
+	if err != nil {
+		return err
+	}
+
+	if err := r.session.Query(fmt.Sprintf(`CREATE KEYSPACE IF NOT EXISTS %s WITH REPLICATION = %s`, Keyspace, replicationCQL)).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to create keyspace: %w", err)
+	}
+	if err := r.session.Query(`
+	CREATE TABLE IF NOT EXISTS metrics_keyspace.schema_migrations (
+		version INT,
+		name TEXT,
+		applied_at TIMESTAMP,
+		PRIMARY KEY (version)
+	)`).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to create schema migrations table: %w", err)
+	}
+
+	statuses, err := r.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if !status.AppliedAt.IsZero() {
+			continue
+		}
+
+		log.Printf("Applying migration %d_%s", status.Version, status.Name)
+		for _, statement := range status.Statements {
+			err := r.session.Query(statement).WithContext(ctx).Exec()
+			if err != nil && columnExists(statement, err) {
+				log.Printf("Skipping statement of migration %d_%s applied by an earlier attempt: %v", status.Version, status.Name, err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", status.Version, status.Name, err)
+			}
+		}
+
+		query := `INSERT INTO metrics_keyspace.schema_migrations (version, name, applied_at) VALUES (?, ?, toTimestamp(now()))`
+		if err := r.session.Query(query, status.Version, status.Name).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed to record migration %d_%s: %w", status.Version, status.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// columnExists reports whether err is Cassandra rejecting an ALTER TABLE ...
+// ADD statement because the column exists
+func columnExists(statement string, err error) bool {
+	fields := strings.Fields(strings.ToUpper(statement))
+	if len(fields) < 4 || fields[0] != "ALTER" || fields[1] != "TABLE" || fields[3] != "ADD" {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "conflicts with an existing column") || strings.Contains(msg, "already exists")
+}
+
+// MigrationStatus returns every migration with the time it was applied, on a
+// cluster without the schema_migrations table every migration is pending
+func (r *Repository) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var table string
+	err = r.session.Query(`SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?`,
+		Keyspace, "schema_migrations").WithContext(ctx).Scan(&table)
+	if err != nil && err != gocql.ErrNotFound {
+		return nil, fmt.Errorf("failed to look up schema migrations table: %w", err)
+	}
+
+	applied := make(map[int]time.Time)
+	if err == nil {
+		iter := r.session.Query(`SELECT version, applied_at FROM metrics_keyspace.schema_migrations`).WithContext(ctx).Iter()
+		var version int
+		var appliedAt time.Time
+		for iter.Scan(&version, &appliedAt) {
+			applied[version] = appliedAt
+		}
+		if err := iter.Close(); err != nil {
+			return nil, fmt.Errorf("failed to read schema migrations: %w", err)
+		}
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Migration: m, AppliedAt: applied[m.Version]}
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the migrations that were not applied yet
+func (r *Repository) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	statuses, err := r.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt.IsZero() {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,151 @@
+package cassandra
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Migrations() returned no migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if len(m.Statements) == 0 {
+			t.Errorf("migration %d_%s has no statements", m.Version, m.Name)
+		}
+		for _, statement := range m.Statements {
+			if !strings.Contains(statement, Keyspace+".") {
+				t.Errorf("migration %d_%s statement does not qualify its table with %s:\n%s", m.Version, m.Name, Keyspace, statement)
+			}
+		}
+	}
+}
+
+func Test_splitStatements(t *testing.T) {
+	cql := `-- Comment; with a semicolon
+CREATE TABLE a (id INT PRIMARY KEY);
+
+CREATE TABLE b (id INT PRIMARY KEY)
+;
+`
+	want := []string{"CREATE TABLE a (id INT PRIMARY KEY)", "CREATE TABLE b (id INT PRIMARY KEY)"}
+	if got := splitStatements(cql); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements() = %q, want %q", got, want)
+	}
+}
+
+func TestReplicationConfig_CQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ReplicationConfig
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: "{'class': 'SimpleStrategy', 'replication_factor': 1}",
+		},
+		{
+			name: "simple strategy",
+			cfg:  ReplicationConfig{Strategy: "SimpleStrategy", ReplicationFactor: 3},
+			want: "{'class': 'SimpleStrategy', 'replication_factor': 3}",
+		},
+		{
+			name: "network topology strategy",
+			cfg:  ReplicationConfig{Strategy: "NetworkTopologyStrategy", Datacenters: map[string]int{"eu-west": 3, "us-east": 2}},
+			want: "{'class': 'NetworkTopologyStrategy', 'eu-west': 3, 'us-east': 2}",
+		},
+		{
+			name:    "network topology strategy without datacenters",
+			cfg:     ReplicationConfig{Strategy: "NetworkTopologyStrategy"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown strategy",
+			cfg:     ReplicationConfig{Strategy: "LocalStrategy"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.CQL()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CQL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDatacenters(t *testing.T) {
+	got, err := ParseDatacenters("dc1:3, dc2:2,")
+	if err != nil {
+		t.Fatalf("ParseDatacenters() error = %v", err)
+	}
+	if want := map[string]int{"dc1": 3, "dc2": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDatacenters() = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"dc1", "dc1:three"} {
+		if _, err := ParseDatacenters(s); err == nil {
+			t.Errorf("ParseDatacenters(%q) error = nil, want error", s)
+		}
+	}
+}
+
+func Test_columnExists(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		err       error
+		want      bool
+	}{
+		{
+			name:      "column added by an earlier attempt",
+			statement: "ALTER TABLE metrics_keyspace.metrics ADD label_map MAP<TEXT, TEXT>",
+			err:       errors.New("Invalid column name label_map because it conflicts with an existing column"),
+			want:      true,
+		},
+		{
+			name:      "lower case",
+			statement: "alter table metrics_keyspace.metrics add label_map map<text, text>",
+			err:       errors.New("Invalid column name label_map because it conflicts with an existing column"),
+			want:      true,
+		},
+		{
+			name:      "other error",
+			statement: "ALTER TABLE metrics_keyspace.metrics ADD label_map MAP<TEXT, TEXT>",
+			err:       errors.New("unconfigured table metrics"),
+		},
+		{
+			name:      "not an ADD",
+			statement: "ALTER TABLE metrics_keyspace.metrics DROP labels",
+			err:       errors.New("Column labels was not found in table metrics"),
+		},
+		{
+			name:      "CREATE TABLE",
+			statement: "CREATE TABLE metrics_keyspace.series (id TEXT PRIMARY KEY)",
+			err:       errors.New("Cannot add already existing table \"series\" to keyspace \"metrics_keyspace\""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := columnExists(tt.statement, tt.err); got != tt.want {
+				t.Errorf("columnExists() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yay14/pulse/internal/promql"
+)
+
+// SelectSeries returns the raw series matching the matchers with their
+// samples between start and end, inclusive, for the query engine. The series
+// are found through the postings of the matchers.
+func (r *Repository) SelectSeries(ctx context.Context, matchers []*promql.Matcher, start, end time.Time) ([]promql.Series, error) {
+	registered, err := r.LookupSeries(ctx, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []promql.Series
+	index := make(map[string]int, len(registered))
+	err = r.scanSamples(ctx, registered, start, end.Add(time.Millisecond), func(s Series, timestamp time.Time, value float64) error {
+		i, ok := index[s.ID]
+		if !ok {
+			labels := make(map[string]string, len(s.Labels)+1)
+			for k, v := range s.Labels {
+				labels[k] = v
+			}
+			labels["__name__"] = s.MetricName
+
+			i = len(result)
+			index[s.ID] = i
+			result = append(result, promql.Series{Labels: labels})
+		}
+		result[i].Points = append(result[i].Points, promql.Point{T: timestamp.UnixMilli(), V: value})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select series: %w", err)
+	}
+
+	return result, nil
+}
@@ -3,18 +3,100 @@ package cassandra
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/internal/retention"
+	"github.com/yay14/pulse/internal/rollup"
+	"github.com/yay14/pulse/internal/tenant"
 )
 
+// Repository stores metrics in Cassandra. Metrics are written and read for
+// the tenant of the context of each call.
 type Repository struct {
 	session *gocql.Session
+	series  *seriesCache
 }
 
-// NewRepository creates a new Cassandra repository
+// IsInvalid reports whether a write failed because its values or statement
+// are rejected, so that retrying it fails again
+func IsInvalid(err error) bool {
+	var marshalErr gocql.MarshalError
+	if errors.As(err, &marshalErr) {
+		return true
+	}
+	var requestErr gocql.RequestError
+	if errors.As(err, &requestErr) {
+		switch requestErr.Code() {
+		case gocql.ErrCodeSyntax, gocql.ErrCodeInvalid:
+			return true
+		}
+	}
+	return false
+}
+
+// Sources are written to the source columns, source_id holds the UUIDs of
+// rows written before them
+const (
+	insertMetric = `INSERT INTO metrics_keyspace.metrics (
+		id,
+		source,
+		source_type,
+		metric_name,
+		metric_value,
+		label_map,
+		timestamp
+	) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	insertHistogram = `INSERT INTO metrics_keyspace.histograms (
+		id,
+		source,
+		source_type,
+		metric_name,
+		label_map,
+		timestamp,
+		sample_count,
+		sample_sum,
+		bucket_bounds,
+		bucket_counts,
+		exponential,
+		scale,
+		zero_threshold,
+		zero_count,
+		positive_offset,
+		positive_counts,
+		negative_offset,
+		negative_counts
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	insertSummary = `INSERT INTO metrics_keyspace.summaries (
+		id,
+		source,
+		source_type,
+		metric_name,
+		label_map,
+		timestamp,
+		sample_count,
+		sample_sum,
+		quantiles
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	insertValidation = `INSERT INTO metrics_keyspace.metric_validation (
+		id,
+		metric_name,
+		source,
+		min_value,
+		max_value
+	) VALUES (?, ?, ?, ?, ?)`
+)
+
+// NewRepository creates a new Cassandra repository. The schema is created by
+// Migrate, which NewRepository does not run.
 func NewRepository(cluster *gocql.ClusterConfig) (*Repository, error) {
 	// Create a new session
 	session, err := cluster.CreateSession()
@@ -22,97 +104,618 @@ func NewRepository(cluster *gocql.ClusterConfig) (*Repository, error) {
 		return nil, fmt.Errorf("failed to create Cassandra session: %w", err)
 	}
 
-	// Create keyspace
-	err = session.Query(`
-		CREATE KEYSPACE IF NOT EXISTS metrics_keyspace 
-		WITH REPLICATION = {'class' : 'SimpleStrategy', 'replication_factor' : 1};
-	`).Exec()
+	return &Repository{session: session, series: newSeriesCache()}, nil
+}
+
+// WriteMetric writes a metric to Cassandra, and its sample to the samples
+// partition of its series. The row of a sample is keyed by its series, source
+// and timestamp, writing it again overwrites it.
+func (r *Repository) WriteMetric(ctx context.Context, metric *ingestion.MetricData, req *ingestion.IngestDataRequest, ttl time.Duration) error {
+	name, err := storedName(ctx, metric.Name)
 	if err != nil {
-		log.Println(err)
-		return nil, fmt.Errorf("failed to create keyspace: %w", err)
-	}
-
-	// Create table metrics
-	err = session.Query(`
-		CREATE TABLE IF NOT EXISTS metrics_keyspace.metrics (
-			id UUID,
-			source_id UUID,
-			source_type TEXT,
-			metric_name TEXT,
-			metric_value DOUBLE,
-			labels TEXT,
-			timestamp TIMESTAMP,
-			PRIMARY KEY (id)
-		);
-	`).Exec()
+		return err
+	}
+
+	seriesID := SeriesID(name, metric.Labels)
+	id := sampleID(seriesID, req.SourceType, req.SourceId, metric.Timestamp)
+
+	// Execute the CQL query
+	if err := r.session.Query(insertMetric, id, req.SourceId, req.SourceType, name, metric.Value, metric.Labels, metric.Timestamp, ttlSeconds(ttl)).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	if err := r.writeSample(ctx, seriesID, metric.Timestamp, metric.Value, ttl); err != nil {
+		return err
+	}
+	if _, err := r.RegisterSeries(ctx, name, metric.Labels); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote metric to Cassandra")
+	return nil
+}
+
+// WriteHistogram writes a metric carrying a histogram to the histograms table
+func (r *Repository) WriteHistogram(ctx context.Context, metric *ingestion.MetricData, req *ingestion.IngestDataRequest, ttl time.Duration) error {
+	name, err := storedName(ctx, metric.Name)
 	if err != nil {
-		log.Println(err)
-		return nil, fmt.Errorf("failed to create metrics table: %w", err)
-	}
-
-	// Create table metrics_validation
-	err = session.Query(`
-	CREATE TABLE IF NOT EXISTS metrics_keyspace.metric_validation (
-		id UUID,
-		metric_name TEXT,
-		source_id UUID,
-		min_value DOUBLE,
-		max_value DOUBLE,
-		PRIMARY KEY (id)
-	);
-	`).Exec()
+		return err
+	}
+	id := sampleID(SeriesID(name, metric.Labels), req.SourceType, req.SourceId, metric.Timestamp)
+	h := metric.Histogram
+
+	bounds := make([]float64, len(h.Buckets))
+	counts := make([]int64, len(h.Buckets))
+	for i, bucket := range h.Buckets {
+		bounds[i], counts[i] = bucket.UpperBound, int64(bucket.Count)
+	}
+
+	e := h.Exponential
+	if err := r.session.Query(insertHistogram, id, req.SourceId, req.SourceType, name, metric.Labels, metric.Timestamp,
+		int64(h.Count), h.Sum, bounds, counts,
+		e != nil, e.GetScale(), e.GetZeroThreshold(), int64(e.GetZeroCount()),
+		e.GetPositiveOffset(), toInt64s(e.GetPositiveCounts()), e.GetNegativeOffset(), toInt64s(e.GetNegativeCounts()),
+		ttlSeconds(ttl),
+	).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	if _, err := r.RegisterSeries(ctx, name, metric.Labels); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteSummary writes a metric carrying a summary to the summaries table
+func (r *Repository) WriteSummary(ctx context.Context, metric *ingestion.MetricData, req *ingestion.IngestDataRequest, ttl time.Duration) error {
+	name, err := storedName(ctx, metric.Name)
 	if err != nil {
-		log.Println(err)
-		return nil, fmt.Errorf("failed to create metrics validation table: %w", err)
+		return err
+	}
+	id := sampleID(SeriesID(name, metric.Labels), req.SourceType, req.SourceId, metric.Timestamp)
+
+	quantiles := make(map[float64]float64, len(metric.Summary.Quantiles))
+	for _, q := range metric.Summary.Quantiles {
+		quantiles[q.Quantile] = q.Value
 	}
 
-	return &Repository{session: session}, nil
+	if err := r.session.Query(insertSummary, id, req.SourceId, req.SourceType, name, metric.Labels, metric.Timestamp,
+		int64(metric.Summary.Count), metric.Summary.Sum, quantiles, ttlSeconds(ttl),
+	).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	if _, err := r.RegisterSeries(ctx, name, metric.Labels); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-// WriteMetric writes a metric to Cassandra
-func (r *Repository) WriteMetric(ctx context.Context, metric *ingestion.MetricData, req *ingestion.IngestDataRequest) error {
-	// Generate a new UUID for the primary key 'id'
-	id := gocql.TimeUUID()
+// ttlSeconds converts a TTL for USING TTL, 0 keeps the row forever
+func ttlSeconds(ttl time.Duration) int {
+	return int(ttl / time.Second)
+}
 
-	// Prepare the CQL query
-	query := `INSERT INTO metrics_keyspace.metrics (
-        id, 
-        source_id, 
-        source_type,
-        metric_name, 
-        metric_value,
-        labels,
-        timestamp
-    ) VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-	// Convert the labels to JSON
+func toInt64s(values []uint64) []int64 {
+	result := make([]int64, len(values))
+	for i, v := range values {
+		result[i] = int64(v)
+	}
+	return result
+}
+
+// WriteExemplar writes the exemplar of a metric with the labels of its series
+func (r *Repository) WriteExemplar(ctx context.Context, metric *ingestion.MetricData, ttl time.Duration) error {
+	query := `INSERT INTO metrics_keyspace.exemplars (
+		metric_name,
+		timestamp,
+		id,
+		labels,
+		exemplar_labels,
+		value
+	) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	name, err := storedName(ctx, metric.Name)
+	if err != nil {
+		return err
+	}
 	labelsJSON, err := json.Marshal(metric.Labels)
 	if err != nil {
 		return fmt.Errorf("failed to marshal labels: %w", err)
 	}
 
-	// Execute the CQL query
-	if err := r.session.Query(query, id, req.SourceId, req.SourceType, metric.Name, metric.Value, labelsJSON, metric.Timestamp).WithContext(ctx).Exec(); err != nil {
-		return fmt.Errorf("failed to execute query: %w", err)
+	exemplar := metric.Exemplar
+	id := exemplarID(SeriesID(name, metric.Labels), exemplar.Timestamp)
+	if err := r.session.Query(query, name, exemplar.Timestamp, id, labelsJSON, exemplar.Labels, exemplar.Value, ttlSeconds(ttl)).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to write exemplar: %w", err)
 	}
 
-	log.Println("Successfully wrote metric to Cassandra")
 	return nil
 }
 
+// QueryExemplars returns the exemplars of a metric between start and end,
+// inclusive, as metrics holding the series labels and the exemplar
+func (r *Repository) QueryExemplars(ctx context.Context, metricName string, start, end time.Time) ([]*ingestion.MetricData, error) {
+	name, err := storedName(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+	var result []*ingestion.MetricData
+
+	query := `SELECT timestamp, labels, exemplar_labels, value FROM metrics_keyspace.exemplars
+		WHERE metric_name = ? AND timestamp >= ? AND timestamp <= ?`
+	iter := r.session.Query(query, name, start, end).WithContext(ctx).Iter()
+
+	var timestamp time.Time
+	var labelsJSON string
+	var exemplarLabels map[string]string
+	var value float64
+	for iter.Scan(&timestamp, &labelsJSON, &exemplarLabels, &value) {
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
+			iter.Close()
+			return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
+		}
+
+		result = append(result, &ingestion.MetricData{
+			Name:      metricName,
+			Labels:    labels,
+			Timestamp: timestamp.UnixMilli(),
+			Exemplar: &ingestion.Exemplar{
+				Labels:    exemplarLabels,
+				Value:     value,
+				Timestamp: timestamp.UnixMilli(),
+			},
+		})
+		exemplarLabels = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to query exemplars: %w", err)
+	}
+
+	return result, nil
+}
+
+// ReadRawSamples reads the raw samples of a metric of the tenant of ctx
+// between start, inclusive, and end, exclusive. An empty metric name reads
+// every registered series of every tenant under its stored name, for the
+// rollup worker. The labels of the samples are canonical JSON, so that they
+// identify the series.
+func (r *Repository) ReadRawSamples(ctx context.Context, metricName string, start, end time.Time) ([]rollup.Sample, error) {
+	var series []Series
+	var err error
+	if metricName == "" {
+		series, err = r.registeredSeries(ctx)
+	} else {
+		nameMatcher, _ := promql.NewMatcher(promql.MatchEqual, "__name__", metricName)
+		series, err = r.LookupSeries(ctx, []*promql.Matcher{nameMatcher})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []rollup.Sample
+	err = r.scanSamples(ctx, series, start, end, func(s Series, timestamp time.Time, value float64) error {
+		labelsJSON, err := canonicalLabels(s.Labels)
+		if err != nil {
+			return err
+		}
+		result = append(result, rollup.Sample{MetricName: s.MetricName, Labels: labelsJSON, Timestamp: timestamp, Value: value})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw samples: %w", err)
+	}
+
+	return result, nil
+}
+
+// ReadRollups reads the rollups of a resolution of a metric of the tenant of
+// ctx starting between start, inclusive, and end, exclusive. An empty metric
+// name reads every metric of every tenant under its stored name, looking up
+// the metrics with rollups in each bucket.
+func (r *Repository) ReadRollups(ctx context.Context, res rollup.Resolution, metricName string, start, end time.Time) ([]rollup.Aggregate, error) {
+	if !start.Before(end) {
+		return nil, nil
+	}
+
+	var stored string
+	if metricName != "" {
+		var err error
+		if stored, err = storedName(ctx, metricName); err != nil {
+			return nil, err
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT metric_name, labels, timestamp, min_value, max_value, sum_value, count, last_value FROM metrics_keyspace.%s
+		WHERE metric_name = ? AND bucket = ? AND timestamp >= ? AND timestamp < ?`, rollupTable(res))
+
+	var result []rollup.Aggregate
+	first, last := rollupBucketRange(res, start, end)
+	for bucket := first; bucket <= last; bucket++ {
+		names := []string{stored}
+		if metricName == "" {
+			var err error
+			if names, err = r.rollupMetrics(ctx, res, bucket); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, name := range names {
+			iter := r.session.Query(query, name, bucket, start, end).WithContext(ctx).Iter()
+			var a rollup.Aggregate
+			for iter.Scan(&a.MetricName, &a.Labels, &a.Timestamp, &a.Min, &a.Max, &a.Sum, &a.Count, &a.Last) {
+				if metricName != "" {
+					a.MetricName = metricName
+				}
+				result = append(result, a)
+			}
+			if err := iter.Close(); err != nil {
+				return nil, fmt.Errorf("failed to read %s rollups: %w", res.Name, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// WriteRollups writes the rollups of a resolution, replacing existing rollups
+// of the same interval, and records their metrics in the buckets
+func (r *Repository) WriteRollups(ctx context.Context, res rollup.Resolution, aggs []rollup.Aggregate) error {
+	query := fmt.Sprintf(`INSERT INTO metrics_keyspace.%s (
+		metric_name,
+		bucket,
+		timestamp,
+		labels,
+		min_value,
+		max_value,
+		sum_value,
+		count,
+		last_value
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, rollupTable(res))
+
+	// The metric is recorded before its rollups, so that the worker finds
+	// every written rollup
+	type bucketMetric struct {
+		bucket int64
+		name   string
+	}
+	recorded := make(map[bucketMetric]struct{})
+	for _, a := range aggs {
+		bucket := rollupBucketOf(res, a.Timestamp)
+		key := bucketMetric{bucket, a.MetricName}
+		if _, ok := recorded[key]; !ok {
+			if err := r.session.Query(insertRollupMetric, res.Name, bucket, a.MetricName).WithContext(ctx).Exec(); err != nil {
+				return fmt.Errorf("failed to record %s rollup metric: %w", res.Name, err)
+			}
+			recorded[key] = struct{}{}
+		}
+
+		if err := r.session.Query(query, a.MetricName, bucket, a.Timestamp, a.Labels, a.Min, a.Max, a.Sum, a.Count, a.Last).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed to write %s rollup: %w", res.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// RollupCheckpoint returns the end of the last rolled up interval of a resolution, zero if none
+func (r *Repository) RollupCheckpoint(ctx context.Context, res rollup.Resolution) (time.Time, error) {
+	var checkpoint time.Time
+	query := `SELECT checkpoint FROM metrics_keyspace.rollup_checkpoints WHERE resolution = ?`
+	if err := r.session.Query(query, res.Name).WithContext(ctx).Scan(&checkpoint); err != nil {
+		if err == gocql.ErrNotFound {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to query rollup checkpoint: %w", err)
+	}
+
+	return checkpoint, nil
+}
+
+// SetRollupCheckpoint stores the end of the last rolled up interval of a resolution
+func (r *Repository) SetRollupCheckpoint(ctx context.Context, res rollup.Resolution, checkpoint time.Time) error {
+	query := `INSERT INTO metrics_keyspace.rollup_checkpoints (resolution, checkpoint) VALUES (?, ?)`
+	if err := r.session.Query(query, res.Name, checkpoint).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to write rollup checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func rollupTable(res rollup.Resolution) string {
+	return "rollups_" + res.Name
+}
+
+// SetRetentionPolicy stores a retention policy of the tenant of ctx,
+// replacing its policy of the same scope and match
+func (r *Repository) SetRetentionPolicy(ctx context.Context, policy retention.Policy) error {
+	query := `INSERT INTO metrics_keyspace.tenant_retention_policies (
+		tenant,
+		scope,
+		match,
+		ttl_seconds,
+		updated_at
+	) VALUES (?, ?, ?, ?, toTimestamp(now()))`
+
+	if err := r.session.Query(query, tenant.FromContext(ctx), string(policy.Scope), policy.Match, int64(policy.TTL/time.Second)).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to set retention policy: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRetentionPolicy deletes the retention policy of a scope and match of
+// the tenant of ctx. The policies set before policies had a tenant belong to
+// the default tenant.
+func (r *Repository) DeleteRetentionPolicy(ctx context.Context, scope retention.Scope, match string) error {
+	id := tenant.FromContext(ctx)
+	query := `DELETE FROM metrics_keyspace.tenant_retention_policies WHERE tenant = ? AND scope = ? AND match = ?`
+	if err := r.session.Query(query, id, string(scope), match).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to delete retention policy: %w", err)
+	}
+	if id == "" {
+		query := `DELETE FROM metrics_keyspace.retention_policies WHERE scope = ? AND match = ?`
+		if err := r.session.Query(query, string(scope), match).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed to delete retention policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ListRetentionPolicies returns the retention policies of every tenant
+func (r *Repository) ListRetentionPolicies(ctx context.Context) ([]retention.Policy, error) {
+	var result []retention.Policy
+
+	iter := r.session.Query(`SELECT tenant, scope, match, ttl_seconds FROM metrics_keyspace.tenant_retention_policies`).WithContext(ctx).Iter()
+	var id, scope, match string
+	var ttl int64
+	for iter.Scan(&id, &scope, &match, &ttl) {
+		result = append(result, retention.Policy{Tenant: id, Scope: retention.Scope(scope), Match: match, TTL: time.Duration(ttl) * time.Second})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	// Policies of the default tenant set before policies had a tenant, unless
+	// they were set again since
+	set := make(map[retention.Policy]bool, len(result))
+	for _, policy := range result {
+		set[retention.Policy{Tenant: policy.Tenant, Scope: policy.Scope, Match: policy.Match}] = true
+	}
+	iter = r.session.Query(`SELECT scope, match, ttl_seconds FROM metrics_keyspace.retention_policies`).WithContext(ctx).Iter()
+	for iter.Scan(&scope, &match, &ttl) {
+		if !set[retention.Policy{Scope: retention.Scope(scope), Match: match}] {
+			result = append(result, retention.Policy{Scope: retention.Scope(scope), Match: match, TTL: time.Duration(ttl) * time.Second})
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	return result, nil
+}
+
+// SweepExpired deletes the samples, histograms, summaries, exemplars and
+// rollups for which expired returns true and returns how many were deleted.
+// Series are passed with their tenant and its metric name, exemplars and
+// rollups without their source. The series left without samples are removed
+// from the registry.
+func (r *Repository) SweepExpired(ctx context.Context, expired func(series retention.Series, timestamp time.Time) bool) (int, error) {
+	started := time.Now()
+	// The series with a sample left, the others are removed from the registry
+	live := make(map[string]struct{})
+	deleted := 0
+	for _, table := range []string{"metrics", "histograms", "summaries"} {
+		iter := r.session.Query(fmt.Sprintf(`SELECT id, source, source_id, source_type, metric_name, labels, label_map, timestamp FROM metrics_keyspace.%s`, table)).WithContext(ctx).Iter()
+
+		var id gocql.UUID
+		var series retention.Series
+		var legacySource, stored, labelsJSON string
+		var labelMap map[string]string
+		var timestamp time.Time
+		for iter.Scan(&id, &series.SourceID, &legacySource, &series.SourceType, &stored, &labelsJSON, &labelMap, &timestamp) {
+			labels, labelsErr := rowLabels(labelsJSON, labelMap)
+			labelMap = nil
+			if series.SourceID == "" {
+				series.SourceID = legacySource
+			}
+			series.Tenant, series.MetricName = tenant.Split(stored)
+			if !expired(series, timestamp) {
+				if labelsErr == nil {
+					live[SeriesID(stored, labels)] = struct{}{}
+				}
+				continue
+			}
+			if err := r.session.Query(fmt.Sprintf(`DELETE FROM metrics_keyspace.%s WHERE id = ?`, table), id).WithContext(ctx).Exec(); err != nil {
+				iter.Close()
+				return deleted, fmt.Errorf("failed to delete from %s: %w", table, err)
+			}
+			// The copy of the sample in the partition of its series
+			if table == "metrics" && labelsErr == nil {
+				query := `DELETE FROM metrics_keyspace.samples WHERE series_id = ? AND bucket = ? AND timestamp = ?`
+				if err := r.session.Query(query, SeriesID(stored, labels), bucketOf(timestamp.UnixMilli()), timestamp).WithContext(ctx).Exec(); err != nil {
+					iter.Close()
+					return deleted, fmt.Errorf("failed to delete from samples: %w", err)
+				}
+			}
+			deleted++
+		}
+		if err := iter.Close(); err != nil {
+			return deleted, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+	}
+
+	iter := r.session.Query(`SELECT metric_name, timestamp, id FROM metrics_keyspace.exemplars`).WithContext(ctx).Iter()
+	var metricName string
+	var timestamp time.Time
+	var id gocql.UUID
+	for iter.Scan(&metricName, &timestamp, &id) {
+		if owner, name := tenant.Split(metricName); !expired(retention.Series{Tenant: owner, MetricName: name}, timestamp) {
+			continue
+		}
+		query := `DELETE FROM metrics_keyspace.exemplars WHERE metric_name = ? AND timestamp = ? AND id = ?`
+		if err := r.session.Query(query, metricName, timestamp, id).WithContext(ctx).Exec(); err != nil {
+			iter.Close()
+			return deleted, fmt.Errorf("failed to delete from exemplars: %w", err)
+		}
+		deleted++
+	}
+	if err := iter.Close(); err != nil {
+		return deleted, fmt.Errorf("failed to scan exemplars: %w", err)
+	}
+
+	// Rollups are resolved without a source, like exemplars
+	for _, res := range rollup.Resolutions {
+		table := rollupTable(res)
+		iter := r.session.Query(fmt.Sprintf(`SELECT metric_name, bucket, timestamp, labels FROM metrics_keyspace.%s`, table)).WithContext(ctx).Iter()
+		var bucket int64
+		var labels string
+		for iter.Scan(&metricName, &bucket, &timestamp, &labels) {
+			if owner, name := tenant.Split(metricName); !expired(retention.Series{Tenant: owner, MetricName: name}, timestamp) {
+				continue
+			}
+			query := fmt.Sprintf(`DELETE FROM metrics_keyspace.%s WHERE metric_name = ? AND bucket = ? AND timestamp = ? AND labels = ?`, table)
+			if err := r.session.Query(query, metricName, bucket, timestamp, labels).WithContext(ctx).Exec(); err != nil {
+				iter.Close()
+				return deleted, fmt.Errorf("failed to delete from %s: %w", table, err)
+			}
+			deleted++
+		}
+		if err := iter.Close(); err != nil {
+			return deleted, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+	}
+
+	if err := r.sweepSeries(ctx, live, started); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
+
+// sweepSeries removes the series without a sample left, swept or expired
+// through their TTL, from the registry and the postings. The deletes are
+// written with the timestamp of sweepDeleteTime, so that they do not shadow a
+// registration a write missed by the scan relies on.
+func (r *Repository) sweepSeries(ctx context.Context, live map[string]struct{}, started time.Time) error {
+	iter := r.session.Query(`SELECT series_id, metric_name, labels FROM metrics_keyspace.series`).WithContext(ctx).Iter()
+
+	deleted := sweepDeleteTime(started).UnixMicro()
+	var s Series
+	for iter.Scan(&s.ID, &s.MetricName, &s.Labels) {
+		if _, ok := live[s.ID]; !ok {
+			if err := r.deleteSeries(ctx, s, deleted); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+		s = Series{}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("failed to scan series: %w", err)
+	}
+	return nil
+}
+
+// deleteSeries deletes a series and then its postings at a timestamp in
+// microseconds, the reverse of RegisterSeries, so that a lookup never finds a
+// series without postings
+func (r *Repository) deleteSeries(ctx context.Context, s Series, timestamp int64) error {
+	if err := r.session.Query(`DELETE FROM metrics_keyspace.series WHERE series_id = ?`, s.ID).WithTimestamp(timestamp).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to delete series: %w", err)
+	}
+
+	posting := `DELETE FROM metrics_keyspace.series_by_label WHERE name = ? AND value = ? AND series_id = ?`
+	if err := r.session.Query(posting, "__name__", s.MetricName, s.ID).WithTimestamp(timestamp).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to delete series posting: %w", err)
+	}
+	for name, value := range s.Labels {
+		if err := r.session.Query(posting, name, value, s.ID).WithTimestamp(timestamp).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed to delete series posting: %w", err)
+		}
+	}
+	return nil
+}
+
+// RegisterMetricMetadata stores the metadata of a metric of the tenant of ctx, replacing earlier metadata
+func (r *Repository) RegisterMetricMetadata(ctx context.Context, metadata *ingestion.MetricMetadata) error {
+	name, err := storedName(ctx, metadata.MetricName)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO metrics_keyspace.metric_metadata (
+		metric_name,
+		type,
+		unit,
+		help,
+		owner,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, toTimestamp(now()))`
+
+	if err := r.session.Query(query, name, metadata.Type.String(), metadata.Unit, metadata.Help, metadata.Owner).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to register metric metadata: %w", err)
+	}
+
+	return nil
+}
+
+// GetMetricMetadata returns the metadata of a metric of the tenant of ctx, nil if none is registered
+func (r *Repository) GetMetricMetadata(ctx context.Context, metricName string) (*ingestion.MetricMetadata, error) {
+	name, err := storedName(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &ingestion.MetricMetadata{MetricName: metricName}
+	var metricType string
+
+	query := `SELECT type, unit, help, owner FROM metrics_keyspace.metric_metadata WHERE metric_name = ?`
+	if err := r.session.Query(query, name).WithContext(ctx).Scan(&metricType, &metadata.Unit, &metadata.Help, &metadata.Owner); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query metric metadata: %w", err)
+	}
+	metadata.Type = ingestion.MetricType(ingestion.MetricType_value[metricType])
+
+	return metadata, nil
+}
+
+// ListMetricMetadata returns the metadata of every registered metric of the tenant of ctx
+func (r *Repository) ListMetricMetadata(ctx context.Context) ([]*ingestion.MetricMetadata, error) {
+	var result []*ingestion.MetricMetadata
+
+	iter := r.session.Query(`SELECT metric_name, type, unit, help, owner FROM metrics_keyspace.metric_metadata`).WithContext(ctx).Iter()
+	var metricName, metricType, unit, help, owner string
+	for iter.Scan(&metricName, &metricType, &unit, &help, &owner) {
+		name, ok := tenantName(ctx, metricName)
+		if !ok {
+			continue
+		}
+		result = append(result, &ingestion.MetricMetadata{
+			MetricName: name,
+			Type:       ingestion.MetricType(ingestion.MetricType_value[metricType]),
+			Unit:       unit,
+			Help:       help,
+			Owner:      owner,
+		})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to list metric metadata: %w", err)
+	}
+
+	return result, nil
+}
+
 // AddMetricValidation adds a validation rule to the metric_validation table
 func (r *Repository) AddMetricValidation(ctx context.Context, validation *ingestion.NewValidationRequest) error {
+	name, err := storedName(ctx, validation.MetricName)
+	if err != nil {
+		return err
+	}
 	id := gocql.TimeUUID()
 
-	query := `INSERT INTO metrics_keyspace.metric_validation (
-		id,
-		metric_name, 
-		source_id, 
-		min_value, 
-		max_value
-		) VALUES (?, ?, ?, ?, ?)`
-
-	if err := r.session.Query(query, id, validation.MetricName, validation.SourceId, validation.MinValue, validation.MaxValue).WithContext(ctx).Exec(); err != nil {
+	if err := r.session.Query(insertValidation, id, name, validation.SourceId, validation.MinValue, validation.MaxValue).WithContext(ctx).Exec(); err != nil {
 		return fmt.Errorf("failed to add validation: %w", err)
 	}
 
@@ -122,10 +725,14 @@ func (r *Repository) AddMetricValidation(ctx context.Context, validation *ingest
 
 // ValidateMetric checks if the given metric value is within the predefined range
 func (r *Repository) ValidateMetric(ctx context.Context, metricName, sourceId string, metricValue float64) (bool, string, error) {
+	name, err := storedName(ctx, metricName)
+	if err != nil {
+		return false, "", err
+	}
 	var minVal, maxVal float64
 
-	query := `SELECT min_value, max_value FROM metrics_keyspace.metric_validation WHERE metric_name = ? AND source_id = ? LIMIT 1`
-	if err := r.session.Query(query, metricName, sourceId).Scan(&minVal, &maxVal); err != nil {
+	query := `SELECT min_value, max_value FROM metrics_keyspace.metric_validation WHERE metric_name = ? AND source = ? LIMIT 1 ALLOW FILTERING`
+	if err := r.session.Query(query, name, sourceId).Scan(&minVal, &maxVal); err != nil {
 		if err == gocql.ErrNotFound {
 			return false, "Validation rule not found for metric", nil
 		}
@@ -0,0 +1,81 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yay14/pulse/internal/rollup"
+)
+
+// rollupBucketIntervals is the number of intervals of a resolution in a
+// partition of its rollup table, a day of 1m rollups
+const rollupBucketIntervals = 1440
+
+// rollupBackfillBatch is the number of rollups copied with one WriteRollups
+const rollupBackfillBatch = 1000
+
+const insertRollupMetric = `INSERT INTO metrics_keyspace.rollup_metrics (resolution, bucket, metric_name) VALUES (?, ?, ?)`
+
+// rollupBucketOf returns the bucket of the partition holding the rollup of a
+// resolution starting at timestamp
+func rollupBucketOf(res rollup.Resolution, timestamp time.Time) int64 {
+	return timestamp.UnixMilli() / (res.Step * rollupBucketIntervals).Milliseconds()
+}
+
+// rollupBucketRange returns the first and last bucket of the rollups of a
+// resolution starting between start, inclusive, and end, exclusive
+func rollupBucketRange(res rollup.Resolution, start, end time.Time) (int64, int64) {
+	return rollupBucketOf(res, start), rollupBucketOf(res, end.Add(-time.Millisecond))
+}
+
+// rollupMetrics returns the stored names of the metrics with rollups of a
+// resolution in a bucket
+func (r *Repository) rollupMetrics(ctx context.Context, res rollup.Resolution, bucket int64) ([]string, error) {
+	iter := r.session.Query(`SELECT metric_name FROM metrics_keyspace.rollup_metrics WHERE resolution = ? AND bucket = ?`,
+		res.Name, bucket).WithContext(ctx).Iter()
+
+	var names []string
+	var name string
+	for iter.Scan(&name) {
+		names = append(names, name)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read %s rollup metrics: %w", res.Name, err)
+	}
+	return names, nil
+}
+
+// BackfillRollups copies the rollups written before the rollup tables were
+// partitioned by bucket into them and returns how many rollups were copied
+func (r *Repository) BackfillRollups(ctx context.Context) (int, error) {
+	copied := 0
+	for _, res := range rollup.Resolutions {
+		iter := r.session.Query(fmt.Sprintf(`SELECT metric_name, labels, timestamp, min_value, max_value, sum_value, count, last_value
+			FROM metrics_keyspace.metrics_%s`, res.Name)).WithContext(ctx).Iter()
+
+		var batch []rollup.Aggregate
+		var a rollup.Aggregate
+		for iter.Scan(&a.MetricName, &a.Labels, &a.Timestamp, &a.Min, &a.Max, &a.Sum, &a.Count, &a.Last) {
+			batch = append(batch, a)
+			if len(batch) < rollupBackfillBatch {
+				continue
+			}
+			if err := r.WriteRollups(ctx, res, batch); err != nil {
+				iter.Close()
+				return copied, err
+			}
+			copied += len(batch)
+			batch = batch[:0]
+		}
+		if err := iter.Close(); err != nil {
+			return copied, fmt.Errorf("failed to scan metrics_%s: %w", res.Name, err)
+		}
+		if err := r.WriteRollups(ctx, res, batch); err != nil {
+			return copied, err
+		}
+		copied += len(batch)
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,39 @@
+package cassandra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/internal/rollup"
+)
+
+func Test_rollupBucketRange(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	minute := rollup.Resolution{Name: "1m", Step: time.Minute}
+	hour := rollup.Resolution{Name: "1h", Step: time.Hour}
+
+	tests := []struct {
+		name      string
+		res       rollup.Resolution
+		start     time.Time
+		end       time.Time
+		wantFirst int64
+		wantLast  int64
+	}{
+		{name: "batch of 1m rollups", res: minute, start: day.Add(time.Hour), end: day.Add(2 * time.Hour), wantFirst: rollupBucketOf(minute, day), wantLast: rollupBucketOf(minute, day)},
+		{name: "end at the next bucket is exclusive", res: minute, start: day, end: day.Add(24 * time.Hour), wantFirst: rollupBucketOf(minute, day), wantLast: rollupBucketOf(minute, day)},
+		{name: "across buckets", res: minute, start: day.Add(23 * time.Hour), end: day.Add(25 * time.Hour), wantFirst: rollupBucketOf(minute, day), wantLast: rollupBucketOf(minute, day) + 1},
+		{name: "1h buckets span 60 days", res: hour, start: day, end: day.Add(7 * 24 * time.Hour), wantFirst: rollupBucketOf(hour, day), wantLast: rollupBucketOf(hour, day.Add(7*24*time.Hour-time.Millisecond))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotFirst, gotLast := rollupBucketRange(tt.res, tt.start, tt.end); gotFirst != tt.wantFirst || gotLast != tt.wantLast {
+				t.Errorf("rollupBucketRange() = %d, %d, want %d, %d", gotFirst, gotLast, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+
+	if got := rollupBucketOf(hour, day.Add(60*24*time.Hour)) - rollupBucketOf(hour, day); got != 1 {
+		t.Errorf("1h buckets 60 days apart differ by %d, want 1", got)
+	}
+}
@@ -0,0 +1,116 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// sampleBucket is the time span of a partition of the samples table
+const sampleBucket = 24 * time.Hour
+
+const insertSample = `INSERT INTO metrics_keyspace.samples (
+		series_id,
+		bucket,
+		timestamp,
+		value
+	) VALUES (?, ?, ?, ?) USING TTL ?`
+
+// bucketOf returns the bucket of the samples partition holding a timestamp
+// in milliseconds
+func bucketOf(timestamp int64) int64 {
+	return timestamp / sampleBucket.Milliseconds()
+}
+
+// bucketRange returns the first and last bucket of the samples between start,
+// inclusive, and end, exclusive
+func bucketRange(start, end time.Time) (int64, int64) {
+	return bucketOf(start.UnixMilli()), bucketOf(end.UnixMilli() - 1)
+}
+
+// writeSample writes a raw sample to the partition of its series and day
+func (r *Repository) writeSample(ctx context.Context, seriesID string, timestamp int64, value float64, ttl time.Duration) error {
+	if err := r.session.Query(insertSample, seriesID, bucketOf(timestamp), timestamp, value, ttlSeconds(ttl)).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to write sample: %w", err)
+	}
+	return nil
+}
+
+// scanSamples calls fn for every raw sample of the series between start,
+// inclusive, and end, exclusive, in the order of the series and timestamps
+func (r *Repository) scanSamples(ctx context.Context, series []Series, start, end time.Time, fn func(s Series, timestamp time.Time, value float64) error) error {
+	if !start.Before(end) {
+		return nil
+	}
+	first, last := bucketRange(start, end)
+
+	for _, s := range series {
+		for bucket := first; bucket <= last; bucket++ {
+			iter := r.session.Query(`SELECT timestamp, value FROM metrics_keyspace.samples
+				WHERE series_id = ? AND bucket = ? AND timestamp >= ? AND timestamp < ?`, s.ID, bucket, start, end).WithContext(ctx).Iter()
+
+			var timestamp time.Time
+			var value float64
+			for iter.Scan(&timestamp, &value) {
+				if err := fn(s, timestamp, value); err != nil {
+					iter.Close()
+					return err
+				}
+			}
+			if err := iter.Close(); err != nil {
+				return fmt.Errorf("failed to read samples of series %s: %w", s.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// registeredSeries returns every registered series of every tenant under its stored name
+func (r *Repository) registeredSeries(ctx context.Context) ([]Series, error) {
+	iter := r.session.Query(`SELECT series_id, metric_name, labels FROM metrics_keyspace.series`).WithContext(ctx).Iter()
+
+	var result []Series
+	var s Series
+	for iter.Scan(&s.ID, &s.MetricName, &s.Labels) {
+		result = append(result, s)
+		s = Series{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read series: %w", err)
+	}
+	return result, nil
+}
+
+// BackfillSamples copies the samples written before the samples table
+// existed into it, keeping the remaining TTL of the rows, and returns how
+// many samples were copied
+func (r *Repository) BackfillSamples(ctx context.Context) (int, error) {
+	iter := r.session.Query(`SELECT metric_name, labels, label_map, timestamp, metric_value, TTL(metric_value) FROM metrics_keyspace.metrics`).WithContext(ctx).Iter()
+
+	copied := 0
+	var name, labelsJSON string
+	var labelMap map[string]string
+	var timestamp time.Time
+	var value float64
+	var ttl int
+	for iter.Scan(&name, &labelsJSON, &labelMap, &timestamp, &value, &ttl) {
+		labels, err := rowLabels(labelsJSON, labelMap)
+		labelMap = nil
+		if err != nil {
+			log.Printf("Skipping sample of %s with invalid labels: %v", name, err)
+			continue
+		}
+
+		if err := r.writeSample(ctx, SeriesID(name, labels), timestamp.UnixMilli(), value, time.Duration(ttl)*time.Second); err != nil {
+			iter.Close()
+			return copied, err
+		}
+		copied++
+	}
+	if err := iter.Close(); err != nil {
+		return copied, fmt.Errorf("failed to scan metrics: %w", err)
+	}
+
+	return copied, nil
+}
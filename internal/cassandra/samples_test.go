@@ -0,0 +1,31 @@
+package cassandra
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_bucketRange(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	first := bucketOf(day.UnixMilli())
+
+	tests := []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		wantFirst int64
+		wantLast  int64
+	}{
+		{name: "within a day", start: day.Add(time.Hour), end: day.Add(2 * time.Hour), wantFirst: first, wantLast: first},
+		{name: "end at midnight is exclusive", start: day, end: day.Add(24 * time.Hour), wantFirst: first, wantLast: first},
+		{name: "across midnight", start: day.Add(23 * time.Hour), end: day.Add(25 * time.Hour), wantFirst: first, wantLast: first + 1},
+		{name: "a week", start: day, end: day.Add(7*24*time.Hour + time.Millisecond), wantFirst: first, wantLast: first + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotFirst, gotLast := bucketRange(tt.start, tt.end); gotFirst != tt.wantFirst || gotLast != tt.wantLast {
+				t.Errorf("bucketRange() = %d, %d, want %d, %d", gotFirst, gotLast, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
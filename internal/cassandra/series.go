@@ -0,0 +1,309 @@
+package cassandra
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yay14/pulse/internal/promql"
+)
+
+// maxKnownSeries bounds the series remembered as registered, the set is
+// cleared when it is full and the series are registered again
+const maxKnownSeries = 1 << 20
+
+// seriesCacheTTL is how long a registered series is remembered before it is
+// registered again
+const seriesCacheTTL = 10 * time.Minute
+
+// seriesLookupBatch is the number of series read with a single IN query
+const seriesLookupBatch = 100
+
+// Series is a registered series, identified by its metric name and labels
+type Series struct {
+	ID         string
+	MetricName string
+	Labels     map[string]string
+}
+
+// SeriesID returns the stable ID of the series with a metric name and labels,
+// the ID does not depend on the order of the labels
+func SeriesID(metricName string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Label names and values cannot contain 0xff in valid UTF-8, so the
+	// separator keeps {a="bc"} and {ab="c"} apart
+	h := sha256.New()
+	h.Write([]byte(metricName))
+	for _, name := range names {
+		h.Write([]byte{0xff})
+		h.Write([]byte(name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[name]))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// sampleID returns the row ID of the sample a source wrote for a series at a
+// timestamp, so that writing the sample again, when a write is retried or
+// replayed from the WAL, overwrites its row instead of duplicating it
+func sampleID(seriesID, sourceType, source string, timestamp int64) gocql.UUID {
+	h := sha256.New()
+	for _, field := range []string{seriesID, sourceType, source} {
+		h.Write([]byte(field))
+		h.Write([]byte{0xff})
+	}
+	binary.Write(h, binary.BigEndian, timestamp)
+
+	var id gocql.UUID
+	copy(id[:], h.Sum(nil))
+	id[6] = id[6]&0x0f | 0x50 // Name-based version
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id
+}
+
+// exemplarID returns the TIMEUUID of the exemplar of a series at a timestamp,
+// with the clock sequence and node derived from the series instead of random
+func exemplarID(seriesID string, timestamp int64) gocql.UUID {
+	id := gocql.UUIDFromTime(time.UnixMilli(timestamp))
+	sum := sha256.Sum256([]byte(seriesID))
+	copy(id[8:], sum[:8])
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// seriesCache remembers when series were registered so the postings are not
+// written again for every sample. Entries expire after seriesCacheTTL, so that
+// a series is registered again while it is written.
+type seriesCache struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func newSeriesCache() *seriesCache {
+	return &seriesCache{ids: make(map[string]time.Time)}
+}
+
+// contains reports whether the series was registered within seriesCacheTTL of now
+func (c *seriesCache) contains(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	registered, ok := c.ids[id]
+	return ok && now.Sub(registered) < seriesCacheTTL
+}
+
+func (c *seriesCache) add(id string, registered time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ids) >= maxKnownSeries {
+		c.ids = make(map[string]time.Time)
+	}
+	c.ids[id] = registered
+}
+
+// sweepDeleteTime returns the write time of the deletes of a sweep started at
+// started. A sample the scan of the sweep missed was written after it started,
+// relying on a registration written less than seriesCacheTTL before, which is
+// newer than the deletes and therefore not removed by them.
+func sweepDeleteTime(started time.Time) time.Time {
+	return started.Add(-seriesCacheTTL)
+}
+
+// RegisterSeries adds a series to the registry and the series_by_label
+// postings, including a __name__ posting for the metric name, and returns its
+// ID. The metric name is the stored name, prefixed with the tenant. The rows
+// are written again once the cache entry expired, which keeps them newer than
+// the deletes of the sweeper, see sweepSeries.
+func (r *Repository) RegisterSeries(ctx context.Context, metricName string, labels map[string]string) (string, error) {
+	id := SeriesID(metricName, labels)
+	now := time.Now()
+	if r.series.contains(id, now) {
+		return id, nil
+	}
+
+	// Postings are written before the series so a lookup never finds a
+	// series row whose postings are missing
+	posting := `INSERT INTO metrics_keyspace.series_by_label (name, value, series_id) VALUES (?, ?, ?)`
+	if err := r.session.Query(posting, "__name__", metricName, id).WithContext(ctx).Exec(); err != nil {
+		return "", fmt.Errorf("failed to write series posting: %w", err)
+	}
+	for name, value := range labels {
+		if err := r.session.Query(posting, name, value, id).WithContext(ctx).Exec(); err != nil {
+			return "", fmt.Errorf("failed to write series posting: %w", err)
+		}
+	}
+
+	query := `INSERT INTO metrics_keyspace.series (series_id, metric_name, labels) VALUES (?, ?, ?)`
+	if err := r.session.Query(query, id, metricName, labels).WithContext(ctx).Exec(); err != nil {
+		return "", fmt.Errorf("failed to register series: %w", err)
+	}
+
+	r.series.add(id, now)
+	return id, nil
+}
+
+// LookupSeries returns the registered series of the tenant of ctx matching
+// every matcher. The series are found by intersecting the postings of the
+// equality matchers with a non-empty value, at least one is required, and
+// the remaining matchers are applied to the labels of the candidates.
+func (r *Repository) LookupSeries(ctx context.Context, matchers []*promql.Matcher) ([]Series, error) {
+	postings, filters := splitMatchers(matchers)
+	if len(postings) == 0 {
+		return nil, fmt.Errorf("at least one equality matcher with a non-empty value is required")
+	}
+
+	var candidates []string
+	for i, m := range postings {
+		value := m.Value
+		if m.Name == "__name__" {
+			var err error
+			if value, err = storedName(ctx, value); err != nil {
+				return nil, err
+			}
+		}
+		ids, err := r.postings(ctx, m.Name, value)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			candidates = ids
+		} else {
+			candidates = intersectSorted(candidates, ids)
+		}
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+	}
+
+	var result []Series
+	for start := 0; start < len(candidates); start += seriesLookupBatch {
+		end := start + seriesLookupBatch
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		iter := r.session.Query(`SELECT series_id, metric_name, labels FROM metrics_keyspace.series WHERE series_id IN ?`,
+			candidates[start:end]).WithContext(ctx).Iter()
+		var s Series
+		for iter.Scan(&s.ID, &s.MetricName, &s.Labels) {
+			// Postings of labels are shared by the series of every tenant
+			var ok bool
+			if s.MetricName, ok = tenantName(ctx, s.MetricName); ok && seriesMatches(filters, s) {
+				result = append(result, s)
+			}
+			s = Series{}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, fmt.Errorf("failed to read series: %w", err)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// postings returns the sorted IDs of the series with a label value
+func (r *Repository) postings(ctx context.Context, name, value string) ([]string, error) {
+	iter := r.session.Query(`SELECT series_id FROM metrics_keyspace.series_by_label WHERE name = ? AND value = ?`,
+		name, value).WithContext(ctx).Iter()
+
+	var ids []string
+	var id string
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read postings of %s=%q: %w", name, value, err)
+	}
+
+	// Clustering order already sorts the IDs of a partition, sorting keeps
+	// intersectSorted independent of it
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// splitMatchers separates the matchers answered by postings, equality
+// matchers with a non-empty value, from the ones applied to series labels.
+// An equality matcher with an empty value selects series without the label,
+// which have no posting.
+func splitMatchers(matchers []*promql.Matcher) (postings, filters []*promql.Matcher) {
+	for _, m := range matchers {
+		if m.Type == promql.MatchEqual && m.Value != "" {
+			postings = append(postings, m)
+		} else {
+			filters = append(filters, m)
+		}
+	}
+	return postings, filters
+}
+
+// intersectSorted returns the IDs present in both sorted slices
+func intersectSorted(a, b []string) []string {
+	var result []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// seriesMatches reports whether every matcher matches a series
+func seriesMatches(matchers []*promql.Matcher, s Series) bool {
+	labels := make(map[string]string, len(s.Labels)+1)
+	for name, value := range s.Labels {
+		labels[name] = value
+	}
+	labels["__name__"] = s.MetricName
+	return promql.MatchLabels(matchers, labels)
+}
+
+// BackfillSeries registers the series of the samples written before the
+// series registry existed and returns how many series were registered
+func (r *Repository) BackfillSeries(ctx context.Context) (int, error) {
+	registered := 0
+	for _, table := range labelTables {
+		iter := r.session.Query(fmt.Sprintf(`SELECT metric_name, labels, label_map FROM metrics_keyspace.%s`, table)).WithContext(ctx).Iter()
+
+		var name, labelsJSON string
+		var labelMap map[string]string
+		for iter.Scan(&name, &labelsJSON, &labelMap) {
+			labels, err := rowLabels(labelsJSON, labelMap)
+			labelMap = nil
+			if err != nil {
+				continue
+			}
+
+			if r.series.contains(SeriesID(name, labels), time.Now()) {
+				continue
+			}
+			if _, err := r.RegisterSeries(ctx, name, labels); err != nil {
+				iter.Close()
+				return registered, err
+			}
+			registered++
+		}
+		if err := iter.Close(); err != nil {
+			return registered, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+	}
+
+	return registered, nil
+}
@@ -0,0 +1,165 @@
+package cassandra
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yay14/pulse/internal/promql"
+)
+
+func TestSeriesID(t *testing.T) {
+	id := SeriesID("http_requests_total", map[string]string{"env": "prod", "service": "checkout"})
+	if len(id) != 32 {
+		t.Errorf("SeriesID() = %q, want 32 hex characters", id)
+	}
+
+	tests := []struct {
+		name       string
+		metricName string
+		labels     map[string]string
+		wantSame   bool
+	}{
+		{name: "same labels", metricName: "http_requests_total", labels: map[string]string{"service": "checkout", "env": "prod"}, wantSame: true},
+		{name: "different value", metricName: "http_requests_total", labels: map[string]string{"env": "dev", "service": "checkout"}},
+		{name: "different metric", metricName: "http_requests", labels: map[string]string{"env": "prod", "service": "checkout"}},
+		{name: "missing label", metricName: "http_requests_total", labels: map[string]string{"env": "prod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SeriesID(tt.metricName, tt.labels); (got == id) != tt.wantSame {
+				t.Errorf("SeriesID() = %q, first ID %q, want same %v", got, id, tt.wantSame)
+			}
+		})
+	}
+
+	if SeriesID("up", map[string]string{"a": "bc"}) == SeriesID("up", map[string]string{"ab": "c"}) {
+		t.Error("SeriesID() is the same for {a=\"bc\"} and {ab=\"c\"}")
+	}
+	if SeriesID("up", nil) != SeriesID("up", map[string]string{}) {
+		t.Error("SeriesID() differs for nil and empty labels")
+	}
+}
+
+func Test_sampleID(t *testing.T) {
+	series := SeriesID("up", map[string]string{"job": "node"})
+	id := sampleID(series, "prometheus", "node-1", 1700000000000)
+	if id != sampleID(series, "prometheus", "node-1", 1700000000000) {
+		t.Error("sampleID() differs for the same sample")
+	}
+	if id.Version() != 5 || id.Variant() != gocql.VariantIETF {
+		t.Errorf("sampleID() = %v, want an RFC 4122 version 5 UUID", id)
+	}
+	for _, other := range []gocql.UUID{
+		sampleID(series, "prometheus", "node-1", 1700000001000),
+		sampleID(series, "prometheus", "node-2", 1700000000000),
+		sampleID(SeriesID("up", map[string]string{"job": "api"}), "prometheus", "node-1", 1700000000000),
+	} {
+		if other == id {
+			t.Errorf("sampleID() = %v for a different sample", other)
+		}
+	}
+}
+
+func Test_exemplarID(t *testing.T) {
+	series := SeriesID("up", map[string]string{"job": "node"})
+	id := exemplarID(series, 1700000000123)
+	if id != exemplarID(series, 1700000000123) {
+		t.Error("exemplarID() differs for the same exemplar")
+	}
+	if id.Version() != 1 || id.Variant() != gocql.VariantIETF {
+		t.Errorf("exemplarID() = %v, want a TIMEUUID", id)
+	}
+	if got := id.Time(); !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("exemplarID() time = %v, want the exemplar timestamp", got)
+	}
+}
+
+func Test_intersectSorted(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "overlap", a: []string{"a", "b", "d"}, b: []string{"b", "c", "d"}, want: []string{"b", "d"}},
+		{name: "disjoint", a: []string{"a"}, b: []string{"b"}},
+		{name: "empty", a: nil, b: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intersectSorted(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intersectSorted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitMatchers(t *testing.T) {
+	matchers, err := promql.ParseSelector(`http_requests_total{env="prod",service=~"check.*",zone=""}`)
+	if err != nil {
+		t.Fatalf("ParseSelector() error = %v", err)
+	}
+
+	postings, filters := splitMatchers(matchers)
+	var got []string
+	for _, m := range postings {
+		got = append(got, m.String())
+	}
+	if want := []string{`__name__="http_requests_total"`, `env="prod"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitMatchers() postings = %v, want %v", got, want)
+	}
+	if len(filters) != 2 {
+		t.Errorf("splitMatchers() filters = %v, want the regexp and empty matchers", filters)
+	}
+
+	s := Series{MetricName: "http_requests_total", Labels: map[string]string{"env": "prod", "service": "checkout"}}
+	if !seriesMatches(filters, s) {
+		t.Errorf("seriesMatches() = false for %v", s)
+	}
+	s.Labels["zone"] = "eu"
+	if seriesMatches(filters, s) {
+		t.Errorf("seriesMatches() = true for %v with a zone label", s)
+	}
+}
+
+func Test_seriesCache(t *testing.T) {
+	c := newSeriesCache()
+	registered := time.Unix(1000, 0)
+	if c.contains("a", registered) {
+		t.Fatal("contains() = true before add()")
+	}
+	c.add("a", registered)
+	if !c.contains("a", registered.Add(seriesCacheTTL-time.Second)) {
+		t.Error("contains() = false within seriesCacheTTL after add()")
+	}
+	if c.contains("a", registered.Add(seriesCacheTTL)) {
+		t.Error("contains() = true seriesCacheTTL after add()")
+	}
+}
+
+func Test_sweepDeleteTime(t *testing.T) {
+	started := time.Unix(10000, 0)
+	deleted := sweepDeleteTime(started)
+
+	// A write racing the sweep, at or after its start, registers the series
+	// again or relies on a cached registration, which must be newer than the
+	// deletes of the sweep to survive them
+	for _, registered := range []time.Duration{-2 * seriesCacheTTL, -seriesCacheTTL, -seriesCacheTTL + time.Second, -time.Second, 0} {
+		c := newSeriesCache()
+		c.add("a", started.Add(registered))
+		for _, written := range []time.Duration{0, time.Second, seriesCacheTTL / 2} {
+			at := started.Add(written)
+			if !c.contains("a", at) {
+				// The write registers the series again at its own time
+				if !at.After(deleted) {
+					t.Errorf("registration at %v is not newer than the deletes at %v", at, deleted)
+				}
+				continue
+			}
+			if !started.Add(registered).After(deleted) {
+				t.Errorf("write at %v relies on a registration at %v, not newer than the deletes at %v", at, started.Add(registered), deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gocql/gocql"
+)
+
+// BackfillSources copies the source_id UUIDs of rows written before the
+// source columns existed into source as text, keeping the remaining TTL of
+// the rows, and returns how many rows were converted
+func (r *Repository) BackfillSources(ctx context.Context) (int, error) {
+	converted := 0
+	for _, table := range []string{"metrics", "histograms", "summaries", "metric_validation"} {
+		// Every table has a column that is set on insert, its TTL is the TTL of the row
+		ttlColumn := "sample_count"
+		switch table {
+		case "metrics":
+			ttlColumn = "metric_value"
+		case "metric_validation":
+			ttlColumn = "min_value"
+		}
+
+		iter := r.session.Query(fmt.Sprintf(`SELECT id, source_id, source, TTL(%s) FROM metrics_keyspace.%s`, ttlColumn, table)).WithContext(ctx).Iter()
+		update := fmt.Sprintf(`UPDATE metrics_keyspace.%s USING TTL ? SET source = ? WHERE id = ?`, table)
+
+		var id gocql.UUID
+		var legacySource, source string
+		var ttl int
+		for iter.Scan(&id, &legacySource, &source, &ttl) {
+			if source != "" || legacySource == "" {
+				continue
+			}
+			if err := r.session.Query(update, ttl, legacySource, id).WithContext(ctx).Exec(); err != nil {
+				iter.Close()
+				return converted, fmt.Errorf("failed to backfill sources of %s: %w", table, err)
+			}
+			converted++
+		}
+		if err := iter.Close(); err != nil {
+			return converted, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+	}
+
+	return converted, nil
+}
@@ -0,0 +1,83 @@
+package cassandra
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+// migratedColumns returns the CQL types of the columns of every table after
+// applying the migrations
+func migratedColumns(t *testing.T) map[string]map[string]string {
+	t.Helper()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+
+	tables := make(map[string]map[string]string)
+	for _, m := range migrations {
+		for _, statement := range m.Statements {
+			fields := strings.Fields(statement)
+			switch {
+			case strings.HasPrefix(statement, "CREATE TABLE"):
+				table := fields[2]
+				if table == "IF" {
+					table = fields[5]
+				}
+				columns := make(map[string]string)
+				body := statement[strings.Index(statement, "(")+1:]
+				for _, line := range strings.Split(body, "\n") {
+					column := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ","))
+					if len(column) >= 2 && column[0] != "PRIMARY" {
+						columns[column[0]] = strings.ToUpper(column[1])
+					}
+				}
+				tables[table] = columns
+			case strings.HasPrefix(statement, "ALTER TABLE") && fields[3] == "ADD":
+				tables[fields[2]][fields[4]] = strings.ToUpper(fields[5])
+			}
+		}
+	}
+	return tables
+}
+
+func TestInsertQueries_SourceColumn(t *testing.T) {
+	tables := migratedColumns(t)
+	insert := regexp.MustCompile(`INSERT INTO (\S+) \(([^)]*)\)`)
+	types := map[string]gocql.Type{"TEXT": gocql.TypeVarchar, "UUID": gocql.TypeUUID}
+
+	for _, query := range []string{insertMetric, insertHistogram, insertSummary, insertValidation} {
+		match := insert.FindStringSubmatch(query)
+		if match == nil {
+			t.Fatalf("cannot parse %s", query)
+		}
+		table := match[1]
+
+		var hasSource bool
+		for _, column := range strings.Split(match[2], ",") {
+			column = strings.TrimSpace(column)
+			if column == "source_id" {
+				t.Errorf("%s writes the UUID source_id column", table)
+			}
+			if column != "source" {
+				continue
+			}
+			hasSource = true
+
+			// The source of a service name, bucket or scrape job is not a UUID
+			typ, ok := types[tables[table][column]]
+			if !ok {
+				t.Fatalf("%s.%s has type %q, want TEXT", table, column, tables[table][column])
+			}
+			if _, err := gocql.Marshal(gocql.NewNativeType(4, typ, ""), "node-exporter"); err != nil {
+				t.Errorf("writing source node-exporter to %s: %v", table, err)
+			}
+		}
+		if !hasSource {
+			t.Errorf("%s does not write the source column", table)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package cassandra
+
+import (
+	"context"
+
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// Metrics of a tenant are stored under their name prefixed with the tenant,
+// so the partitions keyed by metric name and the series IDs of different
+// tenants never overlap. Rows scanned across metric names are filtered by
+// the prefix of their name.
+
+// storedName returns the name a metric of the tenant of ctx is stored under
+func storedName(ctx context.Context, name string) (string, error) {
+	return tenant.Qualify(tenant.FromContext(ctx), name)
+}
+
+// tenantName returns the metric name of a stored name and whether it belongs
+// to the tenant of ctx
+func tenantName(ctx context.Context, stored string) (string, bool) {
+	id, name := tenant.Split(stored)
+	return name, id == tenant.FromContext(ctx)
+}
@@ -0,0 +1,43 @@
+package cassandra
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+func TestTenantNames(t *testing.T) {
+	acme := tenant.NewContext(context.Background(), "acme")
+	stored, err := storedName(acme, "up")
+	if err != nil || stored != "acme/up" {
+		t.Fatalf("storedName(acme, up) = %q, %v, want acme/up", stored, err)
+	}
+	if SeriesID(stored, nil) == SeriesID("up", nil) {
+		t.Error("series of acme and the default tenant share an ID")
+	}
+	if _, err := storedName(context.Background(), "acme/up"); err == nil {
+		t.Error("storedName() let the default tenant address a metric of acme")
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		stored string
+		want   string
+		wantOK bool
+	}{
+		{name: "own metric", ctx: acme, stored: "acme/up", want: "up", wantOK: true},
+		{name: "default tenant", ctx: context.Background(), stored: "up", want: "up", wantOK: true},
+		{name: "other tenant", ctx: acme, stored: "globex/up", want: "up"},
+		{name: "default tenant reads no tenant", ctx: context.Background(), stored: "acme/up", want: "up"},
+		{name: "tenant reads no default tenant", ctx: acme, stored: "up", want: "up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := tenantName(tt.ctx, tt.stored); got != tt.want || ok != tt.wantOK {
+				t.Errorf("tenantName(%q) = %q, %v, want %q, %v", tt.stored, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/internal/wal"
+	"github.com/yay14/pulse/metrics"
+)
+
+const (
+	defaultQueueSize   = 1000
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	maxBackoff         = time.Minute
+)
+
+// Policy decides how a failed write to a sink affects the ingest
+type Policy string
+
+const (
+	PolicyRequired   Policy = "required"    // The ingest fails when the sink fails
+	PolicyBestEffort Policy = "best-effort" // Failed writes are queued and retried in the background
+)
+
+// ParsePolicy parses a failure policy, an empty string is PolicyRequired
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(s); policy {
+	case "":
+		return PolicyRequired, nil
+	case PolicyRequired, PolicyBestEffort:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown sink policy %q", s)
+	}
+}
+
+// Writer writes a batch of metrics of a source to a store
+type Writer interface {
+	Write(ctx context.Context, req *ingestion.IngestDataRequest) error
+}
+
+// WriterFunc adapts a function to a Writer
+type WriterFunc func(ctx context.Context, req *ingestion.IngestDataRequest) error
+
+func (f WriterFunc) Write(ctx context.Context, req *ingestion.IngestDataRequest) error {
+	return f(ctx, req)
+}
+
+// MetricsWriter receives series, it is implemented by the metrics service
+type MetricsWriter interface {
+	WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error)
+}
+
+// ToMetrics returns a Writer converting batches into a WriteRequest of w,
+// written for the tenant of the batch
+func ToMetrics(w MetricsWriter) Writer {
+	return WriterFunc(func(ctx context.Context, req *ingestion.IngestDataRequest) error {
+		_, err := w.WriteMetrics(tenant.NewContext(ctx, req.TenantId), &metrics.WriteRequest{Timeseries: Timeseries(req), SourceId: req.SourceId, SourceType: req.SourceType})
+		return err
+	})
+}
+
+// Timeseries converts the metrics of a batch into series with the metric
+// name in the __name__ label
+func Timeseries(req *ingestion.IngestDataRequest) []*metrics.Timeseries {
+	series := make([]*metrics.Timeseries, len(req.Metrics))
+	for i, metric := range req.Metrics {
+		labels := make(map[string]string, len(metric.Labels)+1)
+		for name, value := range metric.Labels {
+			labels[name] = value
+		}
+		labels["__name__"] = metric.Name
+
+		series[i] = &metrics.Timeseries{
+			Labels: labels,
+			Samples: []*metrics.Sample{{
+				Value:     metric.Value,
+				Timestamp: metric.Timestamp,
+				Histogram: metric.Histogram,
+				Summary:   metric.Summary,
+				Exemplar:  metric.Exemplar,
+			}},
+		}
+	}
+	return series
+}
+
+// Config configures the failure handling of a sink. The retry queue is only
+// used by best-effort sinks, when it is full the oldest batch is dropped.
+// With a WAL, batches are retried from the log instead: required sinks until
+// they are written, best-effort sinks up to MaxAttempts.
+type Config struct {
+	Policy      Policy
+	QueueSize   int           // Batches held for retry, 1000 if zero
+	MaxAttempts int           // Attempts of a batch including the first write, 5 if zero
+	Backoff     time.Duration // Delay before the first retry, doubled for every retry up to a minute, 1s if zero
+}
+
+// Sink is a named store batches are written to
+type Sink struct {
+	Name   string
+	Writer Writer
+	Config
+}
+
+// SinkStats reports the retry queue of a sink
+type SinkStats struct {
+	Name    string
+	Policy  Policy
+	Queued  int    // Batches waiting for a retry, or in the WAL not written to the sink yet
+	Dropped uint64 // Batches dropped because the queue was full or they ran out of attempts
+}
+
+// FanOut writes every batch to all of its sinks
+type FanOut struct {
+	sinks []*sink
+	wal   *wal.WAL
+}
+
+// New creates a new FanOut writing to the sinks when a batch is written
+func New(sinks ...Sink) *FanOut {
+	f := &FanOut{}
+	for _, s := range sinks {
+		if s.Policy == "" {
+			s.Policy = PolicyRequired
+		}
+		if s.QueueSize <= 0 {
+			s.QueueSize = defaultQueueSize
+		}
+		if s.MaxAttempts <= 0 {
+			s.MaxAttempts = defaultMaxAttempts
+		}
+		if s.Backoff <= 0 {
+			s.Backoff = defaultBackoff
+		}
+		f.sinks = append(f.sinks, &sink{Sink: s, notify: make(chan struct{}, 1)})
+	}
+	return f
+}
+
+// NewDurable creates a new FanOut appending every batch to a WAL, from which
+// Run replays it to each sink with a WAL reader of the sink's name. A full
+// WAL drops the oldest batches of best-effort sinks, the batches required
+// sinks have not been written make Write fail with wal.ErrFull.
+func NewDurable(w *wal.WAL, sinks ...Sink) (*FanOut, error) {
+	f := New(sinks...)
+	f.wal = w
+	for _, s := range f.sinks {
+		r, err := w.Reader(s.Name, s.Policy == PolicyBestEffort)
+		if err != nil {
+			return nil, err
+		}
+		s.reader = r
+	}
+	return f, nil
+}
+
+// Write writes a batch to every sink concurrently. The errors of required
+// sinks are returned, failed writes to best-effort sinks are queued. With a
+// WAL the batch is only appended to it.
+func (f *FanOut) Write(ctx context.Context, req *ingestion.IngestDataRequest) error {
+	if f.wal != nil {
+		data, err := proto.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal batch: %w", err)
+		}
+		if _, err := f.wal.Append(data); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	errs := make([]error, len(f.sinks))
+	var wg sync.WaitGroup
+	for i, s := range f.sinks {
+		wg.Add(1)
+		go func(i int, s *sink) {
+			defer wg.Done()
+			err := s.Writer.Write(ctx, req)
+			if err == nil {
+				return
+			}
+			if s.Policy == PolicyRequired {
+				errs[i] = fmt.Errorf("%s: %w", s.Name, err)
+				return
+			}
+			if wal.IsPermanent(err) {
+				log.Printf("Dropping batch of source %s on sink %s: %v", req.SourceId, s.Name, err)
+				s.mu.Lock()
+				s.dropped++
+				s.mu.Unlock()
+				return
+			}
+			log.Printf("Queueing batch of source %s for retry on sink %s: %v", req.SourceId, s.Name, err)
+			s.enqueue(retry{req: req, attempts: 1, due: time.Now().Add(s.backoff(1))})
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Run retries the queued batches of every sink, or replays the WAL to
+// them, until ctx is done
+func (f *FanOut) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range f.sinks {
+		wg.Add(1)
+		go func(s *sink) {
+			defer wg.Done()
+			if s.reader != nil {
+				s.replay(ctx)
+			} else {
+				s.run(ctx)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Stats returns the retry queue statistics of every sink
+func (f *FanOut) Stats() []SinkStats {
+	stats := make([]SinkStats, len(f.sinks))
+	for i, s := range f.sinks {
+		if s.reader != nil {
+			stats[i] = SinkStats{Name: s.Name, Policy: s.Policy, Queued: int(s.reader.Lag()), Dropped: s.reader.Dropped()}
+			continue
+		}
+		s.mu.Lock()
+		stats[i] = SinkStats{Name: s.Name, Policy: s.Policy, Queued: len(s.queue), Dropped: s.dropped}
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// retry is a queued batch
+type retry struct {
+	req      *ingestion.IngestDataRequest
+	attempts int
+	due      time.Time
+}
+
+type sink struct {
+	Sink
+	reader *wal.Reader
+
+	mu      sync.Mutex
+	queue   []retry
+	dropped uint64
+	notify  chan struct{}
+}
+
+// backoff returns the delay before the retry following an attempt
+func (s *sink) backoff(attempts int) time.Duration {
+	d := s.Backoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func (s *sink) enqueue(r retry) {
+	s.mu.Lock()
+	if len(s.queue) >= s.QueueSize {
+		log.Printf("Retry queue of sink %s is full, dropping batch of source %s", s.Name, s.queue[0].req.SourceId)
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, r)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sink) dequeue() (retry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return retry{}, false
+	}
+	r := s.queue[0]
+	s.queue = s.queue[1:]
+	return r, true
+}
+
+// run retries queued batches in order, waiting for each to be due
+func (s *sink) run(ctx context.Context) {
+	for {
+		r, ok := s.dequeue()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			continue
+		}
+
+		if wait := time.Until(r.due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		err := s.Writer.Write(ctx, r.req)
+		if err == nil {
+			continue
+		}
+
+		r.attempts++
+		if wal.IsPermanent(err) || r.attempts >= s.MaxAttempts {
+			log.Printf("Dropping batch of source %s on sink %s after %d attempts: %v", r.req.SourceId, s.Name, r.attempts, err)
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+			continue
+		}
+		r.due = time.Now().Add(s.backoff(r.attempts))
+		s.enqueue(r)
+	}
+}
+
+// replay writes the batches of the WAL to the sink in order, a failing batch
+// holds back the following ones until it is written or dropped. Batches the
+// writer marks with wal.Permanent are dropped without retrying.
+func (s *sink) replay(ctx context.Context) {
+	cfg := wal.ReplayConfig{Backoff: s.Backoff, MaxBackoff: maxBackoff}
+	if s.Policy == PolicyBestEffort {
+		cfg.MaxAttempts = s.MaxAttempts
+	}
+
+	err := wal.Replay(ctx, s.reader, cfg, func(ctx context.Context, data []byte) error {
+		req := &ingestion.IngestDataRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			// Retrying cannot fix a batch that does not decode
+			return wal.Permanent(fmt.Errorf("undecodable batch: %w", err))
+		}
+		return s.Writer.Write(ctx, req)
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Stopped replaying the WAL to sink %s: %v", s.Name, err)
+	}
+}
@@ -0,0 +1,238 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/wal"
+	"github.com/yay14/pulse/metrics"
+)
+
+// fakeWriter fails as many writes as failures before succeeding
+type fakeWriter struct {
+	mu       sync.Mutex
+	failures int
+	written  []*ingestion.IngestDataRequest
+}
+
+func (f *fakeWriter) Write(ctx context.Context, req *ingestion.IngestDataRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("unavailable")
+	}
+	f.written = append(f.written, req)
+	return nil
+}
+
+func (f *fakeWriter) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.written)
+}
+
+func TestFanOut_Write(t *testing.T) {
+	req := &ingestion.IngestDataRequest{SourceId: "api"}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "required sink fails the write", policy: PolicyRequired, wantErr: true},
+		{name: "best-effort sink queues the batch", policy: PolicyBestEffort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeWriter{}
+			secondary := &fakeWriter{failures: 1}
+			f := New(
+				Sink{Name: "primary", Writer: primary},
+				Sink{Name: "secondary", Writer: secondary, Config: Config{Policy: tt.policy}},
+			)
+
+			err := f.Write(context.Background(), req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if primary.count() != 1 {
+				t.Errorf("primary sink wrote %d batches, want 1", primary.count())
+			}
+
+			wantQueued := 0
+			if tt.policy == PolicyBestEffort {
+				wantQueued = 1
+			}
+			if queued := f.Stats()[1].Queued; queued != wantQueued {
+				t.Errorf("secondary sink queued %d batches, want %d", queued, wantQueued)
+			}
+		})
+	}
+}
+
+func TestFanOut_Run(t *testing.T) {
+	w := &fakeWriter{failures: 2}
+	f := New(Sink{Name: "vm", Writer: w, Config: Config{Policy: PolicyBestEffort, Backoff: time.Millisecond, MaxAttempts: 3}})
+
+	if err := f.Write(context.Background(), &ingestion.IngestDataRequest{SourceId: "api"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if w.count() != 1 {
+		t.Errorf("batch written %d times after retries, want 1", w.count())
+	}
+	if stats := f.Stats()[0]; stats.Queued != 0 || stats.Dropped != 0 {
+		t.Errorf("Stats() = %+v, want an empty queue without drops", stats)
+	}
+}
+
+func TestFanOut_Durable(t *testing.T) {
+	dir := t.TempDir()
+	log, err := wal.Open(wal.Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	f, err := NewDurable(log, Sink{Name: "cassandra", Writer: &fakeWriter{failures: 100}})
+	if err != nil {
+		t.Fatalf("NewDurable() error = %v", err)
+	}
+
+	// Batches are acknowledged once appended, even if no sink is available
+	for _, source := range []string{"a", "b"} {
+		if err := f.Write(context.Background(), &ingestion.IngestDataRequest{SourceId: source}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if stats := f.Stats()[0]; stats.Queued != 2 {
+		t.Errorf("Stats() = %+v, want 2 queued", stats)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// After a restart the batches are replayed in order once the sinks recover
+	if log, err = wal.Open(wal.Options{Dir: dir}); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer log.Close()
+	required := &fakeWriter{failures: 2}
+	bestEffort := &fakeWriter{failures: 100}
+	f, err = NewDurable(log,
+		Sink{Name: "cassandra", Writer: required, Config: Config{Backoff: time.Millisecond}},
+		Sink{Name: "vm", Writer: bestEffort, Config: Config{Policy: PolicyBestEffort, Backoff: time.Millisecond, MaxAttempts: 2}},
+	)
+	if err != nil {
+		t.Fatalf("NewDurable() error = %v", err)
+	}
+	if err := f.Write(context.Background(), &ingestion.IngestDataRequest{SourceId: "c"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for (required.count() < 3 || f.Stats()[1].Dropped < 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	required.mu.Lock()
+	var sources []string
+	for _, req := range required.written {
+		sources = append(sources, req.SourceId)
+	}
+	required.mu.Unlock()
+	if len(sources) != 3 || sources[0] != "a" || sources[1] != "b" || sources[2] != "c" {
+		t.Errorf("required sink wrote %v, want [a b c]", sources)
+	}
+	if stats := f.Stats()[0]; stats.Queued != 0 || stats.Dropped != 0 {
+		t.Errorf("Stats() of the required sink = %+v, want an empty log without drops", stats)
+	}
+	if stats := f.Stats()[1]; stats.Dropped == 0 || bestEffort.count() != 0 {
+		t.Errorf("Stats() of the best-effort sink = %+v, want dropped batches", stats)
+	}
+}
+
+func TestSink_QueueLimits(t *testing.T) {
+	w := &fakeWriter{failures: 10}
+	f := New(Sink{Name: "vm", Writer: w, Config: Config{Policy: PolicyBestEffort, QueueSize: 2}})
+
+	for _, source := range []string{"a", "b", "c"} {
+		if err := f.Write(context.Background(), &ingestion.IngestDataRequest{SourceId: source}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	s := f.sinks[0]
+	if stats := f.Stats()[0]; stats.Queued != 2 || stats.Dropped != 1 {
+		t.Errorf("Stats() = %+v, want 2 queued and 1 dropped", stats)
+	}
+	if s.queue[0].req.SourceId != "b" {
+		t.Errorf("oldest queued batch is from %s, want b", s.queue[0].req.SourceId)
+	}
+}
+
+func TestSink_backoff(t *testing.T) {
+	s := &sink{Sink: Sink{Config: Config{Backoff: time.Second}}}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 20: maxBackoff} {
+		if got := s.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestTimeseries(t *testing.T) {
+	req := &ingestion.IngestDataRequest{Metrics: []*ingestion.MetricData{
+		{Name: "http_requests_total", Labels: map[string]string{"code": "200"}, Value: 3, Timestamp: 1000},
+	}}
+
+	series := Timeseries(req)
+	if len(series) != 1 {
+		t.Fatalf("Timeseries() returned %d series, want 1", len(series))
+	}
+	if got := series[0].Labels; got["__name__"] != "http_requests_total" || got["code"] != "200" {
+		t.Errorf("Timeseries() labels = %v", got)
+	}
+	if req.Metrics[0].Labels["__name__"] != "" {
+		t.Error("Timeseries() modified the labels of the request")
+	}
+
+	w := &fakeMetricsWriter{}
+	if err := ToMetrics(w).Write(context.Background(), req); err != nil || len(w.req.Timeseries) != 1 {
+		t.Errorf("ToMetrics().Write() = %v, wrote %v", err, w.req)
+	}
+}
+
+type fakeMetricsWriter struct {
+	req *metrics.WriteRequest
+}
+
+func (f *fakeMetricsWriter) WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	f.req = req
+	return &metrics.WriteResponse{}, nil
+}
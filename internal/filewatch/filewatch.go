@@ -0,0 +1,49 @@
+package filewatch
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultInterval = 10 * time.Second
+
+// Watch calls reload whenever the modification time or size of the file at
+// path changes, checking it every interval (10s if zero) until ctx is done.
+// A failed reload is logged and retried on the next change.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func() error) {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Failed to check %s: %v", path, err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) && info.Size() == size {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+
+		if err := reload(); err != nil {
+			log.Printf("Failed to reload %s: %v", path, err)
+		}
+	}
+}
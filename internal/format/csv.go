@@ -0,0 +1,273 @@
+package format
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+// CSV column types of a column mapping spec
+const (
+	ColumnMetric = "metric" // cell holds the value of the metric named by the context
+	ColumnLabel  = "label"  // cell holds the value of the label named by the context
+	ColumnTime   = "time"   // cell holds the timestamp in the format named by the context
+	ColumnName   = "name"   // cell holds the metric name
+	ColumnValue  = "value"  // cell holds the value of the metric from the name column
+)
+
+// CSVColumn maps a CSV column to part of a sample
+type CSVColumn struct {
+	Type    string
+	Context string
+}
+
+// CSVSpec maps CSV column positions to sample fields. Unmapped columns are nil.
+type CSVSpec []*CSVColumn
+
+// ParseCSVSpec parses a column mapping spec such as
+// "1:label:host,2:metric:cpu,3:time:unix_s", using the same syntax as
+// VictoriaMetrics' /api/v1/import/csv. Positions start at 1.
+func ParseCSVSpec(spec string) (CSVSpec, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, errors.New("CSV column mapping spec is required")
+	}
+
+	var csvSpec CSVSpec
+	var hasTime, hasName, hasValue, hasMetric bool
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid column %q, expected <pos>:<type>[:<context>]", field)
+		}
+
+		pos, err := strconv.Atoi(parts[0])
+		if err != nil || pos < 1 {
+			return nil, fmt.Errorf("invalid column position %q", parts[0])
+		}
+		column := &CSVColumn{Type: parts[1]}
+		if len(parts) == 3 {
+			column.Context = parts[2]
+		}
+
+		switch column.Type {
+		case ColumnMetric, ColumnLabel:
+			if column.Context == "" {
+				return nil, fmt.Errorf("column %d of type %s needs a name", pos, column.Type)
+			}
+			hasMetric = hasMetric || column.Type == ColumnMetric
+		case ColumnTime:
+			if column.Context == "" {
+				column.Context = "unix_ms"
+			}
+			switch column.Context {
+			case "rfc3339", "unix_s", "unix_ms", "unix_ns":
+			default:
+				return nil, fmt.Errorf("unknown time format %q", column.Context)
+			}
+			hasTime = true
+		case ColumnName:
+			hasName = true
+		case ColumnValue:
+			hasValue = true
+		default:
+			return nil, fmt.Errorf("unknown column type %q", column.Type)
+		}
+
+		for len(csvSpec) < pos {
+			csvSpec = append(csvSpec, nil)
+		}
+		if csvSpec[pos-1] != nil {
+			return nil, fmt.Errorf("column %d is mapped twice", pos)
+		}
+		csvSpec[pos-1] = column
+	}
+
+	if !hasTime {
+		return nil, errors.New("CSV column mapping spec needs a time column")
+	}
+	if hasName != hasValue {
+		return nil, errors.New("name and value columns must be used together")
+	}
+	if !hasMetric && !hasName {
+		return nil, errors.New("CSV column mapping spec needs a metric or name column")
+	}
+
+	return csvSpec, nil
+}
+
+// ReadCSV decodes CSV rows according to spec and calls fn for every sample
+func ReadCSV(r io.Reader, spec CSVSpec, fn func(*metrics.Timeseries) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV row %d: %w", row, err)
+		}
+
+		labels := make(map[string]string)
+		var name, value string
+		var timestamp int64
+		for pos, column := range spec {
+			if column == nil || pos >= len(record) {
+				continue
+			}
+			cell := record[pos]
+
+			switch column.Type {
+			case ColumnLabel:
+				if cell != "" {
+					labels[column.Context] = cell
+				}
+			case ColumnTime:
+				if timestamp, err = parseTime(cell, column.Context); err != nil {
+					return fmt.Errorf("row %d: %w", row, err)
+				}
+			case ColumnName:
+				name = cell
+			case ColumnValue:
+				value = cell
+			}
+		}
+
+		emit := func(name, cell string) error {
+			if name == "" || cell == "" {
+				return nil
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return fmt.Errorf("row %d: invalid value %q for %s", row, cell, name)
+			}
+
+			seriesLabels := make(map[string]string, len(labels)+1)
+			for key, labelValue := range labels {
+				seriesLabels[key] = labelValue
+			}
+			seriesLabels["__name__"] = name
+
+			return fn(&metrics.Timeseries{
+				Labels:  seriesLabels,
+				Samples: []*metrics.Sample{{Value: v, Timestamp: timestamp}},
+			})
+		}
+
+		if err := emit(name, value); err != nil {
+			return err
+		}
+		for pos, column := range spec {
+			if column == nil || column.Type != ColumnMetric || pos >= len(record) {
+				continue
+			}
+			if err := emit(column.Context, record[pos]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// CSVWriter encodes timeseries as CSV, one row per sample
+type CSVWriter struct {
+	writer *csv.Writer
+	spec   CSVSpec
+	record []string
+}
+
+// NewCSVWriter creates a CSVWriter that lays out rows according to spec
+func NewCSVWriter(w io.Writer, spec CSVSpec) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w), spec: spec, record: make([]string, len(spec))}
+}
+
+// Write writes one row for every sample of ts. Metric columns only receive
+// values of series with the matching name.
+func (cw *CSVWriter) Write(ts *metrics.Timeseries) error {
+	name := ts.Labels["__name__"]
+
+	for _, sample := range ts.Samples {
+		value := strconv.FormatFloat(sample.Value, 'g', -1, 64)
+		for pos, column := range cw.spec {
+			cw.record[pos] = ""
+			if column == nil {
+				continue
+			}
+
+			switch column.Type {
+			case ColumnLabel:
+				cw.record[pos] = ts.Labels[column.Context]
+			case ColumnTime:
+				cw.record[pos] = formatTime(sample.Timestamp, column.Context)
+			case ColumnName:
+				cw.record[pos] = name
+			case ColumnValue:
+				cw.record[pos] = value
+			case ColumnMetric:
+				if column.Context == name {
+					cw.record[pos] = value
+				}
+			}
+		}
+
+		if err := cw.writer.Write(cw.record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying writer
+func (cw *CSVWriter) Flush() error {
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// parseTime converts a timestamp cell into milliseconds
+func parseTime(cell, layout string) (int64, error) {
+	if layout == "rfc3339" {
+		t, err := time.Parse(time.RFC3339, cell)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rfc3339 timestamp %q", cell)
+		}
+		return t.UnixMilli(), nil
+	}
+
+	v, err := strconv.ParseFloat(cell, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", cell)
+	}
+
+	switch layout {
+	case "unix_s":
+		return int64(v * 1e3), nil
+	case "unix_ms":
+		return int64(v), nil
+	case "unix_ns":
+		return int64(v / 1e6), nil
+	default:
+		return 0, fmt.Errorf("unknown time format %q", layout)
+	}
+}
+
+// formatTime formats a timestamp in milliseconds in the given layout
+func formatTime(timestamp int64, layout string) string {
+	switch layout {
+	case "rfc3339":
+		return time.UnixMilli(timestamp).UTC().Format(time.RFC3339Nano)
+	case "unix_s":
+		return strconv.FormatFloat(float64(timestamp)/1e3, 'f', -1, 64)
+	case "unix_ns":
+		return strconv.FormatInt(timestamp*1e6, 10)
+	default:
+		return strconv.FormatInt(timestamp, 10)
+	}
+}
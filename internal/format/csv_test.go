@@ -0,0 +1,150 @@
+package format
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestParseCSVSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    CSVSpec
+		wantErr bool
+	}{
+		{
+			name: "metric columns with labels",
+			spec: "1:label:host,2:metric:cpu,4:time:unix_s",
+			want: CSVSpec{
+				{Type: ColumnLabel, Context: "host"},
+				{Type: ColumnMetric, Context: "cpu"},
+				nil,
+				{Type: ColumnTime, Context: "unix_s"},
+			},
+		},
+		{
+			name: "name and value columns default to unix_ms",
+			spec: "1:name,2:value,3:time",
+			want: CSVSpec{
+				{Type: ColumnName},
+				{Type: ColumnValue},
+				{Type: ColumnTime, Context: "unix_ms"},
+			},
+		},
+		{
+			name:    "empty spec",
+			spec:    "",
+			wantErr: true,
+		},
+		{
+			name:    "missing time column",
+			spec:    "1:metric:cpu",
+			wantErr: true,
+		},
+		{
+			name:    "name without value",
+			spec:    "1:name,2:time:unix_s",
+			wantErr: true,
+		},
+		{
+			name:    "unknown time format",
+			spec:    "1:metric:cpu,2:time:julian",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate position",
+			spec:    "1:metric:cpu,1:time:unix_s",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCSVSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCSVSpec() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCSVSpec() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	spec, err := ParseCSVSpec("1:label:host,2:metric:cpu,3:metric:mem,4:time:unix_s")
+	if err != nil {
+		t.Fatalf("ParseCSVSpec() error = %v", err)
+	}
+
+	var got []*metrics.Timeseries
+	err = ReadCSV(strings.NewReader("web-1,0.5,128,1620474602\nweb-2,,256,1620474603\n"), spec, func(ts *metrics.Timeseries) error {
+		got = append(got, ts)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadCSV() error = %v", err)
+	}
+
+	want := []*metrics.Timeseries{
+		{Labels: map[string]string{"__name__": "cpu", "host": "web-1"}, Samples: []*metrics.Sample{{Value: 0.5, Timestamp: 1620474602000}}},
+		{Labels: map[string]string{"__name__": "mem", "host": "web-1"}, Samples: []*metrics.Sample{{Value: 128, Timestamp: 1620474602000}}},
+		{Labels: map[string]string{"__name__": "mem", "host": "web-2"}, Samples: []*metrics.Sample{{Value: 256, Timestamp: 1620474603000}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadCSV() = %v, want %v", got, want)
+	}
+
+	err = ReadCSV(strings.NewReader("web-1,abc,1,1620474602\n"), spec, func(*metrics.Timeseries) error { return nil })
+	if err == nil {
+		t.Errorf("ReadCSV() with an invalid value should fail")
+	}
+}
+
+func TestCSVWriter_RoundTrip(t *testing.T) {
+	spec, err := ParseCSVSpec("1:name,2:label:job,3:value,4:time:rfc3339")
+	if err != nil {
+		t.Fatalf("ParseCSVSpec() error = %v", err)
+	}
+
+	ts := &metrics.Timeseries{
+		Labels: map[string]string{"__name__": "up", "job": "api, internal"},
+		Samples: []*metrics.Sample{
+			{Value: 1, Timestamp: 1620474602000},
+			{Value: 0, Timestamp: 1620474617000},
+		},
+	}
+
+	var buf bytes.Buffer
+	writer := NewCSVWriter(&buf, spec)
+	if err := writer.Write(ts); err != nil {
+		t.Fatalf("CSVWriter.Write() error = %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("CSVWriter.Flush() error = %v", err)
+	}
+
+	wantCSV := "up,\"api, internal\",1,2021-05-08T11:50:02Z\nup,\"api, internal\",0,2021-05-08T11:50:17Z\n"
+	if buf.String() != wantCSV {
+		t.Errorf("CSVWriter.Write() = %q, want %q", buf.String(), wantCSV)
+	}
+
+	var samples []*metrics.Sample
+	err = ReadCSV(&buf, spec, func(got *metrics.Timeseries) error {
+		if !reflect.DeepEqual(got.Labels, ts.Labels) {
+			t.Errorf("ReadCSV() labels = %v, want %v", got.Labels, ts.Labels)
+		}
+		samples = append(samples, got.Samples...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadCSV() error = %v", err)
+	}
+	if !reflect.DeepEqual(samples, ts.Samples) {
+		t.Errorf("ReadCSV() samples = %v, want %v", samples, ts.Samples)
+	}
+}
@@ -0,0 +1,194 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Timestamp precisions of the InfluxDB /api/v2/write endpoint
+const (
+	PrecisionNanoseconds  = "ns"
+	PrecisionMicroseconds = "us"
+	PrecisionMilliseconds = "ms"
+	PrecisionSeconds      = "s"
+)
+
+// InfluxField is a numeric field of a line protocol point. Boolean fields are
+// 1 or 0, string fields are dropped as they cannot be stored as samples.
+type InfluxField struct {
+	Key   string
+	Value float64
+}
+
+// InfluxPoint is a single point of the InfluxDB line protocol
+type InfluxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []InfluxField
+	Timestamp   int64 // Milliseconds, 0 if the point has no timestamp
+}
+
+// ParseLineProtocol parses the InfluxDB line protocol and calls fn for every
+// point. Timestamps are read in the given precision, ns if empty.
+func ParseLineProtocol(r io.Reader, precision string, fn func(InfluxPoint) error) error {
+	toMillis, err := precisionToMillis(precision)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		point, err := parsePoint(line, toMillis)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := fn(point); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func precisionToMillis(precision string) (func(int64) int64, error) {
+	switch precision {
+	case "", PrecisionNanoseconds:
+		return func(ts int64) int64 { return ts / 1e6 }, nil
+	case PrecisionMicroseconds:
+		return func(ts int64) int64 { return ts / 1e3 }, nil
+	case PrecisionMilliseconds:
+		return func(ts int64) int64 { return ts }, nil
+	case PrecisionSeconds:
+		return func(ts int64) int64 { return ts * 1e3 }, nil
+	default:
+		return nil, fmt.Errorf("unknown precision %q", precision)
+	}
+}
+
+// parsePoint parses `measurement[,tag=value...] field=value[,field=value...] [timestamp]`
+func parsePoint(line string, toMillis func(int64) int64) (InfluxPoint, error) {
+	point := InfluxPoint{Tags: make(map[string]string)}
+
+	sections := splitUnescaped(line, ' ', true)
+	var parts []string
+	for _, section := range sections {
+		// Sections may be separated by more than one space
+		if section != "" {
+			parts = append(parts, section)
+		}
+	}
+	if len(parts) < 2 || len(parts) > 3 {
+		return point, fmt.Errorf("expected measurement, fields and optional timestamp")
+	}
+
+	key := splitUnescaped(parts[0], ',', false)
+	point.Measurement = unescapeInflux(key[0])
+	if point.Measurement == "" {
+		return point, fmt.Errorf("missing measurement")
+	}
+	for _, tag := range key[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return point, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.Tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+	}
+
+	for _, field := range splitUnescaped(parts[1], ',', true) {
+		kv := splitUnescaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return point, fmt.Errorf("invalid field %q", field)
+		}
+		value, numeric, err := parseFieldValue(kv[1])
+		if err != nil {
+			return point, fmt.Errorf("field %s: %w", kv[0], err)
+		}
+		if numeric {
+			point.Fields = append(point.Fields, InfluxField{Key: unescapeInflux(kv[0]), Value: value})
+		}
+	}
+
+	if len(parts) == 3 {
+		timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		point.Timestamp = toMillis(timestamp)
+	}
+
+	return point, nil
+}
+
+// parseFieldValue parses a float, integer (i), unsigned (u), boolean or string
+// field value. numeric is false for strings.
+func parseFieldValue(s string) (value float64, numeric bool, err error) {
+	if s[0] == '"' {
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return 0, false, fmt.Errorf("unterminated string %s", s)
+		}
+		return 0, false, nil
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid integer %q", s)
+		}
+		return float64(v), true, nil
+	case 'u':
+		v, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		return float64(v), true, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid float %q", s)
+	}
+	return v, true, nil
+}
+
+// splitUnescaped splits s on sep, skipping separators escaped with a backslash
+// and, with quotes set, separators inside double quoted strings
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quotes:
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\"`, `"`, `\\`, `\`)
+
+func unescapeInflux(s string) string {
+	return influxUnescaper.Replace(s)
+}
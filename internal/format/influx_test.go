@@ -0,0 +1,92 @@
+package format
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		precision string
+		want      []InfluxPoint
+		wantErr   bool
+	}{
+		{
+			name: "field types and nanosecond timestamps",
+			input: `# comment
+weather,location=us-midwest,season=summer temperature=82,humidity=71i,raining=f,station="a b" 1465839830100400200
+
+cpu usage_user=0.5,threads=3u
+`,
+			want: []InfluxPoint{
+				{
+					Measurement: "weather",
+					Tags:        map[string]string{"location": "us-midwest", "season": "summer"},
+					Fields:      []InfluxField{{Key: "temperature", Value: 82}, {Key: "humidity", Value: 71}, {Key: "raining", Value: 0}},
+					Timestamp:   1465839830100,
+				},
+				{
+					Measurement: "cpu",
+					Tags:        map[string]string{},
+					Fields:      []InfluxField{{Key: "usage_user", Value: 0.5}, {Key: "threads", Value: 3}},
+				},
+			},
+		},
+		{
+			name:      "escaped characters and second precision",
+			input:     `my\ measurement,tag\,key=tag\=value,path=C:\\dir field\ key="str, with \"quotes\" and = signs",value=1.5,ok=true 1465839830`,
+			precision: PrecisionSeconds,
+			want: []InfluxPoint{
+				{
+					Measurement: "my measurement",
+					Tags:        map[string]string{"tag,key": "tag=value", "path": `C:\dir`},
+					Fields:      []InfluxField{{Key: "value", Value: 1.5}, {Key: "ok", Value: 1}},
+					Timestamp:   1465839830000,
+				},
+			},
+		},
+		{
+			name:      "microsecond precision",
+			input:     "disk free=12i 1465839830100400",
+			precision: PrecisionMicroseconds,
+			want: []InfluxPoint{
+				{Measurement: "disk", Tags: map[string]string{}, Fields: []InfluxField{{Key: "free", Value: 12}}, Timestamp: 1465839830100},
+			},
+		},
+		{
+			name:    "missing fields",
+			input:   "cpu,host=a 1465839830100400200\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			input:   "cpu value=1 yesterday\n",
+			wantErr: true,
+		},
+		{
+			name:      "unknown precision",
+			input:     "cpu value=1\n",
+			precision: "h",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []InfluxPoint
+			err := ParseLineProtocol(strings.NewReader(tt.input), tt.precision, func(p InfluxPoint) error {
+				got = append(got, p)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLineProtocol() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLineProtocol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
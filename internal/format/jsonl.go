@@ -0,0 +1,116 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+// JSONLine is a single series in the VictoriaMetrics JSON line format used by
+// /api/v1/import and /api/v1/export. Timestamps are in milliseconds.
+type JSONLine struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []JSONValue       `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// JSONValue is a sample value of a JSON line. JSON numbers cannot hold NaN
+// and infinities, they are written as null, "Infinity" and "-Infinity" the
+// way VictoriaMetrics exports and imports them.
+type JSONValue float64
+
+// MarshalJSON implements json.Marshaler
+func (v JSONValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return []byte("null"), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return json.Marshal(f)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *JSONValue) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "null":
+		*v = JSONValue(math.NaN())
+	case `"Infinity"`, `"+Inf"`, `"Inf"`:
+		*v = JSONValue(math.Inf(1))
+	case `"-Infinity"`, `"-Inf"`:
+		*v = JSONValue(math.Inf(-1))
+	case `"NaN"`:
+		*v = JSONValue(math.NaN())
+	default:
+		var f float64
+		if err := json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("invalid sample value %s", data)
+		}
+		*v = JSONValue(f)
+	}
+	return nil
+}
+
+// MarshalJSONLine encodes a timeseries as a single JSON line
+func MarshalJSONLine(ts *metrics.Timeseries) ([]byte, error) {
+	// Prepare the metric object
+	metric := map[string]string{
+		"__name__": ts.Labels["__name__"], // Ensure the metric name is included
+	}
+
+	// Add additional labels to the metric object
+	for key, value := range ts.Labels {
+		if key != "__name__" { // Skip the metric name
+			metric[key] = value
+		}
+	}
+
+	// Prepare values and timestamps slices
+	line := JSONLine{
+		Metric:     metric,
+		Values:     make([]JSONValue, len(ts.Samples)),
+		Timestamps: make([]int64, len(ts.Samples)),
+	}
+	for i, sample := range ts.Samples {
+		line.Values[i] = JSONValue(sample.Value)
+		line.Timestamps[i] = sample.Timestamp // Ensure timestamps are in milliseconds
+	}
+
+	return json.Marshal(line)
+}
+
+// ReadJSONLines decodes a stream of JSON lines and calls fn for every series
+func ReadJSONLines(r io.Reader, fn func(*metrics.Timeseries) error) error {
+	dec := json.NewDecoder(r)
+
+	for dec.More() {
+		var line JSONLine
+		if err := dec.Decode(&line); err != nil {
+			return fmt.Errorf("failed to decode JSON line: %w", err)
+		}
+		if len(line.Values) != len(line.Timestamps) {
+			return fmt.Errorf("series %v has %d values but %d timestamps", line.Metric, len(line.Values), len(line.Timestamps))
+		}
+
+		ts := &metrics.Timeseries{
+			Labels:  line.Metric,
+			Samples: make([]*metrics.Sample, len(line.Values)),
+		}
+		for i := range line.Values {
+			ts.Samples[i] = &metrics.Sample{Value: float64(line.Values[i]), Timestamp: line.Timestamps[i]}
+		}
+
+		if err := fn(ts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package format
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestMarshalJSONLine_NonFinite(t *testing.T) {
+	ts := &metrics.Timeseries{
+		Labels: map[string]string{"__name__": "rpc_duration_seconds", "quantile": "0.99"},
+		Samples: []*metrics.Sample{
+			{Value: 0.5, Timestamp: 1000},
+			{Value: math.NaN(), Timestamp: 2000},
+			{Value: math.Inf(1), Timestamp: 3000},
+			{Value: math.Inf(-1), Timestamp: 4000},
+		},
+	}
+
+	line, err := MarshalJSONLine(ts)
+	if err != nil {
+		t.Fatalf("MarshalJSONLine() error = %v", err)
+	}
+	if want := `"values":[0.5,null,"Infinity","-Infinity"]`; !strings.Contains(string(line), want) {
+		t.Errorf("MarshalJSONLine() = %s, want %s", line, want)
+	}
+
+	var got *metrics.Timeseries
+	if err := ReadJSONLines(strings.NewReader(string(line)), func(ts *metrics.Timeseries) error {
+		got = ts
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadJSONLines() error = %v", err)
+	}
+	if len(got.Samples) != 4 || got.Samples[0].Value != 0.5 || !math.IsNaN(got.Samples[1].Value) ||
+		!math.IsInf(got.Samples[2].Value, 1) || !math.IsInf(got.Samples[3].Value, -1) {
+		t.Errorf("ReadJSONLines() samples = %v, want 0.5, NaN, +Inf and -Inf", got.Samples)
+	}
+}
+
+func TestReadJSONLines_InvalidValue(t *testing.T) {
+	err := ReadJSONLines(strings.NewReader(`{"metric":{"__name__":"up"},"values":["one"],"timestamps":[1000]}`), func(*metrics.Timeseries) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("ReadJSONLines() error = nil, want an error for a string value")
+	}
+}
@@ -0,0 +1,96 @@
+package format
+
+import (
+	"math"
+	"sort"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+// Field numbers of the Prometheus remote write protobuf messages
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels    = 1
+	timeSeriesSamples   = 2
+	timeSeriesExemplars = 3
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	exemplarLabels    = 1
+	exemplarValue     = 2
+	exemplarTimestamp = 3
+)
+
+// MarshalRemoteWrite encodes series as a snappy compressed Prometheus remote
+// write request. Exemplars of the samples are written with their series.
+// Histogram and summary samples must be expanded into float series first.
+func MarshalRemoteWrite(series []*metrics.Timeseries) []byte {
+	var req []byte
+	for _, ts := range series {
+		req = protowire.AppendTag(req, writeRequestTimeseries, protowire.BytesType)
+		req = protowire.AppendBytes(req, marshalTimeSeries(ts))
+	}
+	return snappy.Encode(nil, req)
+}
+
+func marshalTimeSeries(ts *metrics.Timeseries) []byte {
+	b := appendLabels(nil, timeSeriesLabels, ts.Labels)
+
+	for _, sample := range ts.Samples {
+		var s []byte
+		s = protowire.AppendTag(s, sampleValue, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+		s = protowire.AppendTag(s, sampleTimestamp, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(sample.Timestamp))
+
+		b = protowire.AppendTag(b, timeSeriesSamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+
+	for _, sample := range ts.Samples {
+		if sample.Exemplar != nil {
+			b = protowire.AppendTag(b, timeSeriesExemplars, protowire.BytesType)
+			b = protowire.AppendBytes(b, marshalExemplar(sample.Exemplar))
+		}
+	}
+
+	return b
+}
+
+func marshalExemplar(exemplar *ingestion.Exemplar) []byte {
+	b := appendLabels(nil, exemplarLabels, exemplar.Labels)
+	b = protowire.AppendTag(b, exemplarValue, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(exemplar.Value))
+	b = protowire.AppendTag(b, exemplarTimestamp, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(exemplar.Timestamp))
+}
+
+// appendLabels appends the labels sorted by name, as remote write requires
+func appendLabels(b []byte, field protowire.Number, labels map[string]string) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var l []byte
+		l = protowire.AppendTag(l, labelName, protowire.BytesType)
+		l = protowire.AppendString(l, name)
+		l = protowire.AppendTag(l, labelValue, protowire.BytesType)
+		l = protowire.AppendString(l, labels[name])
+
+		b = protowire.AppendTag(b, field, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+package format
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestMarshalRemoteWrite(t *testing.T) {
+	series := []*metrics.Timeseries{
+		{
+			Labels: map[string]string{"__name__": "request_seconds_bucket", "le": "0.5", "job": "api"},
+			Samples: []*metrics.Sample{
+				{Value: 3, Timestamp: 1000},
+				{Value: 4, Timestamp: 2000, Exemplar: &ingestion.Exemplar{
+					Labels:    map[string]string{"trace_id": "abc", "span_id": "def"},
+					Value:     0.25,
+					Timestamp: 1500,
+				}},
+			},
+		},
+		{
+			Labels:  map[string]string{"__name__": "up"},
+			Samples: []*metrics.Sample{{Value: 1, Timestamp: 1000}},
+		},
+	}
+
+	req, err := snappy.Decode(nil, MarshalRemoteWrite(series))
+	if err != nil {
+		t.Fatalf("snappy.Decode() error = %v", err)
+	}
+
+	got, err := decodeRemoteWrite(req)
+	if err != nil {
+		t.Fatalf("decodeRemoteWrite() error = %v", err)
+	}
+
+	want := []string{
+		`{__name__="request_seconds_bucket",job="api",le="0.5"} 3@1000 4@2000 # {span_id="def",trace_id="abc"} 0.25@1500`,
+		`{__name__="up"} 1@1000`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("MarshalRemoteWrite() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+// decodeRemoteWrite formats the series of a WriteRequest, one line per series
+func decodeRemoteWrite(b []byte) ([]string, error) {
+	var lines []string
+	err := walkFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != writeRequestTimeseries {
+			return fmt.Errorf("unexpected WriteRequest field %d", num)
+		}
+
+		var labels []string
+		var samples, exemplars []string
+		err := walkFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case timeSeriesLabels:
+				label, err := decodeLabel(v)
+				labels = append(labels, label)
+				return err
+			case timeSeriesSamples:
+				sample, err := decodeValue(v, sampleValue, sampleTimestamp)
+				samples = append(samples, sample)
+				return err
+			case timeSeriesExemplars:
+				var exLabels []string
+				var value, timestamp uint64
+				err := walkFields(v, func(num protowire.Number, v []byte, n uint64) error {
+					switch num {
+					case exemplarLabels:
+						label, err := decodeLabel(v)
+						exLabels = append(exLabels, label)
+						return err
+					case exemplarValue:
+						value = n
+					case exemplarTimestamp:
+						timestamp = n
+					}
+					return nil
+				})
+				exemplars = append(exemplars, fmt.Sprintf("# {%s} %g@%d", strings.Join(exLabels, ","), math.Float64frombits(value), int64(timestamp)))
+				return err
+			}
+			return fmt.Errorf("unexpected TimeSeries field %d", num)
+		})
+		if err != nil {
+			return err
+		}
+
+		line := "{" + strings.Join(labels, ",") + "} " + strings.Join(samples, " ")
+		if len(exemplars) > 0 {
+			line += " " + strings.Join(exemplars, " ")
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, err
+}
+
+func decodeLabel(b []byte) (string, error) {
+	var name, value string
+	err := walkFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case labelName:
+			name = string(v)
+		case labelValue:
+			value = string(v)
+		}
+		return nil
+	})
+	return fmt.Sprintf("%s=%q", name, value), err
+}
+
+func decodeValue(b []byte, valueField, timestampField protowire.Number) (string, error) {
+	var value, timestamp uint64
+	err := walkFields(b, func(num protowire.Number, _ []byte, n uint64) error {
+		switch num {
+		case valueField:
+			value = n
+		case timestampField:
+			timestamp = n
+		}
+		return nil
+	})
+	return fmt.Sprintf("%g@%d", math.Float64frombits(value), int64(timestamp)), err
+}
+
+// walkFields calls fn with the bytes of length delimited fields and the value
+// of varint and fixed64 fields
+func walkFields(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		var u uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			u, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			u, n = protowire.ConsumeFixed64(b)
+		default:
+			return fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, v, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+// maxLineSize bounds the length of a single exposition line
+const maxLineSize = 1 << 20
+
+// Metric types of the exposition formats
+const (
+	TypeCounter        = "counter"
+	TypeGauge          = "gauge"
+	TypeHistogram      = "histogram"
+	TypeGaugeHistogram = "gaugehistogram"
+	TypeSummary        = "summary"
+	TypeInfo           = "info"
+	TypeStateSet       = "stateset"
+	TypeUnknown        = "unknown"
+)
+
+// Sample is a single sample of the Prometheus text or OpenMetrics exposition format
+type Sample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64 // Milliseconds, 0 if the sample has no timestamp
+
+	Family string // Name of the metric family, without _bucket, _sum, _count or _total
+	Type   string // Type declared by the # TYPE line of the family, unknown if absent
+	Help   string // Text of the # HELP line of the family
+
+	Exemplar *Exemplar // OpenMetrics exemplar of the sample, nil if absent
+}
+
+// Exemplar is an OpenMetrics exemplar such as # {trace_id="abc"} 0.67 1520879607.789
+type Exemplar struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64 // Milliseconds, 0 if the exemplar has no timestamp
+}
+
+// family holds the metadata declared by the comment lines of a metric family
+type family struct {
+	typ  string
+	help string
+}
+
+// ParseText parses the Prometheus text exposition format and calls fn for every
+// sample. With openMetrics set, timestamps are read as seconds as defined by
+// OpenMetrics instead of the milliseconds used by the Prometheus format.
+// HELP and TYPE lines are attached to the samples of their family.
+func ParseText(r io.Reader, openMetrics bool, fn func(Sample) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	families := make(map[string]*family)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			if line == "# EOF" {
+				return nil
+			}
+			if err := parseComment(line, families); err != nil {
+				return fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			continue
+		}
+
+		sample, err := parseSampleLine(line, openMetrics)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := resolveFamily(&sample, families); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := fn(sample); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseComment records the metadata of `# HELP name text` and `# TYPE name type` lines
+func parseComment(line string, families map[string]*family) error {
+	fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+	if len(fields) < 3 || (fields[0] != "HELP" && fields[0] != "TYPE") {
+		// Plain comments and metadata such as UNIT are ignored
+		return nil
+	}
+
+	name := fields[1]
+	f, ok := families[name]
+	if !ok {
+		f = &family{typ: TypeUnknown}
+		families[name] = f
+	}
+
+	if fields[0] == "HELP" {
+		f.help = strings.NewReplacer(`\n`, "\n", `\\`, `\`).Replace(fields[2])
+		return nil
+	}
+
+	switch typ := strings.TrimSpace(fields[2]); typ {
+	case TypeCounter, TypeGauge, TypeHistogram, TypeGaugeHistogram, TypeSummary, TypeInfo, TypeStateSet, TypeUnknown:
+		f.typ = typ
+	case "untyped":
+		f.typ = TypeUnknown
+	default:
+		return fmt.Errorf("unknown type %q for %s", typ, name)
+	}
+	return nil
+}
+
+// resolveFamily finds the family of a sample from its name and suffix and checks
+// that histogram and summary samples carry their le and quantile labels.
+func resolveFamily(sample *Sample, families map[string]*family) error {
+	sample.Family, sample.Type = sample.Name, TypeUnknown
+
+	f, ok := families[sample.Name]
+	if !ok {
+		for _, suffix := range []string{"_bucket", "_sum", "_count", "_total", "_created", "_gsum", "_gcount", "_info"} {
+			base := strings.TrimSuffix(sample.Name, suffix)
+			if base == sample.Name {
+				continue
+			}
+			if candidate, found := families[base]; found && suffixAllowed(candidate.typ, suffix) {
+				sample.Family, f, ok = base, candidate, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil
+	}
+	sample.Type, sample.Help = f.typ, f.help
+
+	switch {
+	case sample.Name == sample.Family+"_bucket":
+		if _, ok := sample.Labels["le"]; !ok {
+			return fmt.Errorf("histogram bucket %s has no le label", sample.Name)
+		}
+	case sample.Type == TypeSummary && sample.Name == sample.Family:
+		if _, ok := sample.Labels["quantile"]; !ok {
+			return fmt.Errorf("summary %s has no quantile label", sample.Name)
+		}
+	case (sample.Type == TypeHistogram || sample.Type == TypeGaugeHistogram) && sample.Name == sample.Family:
+		return fmt.Errorf("histogram %s has a sample without a suffix", sample.Name)
+	}
+	return nil
+}
+
+func suffixAllowed(typ, suffix string) bool {
+	switch typ {
+	case TypeCounter:
+		return suffix == "_total" || suffix == "_created"
+	case TypeHistogram:
+		return suffix == "_bucket" || suffix == "_sum" || suffix == "_count" || suffix == "_created"
+	case TypeGaugeHistogram:
+		return suffix == "_bucket" || suffix == "_gsum" || suffix == "_gcount"
+	case TypeSummary:
+		return suffix == "_sum" || suffix == "_count" || suffix == "_created"
+	case TypeInfo:
+		return suffix == "_info"
+	default:
+		return false
+	}
+}
+
+// parseSampleLine parses `name{label="value",...} value [timestamp] [# exemplar]`
+func parseSampleLine(line string, openMetrics bool) (Sample, error) {
+	sample := Sample{Labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ \t")
+	if end == 0 {
+		return sample, fmt.Errorf("missing metric name")
+	}
+	if end < 0 {
+		return sample, fmt.Errorf("missing value for %s", line)
+	}
+	sample.Name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		var err error
+		if rest, err = parseLabels(rest[1:], sample.Labels); err != nil {
+			return sample, err
+		}
+	}
+
+	// An OpenMetrics exemplar follows the sample after " # "
+	if i := strings.Index(rest, "#"); i >= 0 {
+		exemplar, err := parseExemplar(rest[i+1:])
+		if err != nil {
+			return sample, fmt.Errorf("exemplar of %s: %w", sample.Name, err)
+		}
+		sample.Exemplar = exemplar
+		rest = rest[:i]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("expected value and optional timestamp for %s", sample.Name)
+	}
+
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return sample, err
+	}
+	sample.Value = value
+
+	if len(fields) == 2 {
+		timestamp, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return sample, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		if openMetrics {
+			timestamp *= 1e3
+		}
+		sample.Timestamp = int64(timestamp)
+	}
+
+	return sample, nil
+}
+
+// parseExemplar parses `{label="value",...} value [timestamp]`, the timestamp is in seconds
+func parseExemplar(s string) (*Exemplar, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return nil, fmt.Errorf("missing label set")
+	}
+
+	exemplar := &Exemplar{Labels: make(map[string]string)}
+	rest, err := parseLabels(s[1:], exemplar.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("expected value and optional timestamp")
+	}
+	if exemplar.Value, err = parseValue(fields[0]); err != nil {
+		return nil, err
+	}
+	if len(fields) == 2 {
+		timestamp, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		exemplar.Timestamp = int64(math.Round(timestamp * 1e3))
+	}
+
+	return exemplar, nil
+}
+
+// parseLabels parses the label set after the opening brace and returns the remainder after the closing brace
+func parseLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return "", fmt.Errorf("unterminated label set")
+		}
+		if s[0] == '}' {
+			return s[1:], nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("invalid label in %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if s == "" || s[0] != '"' {
+			return "", fmt.Errorf("label %s has an unquoted value", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return "", fmt.Errorf("unterminated value for label %s", name)
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		}
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// WriteOpenMetrics writes every sample of ts as an OpenMetrics sample line.
+// The caller terminates the exposition with "# EOF".
+func WriteOpenMetrics(w io.Writer, ts *metrics.Timeseries) error {
+	name := ts.Labels["__name__"]
+
+	keys := make([]string, 0, len(ts.Labels))
+	for key := range ts.Labels {
+		if key != "__name__" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var series strings.Builder
+	series.WriteString(name)
+	if len(keys) > 0 {
+		series.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				series.WriteByte(',')
+			}
+			series.WriteString(key)
+			series.WriteString(`="`)
+			series.WriteString(escapeLabelValue(ts.Labels[key]))
+			series.WriteByte('"')
+		}
+		series.WriteByte('}')
+	}
+	prefix := series.String()
+
+	for _, sample := range ts.Samples {
+		_, err := fmt.Fprintf(w, "%s %s %s\n", prefix,
+			strconv.FormatFloat(sample.Value, 'g', -1, 64),
+			strconv.FormatFloat(float64(sample.Timestamp)/1e3, 'f', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
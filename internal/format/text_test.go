@@ -0,0 +1,176 @@
+package format
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		openMetrics bool
+		want        []Sample
+		wantErr     bool
+	}{
+		{
+			name: "prometheus text with comments",
+			input: `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+rpc_duration_seconds 1.5e-3
+`,
+			want: []Sample{
+				{Name: "http_requests_total", Labels: map[string]string{"method": "post", "code": "200"}, Value: 1027, Timestamp: 1395066363000, Family: "http_requests_total", Type: TypeCounter, Help: "The total number of HTTP requests."},
+				{Name: "http_requests_total", Labels: map[string]string{"method": "post", "code": "400"}, Value: 3, Timestamp: 1395066363000, Family: "http_requests_total", Type: TypeCounter, Help: "The total number of HTTP requests."},
+				{Name: "rpc_duration_seconds", Labels: map[string]string{}, Value: 0.0015, Family: "rpc_duration_seconds", Type: TypeUnknown},
+			},
+		},
+		{
+			name:        "openmetrics seconds timestamps and exemplars",
+			input:       "foo_total{a=\"x # y\"} 17 1520879607.789 # {trace_id=\"KOO5S4vxi0o\"} 0.67\n# EOF\n",
+			openMetrics: true,
+			want: []Sample{
+				{Name: "foo_total", Labels: map[string]string{"a": "x # y"}, Value: 17, Timestamp: 1520879607789, Family: "foo_total", Type: TypeUnknown, Exemplar: &Exemplar{Labels: map[string]string{"trace_id": "KOO5S4vxi0o"}, Value: 0.67}},
+			},
+		},
+		{
+			name:        "openmetrics histogram bucket exemplar with timestamp",
+			input:       "req_seconds_bucket{le=\"0.5\"} 3 # {trace_id=\"4bf92f\",span_id=\"00f067\"} 0.42 1520879607.5\n",
+			openMetrics: true,
+			want: []Sample{
+				{Name: "req_seconds_bucket", Labels: map[string]string{"le": "0.5"}, Value: 3, Family: "req_seconds_bucket", Type: TypeUnknown, Exemplar: &Exemplar{Labels: map[string]string{"trace_id": "4bf92f", "span_id": "00f067"}, Value: 0.42, Timestamp: 1520879607500}},
+			},
+		},
+		{
+			name:        "malformed exemplar",
+			input:       "foo_total 17 # trace_id=\"abc\" 1\n",
+			openMetrics: true,
+			wantErr:     true,
+		},
+		{
+			name:  "escaped label values and trailing comma",
+			input: `msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\"",} 1.458255915e9`,
+			want: []Sample{
+				{Name: "msdos_file_access_time_seconds", Labels: map[string]string{"path": `C:\DIR\FILE.TXT`, "error": "Cannot find file:\n\"FILE.TXT\""}, Value: 1.458255915e9, Family: "msdos_file_access_time_seconds", Type: TypeUnknown},
+			},
+		},
+		{
+			name: "openmetrics counter, histogram and summary families",
+			input: `# TYPE acme_http_router_request_seconds histogram
+# HELP acme_http_router_request_seconds Latency though all of ACME's HTTP request router.
+acme_http_router_request_seconds_bucket{le="0.5"} 3
+acme_http_router_request_seconds_bucket{le="+Inf"} 4
+acme_http_router_request_seconds_sum 2.5
+acme_http_router_request_seconds_count 4
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.99"} 76656
+rpc_duration_seconds_sum 1.7560473e+07
+# TYPE jobs counter
+jobs_total 7
+# EOF
+ignored_after_eof 1
+`,
+			openMetrics: true,
+			want: []Sample{
+				{Name: "acme_http_router_request_seconds_bucket", Labels: map[string]string{"le": "0.5"}, Value: 3, Family: "acme_http_router_request_seconds", Type: TypeHistogram, Help: "Latency though all of ACME's HTTP request router."},
+				{Name: "acme_http_router_request_seconds_bucket", Labels: map[string]string{"le": "+Inf"}, Value: 4, Family: "acme_http_router_request_seconds", Type: TypeHistogram, Help: "Latency though all of ACME's HTTP request router."},
+				{Name: "acme_http_router_request_seconds_sum", Labels: map[string]string{}, Value: 2.5, Family: "acme_http_router_request_seconds", Type: TypeHistogram, Help: "Latency though all of ACME's HTTP request router."},
+				{Name: "acme_http_router_request_seconds_count", Labels: map[string]string{}, Value: 4, Family: "acme_http_router_request_seconds", Type: TypeHistogram, Help: "Latency though all of ACME's HTTP request router."},
+				{Name: "rpc_duration_seconds", Labels: map[string]string{"quantile": "0.99"}, Value: 76656, Family: "rpc_duration_seconds", Type: TypeSummary},
+				{Name: "rpc_duration_seconds_sum", Labels: map[string]string{}, Value: 1.7560473e+07, Family: "rpc_duration_seconds", Type: TypeSummary},
+				{Name: "jobs_total", Labels: map[string]string{}, Value: 7, Family: "jobs", Type: TypeCounter},
+			},
+		},
+		{
+			name:    "histogram bucket without le",
+			input:   "# TYPE h histogram\nh_bucket 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "summary quantile without label",
+			input:   "# TYPE s summary\ns 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			input:   "# TYPE s timer\ns 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			input:   "up{job=\"a\"}\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated label value",
+			input:   "up{job=\"a} 1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Sample
+			err := ParseText(strings.NewReader(tt.input), tt.openMetrics, func(sample Sample) error {
+				got = append(got, sample)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseText() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseText() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseText_SpecialValues(t *testing.T) {
+	var got []float64
+	err := ParseText(strings.NewReader("a +Inf\nb -Inf\nc NaN\n"), false, func(sample Sample) error {
+		got = append(got, sample.Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseText() error = %v", err)
+	}
+	if !math.IsInf(got[0], 1) || !math.IsInf(got[1], -1) || !math.IsNaN(got[2]) {
+		t.Errorf("ParseText() = %v, want [+Inf -Inf NaN]", got)
+	}
+}
+
+func TestWriteOpenMetrics_RoundTrip(t *testing.T) {
+	ts := &metrics.Timeseries{
+		Labels:  map[string]string{"__name__": "up", "job": "a\"b", "instance": "host:9100"},
+		Samples: []*metrics.Sample{{Value: 1, Timestamp: 1620474602123}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, ts); err != nil {
+		t.Fatalf("WriteOpenMetrics() error = %v", err)
+	}
+
+	want := "up{instance=\"host:9100\",job=\"a\\\"b\"} 1 1620474602.123\n"
+	if buf.String() != want {
+		t.Errorf("WriteOpenMetrics() = %q, want %q", buf.String(), want)
+	}
+
+	err := ParseText(&buf, true, func(sample Sample) error {
+		sample.Labels["__name__"] = sample.Name
+		if !reflect.DeepEqual(sample.Labels, ts.Labels) || sample.Timestamp != 1620474602123 {
+			t.Errorf("ParseText() = %+v, want %v", sample, ts)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseText() error = %v", err)
+	}
+}
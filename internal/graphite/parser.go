@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Point is a single Graphite datapoint
+type Point struct {
+	Path      string
+	Tags      map[string]string // Tags of a Graphite 1.1 tagged path such as cpu;host=a
+	Value     float64
+	Timestamp int64 // Milliseconds
+}
+
+// ParseLine parses a plaintext protocol line `path value timestamp`. The
+// timestamp is in seconds, -1 or a missing timestamp stand for now.
+func ParseLine(line string, now int64) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Point{}, fmt.Errorf("invalid line %q, expected path value timestamp", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	timestamp := now
+	if len(fields) == 3 {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		if seconds != -1 {
+			timestamp = int64(math.Round(seconds * 1e3))
+		}
+	}
+
+	return newPoint(fields[0], value, timestamp)
+}
+
+// newPoint splits the tags off a tagged path
+func newPoint(path string, value float64, timestamp int64) (Point, error) {
+	parts := strings.Split(path, ";")
+	point := Point{Path: parts[0], Tags: make(map[string]string), Value: value, Timestamp: timestamp}
+	if point.Path == "" {
+		return point, fmt.Errorf("empty path")
+	}
+
+	for _, tag := range parts[1:] {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" || value == "" {
+			return point, fmt.Errorf("invalid tag %q in %s", tag, path)
+		}
+		point.Tags[key] = value
+	}
+
+	return point, nil
+}
@@ -0,0 +1,568 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Pickle opcodes used by carbon clients for lists of (path, (timestamp, value))
+// tuples. Opcodes that construct arbitrary objects are not supported, which
+// keeps the decoder safe against untrusted input.
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opNone           = 'N'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opBinInt2        = 'M'
+	opLong           = 'L'
+	opFloat          = 'F'
+	opBinFloat       = 'G'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opEmptyList      = ']'
+	opAppend         = 'a'
+	opAppends        = 'e'
+	opList           = 'l'
+	opEmptyTuple     = ')'
+	opTuple          = 't'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opGet            = 'g'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+	opShortBinBytes  = 'C'
+	opBinBytes       = 'B'
+
+	opProto           = 0x80
+	opTuple1          = 0x85
+	opTuple2          = 0x86
+	opTuple3          = 0x87
+	opNewTrue         = 0x88
+	opNewFalse        = 0x89
+	opLong1           = 0x8a
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+const (
+	// maxPickleSize bounds the size of a single pickle message
+	maxPickleSize = 16 << 20
+
+	// maxPickleDepth bounds the nesting of decoded lists and tuples
+	maxPickleDepth = 16
+
+	// maxPickleItems bounds the items of the lists and tuples of a message
+	maxPickleItems = 1 << 20
+)
+
+// mark separates the items of a MARK delimited list or tuple on the stack
+type mark struct{}
+
+// pickleList is a list under construction. Lists are appended to after they
+// are memoized, so they are shared by pointer until decoding is done.
+type pickleList struct {
+	items []interface{}
+}
+
+// decodePickle decodes a carbon pickle message into points
+func decodePickle(data []byte) ([]Point, error) {
+	value, err := unpickle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of datapoints, got %T", value)
+	}
+
+	points := make([]Point, 0, len(list))
+	for _, item := range list {
+		metric, ok := item.([]interface{})
+		if !ok || len(metric) != 2 {
+			return nil, errors.New("expected (path, (timestamp, value)) tuples")
+		}
+		path, ok := metric[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a path string, got %T", metric[0])
+		}
+		datapoint, ok := metric[1].([]interface{})
+		if !ok || len(datapoint) != 2 {
+			return nil, fmt.Errorf("expected a (timestamp, value) tuple for %s", path)
+		}
+
+		seconds, err := toFloat(datapoint[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp for %s: %w", path, err)
+		}
+		value, err := toFloat(datapoint[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", path, err)
+		}
+
+		point, err := newPoint(path, value, int64(math.Round(seconds*1e3)))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T", v)
+	}
+}
+
+// unpickle evaluates the pickle opcodes in data. Lists and tuples both decode
+// to []interface{}, integers to int64 or *big.Int and strings to string.
+func unpickle(data []byte) (interface{}, error) {
+	d := &pickleDecoder{data: data, memo: make(map[int]interface{})}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, errors.New("pickle: unexpected end of data")
+		}
+		op := d.data[d.pos]
+		d.pos++
+
+		if op == opStop {
+			if len(d.stack) != 1 {
+				return nil, errors.New("pickle: invalid stack at STOP")
+			}
+			r := &listResolver{lists: make(map[*pickleList][]interface{}), tuples: make(map[tupleKey][]interface{})}
+			value, err := r.resolve(d.stack[0], 0)
+			if err != nil {
+				return nil, fmt.Errorf("pickle: %w", err)
+			}
+			return value, nil
+		}
+		if err := d.exec(op); err != nil {
+			return nil, fmt.Errorf("pickle: opcode 0x%02x at %d: %w", op, d.pos-1, err)
+		}
+	}
+}
+
+type pickleDecoder struct {
+	data  []byte
+	pos   int
+	stack []interface{}
+	memo  map[int]interface{}
+}
+
+func (d *pickleDecoder) exec(op byte) error {
+	switch op {
+	case opProto:
+		_, err := d.read(1)
+		return err
+	case opFrame:
+		_, err := d.read(8)
+		return err
+	case opMark:
+		d.push(mark{})
+	case opPop:
+		_, err := d.pop()
+		return err
+	case opNone:
+		d.push(nil)
+	case opNewTrue:
+		d.push(int64(1))
+	case opNewFalse:
+		d.push(int64(0))
+
+	case opInt:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "01":
+			d.push(int64(1))
+		case "00":
+			d.push(int64(0))
+		default:
+			v, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return err
+			}
+			d.push(v)
+		}
+	case opBinInt:
+		b, err := d.read(4)
+		if err != nil {
+			return err
+		}
+		d.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinInt1:
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		d.push(int64(b[0]))
+	case opBinInt2:
+		b, err := d.read(2)
+		if err != nil {
+			return err
+		}
+		d.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		v, ok := new(big.Int).SetString(strings.TrimSuffix(line, "L"), 10)
+		if !ok {
+			return fmt.Errorf("invalid long %q", line)
+		}
+		d.pushInt(v)
+	case opLong1:
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		b, err = d.read(int(b[0]))
+		if err != nil {
+			return err
+		}
+		d.pushInt(decodeLong(b))
+
+	case opFloat:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return err
+		}
+		d.push(v)
+	case opBinFloat:
+		b, err := d.read(8)
+		if err != nil {
+			return err
+		}
+		d.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+	case opString, opUnicode:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		if op == opString {
+			if line, err = unquotePython(line); err != nil {
+				return fmt.Errorf("invalid string: %w", err)
+			}
+		}
+		d.push(line)
+	case opShortBinString, opShortBinUnicode, opShortBinBytes:
+		return d.pushBytes(1)
+	case opBinString, opBinUnicode, opBinBytes:
+		return d.pushBytes(4)
+	case opBinUnicode8:
+		return d.pushBytes(8)
+
+	case opEmptyList:
+		d.push(&pickleList{})
+	case opEmptyTuple:
+		d.push([]interface{}{})
+	case opList:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		d.push(&pickleList{items: items})
+	case opTuple:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		d.push(items)
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(d.stack) < n {
+			return errors.New("stack underflow")
+		}
+		items := append([]interface{}{}, d.stack[len(d.stack)-n:]...)
+		d.stack = d.stack[:len(d.stack)-n]
+		d.push(items)
+	case opAppend:
+		item, err := d.pop()
+		if err != nil {
+			return err
+		}
+		return d.appendTop(item)
+	case opAppends:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		return d.appendTop(items...)
+
+	case opPut:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		return d.put(index)
+	case opBinPut:
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		return d.put(int(b[0]))
+	case opLongBinPut:
+		b, err := d.read(4)
+		if err != nil {
+			return err
+		}
+		return d.put(int(binary.LittleEndian.Uint32(b)))
+	case opMemoize:
+		return d.put(len(d.memo))
+	case opGet:
+		line, err := d.readLine()
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		return d.get(index)
+	case opBinGet:
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		return d.get(int(b[0]))
+	case opLongBinGet:
+		b, err := d.read(4)
+		if err != nil {
+			return err
+		}
+		return d.get(int(binary.LittleEndian.Uint32(b)))
+
+	default:
+		return errors.New("unsupported opcode")
+	}
+	return nil
+}
+
+func (d *pickleDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *pickleDecoder) readLine() (string, error) {
+	i := bytes.IndexByte(d.data[d.pos:], '\n')
+	if i < 0 {
+		return "", errors.New("unterminated line")
+	}
+	line := string(d.data[d.pos : d.pos+i])
+	d.pos += i + 1
+	return line, nil
+}
+
+// pushBytes pushes a string prefixed by its little endian length of size bytes
+func (d *pickleDecoder) pushBytes(size int) error {
+	b, err := d.read(size)
+	if err != nil {
+		return err
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	if n > maxPickleSize {
+		return fmt.Errorf("string of %d bytes is too long", n)
+	}
+	if b, err = d.read(int(n)); err != nil {
+		return err
+	}
+	d.push(string(b))
+	return nil
+}
+
+func (d *pickleDecoder) push(v interface{}) {
+	d.stack = append(d.stack, v)
+}
+
+// pushInt pushes v as an int64 when it fits
+func (d *pickleDecoder) pushInt(v *big.Int) {
+	if v.IsInt64() {
+		d.push(v.Int64())
+		return
+	}
+	d.push(v)
+}
+
+func (d *pickleDecoder) pop() (interface{}, error) {
+	if len(d.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	v := d.stack[len(d.stack)-1]
+	d.stack = d.stack[:len(d.stack)-1]
+	return v, nil
+}
+
+// popMark pops the items above the topmost mark and the mark itself
+func (d *pickleDecoder) popMark() ([]interface{}, error) {
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		if _, ok := d.stack[i].(mark); ok {
+			items := append([]interface{}{}, d.stack[i+1:]...)
+			d.stack = d.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, errors.New("missing mark")
+}
+
+func (d *pickleDecoder) appendTop(items ...interface{}) error {
+	if len(d.stack) == 0 {
+		return errors.New("stack underflow")
+	}
+	list, ok := d.stack[len(d.stack)-1].(*pickleList)
+	if !ok {
+		return fmt.Errorf("cannot append to %T", d.stack[len(d.stack)-1])
+	}
+	list.items = append(list.items, items...)
+	return nil
+}
+
+func (d *pickleDecoder) put(index int) error {
+	if len(d.stack) == 0 {
+		return errors.New("stack underflow")
+	}
+	d.memo[index] = d.stack[len(d.stack)-1]
+	return nil
+}
+
+func (d *pickleDecoder) get(index int) error {
+	v, ok := d.memo[index]
+	if !ok {
+		return fmt.Errorf("memo %d not found", index)
+	}
+	d.push(v)
+	return nil
+}
+
+// tupleKey identifies a tuple by its backing array, tuples are never changed
+// after they are built
+type tupleKey struct {
+	first *interface{}
+	len   int
+}
+
+// listResolver replaces the lists under construction with their items. Every
+// list and tuple is resolved once and shared by all its references, so that
+// memoized values referenced over and over do not multiply the decoded items.
+type listResolver struct {
+	lists  map[*pickleList][]interface{}
+	tuples map[tupleKey][]interface{}
+	items  int
+}
+
+// resolve resolves the lists in v. Nesting beyond maxPickleDepth, which only
+// self-referencing lists reach, is replaced with nil.
+func (r *listResolver) resolve(v interface{}, depth int) (interface{}, error) {
+	if depth > maxPickleDepth {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case *pickleList:
+		if items, ok := r.lists[v]; ok {
+			return items, nil
+		}
+		// Registered before its items so that self references share it
+		items := make([]interface{}, len(v.items))
+		r.lists[v] = items
+		return items, r.resolveItems(items, v.items, depth)
+	case []interface{}:
+		if len(v) == 0 {
+			return v, nil
+		}
+		key := tupleKey{first: &v[0], len: len(v)}
+		if items, ok := r.tuples[key]; ok {
+			return items, nil
+		}
+		items := make([]interface{}, len(v))
+		r.tuples[key] = items
+		return items, r.resolveItems(items, v, depth)
+	default:
+		return v, nil
+	}
+}
+
+func (r *listResolver) resolveItems(dst, src []interface{}, depth int) error {
+	r.items += len(src)
+	if r.items > maxPickleItems {
+		return fmt.Errorf("more than %d items", maxPickleItems)
+	}
+	for i, item := range src {
+		resolved, err := r.resolve(item, depth+1)
+		if err != nil {
+			return err
+		}
+		dst[i] = resolved
+	}
+	return nil
+}
+
+// unquotePython unquotes a string literal written by Python's repr, which
+// prefers single quotes
+func unquotePython(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", fmt.Errorf("unquoted string %s", s)
+	}
+	if s[0] == '\'' {
+		inner := strings.ReplaceAll(s[1:len(s)-1], `\'`, "'")
+		s = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// decodeLong decodes a little endian two's complement integer
+func decodeLong(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	v := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return v
+}
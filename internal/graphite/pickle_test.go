@@ -0,0 +1,95 @@
+package graphite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_decodePickle(t *testing.T) {
+	want := []Point{
+		{Path: "servers.web01.cpu.user", Tags: map[string]string{}, Value: 0.5, Timestamp: 1620474600000},
+		{Path: "disk", Tags: map[string]string{"host": "a"}, Value: 12, Timestamp: 1620474600250},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []Point
+		wantErr bool
+	}{
+		{
+			name: "protocol 0",
+			data: "(lp0\n(Vservers.web01.cpu.user\np1\n(I1620474600\nF0.5\ntp2\ntp3\na(Vdisk;host=a\np4\n(F1620474600.25\nI12\ntp5\ntp6\na.",
+			want: want,
+		},
+		{
+			name: "protocol 0 written by python 2",
+			data: "(lp0\n(S'servers.web01.cpu.user'\np1\n(L1620474600L\nF0.5\ntp2\ntp3\na(S'disk;host=a'\np4\n(F1620474600.25\nI12\ntp5\ntp6\na.",
+			want: want,
+		},
+		{
+			name: "protocol 2",
+			data: "\x80\x02]q\x00(X\x16\x00\x00\x00servers.web01.cpu.userq\x01J\xe8z\x96`G?\xe0\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x0b\x00\x00\x00disk;host=aq\x04GA\xd8%\x9e\xba\x10\x00\x00K\x0c\x86q\x05\x86q\x06e.",
+			want: want,
+		},
+		{
+			name: "protocol 4",
+			data: "\x80\x04\x95M\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x16servers.web01.cpu.user\x94J\xe8z\x96`G?\xe0\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x0bdisk;host=a\x94GA\xd8%\x9e\xba\x10\x00\x00K\x0c\x86\x94\x86\x94e.",
+			want: want,
+		},
+		{
+			name:    "object construction is rejected",
+			data:    "cos\nsystem\n(S'true'\ntR.",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			data:    "\x80\x02]q\x00(X\x16\x00\x00\x00servers",
+			wantErr: true,
+		},
+		{
+			name:    "not a list of datapoints",
+			data:    "K\x01.",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePickle([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodePickle() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodePickle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_unpickle_Limits(t *testing.T) {
+	// Every level is a list of ten references to the list of the level below
+	var nested strings.Builder
+	nested.WriteString("\x80\x02]q\x000")
+	for level := 1; level <= maxPickleDepth; level++ {
+		nested.WriteString("](" + strings.Repeat("h"+string(rune(level-1)), 10) + "eq" + string(rune(level)) + "0")
+	}
+	nested.WriteString("h" + string(rune(maxPickleDepth)) + ".")
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "nested memo references", data: nested.String()},
+		{name: "too many items", data: "\x80\x02](" + strings.Repeat("K\x01", maxPickleItems+1) + "e.", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := unpickle([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Errorf("unpickle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+)
+
+const (
+	// maxBatchSize is the number of plaintext datapoints ingested in one request
+	maxBatchSize = 1000
+
+	// maxLineSize bounds a plaintext line, longer lines close the connection
+	maxLineSize = 64 << 10
+)
+
+// Ingester receives the datapoints, it is implemented by the ingestion service
+type Ingester interface {
+	IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error)
+}
+
+// Config represents the Graphite listener configuration
+type Config struct {
+	Addr       string // Address of the plaintext protocol listener, e.g. :2003
+	PickleAddr string // Optional address of the pickle protocol listener, e.g. :2004
+	SourceID   string // Source the datapoints are ingested for
+	SourceType string
+	Templates  []*Template
+}
+
+// Server is a carbon compatible listener for the Graphite plaintext and pickle
+// protocols. Paths are turned into metric names and labels by the templates.
+type Server struct {
+	cfg      Config
+	ingester Ingester
+
+	listener       net.Listener
+	pickleListener net.Listener
+}
+
+// NewServer creates a new Graphite Server
+func NewServer(cfg Config, ingester Ingester) *Server {
+	return &Server{cfg: cfg, ingester: ingester}
+}
+
+// Start binds the listeners and serves them until ctx is cancelled. A source
+// id is required, the datapoints of every client are ingested for it.
+func (s *Server) Start(ctx context.Context) error {
+	if s.cfg.SourceID == "" {
+		return errors.New("source id is required")
+	}
+
+	var err error
+	if s.listener, err = net.Listen("tcp", s.cfg.Addr); err != nil {
+		return err
+	}
+	if s.cfg.PickleAddr != "" {
+		if s.pickleListener, err = net.Listen("tcp", s.cfg.PickleAddr); err != nil {
+			s.listener.Close()
+			return err
+		}
+		go s.serve(ctx, s.pickleListener, s.handlePickle)
+	}
+	go s.serve(ctx, s.listener, s.handlePlaintext)
+
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+		if s.pickleListener != nil {
+			s.pickleListener.Close()
+		}
+	}()
+
+	log.Printf("Listening for Graphite metrics on %s", s.listener.Addr())
+	return nil
+}
+
+// Addr returns the address of the plaintext listener
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// PickleAddr returns the address of the pickle listener
+func (s *Server) PickleAddr() net.Addr {
+	return s.pickleListener.Addr()
+}
+
+func (s *Server) serve(ctx context.Context, listener net.Listener, handle func(context.Context, net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Error accepting Graphite connection: %v", err)
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			handle(ctx, conn)
+		}()
+	}
+}
+
+// handlePlaintext reads `path value timestamp` lines. Datapoints are ingested
+// whenever the connection has no more buffered input or the batch is full. A
+// line longer than maxLineSize closes the connection.
+func (s *Server) handlePlaintext(ctx context.Context, conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, maxLineSize)
+	var batch []*ingestion.MetricData
+	for {
+		data, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			log.Printf("Closing Graphite connection from %s: line longer than %d bytes", conn.RemoteAddr(), maxLineSize)
+			s.ingest(ctx, batch)
+			return
+		}
+		if line := string(data); strings.TrimSpace(line) != "" {
+			point, parseErr := ParseLine(line, time.Now().UnixMilli())
+			if parseErr != nil {
+				log.Printf("Dropping Graphite line from %s: %v", conn.RemoteAddr(), parseErr)
+			} else {
+				batch = append(batch, s.metricData(point))
+			}
+		}
+
+		if len(batch) >= maxBatchSize || (len(batch) > 0 && (err != nil || reader.Buffered() == 0)) {
+			s.ingest(ctx, batch)
+			batch = nil
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error reading Graphite connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// handlePickle reads pickle messages, each prefixed by its big endian length
+func (s *Server) handlePickle(ctx context.Context, conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error reading Graphite pickle from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > maxPickleSize {
+			log.Printf("Closing Graphite pickle connection from %s: message of %d bytes is too large", conn.RemoteAddr(), size)
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			log.Printf("Error reading Graphite pickle from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		points, err := decodePickle(data)
+		if err != nil {
+			log.Printf("Dropping Graphite pickle from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+
+		batch := make([]*ingestion.MetricData, 0, len(points))
+		for _, point := range points {
+			batch = append(batch, s.metricData(point))
+		}
+		s.ingest(ctx, batch)
+	}
+}
+
+// metricData applies the templates to a point. Tags of tagged paths take
+// precedence over the labels of the template.
+func (s *Server) metricData(point Point) *ingestion.MetricData {
+	name, labels := resolve(s.cfg.Templates, point.Path)
+	for key, value := range point.Tags {
+		labels[format.SanitizeLabelName(key)] = value
+	}
+
+	return &ingestion.MetricData{
+		Name:      name,
+		Labels:    labels,
+		Value:     point.Value,
+		Timestamp: point.Timestamp,
+	}
+}
+
+func (s *Server) ingest(ctx context.Context, metrics []*ingestion.MetricData) {
+	if len(metrics) == 0 {
+		return
+	}
+
+	_, err := s.ingester.IngestData(ctx, &ingestion.IngestDataRequest{
+		SourceId:   s.cfg.SourceID,
+		SourceType: s.cfg.SourceType,
+		Metrics:    metrics,
+	})
+	if err != nil {
+		log.Printf("Failed to ingest %d Graphite metrics: %v", len(metrics), err)
+	}
+}
@@ -0,0 +1,123 @@
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+type fakeIngester struct {
+	mu      sync.Mutex
+	metrics []*ingestion.MetricData
+}
+
+func (f *fakeIngester) IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metrics = append(f.metrics, req.Metrics...)
+	return &ingestion.IngestDataResponse{}, nil
+}
+
+func (f *fakeIngester) waitFor(t *testing.T, n int) []*ingestion.MetricData {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		if len(f.metrics) >= n {
+			defer f.mu.Unlock()
+			return f.metrics
+		}
+		f.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d metrics", n)
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	tmpl, err := ParseTemplate("servers.*.cpu.* -> host, cpu")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ingester := &fakeIngester{}
+	server := NewServer(Config{Addr: "127.0.0.1:0", PickleAddr: "127.0.0.1:0", SourceID: "carbon", SourceType: "graphite", Templates: []*Template{tmpl}}, ingester)
+	if err := server.Start(ctx); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial plaintext listener: %v", err)
+	}
+	conn.Write([]byte("servers.web01.cpu.user 0.5 1620474600\nnot a valid line\n\n"))
+	conn.Close()
+
+	metrics := ingester.waitFor(t, 1)
+	got := metrics[0]
+	if got.Name != "servers_cpu" || got.Value != 0.5 || got.Timestamp != 1620474600000 || got.Labels["host"] != "web01" || got.Labels["cpu"] != "user" {
+		t.Errorf("plaintext metric = %v, want servers_cpu{host=web01,cpu=user} 0.5", got)
+	}
+
+	payload := "\x80\x02]q\x00(X\x0b\x00\x00\x00disk;host=aq\x04GA\xd8%\x9e\xba\x10\x00\x00K\x0c\x86q\x05\x86q\x06e."
+	message := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(message, uint32(len(payload)))
+	message = append(message, payload...)
+
+	conn, err = net.Dial("tcp", server.PickleAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial pickle listener: %v", err)
+	}
+	conn.Write(message)
+	conn.Close()
+
+	metrics = ingester.waitFor(t, 2)
+	got = metrics[1]
+	if got.Name != "disk" || got.Value != 12 || got.Timestamp != 1620474600250 || got.Labels["host"] != "a" {
+		t.Errorf("pickle metric = %v, want disk{host=a} 12", got)
+	}
+}
+
+func TestServer_LongLine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ingester := &fakeIngester{}
+	server := NewServer(Config{Addr: "127.0.0.1:0", SourceID: "carbon", SourceType: "graphite"}, ingester)
+	if err := server.Start(ctx); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial plaintext listener: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("up 1 1620474600\n" + strings.Repeat("a", maxLineSize+1)))
+
+	// The connection is closed instead of buffering the line without bound
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Errorf("Read() error = %v, want the connection closed", err)
+	}
+	if metrics := ingester.waitFor(t, 1); metrics[0].Name != "up" {
+		t.Errorf("metric = %v, want up", metrics[0])
+	}
+}
+
+func TestServer_Start_SourceID(t *testing.T) {
+	server := NewServer(Config{Addr: "127.0.0.1:0", SourceType: "graphite"}, &fakeIngester{})
+	if err := server.Start(context.Background()); err == nil {
+		t.Error("Server.Start() error = nil, want an error for a missing source id")
+	}
+}
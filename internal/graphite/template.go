@@ -0,0 +1,125 @@
+package graphite
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/yay14/pulse/internal/format"
+)
+
+// skipNode is the label name of wildcard nodes that are neither labels nor part of the name
+const skipNode = "_"
+
+// Template turns a dotted Graphite path into a metric name and labels. The
+// nodes matched by wildcards become labels, the literal nodes form the name.
+type Template struct {
+	pattern []string
+	labels  []string // Label name of every wildcard node, in order
+}
+
+// ParseTemplate parses a rule such as "servers.*.cpu.* -> host, cpu". Every
+// node of the pattern containing a glob becomes the next label of the list,
+// a label of "_" drops its node.
+func ParseTemplate(rule string) (*Template, error) {
+	parts := strings.SplitN(rule, "->", 2)
+	pattern := strings.TrimSpace(parts[0])
+	if pattern == "" {
+		return nil, fmt.Errorf("template %q has no pattern", rule)
+	}
+
+	t := &Template{pattern: strings.Split(pattern, ".")}
+	if len(parts) == 2 {
+		for _, label := range strings.Split(parts[1], ",") {
+			if label = strings.TrimSpace(label); label == "" {
+				return nil, fmt.Errorf("template %q has an empty label", rule)
+			}
+			t.labels = append(t.labels, label)
+		}
+	}
+
+	var wildcards int
+	for _, node := range t.pattern {
+		if _, err := path.Match(node, ""); err != nil {
+			return nil, fmt.Errorf("template %q: invalid node %q", rule, node)
+		}
+		if isGlob(node) {
+			wildcards++
+		}
+	}
+	if wildcards != len(t.labels) {
+		return nil, fmt.Errorf("template %q has %d wildcards but %d labels", rule, wildcards, len(t.labels))
+	}
+
+	return t, nil
+}
+
+// LoadTemplates reads one template rule per line from filename, skipping blank
+// lines and # comments
+func LoadTemplates(filename string) ([]*Template, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read graphite templates: %w", err)
+	}
+	defer f.Close()
+
+	var templates []*Template
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		t, err := ParseTemplate(line)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, scanner.Err()
+}
+
+// apply maps the nodes of a path onto the template, ok is false if they don't match
+func (t *Template) apply(nodes []string) (name string, labels map[string]string, ok bool) {
+	if len(nodes) != len(t.pattern) {
+		return "", nil, false
+	}
+
+	labels = make(map[string]string)
+	var nameNodes []string
+	wildcard := 0
+	for i, node := range nodes {
+		if matched, _ := path.Match(t.pattern[i], node); !matched {
+			return "", nil, false
+		}
+		if !isGlob(t.pattern[i]) {
+			nameNodes = append(nameNodes, node)
+			continue
+		}
+		if label := t.labels[wildcard]; label != skipNode {
+			labels[format.SanitizeLabelName(label)] = node
+		}
+		wildcard++
+	}
+
+	return format.SanitizeMetricName(strings.Join(nameNodes, "_")), labels, true
+}
+
+// resolve returns the name and labels of a path from the first matching
+// template. Paths without a matching template keep all their nodes in the name.
+func resolve(templates []*Template, p string) (string, map[string]string) {
+	nodes := strings.Split(p, ".")
+	for _, t := range templates {
+		if name, labels, ok := t.apply(nodes); ok {
+			return name, labels
+		}
+	}
+	return format.SanitizeMetricName(strings.Join(nodes, "_")), make(map[string]string)
+}
+
+func isGlob(node string) bool {
+	return strings.ContainsAny(node, "*?[")
+}
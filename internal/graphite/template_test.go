@@ -0,0 +1,58 @@
+package graphite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_resolve(t *testing.T) {
+	var templates []*Template
+	for _, rule := range []string{
+		"servers.*.cpu.* -> host, cpu",
+		"servers.*.disk.*.bytes_free -> host, _",
+		"stats.counters.* -> name",
+	} {
+		tmpl, err := ParseTemplate(rule)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q) error = %v", rule, err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	tests := []struct {
+		path       string
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{path: "servers.web01.cpu.user", wantName: "servers_cpu", wantLabels: map[string]string{"host": "web01", "cpu": "user"}},
+		{path: "servers.web01.disk.sda1.bytes_free", wantName: "servers_disk_bytes_free", wantLabels: map[string]string{"host": "web01"}},
+		{path: "stats.counters.logins", wantName: "stats_counters", wantLabels: map[string]string{"name": "logins"}},
+		{path: "servers.web01.cpu.user.extra", wantName: "servers_web01_cpu_user_extra", wantLabels: map[string]string{}},
+		{path: "app-1.requests", wantName: "app_1_requests", wantLabels: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			name, labels := resolve(templates, tt.path)
+			if name != tt.wantName {
+				t.Errorf("resolve() name = %s, want %s", name, tt.wantName)
+			}
+			if !reflect.DeepEqual(labels, tt.wantLabels) {
+				t.Errorf("resolve() labels = %v, want %v", labels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestParseTemplate_Invalid(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"servers.*.cpu.* -> host",
+		"servers.*.cpu -> host, cpu",
+		"servers.*.cpu.* -> host, ",
+		"servers.[.cpu -> host",
+	} {
+		if _, err := ParseTemplate(rule); err == nil {
+			t.Errorf("ParseTemplate(%q) error = nil, want error", rule)
+		}
+	}
+}
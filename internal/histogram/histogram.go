@@ -0,0 +1,114 @@
+package histogram
+
+import (
+	"math"
+	"sort"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+// Bucket is a cumulative histogram bucket
+type Bucket struct {
+	UpperBound float64
+	Count      float64 // Number of observations up to UpperBound
+}
+
+// Buckets returns the cumulative buckets of h ordered by upper bound, ending
+// with the +Inf bucket
+func Buckets(h *ingestion.Histogram) []Bucket {
+	var buckets []Bucket
+	if h.Exponential != nil {
+		buckets = ExponentialBuckets(h.Exponential)
+	} else {
+		for _, b := range h.Buckets {
+			if !math.IsInf(b.UpperBound, 1) {
+				buckets = append(buckets, Bucket{UpperBound: b.UpperBound, Count: float64(b.Count)})
+			}
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].UpperBound < buckets[j].UpperBound })
+	}
+
+	return append(buckets, Bucket{UpperBound: math.Inf(1), Count: float64(h.Count)})
+}
+
+// ExponentialBuckets converts exponential buckets into cumulative buckets
+// without the +Inf bucket. Negative buckets come first, followed by the zero
+// bucket with zero_threshold as its upper bound and the positive buckets.
+func ExponentialBuckets(e *ingestion.ExponentialBuckets) []Bucket {
+	// Bucket index i covers (base^i, base^(i+1)] with base = 2^(2^-scale)
+	bound := func(index int64) float64 {
+		return math.Exp2(float64(index) * math.Exp2(-float64(e.Scale)))
+	}
+
+	buckets := make([]Bucket, 0, len(e.NegativeCounts)+len(e.PositiveCounts)+1)
+	var cumulative uint64
+	add := func(upperBound float64, count uint64) {
+		cumulative += count
+		buckets = append(buckets, Bucket{UpperBound: upperBound, Count: float64(cumulative)})
+	}
+
+	// The upper bound of the negative bucket (-base^(i+1), -base^i] is -base^i
+	for i := len(e.NegativeCounts) - 1; i >= 0; i-- {
+		add(-bound(int64(e.NegativeOffset)+int64(i)), e.NegativeCounts[i])
+	}
+	add(e.ZeroThreshold, e.ZeroCount)
+	for i, count := range e.PositiveCounts {
+		add(bound(int64(e.PositiveOffset)+int64(i)+1), count)
+	}
+
+	return buckets
+}
+
+// Quantile estimates the q-quantile of cumulative buckets ordered by upper
+// bound the same way as PromQL's histogram_quantile: the observations are
+// assumed to be spread linearly within the bucket holding the quantile. The
+// last bucket must be the +Inf bucket.
+func Quantile(q float64, buckets []Bucket) float64 {
+	switch {
+	case math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	}
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].UpperBound, 1) {
+		return math.NaN()
+	}
+
+	observations := buckets[len(buckets)-1].Count
+	if observations == 0 {
+		return math.NaN()
+	}
+	rank := q * observations
+
+	b := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].Count >= rank })
+	switch {
+	case b == len(buckets)-1:
+		return buckets[len(buckets)-2].UpperBound
+	case b == 0 && buckets[0].UpperBound <= 0:
+		return buckets[0].UpperBound
+	}
+
+	bucketStart, bucketEnd, count := 0.0, buckets[b].UpperBound, buckets[b].Count
+	if b > 0 {
+		bucketStart = buckets[b-1].UpperBound
+		count -= buckets[b-1].Count
+		rank -= buckets[b-1].Count
+	}
+	if count == 0 {
+		return bucketEnd
+	}
+	return bucketStart + (bucketEnd-bucketStart)*(rank/count)
+}
+
+// SummaryQuantile returns the value of quantile q of a summary, NaN if the
+// summary does not carry it
+func SummaryQuantile(q float64, s *ingestion.Summary) float64 {
+	for _, quantile := range s.Quantiles {
+		if quantile.Quantile == q {
+			return quantile.Value
+		}
+	}
+	return math.NaN()
+}
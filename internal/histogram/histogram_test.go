@@ -0,0 +1,104 @@
+package histogram
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+func TestBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		h    *ingestion.Histogram
+		want []Bucket
+	}{
+		{
+			name: "explicit buckets are sorted and end with +Inf",
+			h: &ingestion.Histogram{
+				Count:   10,
+				Buckets: []*ingestion.Bucket{{UpperBound: 1, Count: 6}, {UpperBound: 0.5, Count: 2}, {UpperBound: math.Inf(1), Count: 10}},
+			},
+			want: []Bucket{{UpperBound: 0.5, Count: 2}, {UpperBound: 1, Count: 6}, {UpperBound: math.Inf(1), Count: 10}},
+		},
+		{
+			name: "exponential buckets",
+			h: &ingestion.Histogram{
+				Count: 7,
+				Exponential: &ingestion.ExponentialBuckets{
+					Scale:          0,
+					ZeroCount:      1,
+					PositiveOffset: 0,
+					PositiveCounts: []uint64{2, 3},
+					NegativeOffset: 1,
+					NegativeCounts: []uint64{1},
+				},
+			},
+			want: []Bucket{
+				{UpperBound: -2, Count: 1},
+				{UpperBound: 0, Count: 2},
+				{UpperBound: 2, Count: 4},
+				{UpperBound: 4, Count: 7},
+				{UpperBound: math.Inf(1), Count: 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Buckets(tt.h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Buckets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	buckets := []Bucket{
+		{UpperBound: 0.1, Count: 10},
+		{UpperBound: 0.5, Count: 50},
+		{UpperBound: 1, Count: 90},
+		{UpperBound: math.Inf(1), Count: 100},
+	}
+
+	tests := []struct {
+		name    string
+		q       float64
+		buckets []Bucket
+		want    float64
+	}{
+		{name: "median", q: 0.5, buckets: buckets, want: 0.5},
+		{name: "interpolated within the first bucket", q: 0.05, buckets: buckets, want: 0.05},
+		{name: "interpolated", q: 0.7, buckets: buckets, want: 0.75},
+		{name: "quantile in the +Inf bucket", q: 0.99, buckets: buckets, want: 1},
+		{name: "below zero", q: -1, buckets: buckets, want: math.Inf(-1)},
+		{name: "above one", q: 2, buckets: buckets, want: math.Inf(1)},
+		{name: "negative first bucket", q: 0.1, buckets: []Bucket{{UpperBound: -1, Count: 5}, {UpperBound: math.Inf(1), Count: 10}}, want: -1},
+		{name: "missing +Inf bucket", q: 0.5, buckets: buckets[:3], want: math.NaN()},
+		{name: "no observations", q: 0.5, buckets: []Bucket{{UpperBound: 1}, {UpperBound: math.Inf(1)}}, want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Quantile(tt.q, tt.buckets)
+			if math.IsNaN(tt.want) {
+				if !math.IsNaN(got) {
+					t.Errorf("Quantile() = %v, want NaN", got)
+				}
+				return
+			}
+			if math.Abs(got-tt.want) > 1e-9 && got != tt.want {
+				t.Errorf("Quantile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaryQuantile(t *testing.T) {
+	s := &ingestion.Summary{Quantiles: []*ingestion.Quantile{{Quantile: 0.5, Value: 3}, {Quantile: 0.99, Value: 9}}}
+	if got := SummaryQuantile(0.99, s); got != 9 {
+		t.Errorf("SummaryQuantile(0.99) = %v, want 9", got)
+	}
+	if got := SummaryQuantile(0.9, s); !math.IsNaN(got) {
+		t.Errorf("SummaryQuantile(0.9) = %v, want NaN", got)
+	}
+}
@@ -0,0 +1,165 @@
+package histogram
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+// Expand flattens the histogram and summary samples of ts into the float
+// series VictoriaMetrics stores: name_bucket with le labels, name_sum and
+// name_count for histograms, name with quantile labels, name_sum and
+// name_count for summaries. Plain samples stay in a series with the labels of ts.
+// The exemplar of a histogram sample goes to the first bucket it falls into.
+func Expand(ts *metrics.Timeseries) []*metrics.Timeseries {
+	name := ts.Labels["__name__"]
+	set := &seriesSet{base: ts.Labels, index: make(map[string]*metrics.Timeseries)}
+
+	for _, sample := range ts.Samples {
+		switch {
+		case sample.Histogram != nil:
+			exemplar := sample.Exemplar
+			for _, b := range Buckets(sample.Histogram) {
+				bucket := set.add(name+"_bucket", "le", formatFloat(b.UpperBound), b.Count, sample.Timestamp)
+				if exemplar != nil && exemplar.Value <= b.UpperBound {
+					bucket.Exemplar = exemplar
+					exemplar = nil
+				}
+			}
+			set.add(name+"_sum", "", "", sample.Histogram.Sum, sample.Timestamp)
+			set.add(name+"_count", "", "", float64(sample.Histogram.Count), sample.Timestamp)
+		case sample.Summary != nil:
+			for _, q := range sample.Summary.Quantiles {
+				set.add(name, "quantile", formatFloat(q.Quantile), q.Value, sample.Timestamp)
+			}
+			set.add(name+"_sum", "", "", sample.Summary.Sum, sample.Timestamp)
+			set.add(name+"_count", "", "", float64(sample.Summary.Count), sample.Timestamp)
+		default:
+			set.add(name, "", "", sample.Value, sample.Timestamp).Exemplar = sample.Exemplar
+		}
+	}
+
+	return set.series
+}
+
+// seriesSet collects samples into series in order of their first sample
+type seriesSet struct {
+	base   map[string]string
+	index  map[string]*metrics.Timeseries
+	series []*metrics.Timeseries
+}
+
+func (s *seriesSet) add(name, label, labelValue string, value float64, timestamp int64) *metrics.Sample {
+	key := name + "\xff" + label + "\xff" + labelValue
+	ts, ok := s.index[key]
+	if !ok {
+		labels := make(map[string]string, len(s.base)+1)
+		for k, v := range s.base {
+			labels[k] = v
+		}
+		labels["__name__"] = name
+		if label != "" {
+			labels[label] = labelValue
+		}
+
+		ts = &metrics.Timeseries{Labels: labels}
+		s.index[key] = ts
+		s.series = append(s.series, ts)
+	}
+	sample := &metrics.Sample{Value: value, Timestamp: timestamp}
+	ts.Samples = append(ts.Samples, sample)
+	return sample
+}
+
+// QuantileSeries computes the q-quantile of _bucket series as returned by a
+// query, like histogram_quantile. Series are grouped by their labels without
+// __name__ and le, and the quantile is computed for every timestamp.
+func QuantileSeries(q float64, series []*metrics.TimeseriesData) []*metrics.TimeseriesData {
+	type group struct {
+		labels  map[string]string
+		buckets map[int64][]Bucket
+	}
+	groups := make(map[string]*group)
+	var keys []string
+
+	for _, ts := range series {
+		le, err := strconv.ParseFloat(ts.Labels["le"], 64)
+		if err != nil || !strings.HasSuffix(ts.Labels["__name__"], "_bucket") {
+			continue
+		}
+
+		labels := make(map[string]string, len(ts.Labels))
+		for k, v := range ts.Labels {
+			if k != "__name__" && k != "le" {
+				labels[k] = v
+			}
+		}
+		key := labelsKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels, buckets: make(map[int64][]Bucket)}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		for _, sample := range ts.Samples {
+			g.buckets[sample.Timestamp] = append(g.buckets[sample.Timestamp], Bucket{UpperBound: le, Count: sample.Value})
+		}
+	}
+
+	var result []*metrics.TimeseriesData
+	for _, key := range keys {
+		g := groups[key]
+		timestamps := make([]int64, 0, len(g.buckets))
+		for timestamp := range g.buckets {
+			timestamps = append(timestamps, timestamp)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		ts := &metrics.TimeseriesData{Labels: g.labels}
+		for _, timestamp := range timestamps {
+			buckets := g.buckets[timestamp]
+			sort.Slice(buckets, func(i, j int) bool { return buckets[i].UpperBound < buckets[j].UpperBound })
+			ensureMonotonic(buckets)
+			ts.Samples = append(ts.Samples, &metrics.Sample{Value: Quantile(q, buckets), Timestamp: timestamp})
+		}
+		result = append(result, ts)
+	}
+
+	return result
+}
+
+// ensureMonotonic raises bucket counts that are lower than a previous bucket,
+// which happens when the buckets of a histogram are scraped at slightly different times
+func ensureMonotonic(buckets []Bucket) {
+	highest := math.Inf(-1)
+	for i := range buckets {
+		if buckets[i].Count < highest {
+			buckets[i].Count = highest
+		}
+		highest = buckets[i].Count
+	}
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('\xff')
+		sb.WriteString(labels[k])
+		sb.WriteByte('\xff')
+	}
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package histogram
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestExpand(t *testing.T) {
+	ts := &metrics.Timeseries{
+		Labels: map[string]string{"__name__": "request_seconds", "job": "api"},
+		Samples: []*metrics.Sample{
+			{Timestamp: 1000, Histogram: &ingestion.Histogram{Count: 4, Sum: 2.5, Buckets: []*ingestion.Bucket{{UpperBound: 0.5, Count: 3}}}},
+			{Timestamp: 2000, Histogram: &ingestion.Histogram{Count: 6, Sum: 4, Buckets: []*ingestion.Bucket{{UpperBound: 0.5, Count: 4}}}, Exemplar: &ingestion.Exemplar{Value: 0.7}},
+		},
+	}
+	summary := &metrics.Timeseries{
+		Labels: map[string]string{"__name__": "rpc_seconds"},
+		Samples: []*metrics.Sample{
+			{Timestamp: 1000, Summary: &ingestion.Summary{Count: 2, Sum: 1, Quantiles: []*ingestion.Quantile{{Quantile: 0.5, Value: 0.4}}}},
+			{Timestamp: 2000, Value: 7, Exemplar: &ingestion.Exemplar{Value: 7}},
+		},
+	}
+
+	var got []string
+	for _, series := range append(Expand(ts), Expand(summary)...) {
+		var samples []string
+		for _, s := range series.Samples {
+			sample := fmt.Sprintf("%g@%d", s.Value, s.Timestamp)
+			if s.Exemplar != nil {
+				sample += fmt.Sprintf("#%g", s.Exemplar.Value)
+			}
+			samples = append(samples, sample)
+		}
+		got = append(got, fmt.Sprintf("%v %v", series.Labels, samples))
+	}
+
+	want := []string{
+		"map[__name__:request_seconds_bucket job:api le:0.5] [3@1000 4@2000]",
+		"map[__name__:request_seconds_bucket job:api le:+Inf] [4@1000 6@2000#0.7]",
+		"map[__name__:request_seconds_sum job:api] [2.5@1000 4@2000]",
+		"map[__name__:request_seconds_count job:api] [4@1000 6@2000]",
+		"map[__name__:rpc_seconds quantile:0.5] [0.4@1000]",
+		"map[__name__:rpc_seconds_sum] [1@1000]",
+		"map[__name__:rpc_seconds_count] [2@1000]",
+		"map[__name__:rpc_seconds] [7@2000#7]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expand() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestQuantileSeries(t *testing.T) {
+	bucket := func(host, le string, values ...float64) *metrics.TimeseriesData {
+		ts := &metrics.TimeseriesData{Labels: map[string]string{"__name__": "request_seconds_bucket", "host": host, "le": le}}
+		for i, v := range values {
+			ts.Samples = append(ts.Samples, &metrics.Sample{Value: v, Timestamp: int64(60 * (i + 1))})
+		}
+		return ts
+	}
+
+	got := QuantileSeries(0.5, []*metrics.TimeseriesData{
+		bucket("a", "1", 2, 5),
+		bucket("a", "+Inf", 4, 10),
+		bucket("a", "0.5", 1, 5),
+		bucket("b", "1", 10),
+		bucket("b", "+Inf", 9), // Lower than the previous bucket
+		{Labels: map[string]string{"__name__": "request_seconds_count", "host": "a"}},
+	})
+
+	var lines []string
+	for _, ts := range got {
+		var samples []string
+		for _, s := range ts.Samples {
+			samples = append(samples, fmt.Sprintf("%g@%d", s.Value, s.Timestamp))
+		}
+		lines = append(lines, fmt.Sprintf("%v %v", ts.Labels, samples))
+	}
+	sort.Strings(lines)
+
+	want := []string{
+		"map[host:a] [1@60 0.5@120]",
+		"map[host:b] [0.5@60]",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("QuantileSeries() = %v, want %v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("QuantileSeries()[%d] = %s, want %s", i, lines[i], want[i])
+		}
+	}
+}
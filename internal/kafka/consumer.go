@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"strings"
 
 	"github.com/Shopify/sarama"
 	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/tenant"
 )
 
 // KafkaConfig represents the Kafka configuration
@@ -14,12 +16,19 @@ type KafkaConfig struct {
 	Brokers []string
 	Topic   string
 	GroupID string
+
+	// RequireTenant drops messages without a tenant instead of ingesting
+	// them for the default tenant
+	RequireTenant bool
 }
 
 // Message represents the data structure of a message consumed from Kafka.
+// The tenant is taken from the x-scope-orgid header of the message, or from
+// the tenant_id field if the header is not set.
 type Message struct {
 	SourceID   string            `json:"source_id"`
 	SourceType string            `json:"source_type"`
+	TenantID   string            `json:"tenant_id"`
 	Metrics    []*ingestion.MetricData `json:"metrics"`
 }
 
@@ -38,7 +47,7 @@ func Consumer(cfg KafkaConfig, handler func(message Message)) {
 
 	go func() {
 		for {
-			if err := consumerGroup.Consume(ctx, []string{cfg.Topic}, &consumer{handler: handler}); err != nil {
+			if err := consumerGroup.Consume(ctx, []string{cfg.Topic}, &consumer{handler: handler, requireTenant: cfg.RequireTenant}); err != nil {
 				log.Fatalf("Error while consuming messages: %v", err)
 			}
 		}
@@ -47,7 +56,8 @@ func Consumer(cfg KafkaConfig, handler func(message Message)) {
 
 // consumer is an implementation of the sarama.ConsumerGroupHandler interface.
 type consumer struct {
-	handler func(message Message)
+	handler       func(message Message)
+	requireTenant bool
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim.
@@ -72,6 +82,18 @@ func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 		log.Printf("Consumed message: %s", string(message.Value))
 		session.MarkMessage(message, "")
 
+		for _, header := range message.Headers {
+			if strings.EqualFold(string(header.Key), tenant.MetadataKey) {
+				kafkaMessage.TenantID = string(header.Value)
+			}
+		}
+		tenantID, err := tenant.Parse(kafkaMessage.TenantID, c.requireTenant)
+		if err != nil {
+			log.Printf("Dropping Kafka message of source %s: %v", kafkaMessage.SourceID, err)
+			continue
+		}
+		kafkaMessage.TenantID = tenantID
+
 		// Invoke the handler function to process the message
 		c.handler(kafkaMessage)
 	}
@@ -0,0 +1,223 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/metrics"
+)
+
+const (
+	// sourceType is the source_type of metrics received over OTLP
+	sourceType = "otlp"
+
+	// maxRequestSize bounds the size of an OTLP/HTTP request body
+	maxRequestSize = 32 << 20
+)
+
+// Ingester receives translated metrics, it is implemented by the ingestion service
+type Ingester interface {
+	IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error)
+}
+
+// MetricsWriter receives translated metrics, it is implemented by the metrics service
+type MetricsWriter interface {
+	WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error)
+}
+
+// Receiver implements the OTLP MetricsService over gRPC and OTLP/HTTP
+type Receiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	ingester Ingester
+	writer   MetricsWriter
+}
+
+// NewReceiver creates a new Receiver. Either sink may be nil.
+func NewReceiver(ingester Ingester, writer MetricsWriter) *Receiver {
+	return &Receiver{ingester: ingester, writer: writer}
+}
+
+// Export translates the OTLP data points and pushes them to the sinks. Data
+// points that cannot be translated are reported as a partial success. Writes
+// a sink rejects keep the status of its error, such as the RetryInfo of a
+// rate limit, other failures are Unavailable.
+func (r *Receiver) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	t := &translator{}
+	batches := t.translate(req)
+
+	for _, b := range batches {
+		if err := r.push(ctx, b); err != nil {
+			log.Printf("Failed to write OTLP metrics for %s: %v", b.sourceID, err)
+			if st, ok := status.FromError(err); ok {
+				return nil, st.Err()
+			}
+			// Unavailable tells OTLP exporters to retry the request
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if t.rejected > 0 {
+		log.Printf("Rejected %d OTLP data points: %s", t.rejected, strings.Join(t.errors, "; "))
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: t.rejected,
+			ErrorMessage:       strings.Join(t.errors, "; "),
+		}
+	}
+	return resp, nil
+}
+
+// ServeHTTP implements the OTLP/HTTP /v1/metrics endpoint for binary protobuf
+// and JSON encoded requests
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	var unmarshal func([]byte, proto.Message) error
+	var marshal func(proto.Message) ([]byte, error)
+	switch mediaType {
+	case "application/x-protobuf":
+		unmarshal, marshal = proto.Unmarshal, proto.Marshal
+	case "application/json":
+		unmarshal, marshal = protojson.Unmarshal, protojson.Marshal
+	default:
+		http.Error(w, fmt.Sprintf("unsupported content type %q", mediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exportReq := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := unmarshal(body, exportReq); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	exportResp, err := r.Export(req.Context(), exportReq)
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	data, err := marshal(exportResp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Write(data)
+}
+
+// httpStatus maps an export error to an OTLP/HTTP status code. Exporters
+// retry 429 and 503 responses and drop the data of 400 responses.
+func httpStatus(err error) int {
+	if _, ok := ratelimit.RetryAfter(err); ok {
+		return http.StatusTooManyRequests
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.ResourceExhausted:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// setRetryAfter sets the Retry-After header of a write rejected by a rate limit
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if wait, ok := ratelimit.RetryAfter(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
+
+func readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxRequestSize)
+
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxRequestSize)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", req.Header.Get("Content-Encoding"))
+	}
+
+	return io.ReadAll(body)
+}
+
+// push sends the samples of one resource to every configured sink
+func (r *Receiver) push(ctx context.Context, b *batch) error {
+	if len(b.samples) == 0 {
+		return nil
+	}
+
+	var errs []error
+	if r.ingester != nil {
+		req := &ingestion.IngestDataRequest{SourceId: b.sourceID, SourceType: sourceType}
+		for _, s := range b.samples {
+			labels := make(map[string]string, len(s.labels))
+			for key, value := range s.labels {
+				if key != "__name__" {
+					labels[key] = value
+				}
+			}
+			req.Metrics = append(req.Metrics, &ingestion.MetricData{
+				Name:      s.labels["__name__"],
+				Labels:    labels,
+				Value:     s.value,
+				Timestamp: s.timestamp,
+			})
+		}
+		if _, err := r.ingester.IngestData(ctx, req); err != nil {
+			errs = append(errs, fmt.Errorf("ingestion: %w", err))
+		}
+	}
+
+	if r.writer != nil {
+		req := &metrics.WriteRequest{SourceId: b.sourceID, SourceType: sourceType}
+		for _, s := range b.samples {
+			req.Timeseries = append(req.Timeseries, &metrics.Timeseries{
+				Labels:  s.labels,
+				Samples: []*metrics.Sample{{Value: s.value, Timestamp: s.timestamp}},
+			})
+		}
+		if _, err := r.writer.WriteMetrics(ctx, req); err != nil {
+			errs = append(errs, fmt.Errorf("write metrics: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,165 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeSink struct {
+	ingested []*ingestion.IngestDataRequest
+	written  []*metrics.WriteRequest
+	err      error // Returned by IngestData
+}
+
+func (f *fakeSink) IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.ingested = append(f.ingested, req)
+	return &ingestion.IngestDataResponse{}, nil
+}
+
+func (f *fakeSink) WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	f.written = append(f.written, req)
+	return &metrics.WriteResponse{}, nil
+}
+
+func TestReceiver_ServeHTTP(t *testing.T) {
+	body, err := proto.Marshal(exportRequest(&metricspb.Metric{Name: "queue.size", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+		DataPoints: []*metricspb.NumberDataPoint{{
+			TimeUnixNano: 1620474602000000000,
+			Attributes:   []*commonpb.KeyValue{stringAttr("queue", "mail")},
+			Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 3},
+		}},
+	}}}))
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(body)
+	gz.Close()
+
+	sink := &fakeSink{}
+	receiver := NewReceiver(sink, sink)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", &gzipped)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Receiver.ServeHTTP() status = %d: %s", rec.Code, rec.Body.String())
+	}
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.PartialSuccess != nil {
+		t.Errorf("Receiver.ServeHTTP() partial success = %v, want none", resp.PartialSuccess)
+	}
+
+	if len(sink.ingested) != 1 || len(sink.ingested[0].Metrics) != 1 {
+		t.Fatalf("ingested = %v, want one request with one metric", sink.ingested)
+	}
+	ingested := sink.ingested[0]
+	metric := ingested.Metrics[0]
+	if ingested.SourceId != "checkout" || ingested.SourceType != "otlp" {
+		t.Errorf("source = %s/%s, want checkout/otlp", ingested.SourceId, ingested.SourceType)
+	}
+	if metric.Name != "queue_size" || metric.Value != 3 || metric.Labels["queue"] != "mail" || metric.Labels["__name__"] != "" {
+		t.Errorf("metric = %v, want queue_size{queue=mail} 3", metric)
+	}
+
+	if len(sink.written) != 1 || sink.written[0].Timeseries[0].Labels["__name__"] != "queue_size" {
+		t.Errorf("written = %v, want one queue_size series", sink.written)
+	}
+}
+
+func TestReceiver_ServeHTTP_BadRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "wrong method", method: http.MethodGet, contentType: "application/x-protobuf", wantStatus: http.StatusMethodNotAllowed},
+		{name: "unsupported content type", method: http.MethodPost, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "malformed protobuf", method: http.MethodPost, contentType: "application/x-protobuf", body: "\xff\xff", wantStatus: http.StatusBadRequest},
+		{name: "malformed json", method: http.MethodPost, contentType: "application/json", body: "{", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/metrics", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			NewReceiver(nil, nil).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Receiver.ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReceiver_Export_Errors(t *testing.T) {
+	req := exportRequest(&metricspb.Metric{Name: "queue.size", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+		DataPoints: []*metricspb.NumberDataPoint{{TimeUnixNano: 1620474602000000000, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 3}}},
+	}}})
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		err            error
+		wantCode       codes.Code
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "rate limited", err: ratelimit.Exceeded(context.Background(), "checkout", 1500*time.Millisecond), wantCode: codes.ResourceExhausted, wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		{name: "rejected", err: status.Error(codes.InvalidArgument, "invalid metric"), wantCode: codes.InvalidArgument, wantStatus: http.StatusBadRequest},
+		{name: "storage failure", err: errors.New("no hosts available"), wantCode: codes.Unavailable, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := NewReceiver(&fakeSink{err: tt.err}, nil)
+
+			_, err := receiver.Export(context.Background(), req)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Receiver.Export() error = %v, want %v", err, tt.wantCode)
+			}
+			if _, limited := ratelimit.RetryAfter(err); limited != (tt.wantRetryAfter != "") {
+				t.Errorf("Receiver.Export() error = %v, RetryInfo %v", err, limited)
+			}
+
+			httpReq := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+			httpReq.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			receiver.ServeHTTP(rec, httpReq)
+			if rec.Code != tt.wantStatus || rec.Header().Get("Retry-After") != tt.wantRetryAfter {
+				t.Errorf("Receiver.ServeHTTP() status = %d, Retry-After %q, want %d, %q", rec.Code, rec.Header().Get("Retry-After"), tt.wantStatus, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/histogram"
+)
+
+const (
+	// defaultSourceID is used for resources without a service.name, as the OpenTelemetry SDKs do
+	defaultSourceID = "unknown_service"
+
+	// flagNoRecordedValue marks data points that carry no value
+	flagNoRecordedValue = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+)
+
+// batch holds the samples translated from a single OTLP resource
+type batch struct {
+	sourceID string
+	samples  []sample
+}
+
+// sample is a translated sample, the metric name is held in the __name__ label
+type sample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// translator converts OTLP metrics into samples and counts rejected data points
+type translator struct {
+	rejected int64
+	errors   []string
+}
+
+// translate maps every data point of req onto samples, one batch per resource
+func (t *translator) translate(req *colmetricspb.ExportMetricsServiceRequest) []*batch {
+	var batches []*batch
+	for _, resourceMetrics := range req.ResourceMetrics {
+		b := &batch{sourceID: defaultSourceID}
+
+		resourceLabels := make(map[string]string)
+		for _, attr := range resourceMetrics.GetResource().GetAttributes() {
+			value := attributeValue(attr.Value)
+			if attr.Key == "service.name" && value != "" {
+				b.sourceID = value
+			}
+			resourceLabels[format.SanitizeLabelName(attr.Key)] = value
+		}
+
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				b.samples = t.appendMetric(b.samples, metric, resourceLabels)
+			}
+		}
+
+		batches = append(batches, b)
+	}
+
+	return batches
+}
+
+func (t *translator) appendMetric(samples []sample, metric *metricspb.Metric, resourceLabels map[string]string) []sample {
+	name := format.SanitizeMetricName(metric.Name)
+
+	switch data := metric.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.DataPoints {
+			samples = t.appendNumber(samples, name, dp, resourceLabels)
+		}
+	case *metricspb.Metric_Sum:
+		if data.Sum.IsMonotonic && !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		for _, dp := range data.Sum.DataPoints {
+			samples = t.appendNumber(samples, name, dp, resourceLabels)
+		}
+	case *metricspb.Metric_Histogram:
+		for _, dp := range data.Histogram.DataPoints {
+			samples = t.appendHistogram(samples, name, dp, resourceLabels)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		for _, dp := range data.ExponentialHistogram.DataPoints {
+			samples = t.appendExponentialHistogram(samples, name, dp, resourceLabels)
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.DataPoints {
+			samples = t.appendSummary(samples, name, dp, resourceLabels)
+		}
+	default:
+		t.reject(1, fmt.Sprintf("metric %s has no supported data", metric.Name))
+	}
+
+	return samples
+}
+
+func (t *translator) appendNumber(samples []sample, name string, dp *metricspb.NumberDataPoint, resourceLabels map[string]string) []sample {
+	if dp.Flags&flagNoRecordedValue != 0 {
+		return samples
+	}
+
+	var value float64
+	switch v := dp.Value.(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		value = v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		value = float64(v.AsInt)
+	default:
+		t.reject(1, fmt.Sprintf("data point of %s has no value", name))
+		return samples
+	}
+
+	labels := pointLabels(name, resourceLabels, dp.Attributes)
+	return append(samples, sample{labels: labels, value: value, timestamp: toMillis(dp.TimeUnixNano)})
+}
+
+// appendHistogram emits cumulative _bucket series with le labels plus _sum and _count
+func (t *translator) appendHistogram(samples []sample, name string, dp *metricspb.HistogramDataPoint, resourceLabels map[string]string) []sample {
+	if dp.Flags&flagNoRecordedValue != 0 {
+		return samples
+	}
+	if len(dp.BucketCounts) != 0 && len(dp.BucketCounts) != len(dp.ExplicitBounds)+1 {
+		t.reject(1, fmt.Sprintf("histogram %s has %d buckets for %d bounds", name, len(dp.BucketCounts), len(dp.ExplicitBounds)))
+		return samples
+	}
+
+	timestamp := toMillis(dp.TimeUnixNano)
+	var cumulative uint64
+	for i, count := range dp.BucketCounts {
+		cumulative += count
+		le := math.Inf(1)
+		if i < len(dp.ExplicitBounds) {
+			le = dp.ExplicitBounds[i]
+		}
+		samples = append(samples, sample{labels: bucketLabels(name, resourceLabels, dp.Attributes, le), value: float64(cumulative), timestamp: timestamp})
+	}
+
+	if dp.Sum != nil {
+		samples = append(samples, sample{labels: pointLabels(name+"_sum", resourceLabels, dp.Attributes), value: *dp.Sum, timestamp: timestamp})
+	}
+	return append(samples, sample{labels: pointLabels(name+"_count", resourceLabels, dp.Attributes), value: float64(dp.Count), timestamp: timestamp})
+}
+
+// appendExponentialHistogram converts the base-2 exponential buckets into
+// cumulative _bucket series with their upper bounds as le labels
+func (t *translator) appendExponentialHistogram(samples []sample, name string, dp *metricspb.ExponentialHistogramDataPoint, resourceLabels map[string]string) []sample {
+	if dp.Flags&flagNoRecordedValue != 0 {
+		return samples
+	}
+	if dp.Scale < -10 || dp.Scale > 20 {
+		t.reject(1, fmt.Sprintf("exponential histogram %s has unsupported scale %d", name, dp.Scale))
+		return samples
+	}
+
+	buckets := histogram.Buckets(&ingestion.Histogram{
+		Count: dp.Count,
+		Exponential: &ingestion.ExponentialBuckets{
+			Scale:          dp.Scale,
+			ZeroThreshold:  dp.ZeroThreshold,
+			ZeroCount:      dp.ZeroCount,
+			PositiveOffset: dp.GetPositive().GetOffset(),
+			PositiveCounts: dp.GetPositive().GetBucketCounts(),
+			NegativeOffset: dp.GetNegative().GetOffset(),
+			NegativeCounts: dp.GetNegative().GetBucketCounts(),
+		},
+	})
+	if len(buckets) > 1 && buckets[len(buckets)-2].Count > float64(dp.Count) {
+		t.reject(1, fmt.Sprintf("exponential histogram %s has more observations in its buckets than its count", name))
+		return samples
+	}
+
+	timestamp := toMillis(dp.TimeUnixNano)
+	for _, b := range buckets {
+		samples = append(samples, sample{labels: bucketLabels(name, resourceLabels, dp.Attributes, b.UpperBound), value: b.Count, timestamp: timestamp})
+	}
+
+	if dp.Sum != nil {
+		samples = append(samples, sample{labels: pointLabels(name+"_sum", resourceLabels, dp.Attributes), value: *dp.Sum, timestamp: timestamp})
+	}
+	return append(samples, sample{labels: pointLabels(name+"_count", resourceLabels, dp.Attributes), value: float64(dp.Count), timestamp: timestamp})
+}
+
+// appendSummary emits the quantiles with quantile labels plus _sum and _count
+func (t *translator) appendSummary(samples []sample, name string, dp *metricspb.SummaryDataPoint, resourceLabels map[string]string) []sample {
+	if dp.Flags&flagNoRecordedValue != 0 {
+		return samples
+	}
+
+	timestamp := toMillis(dp.TimeUnixNano)
+	for _, q := range dp.QuantileValues {
+		labels := pointLabels(name, resourceLabels, dp.Attributes)
+		labels["quantile"] = strconv.FormatFloat(q.Quantile, 'g', -1, 64)
+		samples = append(samples, sample{labels: labels, value: q.Value, timestamp: timestamp})
+	}
+
+	samples = append(samples, sample{labels: pointLabels(name+"_sum", resourceLabels, dp.Attributes), value: dp.Sum, timestamp: timestamp})
+	return append(samples, sample{labels: pointLabels(name+"_count", resourceLabels, dp.Attributes), value: float64(dp.Count), timestamp: timestamp})
+}
+
+func (t *translator) reject(n int64, message string) {
+	t.rejected += n
+	t.errors = append(t.errors, message)
+}
+
+// pointLabels merges resource and data point attributes, data point attributes win
+func pointLabels(name string, resourceLabels map[string]string, attributes []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(resourceLabels)+len(attributes)+1)
+	for key, value := range resourceLabels {
+		labels[key] = value
+	}
+	for _, attr := range attributes {
+		labels[format.SanitizeLabelName(attr.Key)] = attributeValue(attr.Value)
+	}
+	labels["__name__"] = name
+	return labels
+}
+
+func bucketLabels(name string, resourceLabels map[string]string, attributes []*commonpb.KeyValue, le float64) map[string]string {
+	labels := pointLabels(name+"_bucket", resourceLabels, attributes)
+	labels["le"] = strconv.FormatFloat(le, 'g', -1, 64)
+	return labels
+}
+
+// attributeValue renders an attribute as a label value, complex values as JSON
+func attributeValue(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(anyValue(value))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
+
+func anyValue(value *commonpb.AnyValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return v.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i, element := range v.ArrayValue.Values {
+			values[i] = anyValue(element)
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = anyValue(kv.Value)
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func toMillis(unixNano uint64) int64 {
+	return int64(unixNano / 1e6)
+}
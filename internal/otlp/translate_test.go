@@ -0,0 +1,173 @@
+package otlp
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func exportRequest(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttr("service.name", "checkout"),
+				stringAttr("deployment.environment", "prod"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+// render formats samples as sorted `name{le/quantile} value` strings
+func render(samples []sample) []string {
+	var lines []string
+	for _, s := range samples {
+		line := s.labels["__name__"]
+		if le, ok := s.labels["le"]; ok {
+			line += "{le=" + le + "}"
+		}
+		if q, ok := s.labels["quantile"]; ok {
+			line += "{quantile=" + q + "}"
+		}
+		lines = append(lines, fmt.Sprintf("%s %g", line, s.value))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func Test_translator_translate(t *testing.T) {
+	sum := 12.5
+	const ts = uint64(1620474602000000000)
+
+	tests := []struct {
+		name         string
+		metric       *metricspb.Metric
+		want         []string
+		wantRejected int64
+	}{
+		{
+			name: "gauge",
+			metric: &metricspb.Metric{Name: "process.memory.usage", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 512}}},
+			}}},
+			want: []string{"process_memory_usage 512"},
+		},
+		{
+			name: "monotonic sum gets a _total suffix",
+			metric: &metricspb.Metric{Name: "http.requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				IsMonotonic: true,
+				DataPoints:  []*metricspb.NumberDataPoint{{TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 7}}},
+			}}},
+			want: []string{"http_requests_total 7"},
+		},
+		{
+			name: "explicit bucket histogram",
+			metric: &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					TimeUnixNano:   ts,
+					Count:          6,
+					Sum:            &sum,
+					ExplicitBounds: []float64{0.1, 1},
+					BucketCounts:   []uint64{1, 3, 2},
+				}},
+			}}},
+			want: []string{
+				"latency_bucket{le=+Inf} 6",
+				"latency_bucket{le=0.1} 1",
+				"latency_bucket{le=1} 4",
+				"latency_count 6",
+				"latency_sum 12.5",
+			},
+		},
+		{
+			name: "exponential histogram",
+			metric: &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+				DataPoints: []*metricspb.ExponentialHistogramDataPoint{{
+					TimeUnixNano: ts,
+					Count:        7,
+					Sum:          &sum,
+					Scale:        0,
+					ZeroCount:    1,
+					Positive:     &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 0, BucketCounts: []uint64{2, 3}},
+					Negative:     &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: 1, BucketCounts: []uint64{1}},
+				}},
+			}}},
+			want: []string{
+				"latency_bucket{le=+Inf} 7",
+				"latency_bucket{le=-2} 1",
+				"latency_bucket{le=0} 2",
+				"latency_bucket{le=2} 4",
+				"latency_bucket{le=4} 7",
+				"latency_count 7",
+				"latency_sum 12.5",
+			},
+		},
+		{
+			name: "summary",
+			metric: &metricspb.Metric{Name: "rpc.duration", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+				DataPoints: []*metricspb.SummaryDataPoint{{
+					TimeUnixNano:   ts,
+					Count:          10,
+					Sum:            3,
+					QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.5, Value: 0.2}, {Quantile: 0.99, Value: 0.9}},
+				}},
+			}}},
+			want: []string{
+				"rpc_duration_count 10",
+				"rpc_duration_sum 3",
+				"rpc_duration{quantile=0.5} 0.2",
+				"rpc_duration{quantile=0.99} 0.9",
+			},
+		},
+		{
+			name: "histogram with mismatched buckets is rejected",
+			metric: &metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints: []*metricspb.HistogramDataPoint{{TimeUnixNano: ts, ExplicitBounds: []float64{1}, BucketCounts: []uint64{1}}},
+			}}},
+			wantRejected: 1,
+		},
+		{
+			name: "data point without recorded value is skipped",
+			metric: &metricspb.Metric{Name: "up", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{TimeUnixNano: ts, Flags: flagNoRecordedValue}},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &translator{}
+			batches := tr.translate(exportRequest(tt.metric))
+			if len(batches) != 1 {
+				t.Fatalf("translate() returned %d batches, want 1", len(batches))
+			}
+			if batches[0].sourceID != "checkout" {
+				t.Errorf("translate() source = %s, want checkout", batches[0].sourceID)
+			}
+			if tr.rejected != tt.wantRejected {
+				t.Errorf("translate() rejected = %d, want %d", tr.rejected, tt.wantRejected)
+			}
+
+			got := render(batches[0].samples)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("translate() = %v, want %v", got, tt.want)
+			}
+			for _, s := range batches[0].samples {
+				if s.labels["deployment_environment"] != "prod" || s.labels["service_name"] != "checkout" {
+					t.Errorf("sample %v is missing resource labels", s.labels)
+				}
+				if s.timestamp != 1620474602000 {
+					t.Errorf("sample timestamp = %d, want 1620474602000", s.timestamp)
+				}
+			}
+		})
+	}
+}
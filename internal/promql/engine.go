@@ -0,0 +1,506 @@
+package promql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultLookback is how far back an instant selector looks for the latest sample
+const defaultLookback = 5 * time.Minute
+
+// maxSteps bounds the evaluation steps of a range query
+const maxSteps = 11000
+
+// ErrTooManySteps is returned for range queries with more than maxSteps steps
+var ErrTooManySteps = errors.New("range query exceeds the maximum of 11000 steps, increase the step")
+
+// Point is a sample of a series, the timestamp is in milliseconds
+type Point struct {
+	T int64
+	V float64
+}
+
+// Series is a set of labels, holding the metric name in __name__, with
+// points ordered by timestamp
+type Series struct {
+	Labels map[string]string
+	Points []Point
+}
+
+// Queryable reads the series matching a selector with their samples between
+// start and end, inclusive
+type Queryable interface {
+	SelectSeries(ctx context.Context, matchers []*Matcher, start, end time.Time) ([]Series, error)
+}
+
+// Engine evaluates parsed queries over the series of a Queryable
+type Engine struct {
+	queryable Queryable
+	lookback  time.Duration
+}
+
+// NewEngine creates a new Engine
+func NewEngine(queryable Queryable) *Engine {
+	return &Engine{queryable: queryable, lookback: defaultLookback}
+}
+
+// Instant evaluates a query at a single time, every series of the result
+// has a single point
+func (e *Engine) Instant(ctx context.Context, expr Expr, ts time.Time) ([]Series, error) {
+	return e.Range(ctx, expr, ts, ts, time.Second)
+}
+
+// Range evaluates a query at every step from start to end
+func (e *Engine) Range(ctx context.Context, expr Expr, start, end time.Time, step time.Duration) ([]Series, error) {
+	if step < time.Millisecond {
+		return nil, fmt.Errorf("step must be at least 1ms")
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end is before start")
+	}
+	if end.Sub(start)/step >= maxSteps {
+		return nil, ErrTooManySteps
+	}
+
+	ev := &evaluator{lookback: e.lookback.Milliseconds(), data: make(map[*VectorSelector][]Series)}
+	if err := e.load(ctx, ev, expr, start, end); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Series)
+	for ts := start.UnixMilli(); ts <= end.UnixMilli(); ts += step.Milliseconds() {
+		v, err := ev.eval(expr, ts)
+		if err != nil {
+			return nil, err
+		}
+
+		samples := v.vector
+		if v.isScalar {
+			samples = []sample{{labels: map[string]string{}, value: v.scalar}}
+		}
+		for _, s := range samples {
+			key := labelsKey(s.labels)
+			series, ok := result[key]
+			if !ok {
+				series = &Series{Labels: s.labels}
+				result[key] = series
+			}
+			series.Points = append(series.Points, Point{T: ts, V: s.value})
+		}
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]Series, len(keys))
+	for i, key := range keys {
+		series[i] = *result[key]
+	}
+	return series, nil
+}
+
+// load reads the series of every selector of expr once for the whole range
+func (e *Engine) load(ctx context.Context, ev *evaluator, expr Expr, start, end time.Time) error {
+	switch n := expr.(type) {
+	case *VectorSelector:
+		window := n.Range
+		if window == 0 {
+			window = e.lookback
+		}
+		series, err := e.queryable.SelectSeries(ctx, n.Matchers, start.Add(-window), end)
+		if err != nil {
+			return fmt.Errorf("failed to select %s: %w", n, err)
+		}
+		ev.data[n] = series
+	case *Call:
+		return e.load(ctx, ev, n.Arg, start, end)
+	case *AggregateExpr:
+		return e.load(ctx, ev, n.Expr, start, end)
+	case *BinaryExpr:
+		if err := e.load(ctx, ev, n.LHS, start, end); err != nil {
+			return err
+		}
+		return e.load(ctx, ev, n.RHS, start, end)
+	}
+	return nil
+}
+
+// sample is an element of an instant vector
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// value is the result of evaluating an expression at a single time
+type value struct {
+	isScalar bool
+	scalar   float64
+	vector   []sample
+}
+
+type evaluator struct {
+	lookback int64
+	data     map[*VectorSelector][]Series
+}
+
+func (ev *evaluator) eval(expr Expr, ts int64) (value, error) {
+	switch n := expr.(type) {
+	case *NumberLiteral:
+		return value{isScalar: true, scalar: n.Value}, nil
+	case *VectorSelector:
+		var vector []sample
+		for _, series := range ev.data[n] {
+			points := window(series.Points, ts-ev.lookback, ts)
+			if len(points) > 0 {
+				vector = append(vector, sample{labels: series.Labels, value: points[len(points)-1].V})
+			}
+		}
+		return value{vector: vector}, nil
+	case *Call:
+		return ev.call(n, ts), nil
+	case *AggregateExpr:
+		v, err := ev.eval(n.Expr, ts)
+		if err != nil {
+			return value{}, err
+		}
+		if v.isScalar {
+			return value{}, fmt.Errorf("%s expects a vector", n.Op)
+		}
+		return value{vector: aggregate(n, v.vector)}, nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(n.LHS, ts)
+		if err != nil {
+			return value{}, err
+		}
+		rhs, err := ev.eval(n.RHS, ts)
+		if err != nil {
+			return value{}, err
+		}
+		return binary(n.Op, lhs, rhs)
+	default:
+		return value{}, fmt.Errorf("unsupported expression %s", expr)
+	}
+}
+
+// window returns the points with a timestamp in (from, to]
+func window(points []Point, from, to int64) []Point {
+	i := sort.Search(len(points), func(i int) bool { return points[i].T > from })
+	j := sort.Search(len(points), func(i int) bool { return points[i].T > to })
+	return points[i:j]
+}
+
+// call applies a function to the range of every selected series, the metric name is dropped
+func (ev *evaluator) call(n *Call, ts int64) value {
+	rangeMillis := n.Arg.Range.Milliseconds()
+
+	var vector []sample
+	for _, series := range ev.data[n.Arg] {
+		points := window(series.Points, ts-rangeMillis, ts)
+
+		var result float64
+		var ok bool
+		switch n.Func {
+		case "rate":
+			result, ok = extrapolatedRate(points, ts-rangeMillis, ts, true)
+		case "increase":
+			result, ok = extrapolatedRate(points, ts-rangeMillis, ts, false)
+		case "irate":
+			result, ok = instantRate(points)
+		default:
+			result, ok = overTime(n.Func, points)
+		}
+		if ok {
+			vector = append(vector, sample{labels: dropName(series.Labels), value: result})
+		}
+	}
+	return value{vector: vector}
+}
+
+// extrapolatedRate implements rate and increase as Prometheus does: counter
+// resets are corrected and the increase is extrapolated to the bounds of the
+// range unless the samples stop well before them
+func extrapolatedRate(points []Point, rangeStart, rangeEnd int64, isRate bool) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	first, last := points[0], points[len(points)-1]
+
+	increase := last.V - first.V
+	for i := 1; i < len(points); i++ {
+		if points[i].V < points[i-1].V {
+			increase += points[i-1].V
+		}
+	}
+
+	sampledInterval := float64(last.T-first.T) / 1000
+	if sampledInterval == 0 {
+		return 0, false
+	}
+	durationToStart := float64(first.T-rangeStart) / 1000
+	durationToEnd := float64(rangeEnd-last.T) / 1000
+	averageInterval := sampledInterval / float64(len(points)-1)
+
+	// A counter cannot be extrapolated below zero
+	if increase > 0 && first.V >= 0 {
+		if durationToZero := sampledInterval * (first.V / increase); durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	threshold := averageInterval * 1.1
+	extrapolated := sampledInterval
+	if durationToStart < threshold {
+		extrapolated += durationToStart
+	} else {
+		extrapolated += averageInterval / 2
+	}
+	if durationToEnd < threshold {
+		extrapolated += durationToEnd
+	} else {
+		extrapolated += averageInterval / 2
+	}
+
+	increase *= extrapolated / sampledInterval
+	if isRate {
+		increase /= float64(rangeEnd-rangeStart) / 1000
+	}
+	return increase, true
+}
+
+// instantRate is the per-second rate between the last two points
+func instantRate(points []Point) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	prev, last := points[len(points)-2], points[len(points)-1]
+
+	delta := last.V - prev.V
+	if last.V < prev.V {
+		// The counter was reset
+		delta = last.V
+	}
+	interval := float64(last.T-prev.T) / 1000
+	if interval == 0 {
+		return 0, false
+	}
+	return delta / interval, true
+}
+
+// overTime implements the *_over_time functions
+func overTime(fn string, points []Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	switch fn {
+	case "count_over_time":
+		return float64(len(points)), true
+	case "present_over_time":
+		return 1, true
+	case "last_over_time":
+		return points[len(points)-1].V, true
+	}
+
+	sum, min, max := 0.0, points[0].V, points[0].V
+	for _, p := range points {
+		sum += p.V
+		min = math.Min(min, p.V)
+		max = math.Max(max, p.V)
+	}
+	mean := sum / float64(len(points))
+
+	switch fn {
+	case "sum_over_time":
+		return sum, true
+	case "avg_over_time":
+		return mean, true
+	case "min_over_time":
+		return min, true
+	case "max_over_time":
+		return max, true
+	case "stddev_over_time":
+		variance := 0.0
+		for _, p := range points {
+			variance += (p.V - mean) * (p.V - mean)
+		}
+		return math.Sqrt(variance / float64(len(points))), true
+	}
+	return 0, false
+}
+
+// aggregate groups a vector by the labels of an aggregation
+func aggregate(n *AggregateExpr, vector []sample) []sample {
+	type group struct {
+		labels map[string]string
+		sum    float64
+		min    float64
+		max    float64
+		count  int
+	}
+
+	groups := make(map[string]*group)
+	var order []string
+	for _, s := range vector {
+		labels := groupLabels(s.labels, n.Grouping, n.Without)
+		key := labelsKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels, min: s.value, max: s.value}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.sum += s.value
+		g.min = math.Min(g.min, s.value)
+		g.max = math.Max(g.max, s.value)
+		g.count++
+	}
+
+	result := make([]sample, len(order))
+	for i, key := range order {
+		g := groups[key]
+		var v float64
+		switch n.Op {
+		case "sum":
+			v = g.sum
+		case "avg":
+			v = g.sum / float64(g.count)
+		case "min":
+			v = g.min
+		case "max":
+			v = g.max
+		case "count":
+			v = float64(g.count)
+		}
+		result[i] = sample{labels: g.labels, value: v}
+	}
+	return result
+}
+
+// groupLabels returns the labels of the group of a sample
+func groupLabels(labels map[string]string, grouping []string, without bool) map[string]string {
+	result := make(map[string]string)
+	if without {
+		for name, value := range labels {
+			result[name] = value
+		}
+		delete(result, "__name__")
+		for _, name := range grouping {
+			delete(result, name)
+		}
+		return result
+	}
+
+	for _, name := range grouping {
+		if value, ok := labels[name]; ok {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// binary applies an arithmetic operator. Samples of two vectors are matched
+// by their labels without the metric name, each must match at most one sample.
+func binary(op string, lhs, rhs value) (value, error) {
+	switch {
+	case lhs.isScalar && rhs.isScalar:
+		return value{isScalar: true, scalar: arithmetic(op, lhs.scalar, rhs.scalar)}, nil
+	case rhs.isScalar:
+		vector := make([]sample, len(lhs.vector))
+		for i, s := range lhs.vector {
+			vector[i] = sample{labels: dropName(s.labels), value: arithmetic(op, s.value, rhs.scalar)}
+		}
+		return value{vector: vector}, nil
+	case lhs.isScalar:
+		vector := make([]sample, len(rhs.vector))
+		for i, s := range rhs.vector {
+			vector[i] = sample{labels: dropName(s.labels), value: arithmetic(op, lhs.scalar, s.value)}
+		}
+		return value{vector: vector}, nil
+	}
+
+	right := make(map[string]sample, len(rhs.vector))
+	for _, s := range rhs.vector {
+		labels := dropName(s.labels)
+		key := labelsKey(labels)
+		if _, ok := right[key]; ok {
+			return value{}, fmt.Errorf("many-to-many matching not allowed: several series on the right side have the labels %s", key)
+		}
+		right[key] = sample{labels: labels, value: s.value}
+	}
+
+	var vector []sample
+	seen := make(map[string]bool, len(lhs.vector))
+	for _, s := range lhs.vector {
+		labels := dropName(s.labels)
+		key := labelsKey(labels)
+		if seen[key] {
+			return value{}, fmt.Errorf("many-to-many matching not allowed: several series on the left side have the labels %s", key)
+		}
+		seen[key] = true
+
+		if r, ok := right[key]; ok {
+			vector = append(vector, sample{labels: labels, value: arithmetic(op, s.value, r.value)})
+		}
+	}
+	return value{vector: vector}, nil
+}
+
+func arithmetic(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	case "^":
+		return math.Pow(a, b)
+	}
+	return math.NaN()
+}
+
+// dropName returns the labels without the metric name
+func dropName(labels map[string]string) map[string]string {
+	if _, ok := labels["__name__"]; !ok {
+		return labels
+	}
+	result := make(map[string]string, len(labels)-1)
+	for name, value := range labels {
+		if name != "__name__" {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// labelsKey identifies a set of labels
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, labels[name])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
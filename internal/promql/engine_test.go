@@ -0,0 +1,187 @@
+package promql
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeQueryable serves fixed series, filtered by the matchers and time range
+type fakeQueryable struct {
+	series []Series
+}
+
+func (f *fakeQueryable) SelectSeries(ctx context.Context, matchers []*Matcher, start, end time.Time) ([]Series, error) {
+	var result []Series
+	for _, s := range f.series {
+		if !MatchLabels(matchers, s.Labels) {
+			continue
+		}
+		var points []Point
+		for _, p := range s.Points {
+			if p.T >= start.UnixMilli() && p.T <= end.UnixMilli() {
+				points = append(points, p)
+			}
+		}
+		result = append(result, Series{Labels: s.Labels, Points: points})
+	}
+	return result, nil
+}
+
+// counter returns points every 15s from 0 to 10m increasing by perSecond
+func counter(perSecond float64) []Point {
+	var points []Point
+	for ts := int64(0); ts <= 600_000; ts += 15_000 {
+		points = append(points, Point{T: ts, V: perSecond * float64(ts) / 1000})
+	}
+	return points
+}
+
+func testEngine() *Engine {
+	return NewEngine(&fakeQueryable{series: []Series{
+		{Labels: map[string]string{"__name__": "http_requests_total", "service": "checkout", "env": "prod", "instance": "a"}, Points: counter(2)},
+		{Labels: map[string]string{"__name__": "http_requests_total", "service": "checkout", "env": "prod", "instance": "b"}, Points: counter(3)},
+		{Labels: map[string]string{"__name__": "http_requests_total", "service": "cart", "env": "dev", "instance": "a"}, Points: counter(1)},
+		{Labels: map[string]string{"__name__": "capacity", "service": "checkout", "env": "prod", "instance": "a"}, Points: []Point{{T: 590_000, V: 10}}},
+		{Labels: map[string]string{"__name__": "capacity", "service": "checkout", "env": "prod", "instance": "b"}, Points: []Point{{T: 590_000, V: 20}}},
+	}})
+}
+
+func TestEngine_Instant(t *testing.T) {
+	at := time.UnixMilli(600_000)
+
+	tests := []struct {
+		query string
+		want  map[string]float64 // Values by labelsKey
+	}{
+		{
+			query: `http_requests_total{env="prod",instance="a"}`,
+			want:  map[string]float64{`{__name__="http_requests_total",env="prod",instance="a",service="checkout"}`: 1200},
+		},
+		{
+			query: `rate(http_requests_total{service="cart"}[5m])`,
+			want:  map[string]float64{`{env="dev",instance="a",service="cart"}`: 1},
+		},
+		{
+			query: `increase(http_requests_total{service="cart"}[5m])`,
+			want:  map[string]float64{`{env="dev",instance="a",service="cart"}`: 300},
+		},
+		{
+			query: `irate(http_requests_total{service="cart"}[1m])`,
+			want:  map[string]float64{`{env="dev",instance="a",service="cart"}`: 1},
+		},
+		{
+			query: `sum by (service) (rate(http_requests_total[5m]))`,
+			want:  map[string]float64{`{service="cart"}`: 1, `{service="checkout"}`: 5},
+		},
+		{
+			query: `count without (instance) (http_requests_total)`,
+			want:  map[string]float64{`{env="dev",service="cart"}`: 1, `{env="prod",service="checkout"}`: 2},
+		},
+		{
+			query: `max(http_requests_total)`,
+			want:  map[string]float64{`{}`: 1800},
+		},
+		{
+			query: `avg_over_time(http_requests_total{service="cart"}[1m])`,
+			want:  map[string]float64{`{env="dev",instance="a",service="cart"}`: 577.5},
+		},
+		{
+			query: `count_over_time(http_requests_total{service="cart"}[1m])`,
+			want:  map[string]float64{`{env="dev",instance="a",service="cart"}`: 4},
+		},
+		{
+			query: `rate(http_requests_total{env="prod"}[5m]) / capacity * 100`,
+			want:  map[string]float64{`{env="prod",instance="a",service="checkout"}`: 20, `{env="prod",instance="b",service="checkout"}`: 15},
+		},
+		{
+			query: `2 ^ 3 - 1`,
+			want:  map[string]float64{`{}`: 7},
+		},
+		{
+			query: `missing_metric`,
+			want:  map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := ParseExpr(tt.query)
+			if err != nil {
+				t.Fatalf("ParseExpr() error = %v", err)
+			}
+			series, err := testEngine().Instant(context.Background(), expr, at)
+			if err != nil {
+				t.Fatalf("Instant() error = %v", err)
+			}
+
+			got := make(map[string]float64)
+			for _, s := range series {
+				if len(s.Points) != 1 || s.Points[0].T != at.UnixMilli() {
+					t.Fatalf("series %v has points %v, want a single point at %d", s.Labels, s.Points, at.UnixMilli())
+				}
+				got[labelsKey(s.Labels)] = s.Points[0].V
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Instant() = %v, want %v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if v, ok := got[key]; !ok || math.Abs(v-want) > 1e-9 {
+					t.Errorf("Instant()[%s] = %v, want %v", key, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_Range(t *testing.T) {
+	expr, err := ParseExpr(`sum(rate(http_requests_total{env="prod"}[1m]))`)
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+
+	series, err := testEngine().Range(context.Background(), expr, time.UnixMilli(60_000), time.UnixMilli(600_000), time.Minute)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	if len(series) != 1 || len(series[0].Points) != 10 {
+		t.Fatalf("Range() = %v, want one series with 10 points", series)
+	}
+	for _, p := range series[0].Points {
+		if math.Abs(p.V-5) > 1e-9 {
+			t.Errorf("point at %d = %v, want 5", p.T, p.V)
+		}
+	}
+
+	if _, err := testEngine().Range(context.Background(), expr, time.UnixMilli(0), time.UnixMilli(0).Add(24*time.Hour), time.Second); err != ErrTooManySteps {
+		t.Errorf("Range() error = %v, want ErrTooManySteps", err)
+	}
+}
+
+func TestEngine_ManyToMany(t *testing.T) {
+	engine := NewEngine(&fakeQueryable{series: []Series{
+		{Labels: map[string]string{"__name__": "http_requests_total", "instance": "a"}, Points: []Point{{T: 0, V: 1}}},
+		{Labels: map[string]string{"__name__": "http_requests", "instance": "a"}, Points: []Point{{T: 0, V: 1}}},
+		{Labels: map[string]string{"__name__": "capacity", "instance": "a"}, Points: []Point{{T: 0, V: 1}}},
+	}})
+	expr, err := ParseExpr(`{instance="a",__name__=~"http_requests.*"} / capacity`)
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+	if _, err := engine.Instant(context.Background(), expr, time.UnixMilli(0)); err == nil {
+		t.Error("Instant() error = nil, want a many-to-many matching error")
+	}
+}
+
+func Test_extrapolatedRate(t *testing.T) {
+	// The reset from 30 to 5 is corrected: 20 before it and 15 after it
+	points := []Point{{T: 0, V: 10}, {T: 10_000, V: 30}, {T: 20_000, V: 5}, {T: 30_000, V: 15}}
+	got, ok := extrapolatedRate(points, 0, 30_000, false)
+	if !ok || math.Abs(got-35) > 1e-9 {
+		t.Errorf("extrapolatedRate() = %v, %v, want 35", got, ok)
+	}
+
+	if _, ok := extrapolatedRate(points[:1], 0, 30_000, true); ok {
+		t.Error("extrapolatedRate() of a single point ok = true, want false")
+	}
+}
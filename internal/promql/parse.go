@@ -0,0 +1,493 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a node of a parsed query
+type Expr interface {
+	String() string
+}
+
+// NumberLiteral is a scalar constant
+type NumberLiteral struct {
+	Value float64
+}
+
+// VectorSelector selects series by their labels. With a range it selects the
+// samples of the range before each evaluation time, as the argument of a
+// function such as rate.
+type VectorSelector struct {
+	Matchers []*Matcher
+	Range    time.Duration
+}
+
+// Call is a function applied to a range selector
+type Call struct {
+	Func string
+	Arg  *VectorSelector
+}
+
+// AggregateExpr aggregates a vector by the grouping labels, or by every label
+// except the grouping labels if Without is set
+type AggregateExpr struct {
+	Op       string
+	Grouping []string
+	Without  bool
+	Expr     Expr
+}
+
+// BinaryExpr applies an arithmetic operator to scalars and vectors
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+func (e *NumberLiteral) String() string {
+	return strconv.FormatFloat(e.Value, 'g', -1, 64)
+}
+
+func (e *VectorSelector) String() string {
+	parts := make([]string, len(e.Matchers))
+	for i, m := range e.Matchers {
+		parts[i] = m.String()
+	}
+	s := "{" + strings.Join(parts, ",") + "}"
+	if e.Range > 0 {
+		s += "[" + e.Range.String() + "]"
+	}
+	return s
+}
+
+func (e *Call) String() string {
+	return e.Func + "(" + e.Arg.String() + ")"
+}
+
+func (e *AggregateExpr) String() string {
+	grouping := "by"
+	if e.Without {
+		grouping = "without"
+	}
+	return fmt.Sprintf("%s %s (%s) (%s)", e.Op, grouping, strings.Join(e.Grouping, ", "), e.Expr)
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.LHS.String() + " " + e.Op + " " + e.RHS.String() + ")"
+}
+
+// rangeFunctions are the functions of a range selector
+var rangeFunctions = map[string]bool{
+	"rate":              true,
+	"increase":          true,
+	"irate":             true,
+	"avg_over_time":     true,
+	"sum_over_time":     true,
+	"min_over_time":     true,
+	"max_over_time":     true,
+	"count_over_time":   true,
+	"last_over_time":    true,
+	"stddev_over_time":  true,
+	"present_over_time": true,
+}
+
+// aggregateOps are the supported aggregation operators
+var aggregateOps = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+// binaryPrecedence is the precedence of the arithmetic operators, ^ is right associative
+var binaryPrecedence = map[string]int{
+	"+": 1,
+	"-": 1,
+	"*": 2,
+	"/": 2,
+	"%": 2,
+	"^": 3,
+}
+
+// ParseExpr parses a query of the supported PromQL subset: selectors with
+// label matchers, the functions of range selectors, the sum, avg, min, max
+// and count aggregations and arithmetic between scalars and vectors
+func ParseExpr(s string) (Expr, error) {
+	p := &parser{input: s}
+	expr, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d of query", p.input[p.pos:], p.pos)
+	}
+	if sel, ok := expr.(*VectorSelector); ok && sel.Range > 0 {
+		return nil, fmt.Errorf("a range selector must be the argument of a function")
+	}
+	return expr, nil
+}
+
+// expr parses binary expressions whose operators have at least minPrecedence
+func (p *parser) expr(minPrecedence int) (Expr, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		op := string(p.peek())
+		precedence, ok := binaryPrecedence[op]
+		if !ok || precedence < minPrecedence {
+			return lhs, nil
+		}
+		p.pos++
+
+		next := precedence + 1
+		if op == "^" {
+			next = precedence
+		}
+		rhs, err := p.expr(next)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOperand(lhs); err != nil {
+			return nil, err
+		}
+		if err := checkOperand(rhs); err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+}
+
+// unary parses an operand with an optional sign, which binds less tightly than ^
+func (p *parser) unary() (Expr, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '-', '+':
+		sign := p.peek()
+		p.pos++
+		operand, err := p.expr(binaryPrecedence["^"])
+		if err != nil {
+			return nil, err
+		}
+		if sign == '+' {
+			return operand, nil
+		}
+		if n, ok := operand.(*NumberLiteral); ok {
+			return &NumberLiteral{Value: -n.Value}, nil
+		}
+		if err := checkOperand(operand); err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: "*", LHS: &NumberLiteral{Value: -1}, RHS: operand}, nil
+	}
+	return p.primary()
+}
+
+// primary parses a parenthesized expression, a number, a selector, a function call or an aggregation
+func (p *parser) primary() (Expr, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		expr, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case c >= '0' && c <= '9' || c == '.':
+		return p.number()
+	case c == '{':
+		return p.vectorSelector("")
+	}
+
+	start := p.pos
+	name := p.identifier(true)
+	if name == "" {
+		if p.done() {
+			return nil, fmt.Errorf("unexpected end of query")
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d of query", p.input[p.pos:p.pos+1], p.pos)
+	}
+
+	switch strings.ToLower(name) {
+	case "inf":
+		return &NumberLiteral{Value: math.Inf(1)}, nil
+	case "nan":
+		return &NumberLiteral{Value: math.NaN()}, nil
+	}
+
+	afterName := p.pos
+	p.skipSpace()
+	next := p.input[p.pos:]
+	if aggregateOps[name] && (strings.HasPrefix(next, "(") || p.keyword("by") || p.keyword("without")) {
+		p.pos = afterName
+		return p.aggregate(name)
+	}
+	if strings.HasPrefix(next, "(") {
+		if !rangeFunctions[name] {
+			return nil, fmt.Errorf("unknown function %s at position %d of query", name, start)
+		}
+		return p.call(name)
+	}
+
+	p.pos = afterName
+	return p.vectorSelector(name)
+}
+
+// keyword reports whether the input continues with a keyword
+func (p *parser) keyword(word string) bool {
+	rest := p.input[p.pos:]
+	if !strings.HasPrefix(rest, word) {
+		return false
+	}
+	if len(rest) == len(word) {
+		return true
+	}
+	c := rest[len(word)]
+	return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':')
+}
+
+// expect consumes the next non-space character, which must be c
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		if p.done() {
+			return fmt.Errorf("expected %q at end of query", c)
+		}
+		return fmt.Errorf("expected %q at position %d of query", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// number parses a decimal or scientific number
+func (p *parser) number() (Expr, error) {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c >= '0' && c <= '9' || c == '.' {
+			p.pos++
+			continue
+		}
+		if (c == 'e' || c == 'E') && p.pos > start {
+			p.pos++
+			if c := p.peek(); c == '+' || c == '-' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q at position %d of query", p.input[start:p.pos], start)
+	}
+	return &NumberLiteral{Value: value}, nil
+}
+
+// vectorSelector parses a selector with an optional range
+func (p *parser) vectorSelector(name string) (Expr, error) {
+	matchers, err := p.selector(name)
+	if err != nil {
+		return nil, err
+	}
+	sel := &VectorSelector{Matchers: matchers}
+
+	p.skipSpace()
+	if p.peek() == '[' {
+		p.pos++
+		end := strings.IndexByte(p.input[p.pos:], ']')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated range at position %d of query", p.pos)
+		}
+		d, err := ParseDuration(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("range must be positive")
+		}
+		sel.Range = d
+		p.pos += end + 1
+	}
+	return sel, nil
+}
+
+// call parses the parenthesized range selector of a function
+func (p *parser) call(name string) (Expr, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	arg, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	sel, ok := arg.(*VectorSelector)
+	if !ok || sel.Range == 0 {
+		return nil, fmt.Errorf("%s expects a range selector such as metric[5m]", name)
+	}
+	return &Call{Func: name, Arg: sel}, nil
+}
+
+// aggregate parses an aggregation, the grouping goes before or after the expression
+func (p *parser) aggregate(op string) (Expr, error) {
+	agg := &AggregateExpr{Op: op}
+
+	grouping := func() error {
+		p.skipSpace()
+		switch {
+		case p.keyword("by"):
+			p.pos += len("by")
+		case p.keyword("without"):
+			p.pos += len("without")
+			agg.Without = true
+		default:
+			return nil
+		}
+		labels, err := p.groupingLabels()
+		if err != nil {
+			return err
+		}
+		agg.Grouping = labels
+		return nil
+	}
+
+	if err := grouping(); err != nil {
+		return nil, err
+	}
+	hasGrouping := agg.Grouping != nil || agg.Without
+
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	expr, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if err := checkOperand(expr); err != nil {
+		return nil, err
+	}
+	agg.Expr = expr
+
+	if !hasGrouping {
+		if err := grouping(); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+// groupingLabels parses the parenthesized label list of by or without
+func (p *parser) groupingLabels() ([]string, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	labels := []string{}
+	for {
+		p.skipSpace()
+		if p.peek() == ')' {
+			p.pos++
+			sort.Strings(labels)
+			return labels, nil
+		}
+
+		label := p.identifier(false)
+		if label == "" {
+			return nil, fmt.Errorf("expected label name at position %d of query", p.pos)
+		}
+		labels = append(labels, label)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+		default:
+			return nil, fmt.Errorf("expected , or ) at position %d of query", p.pos)
+		}
+	}
+}
+
+// checkOperand rejects range selectors outside of function arguments
+func checkOperand(expr Expr) error {
+	if sel, ok := expr.(*VectorSelector); ok && sel.Range > 0 {
+		return fmt.Errorf("range selector %s must be the argument of a function", sel)
+	}
+	return nil
+}
+
+// durationUnits are the units of PromQL durations
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// ParseDuration parses a PromQL duration such as 5m or 1h30m
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		rest = rest[i:]
+
+		matched := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(rest, u.suffix) {
+				total += time.Duration(n) * u.unit
+				rest = rest[len(u.suffix):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,76 @@
+package promql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "up", want: `{__name__="up"}`},
+		{query: `rate(http_requests_total{job="api"}[5m])`, want: `rate({__name__="http_requests_total",job="api"}[5m0s])`},
+		{query: `sum by (job) (rate(http_requests_total[1h30m]))`, want: `sum by (job) (rate({__name__="http_requests_total"}[1h30m0s]))`},
+		{query: `sum(rate(http_requests_total[5m])) by (service, env)`, want: `sum by (env, service) (rate({__name__="http_requests_total"}[5m0s]))`},
+		{query: `avg without (instance) (up)`, want: `avg without (instance) ({__name__="up"})`},
+		{query: `1 + 2 * 3`, want: `(1 + (2 * 3))`},
+		{query: `(1 + 2) * 3`, want: `((1 + 2) * 3)`},
+		{query: `10 - 4 - 3`, want: `((10 - 4) - 3)`},
+		{query: `2 ^ 3 ^ 2`, want: `(2 ^ (3 ^ 2))`},
+		{query: `-2 ^ 2`, want: `(-1 * (2 ^ 2))`},
+		{query: `-up`, want: `(-1 * {__name__="up"})`},
+		{query: `1.5e3`, want: `1500`},
+		{query: `sum(up) / count(up) * 100`, want: `((sum by () ({__name__="up"}) / count by () ({__name__="up"})) * 100)`},
+		{query: `up[5m]`, wantErr: true},
+		{query: `up[5m] + 1`, wantErr: true},
+		{query: `rate(up)`, wantErr: true},
+		{query: `histogram_quantile(0.9, up)`, wantErr: true},
+		{query: `sum by (job up)`, wantErr: true},
+		{query: `up{job="api"`, wantErr: true},
+		{query: `(up`, wantErr: true},
+		{query: `up +`, wantErr: true},
+		{query: `{job=~".*"}`, wantErr: true},
+		{query: `up[5x]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := ParseExpr(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && expr.String() != tt.want {
+				t.Errorf("ParseExpr() = %s, want %s", expr, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "30s", want: 30 * time.Second},
+		{s: "1h30m", want: 90 * time.Minute},
+		{s: "500ms", want: 500 * time.Millisecond},
+		{s: "1w", want: 7 * 24 * time.Hour},
+		{s: "", wantErr: true},
+		{s: "5", wantErr: true},
+		{s: "m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseDuration(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchType is the operator of a label matcher
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches the value of a single label. A missing label has the value "".
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewMatcher creates a new Matcher, regular expressions are anchored at both ends
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %q", t)
+	}
+	return m, nil
+}
+
+// Matches reports whether the label value v matches
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+// String returns the matcher in selector syntax
+func (m *Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// MatchLabels reports whether every matcher matches labels, with the metric
+// name held in the __name__ label
+func MatchLabels(matchers []*Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricName returns the value of an equality matcher on __name__, if any
+func MetricName(matchers []*Matcher) (string, bool) {
+	for _, m := range matchers {
+		if m.Name == "__name__" && m.Type == MatchEqual {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseSelector parses a series selector such as
+// http_requests_total{job="api",code=~"5.."}. A metric name before the braces
+// becomes an equality matcher on __name__.
+func ParseSelector(s string) ([]*Matcher, error) {
+	p := &parser{input: s}
+	p.skipSpace()
+
+	matchers, err := p.selector(p.identifier(true))
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d of selector", p.input[p.pos:], p.pos)
+	}
+	return matchers, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// identifier reads a label name, or a metric name which may also contain colons
+func (p *parser) identifier(metricName bool) string {
+	start := p.pos
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && p.pos > start:
+		case c == ':' && metricName:
+		default:
+			return p.input[start:p.pos]
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// selector parses the optional label matchers following a metric name, which
+// may be empty, and checks that the selector does not match every series
+func (p *parser) selector(name string) ([]*Matcher, error) {
+	start := p.pos - len(name)
+
+	var matchers []*Matcher
+	if name != "" {
+		matchers = append(matchers, &Matcher{Type: MatchEqual, Name: "__name__", Value: name})
+	}
+
+	p.skipSpace()
+	if p.peek() == '{' {
+		p.pos++
+		labelMatchers, err := p.labelMatchers()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, labelMatchers...)
+	}
+
+	selector := strings.TrimSpace(p.input[start:p.pos])
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("selector %q has no matchers", selector)
+	}
+
+	// As in PromQL, a selector must not match every series
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return matchers, nil
+		}
+	}
+	return nil, fmt.Errorf("selector %q must contain at least one matcher that does not match the empty string", selector)
+}
+
+// labelMatchers parses the matchers after the opening brace up to the closing brace
+func (p *parser) labelMatchers() ([]*Matcher, error) {
+	var matchers []*Matcher
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.pos++
+			return matchers, nil
+		}
+
+		name := p.identifier(false)
+		if name == "" {
+			return nil, fmt.Errorf("expected label name at position %d of selector", p.pos)
+		}
+
+		p.skipSpace()
+		var op MatchType
+		for _, candidate := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(p.input[p.pos:], string(candidate)) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("expected match operator after label %s", name)
+		}
+		p.pos += len(op)
+
+		p.skipSpace()
+		value, err := p.quoted()
+		if err != nil {
+			return nil, fmt.Errorf("label %s: %w", name, err)
+		}
+
+		m, err := NewMatcher(op, name, value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected , or } at position %d of selector", p.pos)
+		}
+	}
+}
+
+// quoted reads a string in double quotes, single quotes or backticks
+func (p *parser) quoted() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return "", fmt.Errorf("expected quoted value at position %d", p.pos)
+	}
+
+	start := p.pos
+	for p.pos++; !p.done(); p.pos++ {
+		switch p.input[p.pos] {
+		case '\\':
+			if quote != '`' {
+				p.pos++
+			}
+		case quote:
+			p.pos++
+			literal := p.input[start:p.pos]
+			if quote == '\'' {
+				// strconv.Unquote only reads single characters in single quotes
+				literal = `"` + strings.ReplaceAll(strings.ReplaceAll(literal[1:len(literal)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			return strconv.Unquote(literal)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
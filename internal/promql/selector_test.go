@@ -0,0 +1,77 @@
+package promql
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+		wantErr  bool
+	}{
+		{selector: "up", want: `[__name__="up"]`},
+		{selector: `http_requests_total{job="api", code=~"5..",method!="GET" , path!~'/health.*'}`, want: `[__name__="http_requests_total" job="api" code=~"5.." method!="GET" path!~"/health.*"]`},
+		{selector: "{__name__=\"up\",instance=`a:9100`,}", want: `[__name__="up" instance="a:9100"]`},
+		{selector: `job:requests:rate5m{label="quote \" and \\ backslash"}`, want: `[__name__="job:requests:rate5m" label="quote \" and \\ backslash"]`},
+		{selector: `{job=~".*"}`, wantErr: true},
+		{selector: `up{job="api"`, wantErr: true},
+		{selector: `up{job=api}`, wantErr: true},
+		{selector: `up{job=~"("}`, wantErr: true},
+		{selector: `up extra`, wantErr: true},
+		{selector: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			matchers, err := ParseSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got := "["
+			for i, m := range matchers {
+				if i > 0 {
+					got += " "
+				}
+				got += m.String()
+			}
+			got += "]"
+			if got != tt.want {
+				t.Errorf("ParseSelector() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	matchers, err := ParseSelector(`http_requests_total{code=~"5..",method!="GET",env=""}`)
+	if err != nil {
+		t.Fatalf("ParseSelector() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "match", labels: map[string]string{"__name__": "http_requests_total", "code": "503", "method": "POST"}, want: true},
+		{name: "regexp is anchored", labels: map[string]string{"__name__": "http_requests_total", "code": "5030", "method": "POST"}},
+		{name: "excluded method", labels: map[string]string{"__name__": "http_requests_total", "code": "500", "method": "GET"}},
+		{name: "empty matcher requires a missing label", labels: map[string]string{"__name__": "http_requests_total", "code": "500", "env": "prod"}},
+		{name: "other metric", labels: map[string]string{"__name__": "up", "code": "500"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchLabels(matchers, tt.labels); got != tt.want {
+				t.Errorf("MatchLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if name, ok := MetricName(matchers); !ok || name != "http_requests_total" {
+		t.Errorf("MetricName() = %s, %v, want http_requests_total", name, ok)
+	}
+}
@@ -0,0 +1,265 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yay14/pulse/internal/filewatch"
+)
+
+// idleBucketTTL is how long buckets of a source are kept after its last write
+const idleBucketTTL = 10 * time.Minute
+
+// Limit is the rate of samples and bytes a source may write. A zero rate
+// is unlimited, a zero burst allows a second worth of the rate at once.
+type Limit struct {
+	SamplesPerSecond float64 `json:"samples_per_second"`
+	BytesPerSecond   float64 `json:"bytes_per_second"`
+	SampleBurst      float64 `json:"sample_burst"`
+	ByteBurst        float64 `json:"byte_burst"`
+}
+
+// Config holds the limits of sources. The limit of a source_id takes
+// precedence over the limit of its source_type, which takes precedence over
+// the default.
+type Config struct {
+	Default     Limit            `json:"default"`
+	SourceTypes map[string]Limit `json:"source_types"`
+	Sources     map[string]Limit `json:"sources"`
+}
+
+// limit returns the limit of a source
+func (c *Config) limit(sourceType, sourceID string) Limit {
+	if limit, ok := c.Sources[sourceID]; ok {
+		return limit
+	}
+	if limit, ok := c.SourceTypes[sourceType]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// validate rejects negative rates and bursts
+func (c *Config) validate() error {
+	check := func(name string, limit Limit) error {
+		if limit.SamplesPerSecond < 0 || limit.BytesPerSecond < 0 || limit.SampleBurst < 0 || limit.ByteBurst < 0 {
+			return fmt.Errorf("limit of %s must not be negative", name)
+		}
+		return nil
+	}
+	if err := check("the default", c.Default); err != nil {
+		return err
+	}
+	for name, limit := range c.SourceTypes {
+		if err := check("source type "+name, limit); err != nil {
+			return err
+		}
+	}
+	for name, limit := range c.Sources {
+		if err := check("source "+name, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFile reads a JSON rate limit configuration from path
+func LoadFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read rate limit config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse rate limit config: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid rate limit config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Limiter holds a token bucket of samples and one of bytes for every source
+// of every tenant, the limits of a source apply to it in each tenant alike.
+// A nil Limiter allows every write.
+type Limiter struct {
+	cfg atomic.Pointer[Config]
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[sourceKey]*sourceBuckets
+	swept   time.Time
+}
+
+type sourceKey struct {
+	tenantID, sourceType, sourceID string
+}
+
+type sourceBuckets struct {
+	samples, bytes bucket
+	used           time.Time
+}
+
+// bucket holds tokens refilled at a rate up to a burst, a write larger than
+// the burst is allowed from a full bucket and leaves it in debt
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a new Limiter
+func NewLimiter(cfg Config) *Limiter {
+	l := &Limiter{now: time.Now, buckets: make(map[sourceKey]*sourceBuckets)}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+// SetConfig replaces the limits, the buckets of sources keep their tokens
+func (l *Limiter) SetConfig(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	l.cfg.Store(&cfg)
+	return nil
+}
+
+// Reserve takes samples and bytes from the buckets of a source of a tenant.
+// If either bucket has too few tokens nothing is taken and the time until the
+// write would be allowed is returned, otherwise zero.
+func (l *Limiter) Reserve(tenantID, sourceType, sourceID string, samples, bytes int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	limit := l.cfg.Load().limit(sourceType, sourceID)
+	if limit.SamplesPerSecond == 0 && limit.BytesPerSecond == 0 {
+		return 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	key := sourceKey{tenantID, sourceType, sourceID}
+	b := l.buckets[key]
+	if b == nil {
+		b = &sourceBuckets{}
+		l.buckets[key] = b
+	}
+	b.used = now
+
+	sampleBurst := burst(limit.SamplesPerSecond, limit.SampleBurst)
+	byteBurst := burst(limit.BytesPerSecond, limit.ByteBurst)
+	wait := b.samples.wait(limit.SamplesPerSecond, sampleBurst, float64(samples), now)
+	if byteWait := b.bytes.wait(limit.BytesPerSecond, byteBurst, float64(bytes), now); byteWait > wait {
+		wait = byteWait
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if limit.SamplesPerSecond > 0 {
+		b.samples.tokens -= float64(samples)
+	}
+	if limit.BytesPerSecond > 0 {
+		b.bytes.tokens -= float64(bytes)
+	}
+	return 0
+}
+
+// Wait blocks until the buckets of a source of a tenant hold enough tokens
+// for a write and takes them
+func (l *Limiter) Wait(ctx context.Context, tenantID, sourceType, sourceID string, samples, bytes int) error {
+	for {
+		wait := l.Reserve(tenantID, sourceType, sourceID, samples, bytes)
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Watch reloads the limits from path whenever the file changes, checking it
+// every interval (10s if zero) until ctx is done. A config that fails to load
+// is logged and the current limits are kept.
+func (l *Limiter) Watch(ctx context.Context, path string, interval time.Duration) {
+	filewatch.Watch(ctx, path, interval, func() error {
+		cfg, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := l.SetConfig(cfg); err != nil {
+			return err
+		}
+		log.Printf("Reloaded rate limits from %s", path)
+		return nil
+	})
+}
+
+// sweep removes the buckets of sources that have not written for a while
+// once they are refilled, a new bucket starts full
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleBucketTTL {
+		return
+	}
+	cfg := l.cfg.Load()
+	for key, b := range l.buckets {
+		if now.Sub(b.used) < idleBucketTTL {
+			continue
+		}
+		limit := cfg.limit(key.sourceType, key.sourceID)
+		if b.samples.full(limit.SamplesPerSecond, burst(limit.SamplesPerSecond, limit.SampleBurst), now) &&
+			b.bytes.full(limit.BytesPerSecond, burst(limit.BytesPerSecond, limit.ByteBurst), now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// burst returns the configured burst or a second worth of the rate
+func burst(rate, burst float64) float64 {
+	if burst > 0 {
+		return burst
+	}
+	return rate
+}
+
+// wait refills the bucket and returns how long it takes until it holds n
+// tokens, or the full burst if n exceeds it. A zero rate is unlimited.
+func (b *bucket) wait(rate, burst, n float64, now time.Time) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	if b.updated.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+rate*now.Sub(b.updated).Seconds())
+	}
+	b.updated = now
+
+	need := math.Min(n, burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration(math.Ceil((need - b.tokens) / rate * float64(time.Second)))
+}
+
+// full reports whether the bucket is refilled to its burst
+func (b *bucket) full(rate, burst float64, now time.Time) bool {
+	return rate == 0 || b.updated.IsZero() || b.tokens+rate*now.Sub(b.updated).Seconds() >= burst
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestLimiter(cfg Config) (*Limiter, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	l, now := newTestLimiter(Config{
+		Default:     Limit{SamplesPerSecond: 10},
+		SourceTypes: map[string]Limit{"queue": {BytesPerSecond: 100, ByteBurst: 200}},
+		Sources:     map[string]Limit{"unlimited": {}},
+	})
+
+	steps := []struct {
+		name                 string
+		advance              time.Duration
+		tenantID             string
+		sourceType, sourceID string
+		samples, bytes       int
+		want                 time.Duration
+	}{
+		{name: "within the burst", sourceType: "app", sourceID: "api", samples: 6},
+		{name: "past the burst", sourceType: "app", sourceID: "api", samples: 6, want: 200 * time.Millisecond},
+		{name: "refilled", advance: 200 * time.Millisecond, sourceType: "app", sourceID: "api", samples: 6},
+		{name: "sources have their own buckets", sourceType: "app", sourceID: "web", samples: 10},
+		{name: "tenants have their own buckets", tenantID: "acme", sourceType: "app", sourceID: "web", samples: 10},
+		{name: "larger than the burst from a full bucket", sourceType: "app", sourceID: "batch", samples: 25},
+		{name: "repays the debt", sourceType: "app", sourceID: "batch", samples: 1, want: 1600 * time.Millisecond},
+		{name: "source type limit", sourceType: "queue", sourceID: "orders", samples: 1000, bytes: 150},
+		{name: "source type byte limit", sourceType: "queue", sourceID: "orders", bytes: 100, want: 500 * time.Millisecond},
+		{name: "source limit takes precedence", sourceType: "queue", sourceID: "unlimited", samples: 1000, bytes: 1000},
+	}
+	for _, step := range steps {
+		*now = now.Add(step.advance)
+		if got := l.Reserve(step.tenantID, step.sourceType, step.sourceID, step.samples, step.bytes); got != step.want {
+			t.Errorf("%s: Reserve() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestLimiter_SetConfig(t *testing.T) {
+	l, _ := newTestLimiter(Config{Default: Limit{SamplesPerSecond: 1}})
+	if wait := l.Reserve("", "app", "api", 1, 0); wait != 0 {
+		t.Fatalf("Reserve() = %v, want 0", wait)
+	}
+	if wait := l.Reserve("", "app", "api", 1, 0); wait == 0 {
+		t.Fatal("Reserve() allowed a write past the limit")
+	}
+
+	if err := l.SetConfig(Config{Default: Limit{SamplesPerSecond: -1}}); err == nil {
+		t.Error("SetConfig() accepted a negative rate")
+	}
+	if err := l.SetConfig(Config{}); err != nil {
+		t.Fatalf("SetConfig() error = %v", err)
+	}
+	if wait := l.Reserve("", "app", "api", 1, 0); wait != 0 {
+		t.Errorf("Reserve() = %v after removing the limit, want 0", wait)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	l := NewLimiter(Config{Default: Limit{SamplesPerSecond: 100, SampleBurst: 1}})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background(), "", "app", "api", 1, 0); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Wait() returned after %v, want about 20ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = NewLimiter(Config{Default: Limit{SamplesPerSecond: 0.001, SampleBurst: 1}})
+	l.Reserve("", "app", "api", 1, 0)
+	if err := l.Wait(ctx, "", "app", "api", 1, 0); err != context.Canceled {
+		t.Errorf("Wait() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	err := Exceeded(context.Background(), "api", 1500*time.Millisecond)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Exceeded() code = %v, want ResourceExhausted", status.Code(err))
+	}
+	if wait, ok := RetryAfter(err); !ok || wait != 1500*time.Millisecond {
+		t.Errorf("RetryAfter() = %v, %v, want 1.5s", wait, ok)
+	}
+	if _, ok := RetryAfter(status.Error(codes.ResourceExhausted, "series limit exceeded")); ok {
+		t.Error("RetryAfter() found a delay in an error without RetryInfo")
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RetryAfterHeader is the gRPC header carrying the seconds to wait after a
+// write was rejected by a rate limit
+const RetryAfterHeader = "retry-after"
+
+// Exceeded returns the ResourceExhausted error of a write rejected for wait.
+// The delay is attached as RetryInfo and, for gRPC calls, sent in whole
+// seconds in the retry-after header.
+func Exceeded(ctx context.Context, sourceID string, wait time.Duration) error {
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	// Fails outside of a gRPC call, where only the RetryInfo is used
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, seconds))
+
+	st := status.Newf(codes.ResourceExhausted, "rate limit of source %s exceeded, retry after %v", sourceID, wait)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// RetryAfter returns the delay of an error returned by Exceeded
+func RetryAfter(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
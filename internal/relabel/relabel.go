@@ -0,0 +1,171 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Action is the operation a relabel rule performs on a label set
+type Action string
+
+// Supported relabel actions, with the same semantics as Prometheus relabel_configs
+const (
+	Replace   Action = "replace"
+	Keep      Action = "keep"
+	Drop      Action = "drop"
+	LabelDrop Action = "labeldrop"
+	LabelKeep Action = "labelkeep"
+	LabelMap  Action = "labelmap"
+	HashMod   Action = "hashmod"
+)
+
+// Config is a single relabel rule as it appears in configuration files
+type Config struct {
+	SourceLabels []string `json:"source_labels"`
+	Separator    string   `json:"separator"`
+	Regex        string   `json:"regex"`
+	TargetLabel  string   `json:"target_label"`
+	Replacement  string   `json:"replacement"`
+	Modulus      uint64   `json:"modulus"`
+	Action       Action   `json:"action"`
+}
+
+// Rule is a compiled relabel rule
+type Rule struct {
+	Config
+	regex *regexp.Regexp
+}
+
+// Compile validates relabel configs, fills in the Prometheus defaults and compiles their regexes
+func Compile(configs []Config) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(configs))
+	for i, cfg := range configs {
+		if cfg.Action == "" {
+			cfg.Action = Replace
+		}
+		if cfg.Separator == "" {
+			cfg.Separator = ";"
+		}
+		if cfg.Regex == "" {
+			cfg.Regex = "(.*)"
+		}
+		if cfg.Replacement == "" && (cfg.Action == Replace || cfg.Action == LabelMap) {
+			cfg.Replacement = "$1"
+		}
+
+		switch cfg.Action {
+		case Replace:
+			if cfg.TargetLabel == "" {
+				return nil, fmt.Errorf("relabel rule %d: replace needs a target_label", i)
+			}
+		case HashMod:
+			if cfg.TargetLabel == "" {
+				return nil, fmt.Errorf("relabel rule %d: hashmod needs a target_label", i)
+			}
+			if cfg.Modulus == 0 {
+				return nil, fmt.Errorf("relabel rule %d: hashmod needs a modulus", i)
+			}
+		case Keep, Drop:
+			if len(cfg.SourceLabels) == 0 {
+				return nil, fmt.Errorf("relabel rule %d: %s needs source_labels", i, cfg.Action)
+			}
+		case LabelDrop, LabelKeep, LabelMap:
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i, cfg.Action)
+		}
+
+		regex, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %w", i, err)
+		}
+		rules = append(rules, &Rule{Config: cfg, regex: regex})
+	}
+
+	return rules, nil
+}
+
+// Process applies the rules in order to a copy of labels. The metric name is
+// read and written through the __name__ label. It returns false if the label
+// set was dropped.
+func Process(labels map[string]string, rules []*Rule) (map[string]string, bool) {
+	if len(rules) == 0 {
+		return labels, true
+	}
+
+	result := make(map[string]string, len(labels))
+	for key, value := range labels {
+		result[key] = value
+	}
+
+	for _, rule := range rules {
+		if !rule.apply(result) {
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
+func (r *Rule) apply(labels map[string]string) bool {
+	switch r.Action {
+	case Keep:
+		return r.regex.MatchString(r.sourceValue(labels))
+	case Drop:
+		return !r.regex.MatchString(r.sourceValue(labels))
+	case Replace:
+		value := r.sourceValue(labels)
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+
+		target := string(r.regex.ExpandString(nil, r.TargetLabel, value, match))
+		replacement := string(r.regex.ExpandString(nil, r.Replacement, value, match))
+		if replacement == "" {
+			delete(labels, target)
+		} else {
+			labels[target] = replacement
+		}
+	case LabelDrop:
+		for name := range labels {
+			if r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case LabelKeep:
+		for name := range labels {
+			if !r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case LabelMap:
+		// Mapped names are collected first so that they are not mapped again
+		mapped := make(map[string]string)
+		for name, value := range labels {
+			if match := r.regex.FindStringSubmatchIndex(name); match != nil {
+				mapped[string(r.regex.ExpandString(nil, r.Replacement, name, match))] = value
+			}
+		}
+		for name, value := range mapped {
+			labels[name] = value
+		}
+	case HashMod:
+		// As in Prometheus, the modulus is taken of the low 8 bytes of the MD5 sum
+		sum := md5.Sum([]byte(r.sourceValue(labels)))
+		labels[r.TargetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.Modulus, 10)
+	}
+
+	return true
+}
+
+func (r *Rule) sourceValue(labels map[string]string) string {
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, r.Separator)
+}
@@ -0,0 +1,126 @@
+package relabel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	labels := map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100", "user_email": "a@b.c"}
+
+	tests := []struct {
+		name     string
+		configs  []Config
+		want     map[string]string
+		wantKeep bool
+	}{
+		{
+			name:     "no rules",
+			want:     labels,
+			wantKeep: true,
+		},
+		{
+			name: "replace with capture groups",
+			configs: []Config{
+				{SourceLabels: []string{"instance"}, Regex: "(.*):(\\d+)", TargetLabel: "host", Replacement: "${1}"},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100", "user_email": "a@b.c", "host": "web-1"},
+			wantKeep: true,
+		},
+		{
+			name: "replace default copies the joined source labels",
+			configs: []Config{
+				{SourceLabels: []string{"job", "instance"}, TargetLabel: "id"},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100", "user_email": "a@b.c", "id": "api;web-1:9100"},
+			wantKeep: true,
+		},
+		{
+			name: "keep matching metric",
+			configs: []Config{
+				{SourceLabels: []string{"__name__"}, Regex: "http_.*", Action: Keep},
+			},
+			want:     labels,
+			wantKeep: true,
+		},
+		{
+			name: "drop matching metric",
+			configs: []Config{
+				{SourceLabels: []string{"__name__"}, Regex: "http_.*", Action: Drop},
+			},
+			wantKeep: false,
+		},
+		{
+			name: "labeldrop",
+			configs: []Config{
+				{Regex: "user_.*", Action: LabelDrop},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100"},
+			wantKeep: true,
+		},
+		{
+			name: "labelkeep",
+			configs: []Config{
+				{Regex: "__name__|job", Action: LabelKeep},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api"},
+			wantKeep: true,
+		},
+		{
+			name: "labelmap with capture groups",
+			configs: []Config{
+				{Regex: "user_(.*)", Replacement: "customer_$1", Action: LabelMap},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100", "user_email": "a@b.c", "customer_email": "a@b.c"},
+			wantKeep: true,
+		},
+		{
+			name: "hashmod",
+			configs: []Config{
+				{SourceLabels: []string{"instance"}, TargetLabel: "shard", Modulus: 8, Action: HashMod},
+			},
+			want:     map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web-1:9100", "user_email": "a@b.c", "shard": "3"},
+			wantKeep: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Compile(tt.configs)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, keep := Process(labels, rules)
+			if keep != tt.wantKeep {
+				t.Errorf("Process() keep = %v, want %v", keep, tt.wantKeep)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if labels["host"] != "" {
+		t.Errorf("Process() modified its input")
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "replace without target", config: Config{SourceLabels: []string{"a"}}},
+		{name: "keep without source", config: Config{Action: Keep}},
+		{name: "unknown action", config: Config{Action: "rename"}},
+		{name: "hashmod without modulus", config: Config{SourceLabels: []string{"a"}, TargetLabel: "shard", Action: HashMod}},
+		{name: "hashmod without target", config: Config{SourceLabels: []string{"a"}, Modulus: 2, Action: HashMod}},
+		{name: "invalid regex", config: Config{Action: LabelDrop, Regex: "("}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile([]Config{tt.config}); err == nil {
+				t.Errorf("Compile() expected an error")
+			}
+		})
+	}
+}
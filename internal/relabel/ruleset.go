@@ -0,0 +1,81 @@
+package relabel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/yay14/pulse/internal/filewatch"
+)
+
+// FileConfig is the layout of a relabel configuration file
+type FileConfig struct {
+	RelabelConfigs []Config `json:"relabel_configs"`
+}
+
+// LoadFile reads and compiles the relabel_configs of a JSON file
+func LoadFile(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relabel config: %w", err)
+	}
+
+	var cfg FileConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse relabel config: %w", err)
+	}
+	return Compile(cfg.RelabelConfigs)
+}
+
+// RuleSet holds rules that can be replaced while samples are processed. A
+// nil RuleSet has no rules.
+type RuleSet struct {
+	rules atomic.Pointer[[]*Rule]
+}
+
+// NewRuleSet creates a new RuleSet with rules
+func NewRuleSet(rules []*Rule) *RuleSet {
+	s := &RuleSet{}
+	s.Store(rules)
+	return s
+}
+
+// Rules returns the current rules
+func (s *RuleSet) Rules() []*Rule {
+	if s == nil {
+		return nil
+	}
+	if rules := s.rules.Load(); rules != nil {
+		return *rules
+	}
+	return nil
+}
+
+// Store replaces the rules
+func (s *RuleSet) Store(rules []*Rule) {
+	s.rules.Store(&rules)
+}
+
+// Process applies the current rules to labels, see Process
+func (s *RuleSet) Process(labels map[string]string) (map[string]string, bool) {
+	return Process(labels, s.Rules())
+}
+
+// Watch reloads the rules from path whenever the file changes, checking it
+// every interval (10s if zero) until ctx is done. A config that fails to load
+// is logged and the current rules are kept.
+func (s *RuleSet) Watch(ctx context.Context, path string, interval time.Duration) {
+	filewatch.Watch(ctx, path, interval, func() error {
+		rules, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		s.Store(rules)
+		log.Printf("Reloaded %d relabel rules from %s", len(rules), path)
+		return nil
+	})
+}
@@ -0,0 +1,61 @@
+package relabel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRuleSet_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.json")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dropDebug := `{"relabel_configs": [{"source_labels": ["__name__"], "regex": "debug_.*", "action": "drop"}]}`
+	write(dropDebug, time.Now().Add(-time.Hour))
+
+	rules, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	s := NewRuleSet(rules)
+	if _, keep := s.Process(map[string]string{"__name__": "debug_queue"}); keep {
+		t.Fatal("Process() kept a dropped metric")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watch(ctx, path, time.Millisecond)
+
+	// An invalid config keeps the current rules
+	write(`{"relabel_configs": [{"action": "rename"}]}`, time.Now().Add(-time.Minute))
+	time.Sleep(20 * time.Millisecond)
+	if len(s.Rules()) != 1 || s.Rules()[0].Action != Drop {
+		t.Fatalf("Rules() = %v after an invalid reload, want the drop rule", s.Rules())
+	}
+
+	write(`{"relabel_configs": [{"regex": "user_.*", "action": "labeldrop"}]}`, time.Now())
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Rules()[0].Action != LabelDrop && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	labels, keep := s.Process(map[string]string{"__name__": "debug_queue", "user_id": "42"})
+	if !keep || labels["user_id"] != "" {
+		t.Errorf("Process() = %v, %v after the reload, want user_id dropped", labels, keep)
+	}
+}
+
+func TestRuleSet_Nil(t *testing.T) {
+	var s *RuleSet
+	labels := map[string]string{"__name__": "up"}
+	if got, keep := s.Process(labels); !keep || got["__name__"] != "up" {
+		t.Errorf("Process() = %v, %v, want the labels unchanged", got, keep)
+	}
+}
@@ -0,0 +1,153 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxTTL is the longest TTL Cassandra accepts
+	MaxTTL = 20 * 365 * 24 * time.Hour
+
+	// policyCacheTTL bounds how long the policies are cached by a Resolver
+	policyCacheTTL = time.Minute
+)
+
+// Scope selects the data a policy applies to
+type Scope string
+
+const (
+	ScopeGlobal     Scope = "global"      // Every sample
+	ScopeSourceType Scope = "source_type" // Samples of sources of a type
+	ScopeSourceID   Scope = "source_id"   // Samples of a single source
+	ScopeMetricName Scope = "metric_name" // Samples of a metric
+)
+
+// Policy is a retention policy of a tenant. Match is the source type, source
+// id or metric name the policy applies to, it is empty for ScopeGlobal.
+type Policy struct {
+	Tenant string
+	Scope  Scope
+	Match  string
+	TTL    time.Duration
+}
+
+// Validate checks the scope, match and TTL of the policy
+func (p Policy) Validate() error {
+	switch p.Scope {
+	case ScopeGlobal:
+		if p.Match != "" {
+			return fmt.Errorf("global policy must not have a match")
+		}
+	case ScopeSourceType, ScopeSourceID, ScopeMetricName:
+		if p.Match == "" {
+			return fmt.Errorf("%s policy requires a match", p.Scope)
+		}
+	default:
+		return fmt.Errorf("unknown scope %q", p.Scope)
+	}
+
+	if p.TTL < time.Second || p.TTL > MaxTTL {
+		return fmt.Errorf("TTL must be between 1s and %v", MaxTTL)
+	}
+	return nil
+}
+
+// Series identifies the data a policy is resolved for, only the policies of
+// its tenant apply to it
+type Series struct {
+	Tenant     string
+	SourceType string
+	SourceID   string
+	MetricName string
+}
+
+// Resolve returns the TTL of series under policies, 0 if no policy applies.
+// When several policies apply the shortest TTL wins, so that a policy can cap
+// the retention of a source regardless of the policies of its metrics.
+func Resolve(policies []Policy, series Series) time.Duration {
+	var ttl time.Duration
+	for _, p := range policies {
+		if !p.applies(series) {
+			continue
+		}
+		if ttl == 0 || p.TTL < ttl {
+			ttl = p.TTL
+		}
+	}
+	return ttl
+}
+
+func (p Policy) applies(series Series) bool {
+	if p.Tenant != series.Tenant {
+		return false
+	}
+	switch p.Scope {
+	case ScopeGlobal:
+		return true
+	case ScopeSourceType:
+		return p.Match == series.SourceType
+	case ScopeSourceID:
+		return p.Match == series.SourceID
+	case ScopeMetricName:
+		return p.Match == series.MetricName
+	default:
+		return false
+	}
+}
+
+// PolicyStore persists the policies of every tenant, it is implemented by the
+// Cassandra repository
+type PolicyStore interface {
+	ListRetentionPolicies(ctx context.Context) ([]Policy, error)
+}
+
+// Resolver resolves the TTL of series from the cached policies of a store
+type Resolver struct {
+	store PolicyStore
+	now   func() time.Time
+
+	mu       sync.Mutex
+	policies []Policy
+	expires  time.Time
+}
+
+// NewResolver creates a new Resolver
+func NewResolver(store PolicyStore) *Resolver {
+	return &Resolver{store: store, now: time.Now}
+}
+
+// TTL returns the TTL of series, 0 if it is kept forever
+func (r *Resolver) TTL(ctx context.Context, series Series) (time.Duration, error) {
+	policies, err := r.Policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return Resolve(policies, series), nil
+}
+
+// Policies returns the cached policies, reloading them from the store when the cache expired
+func (r *Resolver) Policies(ctx context.Context) ([]Policy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.now().Before(r.expires) {
+		return r.policies, nil
+	}
+
+	policies, err := r.store.ListRetentionPolicies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retention policies: %w", err)
+	}
+	r.policies, r.expires = policies, r.now().Add(policyCacheTTL)
+	return policies, nil
+}
+
+// Invalidate drops the cached policies after they were changed
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expires = time.Time{}
+}
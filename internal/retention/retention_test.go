@@ -0,0 +1,160 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestResolve(t *testing.T) {
+	policies := []Policy{
+		{Scope: ScopeGlobal, TTL: 365 * day},
+		{Scope: ScopeSourceType, Match: "statsd", TTL: 90 * day},
+		{Scope: ScopeSourceID, Match: "payments", TTL: 30 * day},
+		{Scope: ScopeMetricName, Match: "audit_events", TTL: 180 * day},
+	}
+
+	tests := []struct {
+		name     string
+		policies []Policy
+		series   Series
+		want     time.Duration
+	}{
+		{name: "no policies", series: Series{MetricName: "up"}, want: 0},
+		{name: "global", policies: policies, series: Series{SourceType: "otlp", SourceID: "api", MetricName: "up"}, want: 365 * day},
+		{name: "source type", policies: policies, series: Series{SourceType: "statsd", SourceID: "api", MetricName: "up"}, want: 90 * day},
+		{name: "metric name", policies: policies, series: Series{SourceType: "otlp", SourceID: "api", MetricName: "audit_events"}, want: 180 * day},
+		{name: "source cap wins over metric", policies: policies, series: Series{SourceType: "otlp", SourceID: "payments", MetricName: "audit_events"}, want: 30 * day},
+		{name: "other tenant", policies: policies, series: Series{Tenant: "acme", SourceType: "statsd", SourceID: "payments", MetricName: "up"}, want: 0},
+		{
+			name:     "tenant policy",
+			policies: append(policies, Policy{Tenant: "acme", Scope: ScopeGlobal, TTL: 7 * day}),
+			series:   Series{Tenant: "acme", SourceType: "statsd", MetricName: "up"},
+			want:     7 * day,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Resolve(tt.policies, tt.series); got != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "global", policy: Policy{Scope: ScopeGlobal, TTL: day}},
+		{name: "source id", policy: Policy{Scope: ScopeSourceID, Match: "payments", TTL: 30 * day}},
+		{name: "global with match", policy: Policy{Scope: ScopeGlobal, Match: "x", TTL: day}, wantErr: true},
+		{name: "missing match", policy: Policy{Scope: ScopeMetricName, TTL: day}, wantErr: true},
+		{name: "unknown scope", policy: Policy{Scope: "tenant", Match: "x", TTL: day}, wantErr: true},
+		{name: "zero TTL", policy: Policy{Scope: ScopeGlobal}, wantErr: true},
+		{name: "TTL above the Cassandra limit", policy: Policy{Scope: ScopeGlobal, TTL: MaxTTL + day}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type fakeStore struct {
+	policies []Policy
+	loads    int
+	sweeps   int
+	samples  map[Series][]time.Time
+}
+
+func (f *fakeStore) ListRetentionPolicies(ctx context.Context) ([]Policy, error) {
+	f.loads++
+	return f.policies, nil
+}
+
+func (f *fakeStore) SweepExpired(ctx context.Context, expired func(series Series, timestamp time.Time) bool) (int, error) {
+	f.sweeps++
+	deleted := 0
+	for series, timestamps := range f.samples {
+		var kept []time.Time
+		for _, timestamp := range timestamps {
+			if expired(series, timestamp) {
+				deleted++
+				continue
+			}
+			kept = append(kept, timestamp)
+		}
+		f.samples[series] = kept
+	}
+	return deleted, nil
+}
+
+func TestResolver_Cache(t *testing.T) {
+	store := &fakeStore{policies: []Policy{{Scope: ScopeGlobal, TTL: day}}}
+	r := NewResolver(store)
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ttl, err := r.TTL(context.Background(), Series{}); err != nil || ttl != day {
+			t.Fatalf("TTL() = %v, %v, want %v", ttl, err, day)
+		}
+	}
+	if store.loads != 1 {
+		t.Errorf("policies loaded %d times, want 1", store.loads)
+	}
+
+	r.Invalidate()
+	store.policies = nil
+	if ttl, _ := r.TTL(context.Background(), Series{}); ttl != 0 {
+		t.Errorf("TTL() after Invalidate() = %v, want 0", ttl)
+	}
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	payments := Series{SourceType: "otlp", SourceID: "payments", MetricName: "up"}
+	api := Series{SourceType: "otlp", SourceID: "api", MetricName: "up"}
+	acmePayments := Series{Tenant: "acme", SourceType: "otlp", SourceID: "payments", MetricName: "up"}
+	store := &fakeStore{
+		policies: []Policy{{Scope: ScopeSourceID, Match: "payments", TTL: 30 * day}},
+		samples: map[Series][]time.Time{
+			payments:     {now.Add(-40 * day), now.Add(-10 * day)},
+			api:          {now.Add(-40 * day)},
+			acmePayments: {now.Add(-40 * day)},
+		},
+	}
+
+	s := NewSweeper(NewResolver(store), store, time.Hour)
+	s.now = func() time.Time { return now }
+
+	deleted, err := s.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	// The policy of the default tenant does not apply to the samples of acme
+	if deleted != 1 || len(store.samples[payments]) != 1 || len(store.samples[api]) != 1 || len(store.samples[acmePayments]) != 1 {
+		t.Errorf("Sweep() deleted %d, left %v", deleted, store.samples)
+	}
+}
+
+func TestSweeper_Sweep_NoPolicies(t *testing.T) {
+	store := &fakeStore{samples: map[Series][]time.Time{{MetricName: "up"}: {time.Unix(0, 0)}}}
+	s := NewSweeper(NewResolver(store), store, time.Hour)
+
+	// The store is swept for the series whose samples expired through their TTL
+	deleted, err := s.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if store.sweeps != 1 || deleted != 0 {
+		t.Errorf("Sweep() swept the store %d times and deleted %d, want 1 and 0", store.sweeps, deleted)
+	}
+}
@@ -0,0 +1,72 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const defaultSweepInterval = time.Hour
+
+// SweepStore deletes the stored samples and rollups of every tenant for which
+// expired returns true, and the series left without samples. It is
+// implemented by the Cassandra repository.
+type SweepStore interface {
+	SweepExpired(ctx context.Context, expired func(series Series, timestamp time.Time) bool) (int, error)
+}
+
+// Sweeper deletes samples written before a policy shortened their retention.
+// The TTL of a sample is fixed when it is inserted, so a new or shortened
+// policy only takes effect for existing samples through the sweeper.
+type Sweeper struct {
+	resolver *Resolver
+	store    SweepStore
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewSweeper creates a new Sweeper that runs every interval
+func NewSweeper(resolver *Resolver, store SweepStore, interval time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	return &Sweeper{resolver: resolver, store: store, interval: interval, now: time.Now}
+}
+
+// Run sweeps every interval until ctx is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.Sweep(ctx)
+			if err != nil {
+				log.Printf("Error sweeping expired samples: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Swept %d expired samples", deleted)
+			}
+		}
+	}
+}
+
+// Sweep deletes the samples older than the TTL of their policy and returns how many were deleted
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	policies, err := s.resolver.Policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without policies no sample expires, the store is still swept to drop
+	// the series whose samples expired through their TTL
+	now := s.now()
+	return s.store.SweepExpired(ctx, func(series Series, timestamp time.Time) bool {
+		ttl := Resolve(policies, series)
+		return ttl > 0 && now.Sub(timestamp) > ttl
+	})
+}
@@ -0,0 +1,151 @@
+package rollup
+
+import (
+	"sort"
+	"time"
+)
+
+// Resolution is the interval of a rollup table
+type Resolution struct {
+	Name string // Suffix of the table, e.g. 1m for rollups_1m
+	Step time.Duration
+}
+
+// Resolutions are the rollup resolutions from finest to coarsest. Each is
+// built from the one before it, the first from the raw samples.
+var Resolutions = []Resolution{
+	{Name: "1m", Step: time.Minute},
+	{Name: "5m", Step: 5 * time.Minute},
+	{Name: "1h", Step: time.Hour},
+}
+
+// PickResolution returns the coarsest resolution whose step does not exceed
+// step, or false if raw samples must be read
+func PickResolution(step time.Duration) (Resolution, bool) {
+	for i := len(Resolutions) - 1; i >= 0; i-- {
+		if Resolutions[i].Step <= step {
+			return Resolutions[i], true
+		}
+	}
+	return Resolution{}, false
+}
+
+// Sample is a raw sample of a series, the labels are the JSON stored with it
+type Sample struct {
+	MetricName string
+	Labels     string
+	Timestamp  time.Time
+	Value      float64
+}
+
+// Aggregate summarizes the samples of a series in the interval starting at Timestamp
+type Aggregate struct {
+	MetricName string
+	Labels     string
+	Timestamp  time.Time
+	Min        float64
+	Max        float64
+	Sum        float64
+	Count      int64
+	Last       float64
+
+	lastAt time.Time // Timestamp of the sample Last was taken from
+}
+
+// Value returns the aggregate selected by name: min, max, sum, count, last or avg
+func (a *Aggregate) Value(name string) (float64, bool) {
+	switch name {
+	case "min":
+		return a.Min, true
+	case "max":
+		return a.Max, true
+	case "sum":
+		return a.Sum, true
+	case "count":
+		return float64(a.Count), true
+	case "last":
+		return a.Last, true
+	case "", "avg":
+		return a.Sum / float64(a.Count), true
+	default:
+		return 0, false
+	}
+}
+
+func (a *Aggregate) merge(b Aggregate) {
+	if b.Min < a.Min {
+		a.Min = b.Min
+	}
+	if b.Max > a.Max {
+		a.Max = b.Max
+	}
+	a.Sum += b.Sum
+	a.Count += b.Count
+	if !b.lastAt.Before(a.lastAt) {
+		a.Last, a.lastAt = b.Last, b.lastAt
+	}
+}
+
+// AggregateSamples rolls raw samples up into intervals of step
+func AggregateSamples(samples []Sample, step time.Duration) []Aggregate {
+	aggs := make([]Aggregate, len(samples))
+	for i, s := range samples {
+		aggs[i] = Aggregate{
+			MetricName: s.MetricName,
+			Labels:     s.Labels,
+			Timestamp:  s.Timestamp,
+			Min:        s.Value,
+			Max:        s.Value,
+			Sum:        s.Value,
+			Count:      1,
+			Last:       s.Value,
+			lastAt:     s.Timestamp,
+		}
+	}
+	return mergeAggregates(aggs, step)
+}
+
+// MergeAggregates rolls the aggregates of a finer resolution up into intervals of step
+func MergeAggregates(aggs []Aggregate, step time.Duration) []Aggregate {
+	finer := make([]Aggregate, len(aggs))
+	for i, a := range aggs {
+		a.lastAt = a.Timestamp
+		finer[i] = a
+	}
+	return mergeAggregates(finer, step)
+}
+
+// mergeAggregates merges aggs by series and interval, ordered by series and timestamp
+func mergeAggregates(aggs []Aggregate, step time.Duration) []Aggregate {
+	type key struct {
+		metricName string
+		labels     string
+		interval   int64
+	}
+	index := make(map[key]int)
+
+	var result []Aggregate
+	for _, a := range aggs {
+		start := a.Timestamp.Truncate(step)
+		k := key{a.MetricName, a.Labels, start.UnixMilli()}
+		if i, ok := index[k]; ok {
+			result[i].merge(a)
+			continue
+		}
+
+		a.Timestamp = start
+		index[k] = len(result)
+		result = append(result, a)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MetricName != result[j].MetricName {
+			return result[i].MetricName < result[j].MetricName
+		}
+		if result[i].Labels != result[j].Labels {
+			return result[i].Labels < result[j].Labels
+		}
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
+}
@@ -0,0 +1,80 @@
+package rollup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPickResolution(t *testing.T) {
+	tests := []struct {
+		step    time.Duration
+		want    string
+		wantRaw bool
+	}{
+		{step: 15 * time.Second, wantRaw: true},
+		{step: time.Minute, want: "1m"},
+		{step: 4 * time.Minute, want: "1m"},
+		{step: 10 * time.Minute, want: "5m"},
+		{step: 24 * time.Hour, want: "1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step.String(), func(t *testing.T) {
+			got, ok := PickResolution(tt.step)
+			if ok == tt.wantRaw || got.Name != tt.want {
+				t.Errorf("PickResolution() = %v, %v, want %q, raw %v", got, ok, tt.want, tt.wantRaw)
+			}
+		})
+	}
+}
+
+func TestAggregateSamples(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: base.Add(50 * time.Second), Value: 4},
+		{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: base.Add(10 * time.Second), Value: 2},
+		{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: base.Add(30 * time.Second), Value: 9},
+		{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: base.Add(70 * time.Second), Value: 1},
+		{MetricName: "cpu", Labels: `{"host":"b"}`, Timestamp: base.Add(20 * time.Second), Value: 5},
+	}
+
+	got := formatAggregates(AggregateSamples(samples, time.Minute))
+	want := []string{
+		`cpu{"host":"a"} 12:00 min=2 max=9 sum=15 count=3 last=4`,
+		`cpu{"host":"a"} 12:01 min=1 max=1 sum=1 count=1 last=1`,
+		`cpu{"host":"b"} 12:00 min=5 max=5 sum=5 count=1 last=5`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("AggregateSamples() =\n%v\nwant\n%v", got, want)
+	}
+
+	got = formatAggregates(MergeAggregates(AggregateSamples(samples, time.Minute), 5*time.Minute))
+	want = []string{
+		`cpu{"host":"a"} 12:00 min=1 max=9 sum=16 count=4 last=1`,
+		`cpu{"host":"b"} 12:00 min=5 max=5 sum=5 count=1 last=5`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("MergeAggregates() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestAggregate_Value(t *testing.T) {
+	a := Aggregate{Min: 1, Max: 9, Sum: 12, Count: 3, Last: 2}
+	for name, want := range map[string]float64{"": 4, "avg": 4, "min": 1, "max": 9, "sum": 12, "count": 3, "last": 2} {
+		if got, ok := a.Value(name); !ok || got != want {
+			t.Errorf("Value(%q) = %v, %v, want %v", name, got, ok, want)
+		}
+	}
+	if _, ok := a.Value("median"); ok {
+		t.Errorf("Value(median) ok, want unknown aggregate")
+	}
+}
+
+func formatAggregates(aggs []Aggregate) []string {
+	var lines []string
+	for _, a := range aggs {
+		lines = append(lines, fmt.Sprintf("%s%s %s min=%g max=%g sum=%g count=%d last=%g",
+			a.MetricName, a.Labels, a.Timestamp.Format("15:04"), a.Min, a.Max, a.Sum, a.Count, a.Last))
+	}
+	return lines
+}
@@ -0,0 +1,145 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultInterval = time.Minute
+	defaultDelay    = time.Minute
+	defaultBackfill = 24 * time.Hour
+
+	// batchIntervals is the number of intervals rolled up between checkpoints
+	batchIntervals = 60
+)
+
+// Store reads raw samples and reads and writes rollups, it is implemented by
+// the Cassandra repository. An empty metric name reads every metric.
+type Store interface {
+	ReadRawSamples(ctx context.Context, metricName string, start, end time.Time) ([]Sample, error)
+	ReadRollups(ctx context.Context, res Resolution, metricName string, start, end time.Time) ([]Aggregate, error)
+	WriteRollups(ctx context.Context, res Resolution, aggs []Aggregate) error
+	RollupCheckpoint(ctx context.Context, res Resolution) (time.Time, error)
+	SetRollupCheckpoint(ctx context.Context, res Resolution, checkpoint time.Time) error
+}
+
+// Config represents the rollup worker configuration
+type Config struct {
+	Interval time.Duration // How often rollups run
+	Delay    time.Duration // How long to wait for late samples before an interval is rolled up
+	Backfill time.Duration // How far back the first run of a resolution starts without a checkpoint
+}
+
+// Worker periodically rolls raw samples up into the rollup tables. The end of
+// the last rolled up interval is checkpointed per resolution so that a
+// restarted worker continues where it stopped.
+type Worker struct {
+	cfg   Config
+	store Store
+}
+
+// NewWorker creates a new rollup Worker
+func NewWorker(cfg Config, store Store) *Worker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = defaultDelay
+	}
+	if cfg.Backfill <= 0 {
+		cfg.Backfill = defaultBackfill
+	}
+	return &Worker{cfg: cfg, store: store}
+}
+
+// Run rolls up every interval until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.RunOnce(ctx, time.Now()); err != nil {
+			log.Printf("Error rolling up samples: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rolls up every resolution up to the last complete interval before now
+func (w *Worker) RunOnce(ctx context.Context, now time.Time) error {
+	ready := now.Add(-w.cfg.Delay)
+	for i, res := range Resolutions {
+		// Coarser resolutions only roll up intervals that are complete in their source
+		end := ready.Truncate(res.Step)
+		if i > 0 {
+			sourceCheckpoint, err := w.store.RollupCheckpoint(ctx, Resolutions[i-1])
+			if err != nil {
+				return fmt.Errorf("failed to read %s checkpoint: %w", Resolutions[i-1].Name, err)
+			}
+			if sourceCheckpoint.IsZero() {
+				continue
+			}
+			if sourceEnd := sourceCheckpoint.Truncate(res.Step); sourceEnd.Before(end) {
+				end = sourceEnd
+			}
+		}
+
+		if err := w.rollup(ctx, i, end); err != nil {
+			return fmt.Errorf("failed to roll up %s: %w", res.Name, err)
+		}
+	}
+	return nil
+}
+
+// rollup rolls up the intervals of Resolutions[i] from its checkpoint to end
+func (w *Worker) rollup(ctx context.Context, i int, end time.Time) error {
+	res := Resolutions[i]
+
+	checkpoint, err := w.store.RollupCheckpoint(ctx, res)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if checkpoint.IsZero() {
+		checkpoint = end.Add(-w.cfg.Backfill).Truncate(res.Step)
+	}
+
+	for checkpoint.Before(end) {
+		batchEnd := checkpoint.Add(batchIntervals * res.Step)
+		if batchEnd.After(end) {
+			batchEnd = end
+		}
+
+		var aggs []Aggregate
+		if i == 0 {
+			samples, err := w.store.ReadRawSamples(ctx, "", checkpoint, batchEnd)
+			if err != nil {
+				return err
+			}
+			aggs = AggregateSamples(samples, res.Step)
+		} else {
+			finer, err := w.store.ReadRollups(ctx, Resolutions[i-1], "", checkpoint, batchEnd)
+			if err != nil {
+				return err
+			}
+			aggs = MergeAggregates(finer, res.Step)
+		}
+
+		if err := w.store.WriteRollups(ctx, res, aggs); err != nil {
+			return err
+		}
+		if err := w.store.SetRollupCheckpoint(ctx, res, batchEnd); err != nil {
+			return fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+		checkpoint = batchEnd
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	samples     []Sample
+	rollups     map[string][]Aggregate
+	checkpoints map[string]time.Time
+}
+
+func newFakeStore(samples []Sample) *fakeStore {
+	return &fakeStore{samples: samples, rollups: make(map[string][]Aggregate), checkpoints: make(map[string]time.Time)}
+}
+
+func (f *fakeStore) ReadRawSamples(ctx context.Context, metricName string, start, end time.Time) ([]Sample, error) {
+	var result []Sample
+	for _, s := range f.samples {
+		if !s.Timestamp.Before(start) && s.Timestamp.Before(end) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeStore) ReadRollups(ctx context.Context, res Resolution, metricName string, start, end time.Time) ([]Aggregate, error) {
+	var result []Aggregate
+	for _, a := range f.rollups[res.Name] {
+		if !a.Timestamp.Before(start) && a.Timestamp.Before(end) {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeStore) WriteRollups(ctx context.Context, res Resolution, aggs []Aggregate) error {
+	f.rollups[res.Name] = append(f.rollups[res.Name], aggs...)
+	return nil
+}
+
+func (f *fakeStore) RollupCheckpoint(ctx context.Context, res Resolution) (time.Time, error) {
+	return f.checkpoints[res.Name], nil
+}
+
+func (f *fakeStore) SetRollupCheckpoint(ctx context.Context, res Resolution, checkpoint time.Time) error {
+	f.checkpoints[res.Name] = checkpoint
+	return nil
+}
+
+func TestWorker_RunOnce(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i := 0; i < 12; i++ {
+		samples = append(samples, Sample{MetricName: "cpu", Labels: `{}`, Timestamp: base.Add(time.Duration(i) * 30 * time.Second), Value: float64(i)})
+	}
+	store := newFakeStore(samples)
+	w := NewWorker(Config{Delay: time.Minute, Backfill: time.Hour}, store)
+
+	// At 12:06:30 the intervals up to 12:05 are complete
+	if err := w.RunOnce(context.Background(), base.Add(6*time.Minute+30*time.Second)); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	got := fmt.Sprint(formatAggregates(store.rollups["1m"]))
+	want := fmt.Sprint([]string{
+		"cpu{} 12:00 min=0 max=1 sum=1 count=2 last=1",
+		"cpu{} 12:01 min=2 max=3 sum=5 count=2 last=3",
+		"cpu{} 12:02 min=4 max=5 sum=9 count=2 last=5",
+		"cpu{} 12:03 min=6 max=7 sum=13 count=2 last=7",
+		"cpu{} 12:04 min=8 max=9 sum=17 count=2 last=9",
+	})
+	if got != want {
+		t.Errorf("1m rollups =\n%v\nwant\n%v", got, want)
+	}
+
+	got = fmt.Sprint(formatAggregates(store.rollups["5m"]))
+	want = fmt.Sprint([]string{"cpu{} 12:00 min=0 max=9 sum=45 count=10 last=9"})
+	if got != want {
+		t.Errorf("5m rollups =\n%v\nwant\n%v", got, want)
+	}
+	if len(store.rollups["1h"]) != 0 {
+		t.Errorf("1h rollups = %v, want none before the hour is complete", store.rollups["1h"])
+	}
+	if !store.checkpoints["1m"].Equal(base.Add(5 * time.Minute)) {
+		t.Errorf("1m checkpoint = %v, want %v", store.checkpoints["1m"], base.Add(5*time.Minute))
+	}
+
+	// The next run continues from the checkpoint without rolling up intervals twice
+	if err := w.RunOnce(context.Background(), base.Add(7*time.Minute)); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if n := len(store.rollups["1m"]); n != 6 {
+		t.Errorf("1m rollups after second run = %d, want 6", n)
+	}
+}
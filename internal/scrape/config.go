@@ -0,0 +1,101 @@
+package scrape
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/yay14/pulse/internal/relabel"
+)
+
+const (
+	defaultScrapeInterval = time.Minute
+	defaultScrapeTimeout  = 10 * time.Second
+)
+
+// Duration is a time.Duration that is written as a string such as "15s" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Config represents the scrape configuration
+type Config struct {
+	ScrapeInterval Duration       `json:"scrape_interval"`
+	ScrapeTimeout  Duration       `json:"scrape_timeout"`
+	Targets        []TargetConfig `json:"targets"`
+}
+
+// TargetConfig describes a single endpoint exposing metrics in the Prometheus text format
+type TargetConfig struct {
+	URL            string            `json:"url"`
+	SourceID       string            `json:"source_id"` // Free-form source, such as the job name, stored as text
+	SourceType     string            `json:"source_type"`
+	Interval       Duration          `json:"interval"`
+	Timeout        Duration          `json:"timeout"`
+	Labels         map[string]string `json:"labels"`
+	RelabelConfigs []relabel.Config  `json:"metric_relabel_configs"`
+}
+
+// LoadConfig reads a JSON scrape configuration from path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scrape config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse scrape config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// validate checks the targets and applies the global interval and timeout defaults
+func (c *Config) validate() error {
+	if c.ScrapeInterval <= 0 {
+		c.ScrapeInterval = Duration(defaultScrapeInterval)
+	}
+	if c.ScrapeTimeout <= 0 {
+		c.ScrapeTimeout = Duration(defaultScrapeTimeout)
+	}
+
+	for i := range c.Targets {
+		target := &c.Targets[i]
+		if _, err := url.ParseRequestURI(target.URL); err != nil {
+			return fmt.Errorf("target %d has an invalid url: %w", i, err)
+		}
+		if target.SourceID == "" {
+			return fmt.Errorf("target %s has no source_id", target.URL)
+		}
+		if target.Interval <= 0 {
+			target.Interval = c.ScrapeInterval
+		}
+		if target.Timeout <= 0 {
+			target.Timeout = c.ScrapeTimeout
+		}
+		if target.Timeout > target.Interval {
+			target.Timeout = target.Interval
+		}
+		if _, err := relabel.Compile(target.RelabelConfigs); err != nil {
+			return fmt.Errorf("target %s: %w", target.URL, err)
+		}
+	}
+
+	return nil
+}
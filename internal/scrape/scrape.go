@@ -0,0 +1,237 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/metrics"
+)
+
+// acceptHeader prefers OpenMetrics and falls back to the Prometheus text format
+const acceptHeader = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+
+// Ingester receives scraped samples, it is implemented by the ingestion service
+type Ingester interface {
+	IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error)
+}
+
+// MetricsWriter receives scraped samples, it is implemented by the metrics service
+type MetricsWriter interface {
+	WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error)
+}
+
+// Scraper periodically pulls Prometheus endpoints and pushes their samples
+// through the ingestion and WriteMetrics paths
+type Scraper struct {
+	targets  []*target
+	ingester Ingester
+	writer   MetricsWriter
+	client   *http.Client
+}
+
+// target is a validated target with its compiled relabel rules
+type target struct {
+	TargetConfig
+	instance string
+	rules    []*relabel.Rule
+}
+
+// sample is a scraped sample, the metric name is held in the __name__ label
+type sample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// NewScraper validates cfg and creates a Scraper. Either sink may be nil.
+func NewScraper(cfg *Config, ingester Ingester, writer MetricsWriter) (*Scraper, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Scraper{ingester: ingester, writer: writer, client: &http.Client{}}
+	for _, targetConfig := range cfg.Targets {
+		rules, err := relabel.Compile(targetConfig.RelabelConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", targetConfig.URL, err)
+		}
+		u, err := url.Parse(targetConfig.URL)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", targetConfig.URL, err)
+		}
+
+		s.targets = append(s.targets, &target{TargetConfig: targetConfig, instance: u.Host, rules: rules})
+	}
+
+	return s, nil
+}
+
+// Run scrapes every target on its interval until ctx is cancelled
+func (s *Scraper) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			s.loop(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (s *Scraper) loop(ctx context.Context, t *target) {
+	log.Printf("Scraping %s every %s", t.URL, time.Duration(t.Interval))
+
+	ticker := time.NewTicker(time.Duration(t.Interval))
+	defer ticker.Stop()
+
+	for {
+		if err := s.scrape(ctx, t); err != nil {
+			log.Printf("Failed to push samples scraped from %s: %v", t.URL, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape fetches a target once and pushes its samples together with the
+// up and scrape_duration_seconds health series
+func (s *Scraper) scrape(ctx context.Context, t *target) error {
+	start := time.Now()
+	samples, err := s.fetch(ctx, t, start)
+	duration := time.Since(start)
+
+	up := 1.0
+	if err != nil {
+		log.Printf("Failed to scrape %s: %v", t.URL, err)
+		samples, up = nil, 0
+	}
+
+	timestamp := start.UnixMilli()
+	samples = append(samples,
+		sample{labels: t.targetLabels("up"), value: up, timestamp: timestamp},
+		sample{labels: t.targetLabels("scrape_duration_seconds"), value: duration.Seconds(), timestamp: timestamp},
+		sample{labels: t.targetLabels("scrape_samples_scraped"), value: float64(len(samples)), timestamp: timestamp},
+	)
+
+	return s.push(ctx, t, samples)
+}
+
+// fetch pulls the target's endpoint and returns its relabeled samples
+func (s *Scraper) fetch(ctx context.Context, t *target, start time.Time) ([]sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.Timeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	openMetrics := mediaType == "application/openmetrics-text"
+
+	var samples []sample
+	err = format.ParseText(resp.Body, openMetrics, func(parsed format.Sample) error {
+		parsed.Labels["__name__"] = parsed.Name
+		labels, keep := relabel.Process(parsed.Labels, t.rules)
+		if !keep || labels["__name__"] == "" {
+			return nil
+		}
+
+		// Target labels take precedence over the labels exposed by the target
+		for key, value := range t.targetLabels("") {
+			if key != "__name__" {
+				labels[key] = value
+			}
+		}
+
+		timestamp := parsed.Timestamp
+		if timestamp == 0 {
+			timestamp = start.UnixMilli()
+		}
+		samples = append(samples, sample{labels: labels, value: parsed.Value, timestamp: timestamp})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// targetLabels returns the configured labels of the target plus instance, named name
+func (t *target) targetLabels(name string) map[string]string {
+	labels := map[string]string{"__name__": name, "instance": t.instance}
+	for key, value := range t.Labels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// push sends the samples to every configured sink. NaN and infinite values,
+// such as the quantiles of an empty summary, are kept, the metrics service
+// encodes them the way VictoriaMetrics imports them.
+func (s *Scraper) push(ctx context.Context, t *target, samples []sample) error {
+	var errs []error
+
+	if s.ingester != nil {
+		req := &ingestion.IngestDataRequest{SourceId: t.SourceID, SourceType: t.SourceType}
+		for _, smpl := range samples {
+			labels := make(map[string]string, len(smpl.labels))
+			for key, value := range smpl.labels {
+				if key != "__name__" {
+					labels[key] = value
+				}
+			}
+			req.Metrics = append(req.Metrics, &ingestion.MetricData{
+				Name:      smpl.labels["__name__"],
+				Labels:    labels,
+				Value:     smpl.value,
+				Timestamp: smpl.timestamp,
+			})
+		}
+		if _, err := s.ingester.IngestData(ctx, req); err != nil {
+			errs = append(errs, fmt.Errorf("ingestion: %w", err))
+		}
+	}
+
+	if s.writer != nil {
+		req := &metrics.WriteRequest{SourceId: t.SourceID, SourceType: t.SourceType}
+		for _, smpl := range samples {
+			req.Timeseries = append(req.Timeseries, &metrics.Timeseries{
+				Labels:  smpl.labels,
+				Samples: []*metrics.Sample{{Value: smpl.value, Timestamp: smpl.timestamp}},
+			})
+		}
+		if _, err := s.writer.WriteMetrics(ctx, req); err != nil {
+			errs = append(errs, fmt.Errorf("write metrics: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,215 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeSink struct {
+	ingested []*ingestion.IngestDataRequest
+	written  []*metrics.WriteRequest
+}
+
+func (f *fakeSink) IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
+	f.ingested = append(f.ingested, req)
+	return &ingestion.IngestDataResponse{}, nil
+}
+
+func (f *fakeSink) WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	f.written = append(f.written, req)
+	return &metrics.WriteResponse{}, nil
+}
+
+func TestScraper_scrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			fmt.Fprint(w, `# TYPE http_requests_total counter
+http_requests_total{code="200",instance="spoofed"} 10
+debug_goroutines 42
+`)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name      string
+		target    TargetConfig
+		wantUp    float64
+		wantNames []string
+	}{
+		{
+			name: "healthy target with relabeling",
+			target: TargetConfig{
+				URL:        server.URL + "/metrics",
+				SourceID:   "checkout",
+				SourceType: "app",
+				Labels:     map[string]string{"env": "prod"},
+				RelabelConfigs: []relabel.Config{
+					{SourceLabels: []string{"__name__"}, Regex: "debug_.*", Action: relabel.Drop},
+				},
+			},
+			wantUp:    1,
+			wantNames: []string{"http_requests_total", "up", "scrape_duration_seconds", "scrape_samples_scraped"},
+		},
+		{
+			name:      "missing endpoint",
+			target:    TargetConfig{URL: server.URL + "/missing", SourceID: "checkout"},
+			wantUp:    0,
+			wantNames: []string{"up", "scrape_duration_seconds", "scrape_samples_scraped"},
+		},
+		{
+			name:      "timeout",
+			target:    TargetConfig{URL: server.URL + "/slow", SourceID: "checkout", Timeout: Duration(50 * time.Millisecond)},
+			wantUp:    0,
+			wantNames: []string{"up", "scrape_duration_seconds", "scrape_samples_scraped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeSink{}
+			scraper, err := NewScraper(&Config{Targets: []TargetConfig{tt.target}}, sink, sink)
+			if err != nil {
+				t.Fatalf("NewScraper() error = %v", err)
+			}
+			if err := scraper.scrape(context.Background(), scraper.targets[0]); err != nil {
+				t.Fatalf("Scraper.scrape() error = %v", err)
+			}
+
+			if len(sink.ingested) != 1 || len(sink.written) != 1 {
+				t.Fatalf("Scraper.scrape() pushed %d ingest and %d write requests, want 1 each", len(sink.ingested), len(sink.written))
+			}
+
+			req := sink.ingested[0]
+			if req.SourceId != tt.target.SourceID || req.SourceType != tt.target.SourceType {
+				t.Errorf("source = %s/%s, want %s/%s", req.SourceId, req.SourceType, tt.target.SourceID, tt.target.SourceType)
+			}
+			if len(req.Metrics) != len(tt.wantNames) {
+				t.Fatalf("ingested %d metrics, want %d: %v", len(req.Metrics), len(tt.wantNames), req.Metrics)
+			}
+			for i, metric := range req.Metrics {
+				if metric.Name != tt.wantNames[i] {
+					t.Errorf("metric %d = %s, want %s", i, metric.Name, tt.wantNames[i])
+				}
+				if metric.Labels["instance"] != host {
+					t.Errorf("metric %s instance = %q, want %q", metric.Name, metric.Labels["instance"], host)
+				}
+				for key, value := range tt.target.Labels {
+					if metric.Labels[key] != value {
+						t.Errorf("metric %s label %s = %q, want %q", metric.Name, key, metric.Labels[key], value)
+					}
+				}
+				if metric.Name == "up" && metric.Value != tt.wantUp {
+					t.Errorf("up = %v, want %v", metric.Value, tt.wantUp)
+				}
+			}
+
+			for i, ts := range sink.written[0].Timeseries {
+				if ts.Labels["__name__"] != tt.wantNames[i] {
+					t.Errorf("timeseries %d = %s, want %s", i, ts.Labels["__name__"], tt.wantNames[i])
+				}
+			}
+		})
+	}
+}
+
+// jsonLineWriter encodes the written series as JSON lines like the metrics
+// service does for VictoriaMetrics
+type jsonLineWriter struct {
+	lines []string
+}
+
+func (w *jsonLineWriter) WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	for _, ts := range req.Timeseries {
+		line, err := format.MarshalJSONLine(ts)
+		if err != nil {
+			return nil, err
+		}
+		w.lines = append(w.lines, string(line))
+	}
+	return &metrics.WriteResponse{}, nil
+}
+
+func TestScraper_scrape_NaN(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, `# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.99"} NaN
+rpc_duration_seconds_sum 0
+rpc_duration_seconds_count 0
+`)
+	}))
+	defer server.Close()
+
+	sink, writer := &fakeSink{}, &jsonLineWriter{}
+	scraper, err := NewScraper(&Config{Targets: []TargetConfig{{URL: server.URL, SourceID: "checkout"}}}, sink, writer)
+	if err != nil {
+		t.Fatalf("NewScraper() error = %v", err)
+	}
+	if err := scraper.scrape(context.Background(), scraper.targets[0]); err != nil {
+		t.Fatalf("Scraper.scrape() error = %v", err)
+	}
+
+	if len(sink.ingested) != 1 || !math.IsNaN(sink.ingested[0].Metrics[0].Value) {
+		t.Fatalf("Scraper.scrape() ingested %v, want the NaN quantile first", sink.ingested)
+	}
+	if len(writer.lines) == 0 || !strings.Contains(writer.lines[0], `"values":[null]`) {
+		t.Errorf("Scraper.scrape() wrote %v, want the NaN quantile as null", writer.lines)
+	}
+}
+
+func TestNewScraper_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target TargetConfig
+	}{
+		{name: "invalid url", target: TargetConfig{URL: "::", SourceID: "a"}},
+		{name: "missing source", target: TargetConfig{URL: "http://localhost/metrics"}},
+		{name: "invalid relabel rule", target: TargetConfig{URL: "http://localhost/metrics", SourceID: "a", RelabelConfigs: []relabel.Config{{Action: "rename"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewScraper(&Config{Targets: []TargetConfig{tt.target}}, nil, nil); err == nil {
+				t.Errorf("NewScraper() expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrape.json")
+	config := `{"scrape_interval": "30s", "targets": [{"url": "http://node:9100/metrics", "source_id": "node-exporter", "source_type": "node"}]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if _, err := NewScraper(cfg, nil, nil); err != nil {
+		t.Fatalf("NewScraper() error = %v", err)
+	}
+	if target := cfg.Targets[0]; target.SourceID != "node-exporter" || target.Interval != Duration(30*time.Second) {
+		t.Errorf("LoadConfig() target = %+v, want source node-exporter scraped every 30s", target)
+	}
+}
@@ -0,0 +1,67 @@
+package ingestion
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/cardinality"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// WithCardinalityTracker tracks the active series of every source and
+// rejects new series past the limits of the tracker
+func WithCardinalityTracker(tracker *cardinality.Tracker) Option {
+	return func(s *IngestionService) {
+		s.cardinality = tracker
+	}
+}
+
+// admitSeries removes the metrics of new series past the cardinality limits
+// from a batch in place and returns the error of the last rejected series
+func (s *IngestionService) admitSeries(req *ingestion.IngestDataRequest) (rejected int, err error) {
+	if s.cardinality == nil {
+		return 0, nil
+	}
+
+	admitted := req.Metrics[:0]
+	for _, metric := range req.Metrics {
+		if admitErr := s.cardinality.Admit(req.TenantId, req.SourceId, metric.Name, metric.Labels); admitErr != nil {
+			rejected++
+			err = admitErr
+			continue
+		}
+		admitted = append(admitted, metric)
+	}
+	req.Metrics = admitted
+	return rejected, err
+}
+
+// GetCardinalityReport lists the sources, metrics and labels of the tenant
+// of the request with the most active series
+func (s *IngestionService) GetCardinalityReport(ctx context.Context, req *ingestion.GetCardinalityReportRequest) (*ingestion.GetCardinalityReportResponse, error) {
+	if s.cardinality == nil {
+		return nil, status.Error(codes.FailedPrecondition, "cardinality tracking is not enabled")
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+
+	report := s.cardinality.Report(tenant.FromContext(ctx), req.SourceId, int(req.Limit))
+	log.Printf("Cardinality report for source %q: %d active series", req.SourceId, report.TotalSeries)
+
+	resp := &ingestion.GetCardinalityReportResponse{TotalSeries: int64(report.TotalSeries)}
+	for _, source := range report.Sources {
+		resp.Sources = append(resp.Sources, &ingestion.SourceCardinality{SourceId: source.SourceID, Series: int64(source.Series), Limit: int64(source.Limit)})
+	}
+	for _, metric := range report.Metrics {
+		resp.Metrics = append(resp.Metrics, &ingestion.MetricCardinality{MetricName: metric.MetricName, Series: int64(metric.Series)})
+	}
+	for _, label := range report.Labels {
+		resp.Labels = append(resp.Labels, &ingestion.LabelCardinality{LabelName: label.LabelName, Series: int64(label.Series), Values: int64(label.Values)})
+	}
+	return resp, nil
+}
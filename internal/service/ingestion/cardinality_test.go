@@ -0,0 +1,65 @@
+package ingestion
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/cardinality"
+)
+
+func TestIngestionService_admitSeries(t *testing.T) {
+	s := &IngestionService{cardinality: cardinality.NewTracker(cardinality.Limits{MaxSeriesPerSource: 2})}
+
+	req := &ingestion.IngestDataRequest{SourceId: "api", Metrics: []*ingestion.MetricData{
+		{Name: "up", Labels: map[string]string{"pod": "a"}},
+		{Name: "up", Labels: map[string]string{"pod": "b"}},
+		{Name: "up", Labels: map[string]string{"pod": "c"}},
+	}}
+	rejected, err := s.admitSeries(req)
+	if rejected != 1 || err == nil {
+		t.Errorf("admitSeries() = %d, %v, want 1 rejected series", rejected, err)
+	}
+	if len(req.Metrics) != 2 || req.Metrics[1].Labels["pod"] != "b" {
+		t.Errorf("admitSeries() kept %v, want pods a and b", req.Metrics)
+	}
+
+	resp, err := s.GetCardinalityReport(context.Background(), &ingestion.GetCardinalityReportRequest{SourceId: "api"})
+	if err != nil {
+		t.Fatalf("GetCardinalityReport() error = %v", err)
+	}
+	if resp.TotalSeries != 2 || len(resp.Sources) != 1 || resp.Sources[0].Limit != 2 {
+		t.Errorf("GetCardinalityReport() = %v, want 2 series of api limited to 2", resp)
+	}
+	if len(resp.Labels) != 1 || resp.Labels[0].LabelName != "pod" || resp.Labels[0].Values != 2 {
+		t.Errorf("GetCardinalityReport() labels = %v, want pod with 2 values", resp.Labels)
+	}
+}
+
+func TestIngestionService_GetCardinalityReport_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *IngestionService
+		req  *ingestion.GetCardinalityReportRequest
+		want codes.Code
+	}{
+		{name: "tracking disabled", s: &IngestionService{}, req: &ingestion.GetCardinalityReportRequest{}, want: codes.FailedPrecondition},
+		{
+			name: "negative limit",
+			s:    &IngestionService{cardinality: cardinality.NewTracker(cardinality.Limits{})},
+			req:  &ingestion.GetCardinalityReportRequest{Limit: -1},
+			want: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.s.GetCardinalityReport(context.Background(), tt.req)
+			if status.Code(err) != tt.want {
+				t.Errorf("GetCardinalityReport() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/ratelimit"
+)
+
+// maxExpositionSize bounds the size of an exposition payload posted over HTTP
+const maxExpositionSize = 32 << 20
+
+// IngestExposition parses a Prometheus text or OpenMetrics payload and ingests its samples
+func (s *IngestionService) IngestExposition(ctx context.Context, req *ingestion.IngestExpositionRequest) (*ingestion.IngestDataResponse, error) {
+	metrics, err := parseExposition(bytes.NewReader(req.Payload), req.ContentType, time.Now())
+	if err != nil {
+		log.Printf("Error parsing exposition from source %s: %v", req.SourceId, err)
+		return &ingestion.IngestDataResponse{Status: "Failed to parse exposition"}, err
+	}
+
+	return s.IngestData(ctx, &ingestion.IngestDataRequest{
+		SourceId:   req.SourceId,
+		SourceType: req.SourceType,
+		Metrics:    metrics,
+	})
+}
+
+// HandleExposition ingests a Prometheus text or OpenMetrics payload posted over
+// HTTP. The source is taken from the source_id and source_type query parameters.
+func (s *IngestionService) HandleExposition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sourceID := r.URL.Query().Get("source_id")
+	if sourceID == "" {
+		http.Error(w, "source_id is required", http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxExpositionSize)
+	metrics, err := parseExposition(body, r.Header.Get("Content-Type"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.IngestData(r.Context(), &ingestion.IngestDataRequest{
+		SourceId:   sourceID,
+		SourceType: r.URL.Query().Get("source_type"),
+		Metrics:    metrics,
+	})
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseExposition converts every sample of an exposition payload into MetricData.
+// Histogram and summary samples keep their _bucket, _sum and _count names and
+// their le and quantile labels. Samples without a timestamp get now.
+func parseExposition(r io.Reader, contentType string, now time.Time) ([]*ingestion.MetricData, error) {
+	var metrics []*ingestion.MetricData
+
+	err := format.ParseText(r, isOpenMetrics(contentType), func(sample format.Sample) error {
+		timestamp := sample.Timestamp
+		if timestamp == 0 {
+			timestamp = now.UnixMilli()
+		}
+
+		metric := &ingestion.MetricData{
+			Name:      sample.Name,
+			Labels:    sample.Labels,
+			Value:     sample.Value,
+			Timestamp: timestamp,
+		}
+		if sample.Exemplar != nil {
+			metric.Exemplar = &ingestion.Exemplar{
+				Labels:    sample.Exemplar.Labels,
+				Value:     sample.Exemplar.Value,
+				Timestamp: sample.Exemplar.Timestamp,
+			}
+			if metric.Exemplar.Timestamp == 0 {
+				metric.Exemplar.Timestamp = timestamp
+			}
+		}
+
+		metrics = append(metrics, metric)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exposition: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// httpStatus maps an ingestion error to an HTTP status code. Metrics rejected
+// by a policy or a limit are client errors, storage failures are server errors.
+func httpStatus(err error) int {
+	if _, ok := ratelimit.RetryAfter(err); ok {
+		return http.StatusTooManyRequests
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.ResourceExhausted:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setRetryAfter sets the Retry-After header of a write rejected by a rate limit
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if wait, ok := ratelimit.RetryAfter(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
+
+func isOpenMetrics(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/openmetrics-text"
+}
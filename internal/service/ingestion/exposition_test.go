@@ -0,0 +1,141 @@
+package ingestion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+func Test_parseExposition(t *testing.T) {
+	now := time.UnixMilli(1620474602000)
+
+	type args struct {
+		payload     string
+		contentType string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*ingestion.MetricData
+		wantErr bool
+	}{
+		{
+			name: "prometheus histogram",
+			args: args{
+				payload: `# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054 1620474600000
+http_request_duration_seconds_bucket{le="+Inf"} 144320 1620474600000
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+`,
+				contentType: "text/plain; version=0.0.4",
+			},
+			want: []*ingestion.MetricData{
+				{Name: "http_request_duration_seconds_bucket", Labels: map[string]string{"le": "0.05"}, Value: 24054, Timestamp: 1620474600000},
+				{Name: "http_request_duration_seconds_bucket", Labels: map[string]string{"le": "+Inf"}, Value: 144320, Timestamp: 1620474600000},
+				{Name: "http_request_duration_seconds_sum", Labels: map[string]string{}, Value: 53423, Timestamp: 1620474602000},
+				{Name: "http_request_duration_seconds_count", Labels: map[string]string{}, Value: 144320, Timestamp: 1620474602000},
+			},
+		},
+		{
+			name: "openmetrics summary with second timestamps",
+			args: args{
+				payload: `# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773 1620474600.5
+rpc_duration_seconds_count 2693
+# EOF
+`,
+				contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			},
+			want: []*ingestion.MetricData{
+				{Name: "rpc_duration_seconds", Labels: map[string]string{"quantile": "0.5"}, Value: 4773, Timestamp: 1620474600500},
+				{Name: "rpc_duration_seconds_count", Labels: map[string]string{}, Value: 2693, Timestamp: 1620474602000},
+			},
+		},
+		{
+			name: "openmetrics exemplar without timestamp",
+			args: args{
+				payload: `# TYPE http_requests counter
+http_requests_total{code="200"} 1027 # {trace_id="4bf92f3577b34da6"} 1
+# EOF
+`,
+				contentType: "application/openmetrics-text; version=1.0.0",
+			},
+			want: []*ingestion.MetricData{
+				{
+					Name:      "http_requests_total",
+					Labels:    map[string]string{"code": "200"},
+					Value:     1027,
+					Timestamp: 1620474602000,
+					Exemplar:  &ingestion.Exemplar{Labels: map[string]string{"trace_id": "4bf92f3577b34da6"}, Value: 1, Timestamp: 1620474602000},
+				},
+			},
+		},
+		{
+			name: "malformed sample",
+			args: args{
+				payload: "up{job=\"api\" 1\n",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExposition(strings.NewReader(tt.args.payload), tt.args.contentType, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseExposition() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExposition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestionService_HandleExposition_BadRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			target:     "/api/v1/ingest/prometheus?source_id=a",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "missing source",
+			method:     http.MethodPost,
+			target:     "/api/v1/ingest/prometheus",
+			body:       "up 1\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed payload",
+			method:     http.MethodPost,
+			target:     "/api/v1/ingest/prometheus?source_id=a",
+			body:       "up{\n",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &IngestionService{}
+			rec := httptest.NewRecorder()
+			s.HandleExposition(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("IngestionService.HandleExposition() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
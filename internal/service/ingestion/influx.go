@@ -0,0 +1,137 @@
+package ingestion
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+)
+
+// influxSourceType is the source_type of metrics written over the InfluxDB line protocol
+const influxSourceType = "influxdb"
+
+// HandleInfluxWrite implements the InfluxDB /api/v2/write endpoint. The bucket
+// query parameter is used as the source, the precision parameter selects the
+// unit of the timestamps.
+func (s *IngestionService) HandleInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeInfluxError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		writeInfluxError(w, http.StatusBadRequest, "bucket is required")
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxExpositionSize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			writeInfluxError(w, http.StatusBadRequest, fmt.Sprintf("invalid gzip body: %v", err))
+			return
+		}
+		defer gz.Close()
+		// The decompressed payload is bounded as well, not only the gzip body
+		body = http.MaxBytesReader(w, gz, maxExpositionSize)
+	}
+
+	// The line cut off at the limit fails to parse before the scanner returns
+	// the error of the reader, which is therefore checked on its own
+	read := &readErrors{r: body}
+	metrics, err := parseLineProtocol(read, r.URL.Query().Get("precision"), time.Now())
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(read.err, &tooLarge) {
+			writeInfluxError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("payload exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeInfluxError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = s.IngestData(r.Context(), &ingestion.IngestDataRequest{
+		SourceId:   bucket,
+		SourceType: influxSourceType,
+		Metrics:    metrics,
+	})
+	if err != nil {
+		setRetryAfter(w, err)
+		writeInfluxError(w, httpStatus(err), err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readErrors remembers the last error of a reader other than io.EOF
+type readErrors struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrors) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// parseLineProtocol converts every field of a line protocol payload into a
+// MetricData named measurement_field with the tags as labels. Points without a
+// timestamp get now.
+func parseLineProtocol(r io.Reader, precision string, now time.Time) ([]*ingestion.MetricData, error) {
+	var metrics []*ingestion.MetricData
+
+	err := format.ParseLineProtocol(r, precision, func(point format.InfluxPoint) error {
+		timestamp := point.Timestamp
+		if timestamp == 0 {
+			timestamp = now.UnixMilli()
+		}
+
+		for _, field := range point.Fields {
+			labels := make(map[string]string, len(point.Tags))
+			for key, value := range point.Tags {
+				labels[format.SanitizeLabelName(key)] = value
+			}
+
+			metrics = append(metrics, &ingestion.MetricData{
+				Name:      format.SanitizeMetricName(point.Measurement + "_" + field.Key),
+				Labels:    labels,
+				Value:     field.Value,
+				Timestamp: timestamp,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse line protocol: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// writeInfluxError writes an error in the JSON shape returned by InfluxDB so
+// that its clients can report it
+func writeInfluxError(w http.ResponseWriter, code int, message string) {
+	errCode := "invalid"
+	switch {
+	case code == http.StatusRequestEntityTooLarge:
+		errCode = "request too large"
+	case code >= http.StatusInternalServerError:
+		errCode = "internal error"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"code": errCode, "message": message})
+}
@@ -0,0 +1,125 @@
+package ingestion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/fanout"
+)
+
+func Test_parseLineProtocol(t *testing.T) {
+	now := time.UnixMilli(1620474602000)
+
+	got, err := parseLineProtocol(strings.NewReader(`sensor.room,site=lab-1,floor\ no=2 temp=21.5,door=t,label="x" 1620474600
+sensor.room,site=lab-2 temp=19
+`), "s", now)
+	if err != nil {
+		t.Fatalf("parseLineProtocol() error = %v", err)
+	}
+
+	want := []*ingestion.MetricData{
+		{Name: "sensor_room_temp", Labels: map[string]string{"site": "lab-1", "floor_no": "2"}, Value: 21.5, Timestamp: 1620474600000},
+		{Name: "sensor_room_door", Labels: map[string]string{"site": "lab-1", "floor_no": "2"}, Value: 1, Timestamp: 1620474600000},
+		{Name: "sensor_room_temp", Labels: map[string]string{"site": "lab-2"}, Value: 19, Timestamp: 1620474602000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLineProtocol() = %v, want %v", got, want)
+	}
+}
+
+func TestIngestionService_HandleInfluxWrite_BadRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			target:     "/api/v2/write?bucket=iot",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "missing bucket",
+			method:     http.MethodPost,
+			target:     "/api/v2/write?org=acme",
+			body:       "cpu value=1\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown precision",
+			method:     http.MethodPost,
+			target:     "/api/v2/write?bucket=iot&precision=h",
+			body:       "cpu value=1\n",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed line",
+			method:     http.MethodPost,
+			target:     "/api/v2/write?bucket=iot",
+			body:       "cpu value=\n",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &IngestionService{}
+			rec := httptest.NewRecorder()
+			s.HandleInfluxWrite(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("IngestionService.HandleInfluxWrite() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), `"code":"invalid"`) {
+				t.Errorf("IngestionService.HandleInfluxWrite() body = %s, want an InfluxDB error", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestIngestionService_HandleInfluxWrite_GzipBomb(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	line := []byte(strings.Repeat(" ", 1<<15) + "\n")
+	for written := 0; written <= maxExpositionSize; written += len(line) {
+		gz.Write(line)
+	}
+	gz.Close()
+
+	// The gzip body is small, the decompressed payload is over the limit
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/write?bucket=iot", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	(&IngestionService{}).HandleInfluxWrite(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("IngestionService.HandleInfluxWrite() status = %d, want %d: %s", rec.Code, http.StatusRequestEntityTooLarge, rec.Body.String())
+	}
+}
+
+func TestIngestionService_HandleInfluxWrite(t *testing.T) {
+	var written []*ingestion.IngestDataRequest
+	sink := fanout.Sink{Name: "test", Writer: fanout.WriterFunc(func(ctx context.Context, req *ingestion.IngestDataRequest) error {
+		written = append(written, req)
+		return nil
+	})}
+	s := &IngestionService{sinks: fanout.New(sink)}
+
+	// Bucket names are written as the source id, which is stored as text
+	rec := httptest.NewRecorder()
+	s.HandleInfluxWrite(rec, httptest.NewRequest(http.MethodPost, "/api/v2/write?bucket=iot&precision=s", strings.NewReader("cpu value=1 1620474600\n")))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("IngestionService.HandleInfluxWrite() status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if len(written) != 1 || written[0].SourceId != "iot" || written[0].SourceType != influxSourceType {
+		t.Fatalf("IngestionService.HandleInfluxWrite() wrote %v, want a batch of source iot", written)
+	}
+}
@@ -2,37 +2,134 @@ package ingestion
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
 	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/cardinality"
 	"github.com/yay14/pulse/internal/cassandra"
+	"github.com/yay14/pulse/internal/fanout"
 	"github.com/yay14/pulse/internal/kafka"
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/internal/retention"
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/internal/wal"
 )
 
 // IngestionService implements the IngestionServiceServer
 type IngestionService struct {
 	ingestion.UnimplementedIngestionServiceServer
 	repo *cassandra.Repository
+
+	metadata       *metadataRegistry
+	metadataPolicy MetadataPolicy
+	retention      *retention.Resolver
+	relabel        *relabel.RuleSet
+	cardinality    *cardinality.Tracker
+	limiter        *ratelimit.Limiter
+
+	cassandraSink fanout.Config
+	extraSinks    []fanout.Sink
+	wal           *wal.WAL
+	sinks         *fanout.FanOut
 }
 
-// NewIngestionService creates a new IngestionService
-func NewIngestionService(repo *cassandra.Repository) *IngestionService {
-	return &IngestionService{repo: repo}
+// Option configures an IngestionService
+type Option func(*IngestionService)
+
+// NewIngestionService creates a new IngestionService, it fails when the
+// readers of the sinks cannot be opened on the WAL
+func NewIngestionService(repo *cassandra.Repository, opts ...Option) (*IngestionService, error) {
+	s := &IngestionService{
+		repo:           repo,
+		metadata:       newMetadataRegistry(repo),
+		metadataPolicy: MetadataPolicyAllow,
+		retention:      retention.NewResolver(repo),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	cassandraSink := fanout.Sink{Name: CassandraSinkName, Writer: fanout.WriterFunc(s.writeCassandra), Config: s.cassandraSink}
+	sinks := append([]fanout.Sink{cassandraSink}, s.extraSinks...)
+	if s.wal == nil {
+		s.sinks = fanout.New(sinks...)
+		return s, nil
+	}
+
+	var err error
+	if s.sinks, err = fanout.NewDurable(s.wal, sinks...); err != nil {
+		return nil, fmt.Errorf("failed to open sink readers on the WAL: %w", err)
+	}
+	return s, nil
 }
 
-// IngestData ingests metrics data from Kafka and writes to Cassandra
+// IngestData ingests metrics data of the tenant of ctx and writes it to every
+// sink, writes past the rate limit of their source are rejected
 func (s *IngestionService) IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
+	if wait := s.limiter.Reserve(tenant.FromContext(ctx), req.SourceType, req.SourceId, len(req.Metrics), proto.Size(req)); wait > 0 {
+		log.Printf("Rate limiting source %s for %v", req.SourceId, wait)
+		return &ingestion.IngestDataResponse{Status: "Rate limit exceeded"}, ratelimit.Exceeded(ctx, req.SourceId, wait)
+	}
+	return s.ingest(ctx, req)
+}
+
+// ingest writes a batch that passed the rate limits to every sink
+func (s *IngestionService) ingest(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
 	log.Println("Ingesting data for source:", req.SourceId)
 
-	for _, metric := range req.Metrics {
-		// Write each metric to Cassandra
-		err := s.repo.WriteMetric(ctx, metric, req)
-		if err != nil {
-			log.Printf("Error writing metric to Cassandra: %v", err)
-			return &ingestion.IngestDataResponse{Status: "Failed to write to Cassandra"}, err
+	// The batch carries its tenant to the sinks, also when it is replayed from the WAL
+	req.TenantId = tenant.FromContext(ctx)
+
+	if dropped := s.relabelMetrics(req); dropped > 0 {
+		log.Printf("Relabeling dropped %d metrics of source %s", dropped, req.SourceId)
+		if len(req.Metrics) == 0 {
+			return &ingestion.IngestDataResponse{Status: "All metrics dropped by relabeling"}, nil
+		}
+	}
+
+	if err := checkMetricNames(req); err != nil {
+		log.Printf("Rejecting data for source %s: %v", req.SourceId, err)
+		return &ingestion.IngestDataResponse{Status: "Rejected invalid metric names"}, err
+	}
+
+	missing, err := s.checkMetadata(ctx, req)
+	if err != nil {
+		log.Printf("Rejecting data for source %s: %v", req.SourceId, err)
+		return &ingestion.IngestDataResponse{Status: "Rejected metrics without registered metadata"}, err
+	}
+
+	// New series past the cardinality limits are rejected, the others are written
+	total := len(req.Metrics)
+	rejected, limitErr := s.admitSeries(req)
+	if rejected > 0 {
+		log.Printf("Rejected %d of %d metrics of source %s: %v", rejected, total, req.SourceId, limitErr)
+		limitErr = status.Errorf(codes.ResourceExhausted, "%d of %d series rejected: %v", rejected, total, limitErr)
+		if len(req.Metrics) == 0 {
+			return &ingestion.IngestDataResponse{Status: "Rejected series past the cardinality limits"}, limitErr
 		}
 	}
 
+	if err := s.sinks.Write(ctx, req); errors.Is(err, wal.ErrFull) {
+		log.Printf("Rejecting data of source %s: %v", req.SourceId, err)
+		return &ingestion.IngestDataResponse{Status: "Write-ahead log is full"}, status.Error(codes.Unavailable, err.Error())
+	} else if err != nil {
+		log.Printf("Error writing data of source %s: %v", req.SourceId, err)
+		return &ingestion.IngestDataResponse{Status: "Failed to write to a required sink"}, err
+	}
+
+	if rejected > 0 {
+		return &ingestion.IngestDataResponse{Status: fmt.Sprintf("Data ingested, %d series rejected past the cardinality limits", rejected)}, limitErr
+	}
+	if len(missing) > 0 {
+		return &ingestion.IngestDataResponse{Status: fmt.Sprintf("Data ingested successfully, %d metrics without registered metadata", len(missing))}, nil
+	}
 	return &ingestion.IngestDataResponse{Status: "Data ingested successfully"}, nil
 }
 
@@ -74,6 +171,8 @@ func (s *IngestionService) ValidateData(ctx context.Context, req *ingestion.Vali
 }
 
 // StartKafkaConsumer starts consuming messages from Kafka and processes them
+// for the tenant of each message. A source past its rate limit holds up its
+// partition until the limit allows the message, nothing is dropped.
 func (s *IngestionService) StartKafkaConsumer(cfg kafka.KafkaConfig) {
 	kafka.Consumer(cfg, func(message kafka.Message) {
 		// Process Kafka message and ingest metrics
@@ -82,7 +181,12 @@ func (s *IngestionService) StartKafkaConsumer(cfg kafka.KafkaConfig) {
 			SourceType: message.SourceType,
 			Metrics:    message.Metrics,
 		}
-		_, err := s.IngestData(context.Background(), req)
+		ctx := tenant.NewContext(context.Background(), message.TenantID)
+		if err := s.limiter.Wait(ctx, message.TenantID, req.SourceType, req.SourceId, len(req.Metrics), proto.Size(req)); err != nil {
+			log.Printf("Failed to wait for the rate limit of source %s: %v", req.SourceId, err)
+			return
+		}
+		_, err := s.ingest(ctx, req)
 		if err != nil {
 			log.Printf("Failed to ingest data from Kafka message: %v", err)
 		}
@@ -0,0 +1,220 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+const (
+	// metadataCacheTTL bounds how long registered and missing metadata is cached
+	metadataCacheTTL = time.Minute
+
+	// metadataMissingLabel is added to metrics without registered metadata by MetadataPolicyFlag
+	metadataMissingLabel = "metadata_missing"
+)
+
+// MetadataPolicy decides what happens to metrics without registered metadata
+type MetadataPolicy string
+
+const (
+	MetadataPolicyAllow  MetadataPolicy = "allow"  // Ingest metrics without checking their metadata
+	MetadataPolicyFlag   MetadataPolicy = "flag"   // Ingest metrics without metadata with a metadata_missing label
+	MetadataPolicyReject MetadataPolicy = "reject" // Reject requests containing metrics without metadata
+)
+
+// ParseMetadataPolicy parses a metadata policy, an empty string is MetadataPolicyAllow
+func ParseMetadataPolicy(s string) (MetadataPolicy, error) {
+	switch policy := MetadataPolicy(s); policy {
+	case "":
+		return MetadataPolicyAllow, nil
+	case MetadataPolicyAllow, MetadataPolicyFlag, MetadataPolicyReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown metadata policy %q", s)
+	}
+}
+
+// WithMetadataPolicy sets the policy for metrics without registered metadata
+func WithMetadataPolicy(policy MetadataPolicy) Option {
+	return func(s *IngestionService) {
+		s.metadataPolicy = policy
+	}
+}
+
+// metadataStore persists the metadata registry, it is implemented by the Cassandra repository
+type metadataStore interface {
+	RegisterMetricMetadata(ctx context.Context, metadata *ingestion.MetricMetadata) error
+	GetMetricMetadata(ctx context.Context, metricName string) (*ingestion.MetricMetadata, error)
+	ListMetricMetadata(ctx context.Context) ([]*ingestion.MetricMetadata, error)
+}
+
+// metadataRegistry caches the metadata of the store, including metrics without metadata
+type metadataRegistry struct {
+	store metadataStore
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]metadataEntry
+}
+
+type metadataEntry struct {
+	metadata *ingestion.MetricMetadata // nil if the metric has no metadata
+	expires  time.Time
+}
+
+func newMetadataRegistry(store metadataStore) *metadataRegistry {
+	return &metadataRegistry{store: store, now: time.Now, entries: make(map[string]metadataEntry)}
+}
+
+func (r *metadataRegistry) register(ctx context.Context, metadata *ingestion.MetricMetadata) error {
+	if err := r.store.RegisterMetricMetadata(ctx, metadata); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[metadataKey(ctx, metadata.MetricName)] = metadataEntry{metadata: metadata, expires: r.now().Add(metadataCacheTTL)}
+	return nil
+}
+
+// lookup returns the metadata of a metric. Series of histograms, summaries and
+// counters resolve to the metadata of their family when they have none of their own.
+func (r *metadataRegistry) lookup(ctx context.Context, metricName string) (*ingestion.MetricMetadata, error) {
+	metadata, err := r.get(ctx, metricName)
+	if metadata != nil || err != nil {
+		return metadata, err
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total"} {
+		if family := strings.TrimSuffix(metricName, suffix); family != metricName {
+			return r.get(ctx, family)
+		}
+	}
+	return nil, nil
+}
+
+// metadataKey returns the cache key of a metric of the tenant of ctx
+func metadataKey(ctx context.Context, metricName string) string {
+	return tenant.FromContext(ctx) + tenant.Separator + metricName
+}
+
+func (r *metadataRegistry) get(ctx context.Context, metricName string) (*ingestion.MetricMetadata, error) {
+	key := metadataKey(ctx, metricName)
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.metadata, nil
+	}
+
+	metadata, err := r.store.GetMetricMetadata(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[key] = metadataEntry{metadata: metadata, expires: r.now().Add(metadataCacheTTL)}
+	return metadata, nil
+}
+
+// RegisterMetricMetadata registers the type, unit, help text and owner of a metric
+func (s *IngestionService) RegisterMetricMetadata(ctx context.Context, req *ingestion.RegisterMetricMetadataRequest) (*ingestion.RegisterMetricMetadataResponse, error) {
+	metadata := req.Metadata
+	if metadata == nil || metadata.MetricName == "" {
+		return &ingestion.RegisterMetricMetadataResponse{Success: false}, status.Error(codes.InvalidArgument, "metric name is required")
+	}
+	if format.SanitizeMetricName(metadata.MetricName) != metadata.MetricName {
+		return &ingestion.RegisterMetricMetadataResponse{Success: false}, status.Errorf(codes.InvalidArgument, "invalid metric name %q", metadata.MetricName)
+	}
+
+	if err := s.metadata.register(ctx, metadata); err != nil {
+		log.Printf("Error registering metadata of %s: %v", metadata.MetricName, err)
+		return &ingestion.RegisterMetricMetadataResponse{Success: false}, err
+	}
+
+	return &ingestion.RegisterMetricMetadataResponse{Success: true}, nil
+}
+
+// GetMetricMetadata returns the registered metadata of a metric
+func (s *IngestionService) GetMetricMetadata(ctx context.Context, req *ingestion.GetMetricMetadataRequest) (*ingestion.GetMetricMetadataResponse, error) {
+	metadata, err := s.metadata.lookup(ctx, req.MetricName)
+	if err != nil {
+		log.Printf("Error getting metadata of %s: %v", req.MetricName, err)
+		return &ingestion.GetMetricMetadataResponse{}, err
+	}
+	if metadata == nil {
+		return &ingestion.GetMetricMetadataResponse{}, status.Errorf(codes.NotFound, "no metadata registered for %s", req.MetricName)
+	}
+
+	return &ingestion.GetMetricMetadataResponse{Metadata: metadata}, nil
+}
+
+// ListMetricMetadata lists the registered metadata, optionally filtered by a name prefix
+func (s *IngestionService) ListMetricMetadata(ctx context.Context, req *ingestion.ListMetricMetadataRequest) (*ingestion.ListMetricMetadataResponse, error) {
+	all, err := s.metadata.store.ListMetricMetadata(ctx)
+	if err != nil {
+		log.Printf("Error listing metric metadata: %v", err)
+		return &ingestion.ListMetricMetadataResponse{}, err
+	}
+
+	resp := &ingestion.ListMetricMetadataResponse{}
+	for _, metadata := range all {
+		if strings.HasPrefix(metadata.MetricName, req.Prefix) {
+			resp.Metadata = append(resp.Metadata, metadata)
+		}
+	}
+	sort.Slice(resp.Metadata, func(i, j int) bool { return resp.Metadata[i].MetricName < resp.Metadata[j].MetricName })
+
+	return resp, nil
+}
+
+// checkMetadata applies the metadata policy to the metrics of req. It returns
+// the names of the metrics without metadata, and an error if they are rejected.
+func (s *IngestionService) checkMetadata(ctx context.Context, req *ingestion.IngestDataRequest) ([]string, error) {
+	if s.metadata == nil || s.metadataPolicy == "" || s.metadataPolicy == MetadataPolicyAllow {
+		return nil, nil
+	}
+
+	var missing []string
+	seen := make(map[string]bool)
+	for _, metric := range req.Metrics {
+		metadata, err := s.metadata.lookup(ctx, metric.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up metadata of %s: %w", metric.Name, err)
+		}
+		if metadata != nil {
+			continue
+		}
+
+		if !seen[metric.Name] {
+			seen[metric.Name] = true
+			missing = append(missing, metric.Name)
+		}
+		if s.metadataPolicy == MetadataPolicyFlag {
+			labels := make(map[string]string, len(metric.Labels)+1)
+			for key, value := range metric.Labels {
+				labels[key] = value
+			}
+			labels[metadataMissingLabel] = "true"
+			metric.Labels = labels
+		}
+	}
+
+	if len(missing) > 0 && s.metadataPolicy == MetadataPolicyReject {
+		return missing, status.Errorf(codes.FailedPrecondition, "metrics without registered metadata: %s", strings.Join(missing, ", "))
+	}
+	return missing, nil
+}
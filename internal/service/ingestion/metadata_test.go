@@ -0,0 +1,158 @@
+package ingestion
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+type fakeMetadataStore struct {
+	metadata map[string]*ingestion.MetricMetadata
+	gets     int
+}
+
+func (f *fakeMetadataStore) RegisterMetricMetadata(ctx context.Context, metadata *ingestion.MetricMetadata) error {
+	f.metadata[metadata.MetricName] = metadata
+	return nil
+}
+
+func (f *fakeMetadataStore) GetMetricMetadata(ctx context.Context, metricName string) (*ingestion.MetricMetadata, error) {
+	f.gets++
+	return f.metadata[metricName], nil
+}
+
+func (f *fakeMetadataStore) ListMetricMetadata(ctx context.Context) ([]*ingestion.MetricMetadata, error) {
+	var result []*ingestion.MetricMetadata
+	for _, metadata := range f.metadata {
+		result = append(result, metadata)
+	}
+	return result, nil
+}
+
+func newMetadataTestService(policy MetadataPolicy) (*IngestionService, *fakeMetadataStore) {
+	store := &fakeMetadataStore{metadata: map[string]*ingestion.MetricMetadata{
+		"http_request_duration_seconds": {MetricName: "http_request_duration_seconds", Type: ingestion.MetricType_METRIC_TYPE_HISTOGRAM, Unit: "seconds"},
+		"queue_depth":                   {MetricName: "queue_depth", Type: ingestion.MetricType_METRIC_TYPE_GAUGE, Owner: "platform"},
+	}}
+	return &IngestionService{metadata: newMetadataRegistry(store), metadataPolicy: policy}, store
+}
+
+func TestIngestionService_GetMetricMetadata(t *testing.T) {
+	s, store := newMetadataTestService(MetadataPolicyAllow)
+
+	tests := []struct {
+		name     string
+		metric   string
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "registered", metric: "queue_depth", want: "queue_depth"},
+		{name: "histogram series resolve to the family", metric: "http_request_duration_seconds_bucket", want: "http_request_duration_seconds"},
+		{name: "not registered", metric: "cpu_seconds_total", wantCode: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.GetMetricMetadata(context.Background(), &ingestion.GetMetricMetadataRequest{MetricName: tt.metric})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("IngestionService.GetMetricMetadata() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err == nil && resp.Metadata.MetricName != tt.want {
+				t.Errorf("IngestionService.GetMetricMetadata() = %v, want %s", resp.Metadata, tt.want)
+			}
+		})
+	}
+
+	gets := store.gets
+	s.GetMetricMetadata(context.Background(), &ingestion.GetMetricMetadataRequest{MetricName: "queue_depth"})
+	if store.gets != gets {
+		t.Errorf("IngestionService.GetMetricMetadata() did not use the cache")
+	}
+}
+
+func TestIngestionService_RegisterAndListMetricMetadata(t *testing.T) {
+	s, _ := newMetadataTestService(MetadataPolicyAllow)
+	ctx := context.Background()
+
+	if _, err := s.RegisterMetricMetadata(ctx, &ingestion.RegisterMetricMetadataRequest{Metadata: &ingestion.MetricMetadata{MetricName: "queue-depth"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("IngestionService.RegisterMetricMetadata() error = %v, want InvalidArgument", err)
+	}
+
+	// Cache the miss before registering to check that registration replaces it
+	s.metadata.lookup(ctx, "queue_age_seconds")
+	if _, err := s.RegisterMetricMetadata(ctx, &ingestion.RegisterMetricMetadataRequest{Metadata: &ingestion.MetricMetadata{MetricName: "queue_age_seconds", Unit: "seconds"}}); err != nil {
+		t.Fatalf("IngestionService.RegisterMetricMetadata() error = %v", err)
+	}
+	if _, err := s.GetMetricMetadata(ctx, &ingestion.GetMetricMetadataRequest{MetricName: "queue_age_seconds"}); err != nil {
+		t.Errorf("IngestionService.GetMetricMetadata() after registering error = %v", err)
+	}
+
+	resp, err := s.ListMetricMetadata(ctx, &ingestion.ListMetricMetadataRequest{Prefix: "queue_"})
+	if err != nil {
+		t.Fatalf("IngestionService.ListMetricMetadata() error = %v", err)
+	}
+	var names []string
+	for _, metadata := range resp.Metadata {
+		names = append(names, metadata.MetricName)
+	}
+	if want := []string{"queue_age_seconds", "queue_depth"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("IngestionService.ListMetricMetadata() = %v, want %v", names, want)
+	}
+}
+
+func TestIngestionService_checkMetadata(t *testing.T) {
+	newRequest := func() *ingestion.IngestDataRequest {
+		return &ingestion.IngestDataRequest{Metrics: []*ingestion.MetricData{
+			{Name: "queue_depth", Labels: map[string]string{"queue": "mail"}},
+			{Name: "cpu_seconds_total", Labels: map[string]string{"cpu": "0"}},
+			{Name: "cpu_seconds_total", Labels: map[string]string{"cpu": "1"}},
+		}}
+	}
+
+	tests := []struct {
+		policy      MetadataPolicy
+		wantMissing []string
+		wantCode    codes.Code
+		wantFlagged bool
+	}{
+		{policy: MetadataPolicyAllow},
+		{policy: MetadataPolicyFlag, wantMissing: []string{"cpu_seconds_total"}, wantFlagged: true},
+		{policy: MetadataPolicyReject, wantMissing: []string{"cpu_seconds_total"}, wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			s, _ := newMetadataTestService(tt.policy)
+			req := newRequest()
+
+			missing, err := s.checkMetadata(context.Background(), req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("IngestionService.checkMetadata() error = %v, want code %v", err, tt.wantCode)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("IngestionService.checkMetadata() missing = %v, want %v", missing, tt.wantMissing)
+			}
+
+			if _, flagged := req.Metrics[0].Labels[metadataMissingLabel]; flagged {
+				t.Errorf("registered metric was flagged: %v", req.Metrics[0].Labels)
+			}
+			for _, metric := range req.Metrics[1:] {
+				if _, flagged := metric.Labels[metadataMissingLabel]; flagged != tt.wantFlagged {
+					t.Errorf("metric %v flagged = %v, want %v", metric.Labels, flagged, tt.wantFlagged)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMetadataPolicy(t *testing.T) {
+	if policy, err := ParseMetadataPolicy(""); err != nil || policy != MetadataPolicyAllow {
+		t.Errorf("ParseMetadataPolicy(\"\") = %v, %v, want allow", policy, err)
+	}
+	if _, err := ParseMetadataPolicy("drop"); err == nil {
+		t.Errorf("ParseMetadataPolicy(\"drop\") error = nil, want error")
+	}
+}
@@ -0,0 +1,10 @@
+package ingestion
+
+import "github.com/yay14/pulse/internal/ratelimit"
+
+// WithRateLimiter limits the samples and bytes every source may ingest per second
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(s *IngestionService) {
+		s.limiter = limiter
+	}
+}
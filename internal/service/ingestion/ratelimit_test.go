@@ -0,0 +1,45 @@
+package ingestion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/ratelimit"
+)
+
+func TestIngestionService_IngestData_RateLimited(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Config{Sources: map[string]ratelimit.Limit{"api": {SamplesPerSecond: 1}}})
+	s := &IngestionService{limiter: limiter}
+
+	req := &ingestion.IngestDataRequest{SourceId: "api", Metrics: []*ingestion.MetricData{{Name: "up"}, {Name: "up"}}}
+	limiter.Reserve("", "", "api", 1, 0)
+	_, err := s.IngestData(context.Background(), req)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("IngestData() error = %v, want ResourceExhausted", err)
+	}
+	if _, ok := ratelimit.RetryAfter(err); !ok {
+		t.Errorf("IngestData() error = %v, want a retry delay", err)
+	}
+}
+
+func TestIngestionService_HandleExposition_RateLimited(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Config{Default: ratelimit.Limit{SamplesPerSecond: 0.5, SampleBurst: 1}})
+	limiter.Reserve("", "", "a", 1, 0)
+	s := &IngestionService{limiter: limiter}
+
+	rec := httptest.NewRecorder()
+	s.HandleExposition(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest/prometheus?source_id=a", strings.NewReader("up 1\n")))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("IngestionService.HandleExposition() status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+}
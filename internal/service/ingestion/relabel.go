@@ -0,0 +1,47 @@
+package ingestion
+
+import (
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/relabel"
+)
+
+// WithRelabeling applies relabel rules to every ingested metric before it is
+// validated and stored
+func WithRelabeling(rules *relabel.RuleSet) Option {
+	return func(s *IngestionService) {
+		s.relabel = rules
+	}
+}
+
+// relabelMetrics applies the relabel rules to the metrics of a batch in
+// place. The metric name is relabeled through the __name__ label, metrics
+// that are dropped or lose their name are removed from the batch. It returns
+// the number of removed metrics.
+func (s *IngestionService) relabelMetrics(req *ingestion.IngestDataRequest) int {
+	rules := s.relabel.Rules()
+	if len(rules) == 0 {
+		return 0
+	}
+
+	kept := req.Metrics[:0]
+	for _, metric := range req.Metrics {
+		labels := make(map[string]string, len(metric.Labels)+1)
+		for name, value := range metric.Labels {
+			labels[name] = value
+		}
+		labels["__name__"] = metric.Name
+
+		labels, keep := relabel.Process(labels, rules)
+		if !keep || labels["__name__"] == "" {
+			continue
+		}
+		metric.Name = labels["__name__"]
+		delete(labels, "__name__")
+		metric.Labels = labels
+		kept = append(kept, metric)
+	}
+
+	dropped := len(req.Metrics) - len(kept)
+	req.Metrics = kept
+	return dropped
+}
@@ -0,0 +1,36 @@
+package ingestion
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/relabel"
+)
+
+func TestIngestionService_relabelMetrics(t *testing.T) {
+	rules, err := relabel.Compile([]relabel.Config{
+		{SourceLabels: []string{"__name__"}, Regex: "debug_.*", Action: relabel.Drop},
+		{Regex: "email", Action: relabel.LabelDrop},
+		{SourceLabels: []string{"__name__"}, Regex: "legacy_(.*)", TargetLabel: "__name__", Replacement: "$1"},
+	})
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	s := &IngestionService{relabel: relabel.NewRuleSet(rules)}
+
+	req := &ingestion.IngestDataRequest{SourceId: "api", Metrics: []*ingestion.MetricData{
+		{Name: "debug_queue_depth", Value: 1},
+		{Name: "legacy_http_requests_total", Labels: map[string]string{"code": "200", "email": "a@b.c"}, Value: 2},
+	}}
+	if dropped := s.relabelMetrics(req); dropped != 1 {
+		t.Errorf("relabelMetrics() dropped %d metrics, want 1", dropped)
+	}
+	if len(req.Metrics) != 1 {
+		t.Fatalf("relabelMetrics() kept %d metrics, want 1", len(req.Metrics))
+	}
+	metric := req.Metrics[0]
+	if metric.Name != "http_requests_total" || !reflect.DeepEqual(metric.Labels, map[string]string{"code": "200"}) {
+		t.Errorf("relabelMetrics() = %s %v, want http_requests_total without email", metric.Name, metric.Labels)
+	}
+}
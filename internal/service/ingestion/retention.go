@@ -0,0 +1,100 @@
+package ingestion
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/retention"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// retentionScopes maps the RPC retention scopes to the scopes of the retention package
+var retentionScopes = map[ingestion.RetentionScope]retention.Scope{
+	ingestion.RetentionScope_RETENTION_SCOPE_GLOBAL:      retention.ScopeGlobal,
+	ingestion.RetentionScope_RETENTION_SCOPE_SOURCE_TYPE: retention.ScopeSourceType,
+	ingestion.RetentionScope_RETENTION_SCOPE_SOURCE_ID:   retention.ScopeSourceID,
+	ingestion.RetentionScope_RETENTION_SCOPE_METRIC_NAME: retention.ScopeMetricName,
+}
+
+// SetRetentionPolicy sets the retention of the data of a scope of the tenant
+// of ctx. New data is written with the TTL of the policy, older data is
+// removed by the sweeper.
+func (s *IngestionService) SetRetentionPolicy(ctx context.Context, req *ingestion.SetRetentionPolicyRequest) (*ingestion.SetRetentionPolicyResponse, error) {
+	if req.Policy == nil {
+		return &ingestion.SetRetentionPolicyResponse{Success: false}, status.Error(codes.InvalidArgument, "policy is required")
+	}
+	scope, ok := retentionScopes[req.Policy.Scope]
+	if !ok {
+		return &ingestion.SetRetentionPolicyResponse{Success: false}, status.Errorf(codes.InvalidArgument, "unknown scope %v", req.Policy.Scope)
+	}
+
+	policy := retention.Policy{Scope: scope, Match: req.Policy.Match, TTL: time.Duration(req.Policy.TtlSeconds) * time.Second}
+	if err := policy.Validate(); err != nil {
+		return &ingestion.SetRetentionPolicyResponse{Success: false}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.repo.SetRetentionPolicy(ctx, policy); err != nil {
+		log.Printf("Error setting retention policy %s=%s: %v", policy.Scope, policy.Match, err)
+		return &ingestion.SetRetentionPolicyResponse{Success: false}, err
+	}
+	s.retention.Invalidate()
+
+	return &ingestion.SetRetentionPolicyResponse{Success: true}, nil
+}
+
+// DeleteRetentionPolicy deletes the retention policy of a scope and match of the tenant of ctx
+func (s *IngestionService) DeleteRetentionPolicy(ctx context.Context, req *ingestion.DeleteRetentionPolicyRequest) (*ingestion.DeleteRetentionPolicyResponse, error) {
+	scope, ok := retentionScopes[req.Scope]
+	if !ok {
+		return &ingestion.DeleteRetentionPolicyResponse{Success: false}, status.Errorf(codes.InvalidArgument, "unknown scope %v", req.Scope)
+	}
+
+	if err := s.repo.DeleteRetentionPolicy(ctx, scope, req.Match); err != nil {
+		log.Printf("Error deleting retention policy %s=%s: %v", scope, req.Match, err)
+		return &ingestion.DeleteRetentionPolicyResponse{Success: false}, err
+	}
+	s.retention.Invalidate()
+
+	return &ingestion.DeleteRetentionPolicyResponse{Success: true}, nil
+}
+
+// ListRetentionPolicies lists the retention policies of the tenant of ctx ordered by scope and match
+func (s *IngestionService) ListRetentionPolicies(ctx context.Context, req *ingestion.ListRetentionPoliciesRequest) (*ingestion.ListRetentionPoliciesResponse, error) {
+	id := tenant.FromContext(ctx)
+	policies, err := s.repo.ListRetentionPolicies(ctx)
+	if err != nil {
+		log.Printf("Error listing retention policies: %v", err)
+		return &ingestion.ListRetentionPoliciesResponse{}, err
+	}
+
+	rpcScopes := make(map[retention.Scope]ingestion.RetentionScope, len(retentionScopes))
+	for rpcScope, scope := range retentionScopes {
+		rpcScopes[scope] = rpcScope
+	}
+
+	resp := &ingestion.ListRetentionPoliciesResponse{}
+	for _, policy := range policies {
+		if policy.Tenant != id {
+			continue
+		}
+		resp.Policies = append(resp.Policies, &ingestion.RetentionPolicy{
+			Scope:      rpcScopes[policy.Scope],
+			Match:      policy.Match,
+			TtlSeconds: int64(policy.TTL / time.Second),
+		})
+	}
+	sort.Slice(resp.Policies, func(i, j int) bool {
+		if resp.Policies[i].Scope != resp.Policies[j].Scope {
+			return resp.Policies[i].Scope < resp.Policies[j].Scope
+		}
+		return resp.Policies[i].Match < resp.Policies[j].Match
+	})
+
+	return resp, nil
+}
@@ -0,0 +1,34 @@
+package ingestion
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+func TestIngestionService_SetRetentionPolicy_InvalidArgument(t *testing.T) {
+	s := &IngestionService{}
+
+	tests := []struct {
+		name   string
+		policy *ingestion.RetentionPolicy
+	}{
+		{name: "missing policy"},
+		{name: "unknown scope", policy: &ingestion.RetentionPolicy{Scope: 42, Match: "api", TtlSeconds: 60}},
+		{name: "source without match", policy: &ingestion.RetentionPolicy{Scope: ingestion.RetentionScope_RETENTION_SCOPE_SOURCE_ID, TtlSeconds: 60}},
+		{name: "global with match", policy: &ingestion.RetentionPolicy{Match: "api", TtlSeconds: 60}},
+		{name: "missing TTL", policy: &ingestion.RetentionPolicy{Scope: ingestion.RetentionScope_RETENTION_SCOPE_METRIC_NAME, Match: "up"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.SetRetentionPolicy(context.Background(), &ingestion.SetRetentionPolicyRequest{Policy: tt.policy})
+			if status.Code(err) != codes.InvalidArgument || resp.Success {
+				t.Errorf("SetRetentionPolicy() = %v, %v, want InvalidArgument", resp, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/cassandra"
+	"github.com/yay14/pulse/internal/fanout"
+	"github.com/yay14/pulse/internal/retention"
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/internal/wal"
+)
+
+// CassandraSinkName is the name of the sink writing to the Cassandra repository
+const CassandraSinkName = "cassandra"
+
+// WithCassandraSink sets the failure handling of the Cassandra sink, which
+// is required by default
+func WithCassandraSink(cfg fanout.Config) Option {
+	return func(s *IngestionService) {
+		s.cassandraSink = cfg
+	}
+}
+
+// WithSink adds a sink every ingested batch is written to, besides Cassandra
+func WithSink(sink fanout.Sink) Option {
+	return func(s *IngestionService) {
+		s.extraSinks = append(s.extraSinks, sink)
+	}
+}
+
+// WithWAL appends every ingested batch to a write-ahead log and acknowledges
+// it, the batches are replayed from the log to each sink by RunSinkRetries
+func WithWAL(w *wal.WAL) Option {
+	return func(s *IngestionService) {
+		s.wal = w
+	}
+}
+
+// RunSinkRetries retries the failed writes of best-effort sinks, or replays
+// the WAL to every sink, until ctx is done
+func (s *IngestionService) RunSinkRetries(ctx context.Context) {
+	s.sinks.Run(ctx)
+}
+
+// SinkStats returns the retry queue statistics of every sink
+func (s *IngestionService) SinkStats() []fanout.SinkStats {
+	return s.sinks.Stats()
+}
+
+// writeCassandra writes a batch to Cassandra for its tenant with the
+// retention of each metric. Writes Cassandra rejects are marked permanent, so
+// that they are not retried.
+func (s *IngestionService) writeCassandra(ctx context.Context, req *ingestion.IngestDataRequest) error {
+	err := s.writeCassandraMetrics(ctx, req)
+	if cassandra.IsInvalid(err) {
+		return wal.Permanent(err)
+	}
+	return err
+}
+
+func (s *IngestionService) writeCassandraMetrics(ctx context.Context, req *ingestion.IngestDataRequest) error {
+	ctx = tenant.NewContext(ctx, req.TenantId)
+	for _, metric := range req.Metrics {
+		ttl, err := s.retention.TTL(ctx, retention.Series{Tenant: req.TenantId, SourceType: req.SourceType, SourceID: req.SourceId, MetricName: metric.Name})
+		if err != nil {
+			return fmt.Errorf("failed to resolve retention of %s: %w", metric.Name, err)
+		}
+
+		// Distributions go to their own tables
+		switch {
+		case metric.Histogram != nil:
+			err = s.repo.WriteHistogram(ctx, metric, req, ttl)
+		case metric.Summary != nil:
+			err = s.repo.WriteSummary(ctx, metric, req, ttl)
+		default:
+			err = s.repo.WriteMetric(ctx, metric, req, ttl)
+		}
+		if err != nil {
+			log.Printf("Error writing metric to Cassandra: %v", err)
+			return err
+		}
+
+		if metric.Exemplar != nil {
+			if err := s.repo.WriteExemplar(ctx, metric, ttl); err != nil {
+				log.Printf("Error writing exemplar to Cassandra: %v", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
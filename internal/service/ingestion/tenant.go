@@ -0,0 +1,20 @@
+package ingestion
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+// checkMetricNames rejects a batch with a metric name that cannot be stored
+// for its tenant, such as a name addressing the metrics of another tenant
+func checkMetricNames(req *ingestion.IngestDataRequest) error {
+	for _, metric := range req.Metrics {
+		if _, err := tenant.Qualify(req.TenantId, metric.Name); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package ingestion
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/fanout"
+	"github.com/yay14/pulse/internal/tenant"
+)
+
+func TestIngestionService_IngestData_Tenant(t *testing.T) {
+	var written []*ingestion.IngestDataRequest
+	sink := fanout.Sink{Name: "test", Writer: fanout.WriterFunc(func(ctx context.Context, req *ingestion.IngestDataRequest) error {
+		written = append(written, req)
+		return nil
+	})}
+	s := &IngestionService{sinks: fanout.New(sink)}
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	// The tenant of the request replaces the tenant sent in the batch
+	req := &ingestion.IngestDataRequest{SourceId: "api", TenantId: "globex", Metrics: []*ingestion.MetricData{{Name: "up", Value: 1}}}
+	if _, err := s.IngestData(ctx, req); err != nil {
+		t.Fatalf("IngestData() error = %v", err)
+	}
+	if len(written) != 1 || written[0].TenantId != "acme" {
+		t.Fatalf("IngestData() wrote %v, want a batch of tenant acme", written)
+	}
+
+	// Names with the separator would be stored among the metrics of another tenant
+	req = &ingestion.IngestDataRequest{SourceId: "api", Metrics: []*ingestion.MetricData{{Name: "globex/up", Value: 1}}}
+	if _, err := s.IngestData(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("IngestData() error = %v, want InvalidArgument", err)
+	}
+	if len(written) != 1 {
+		t.Errorf("IngestData() wrote %d batches, want the invalid batch rejected", len(written))
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/metrics"
+)
+
+// WithQueryEngine sets the engine evaluating queries with the Cassandra backend
+func WithQueryEngine(engine *promql.Engine) Option {
+	return func(s *MetricsService) {
+		s.engine = engine
+	}
+}
+
+// engineQuery evaluates a query over the raw samples in Cassandra, at every
+// step from start to end or at start alone for an instant query. As in the
+// responses of VictoriaMetrics, sample timestamps are unix seconds.
+func (s *MetricsService) engineQuery(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]*metrics.TimeseriesData, error) {
+	if s.engine == nil {
+		return nil, status.Error(codes.Unimplemented, "no Cassandra query engine configured")
+	}
+
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var series []promql.Series
+	if step == 0 {
+		series, err = s.engine.Instant(ctx, expr, start)
+	} else {
+		series, err = s.engine.Range(ctx, expr, start, end, step)
+	}
+	if errors.Is(err, promql.ErrTooManySteps) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error evaluating query %s over Cassandra: %v", query, err)
+		return nil, err
+	}
+
+	timeseries := make([]*metrics.TimeseriesData, len(series))
+	for i, s := range series {
+		ts := &metrics.TimeseriesData{Labels: s.Labels}
+		for _, p := range s.Points {
+			ts.Samples = append(ts.Samples, &metrics.Sample{Value: p.V, Timestamp: p.T / 1000})
+		}
+		timeseries[i] = ts
+	}
+	return timeseries, nil
+}
+
+// engineRangeQuery evaluates a RangeQueryReadRequest with the Cassandra
+// backend, without a range the query is evaluated at the current time
+func (s *MetricsService) engineRangeQuery(ctx context.Context, req *metrics.RangeQueryReadRequest) ([]*metrics.TimeseriesData, error) {
+	if req.Start == "" && req.End == "" && req.Step == "" {
+		return s.engineQuery(ctx, req.Query, time.Now(), time.Time{}, 0)
+	}
+
+	start, end, step, err := parseRange(req.Start, req.End, req.Step)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.engineQuery(ctx, req.Query, start, end, step)
+}
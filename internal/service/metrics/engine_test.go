@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeQueryable struct {
+	series []promql.Series
+}
+
+func (f *fakeQueryable) SelectSeries(ctx context.Context, matchers []*promql.Matcher, start, end time.Time) ([]promql.Series, error) {
+	var result []promql.Series
+	for _, s := range f.series {
+		if promql.MatchLabels(matchers, s.Labels) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func TestMetricsService_RangeQueryMetrics_Cassandra(t *testing.T) {
+	engine := promql.NewEngine(&fakeQueryable{series: []promql.Series{
+		{Labels: map[string]string{"__name__": "audit_events_total", "service": "checkout"}, Points: []promql.Point{{T: 0, V: 0}, {T: 60_000, V: 60}, {T: 120_000, V: 120}}},
+		{Labels: map[string]string{"__name__": "audit_events_total", "service": "cart"}, Points: []promql.Point{{T: 0, V: 0}, {T: 60_000, V: 30}, {T: 120_000, V: 60}}},
+	}})
+	s := NewMetricsService(WithQueryEngine(engine))
+
+	tests := []struct {
+		name     string
+		req      *metrics.RangeQueryReadRequest
+		want     []float64
+		wantCode codes.Code
+	}{
+		{
+			name: "range",
+			req:  &metrics.RangeQueryReadRequest{Query: `sum(rate(audit_events_total[2m]))`, Start: "60", End: "120", Step: "60", Backend: metrics.QueryBackend_QUERY_BACKEND_CASSANDRA},
+			want: []float64{0.75, 1.5},
+		},
+		{
+			name:     "invalid query",
+			req:      &metrics.RangeQueryReadRequest{Query: `sum(`, Start: "60", End: "120", Step: "60", Backend: metrics.QueryBackend_QUERY_BACKEND_CASSANDRA},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "too many steps",
+			req:      &metrics.RangeQueryReadRequest{Query: `audit_events_total`, Start: "0", End: "86400", Step: "1", Backend: metrics.QueryBackend_QUERY_BACKEND_CASSANDRA},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.RangeQueryMetrics(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("RangeQueryMetrics() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(resp.Timeseries) != 1 {
+				t.Fatalf("RangeQueryMetrics() returned %d timeseries, want 1", len(resp.Timeseries))
+			}
+			samples := resp.Timeseries[0].Samples
+			if len(samples) != len(tt.want) {
+				t.Fatalf("RangeQueryMetrics() returned %d samples, want %d", len(samples), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if samples[i].Value != want || samples[i].Timestamp != 60+int64(i)*60 {
+					t.Errorf("sample %d = %v at %d, want %v at %d", i, samples[i].Value, samples[i].Timestamp, want, 60+i*60)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsService_InstantQueryMetrics_NoEngine(t *testing.T) {
+	s := NewMetricsService()
+	_, err := s.InstantQueryMetrics(context.Background(), &metrics.InstantQueryReadRequest{Query: "up", Backend: metrics.QueryBackend_QUERY_BACKEND_CASSANDRA})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("InstantQueryMetrics() error = %v, want Unimplemented", err)
+	}
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/metrics"
+)
+
+const (
+	// exportChunkSize is the size of the data messages sent by ExportSeries
+	exportChunkSize = 64 * 1024
+
+	// importBatchSize is the number of series posted to VictoriaMetrics per import request
+	importBatchSize = 1000
+)
+
+// ExportSeries exports the selected series of the tenant of the stream from
+// VictoriaMetrics' /api/v1/export and streams them in the requested format.
+func (s *MetricsService) ExportSeries(req *metrics.ExportSeriesRequest, stream metrics.MetricsService_ExportSeriesServer) error {
+	vmURL := os.Getenv("VICTORIA_METRICS_URL")
+
+	if len(req.Match) == 0 {
+		return fmt.Errorf("at least one series selector is required")
+	}
+
+	var csvSpec format.CSVSpec
+	if req.Format == metrics.SeriesFormat_SERIES_FORMAT_CSV {
+		var err error
+		if csvSpec, err = format.ParseCSVSpec(req.CsvFormat); err != nil {
+			return err
+		}
+	}
+
+	params := url.Values{}
+	for _, match := range req.Match {
+		params.Add("match[]", match)
+	}
+	if req.Start != "" {
+		params.Set("start", req.Start)
+	}
+	if req.End != "" {
+		params.Set("end", req.End)
+	}
+
+	exportEndpoint := tenantEndpoint(stream.Context(), vmURL+"/api/v1/export?"+params.Encode())
+	log.Printf("Exporting series from VictoriaMetrics: %s", exportEndpoint)
+
+	httpReq, err := http.NewRequestWithContext(stream.Context(), http.MethodGet, exportEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		log.Printf("Error sending request to VictoriaMetrics: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Unexpected response status: %s", resp.Status)
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	out := &chunkWriter{stream: stream}
+	switch req.Format {
+	case metrics.SeriesFormat_SERIES_FORMAT_JSON_LINES:
+		// VictoriaMetrics already exports JSON lines, pass them through
+		_, err = io.Copy(out, resp.Body)
+	case metrics.SeriesFormat_SERIES_FORMAT_CSV:
+		csvWriter := format.NewCSVWriter(out, csvSpec)
+		if err = format.ReadJSONLines(resp.Body, csvWriter.Write); err == nil {
+			err = csvWriter.Flush()
+		}
+	case metrics.SeriesFormat_SERIES_FORMAT_OPENMETRICS:
+		err = format.ReadJSONLines(resp.Body, func(ts *metrics.Timeseries) error {
+			return format.WriteOpenMetrics(out, ts)
+		})
+		if err == nil {
+			_, err = io.WriteString(out, "# EOF\n")
+		}
+	default:
+		err = fmt.Errorf("unsupported export format %v", req.Format)
+	}
+	if err != nil {
+		log.Printf("Error exporting series: %v", err)
+		return err
+	}
+
+	return out.Flush()
+}
+
+// ImportSeries reads series in the format given by the first chunk and writes
+// them to VictoriaMetrics for the tenant of the stream in batches of JSON lines.
+func (s *MetricsService) ImportSeries(stream metrics.MetricsService_ImportSeriesServer) error {
+	vmURL := os.Getenv("VICTORIA_METRICS_URL")
+
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&metrics.ImportSeriesResponse{Status: "No data to import"})
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := &chunkReader{stream: stream, data: first.Data}
+	importer := &seriesImporter{ctx: stream.Context(), vmURL: vmURL, tenantID: tenant.FromContext(stream.Context())}
+
+	switch first.Format {
+	case metrics.SeriesFormat_SERIES_FORMAT_JSON_LINES:
+		err = format.ReadJSONLines(reader, importer.add)
+	case metrics.SeriesFormat_SERIES_FORMAT_CSV:
+		csvSpec, specErr := format.ParseCSVSpec(first.CsvFormat)
+		if specErr != nil {
+			return specErr
+		}
+		err = format.ReadCSV(reader, csvSpec, importer.add)
+	case metrics.SeriesFormat_SERIES_FORMAT_OPENMETRICS:
+		now := time.Now().UnixMilli()
+		err = format.ParseText(reader, true, func(sample format.Sample) error {
+			if sample.Timestamp == 0 {
+				sample.Timestamp = now
+			}
+			sample.Labels["__name__"] = sample.Name
+			return importer.add(&metrics.Timeseries{
+				Labels:  sample.Labels,
+				Samples: []*metrics.Sample{{Value: sample.Value, Timestamp: sample.Timestamp}},
+			})
+		})
+	default:
+		err = fmt.Errorf("unsupported import format %v", first.Format)
+	}
+	if err == nil {
+		err = importer.flush()
+	}
+	if err != nil {
+		log.Printf("Error importing series after %d samples: %v", importer.samples, err)
+		return err
+	}
+
+	log.Printf("Imported %d samples into VictoriaMetrics", importer.samples)
+	return stream.SendAndClose(&metrics.ImportSeriesResponse{
+		Status:  "Data imported into VictoriaMetrics successfully",
+		Samples: importer.samples,
+	})
+}
+
+// seriesImporter batches series as JSON lines and posts them to /api/v1/import
+type seriesImporter struct {
+	ctx      context.Context
+	vmURL    string
+	tenantID string
+	buf      bytes.Buffer
+	pending  int
+	samples  int64
+}
+
+func (i *seriesImporter) add(ts *metrics.Timeseries) error {
+	setTenantLabel([]*metrics.Timeseries{ts}, i.tenantID)
+	jsonDataLine, err := format.MarshalJSONLine(ts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal series: %w", err)
+	}
+	i.buf.Write(jsonDataLine)
+	i.buf.WriteByte('\n')
+	i.pending++
+	i.samples += int64(len(ts.Samples))
+
+	if i.pending >= importBatchSize {
+		return i.flush()
+	}
+	return nil
+}
+
+func (i *seriesImporter) flush() error {
+	if i.pending == 0 {
+		return nil
+	}
+
+	httpReq, err := http.NewRequestWithContext(i.ctx, http.MethodPost, i.vmURL+"/api/v1/import", bytes.NewReader(i.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send data to VictoriaMetrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected response status: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	i.buf.Reset()
+	i.pending = 0
+	return nil
+}
+
+// chunkWriter buffers written data and sends it as SeriesChunk messages
+type chunkWriter struct {
+	stream metrics.MetricsService_ExportSeriesServer
+	buf    []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= exportChunkSize {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends any buffered data
+func (w *chunkWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	// The message may be read after Send returns, so never reuse the buffer
+	err := w.stream.Send(&metrics.SeriesChunk{Data: w.buf})
+	w.buf = make([]byte, 0, exportChunkSize)
+	return err
+}
+
+// chunkReader exposes the data of an ImportSeries stream as an io.Reader
+type chunkReader struct {
+	stream metrics.MetricsService_ImportSeriesServer
+	data   []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.data = chunk.Data
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yay14/pulse/metrics"
+	"google.golang.org/grpc"
+)
+
+type fakeExportStream struct {
+	grpc.ServerStream
+	data strings.Builder
+}
+
+func (f *fakeExportStream) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeExportStream) Send(chunk *metrics.SeriesChunk) error {
+	f.data.Write(chunk.Data)
+	return nil
+}
+
+type fakeImportStream struct {
+	grpc.ServerStream
+	chunks []*metrics.ImportSeriesChunk
+	resp   *metrics.ImportSeriesResponse
+}
+
+func (f *fakeImportStream) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeImportStream) Recv() (*metrics.ImportSeriesChunk, error) {
+	if len(f.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := f.chunks[0]
+	f.chunks = f.chunks[1:]
+	return chunk, nil
+}
+
+func (f *fakeImportStream) SendAndClose(resp *metrics.ImportSeriesResponse) error {
+	f.resp = resp
+	return nil
+}
+
+const exportedSeries = `{"metric":{"__name__":"up","job":"api"},"values":[1,0],"timestamps":[1620474602000,1620474617000]}
+{"metric":{"__name__":"up","job":"db"},"values":[1],"timestamps":[1620474602000]}
+`
+
+func TestMetricsService_ExportSeries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/export" || r.URL.Query().Get("match[]") != `{__name__="up"}` {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, exportedSeries)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	tests := []struct {
+		name    string
+		req     *metrics.ExportSeriesRequest
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "json lines pass through",
+			req:  &metrics.ExportSeriesRequest{Match: []string{`{__name__="up"}`}},
+			want: exportedSeries,
+		},
+		{
+			name: "csv",
+			req: &metrics.ExportSeriesRequest{
+				Match:     []string{`{__name__="up"}`},
+				Format:    metrics.SeriesFormat_SERIES_FORMAT_CSV,
+				CsvFormat: "1:label:job,2:metric:up,3:time:unix_s",
+			},
+			want: "api,1,1620474602\napi,0,1620474617\ndb,1,1620474602\n",
+		},
+		{
+			name: "openmetrics",
+			req: &metrics.ExportSeriesRequest{
+				Match:  []string{`{__name__="up"}`},
+				Format: metrics.SeriesFormat_SERIES_FORMAT_OPENMETRICS,
+			},
+			want: "up{job=\"api\"} 1 1620474602\nup{job=\"api\"} 0 1620474617\nup{job=\"db\"} 1 1620474602\n# EOF\n",
+		},
+		{
+			name:    "missing selector",
+			req:     &metrics.ExportSeriesRequest{},
+			wantErr: true,
+		},
+		{
+			name: "csv without spec",
+			req: &metrics.ExportSeriesRequest{
+				Match:  []string{`{__name__="up"}`},
+				Format: metrics.SeriesFormat_SERIES_FORMAT_CSV,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMetricsService()
+			stream := &fakeExportStream{}
+			err := s.ExportSeries(tt.req, stream)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MetricsService.ExportSeries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := stream.data.String(); !tt.wantErr && got != tt.want {
+				t.Errorf("MetricsService.ExportSeries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsService_ImportSeries(t *testing.T) {
+	var imported strings.Builder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/import" {
+			http.NotFound(w, r)
+			return
+		}
+		io.Copy(&imported, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	s := NewMetricsService()
+	stream := &fakeImportStream{chunks: []*metrics.ImportSeriesChunk{
+		{Format: metrics.SeriesFormat_SERIES_FORMAT_OPENMETRICS, Data: []byte("# TYPE up gauge\nup{job=\"a")},
+		{Data: []byte("pi\"} 1 1620474602\n")},
+		{Data: []byte("up{job=\"db\"} 0 1620474602.5\n# EOF\n")},
+	}}
+
+	if err := s.ImportSeries(stream); err != nil {
+		t.Fatalf("MetricsService.ImportSeries() error = %v", err)
+	}
+	if stream.resp == nil || stream.resp.Samples != 2 {
+		t.Fatalf("MetricsService.ImportSeries() = %v, want 2 samples", stream.resp)
+	}
+
+	want := `{"metric":{"__name__":"up","job":"api"},"values":[1],"timestamps":[1620474602000]}
+{"metric":{"__name__":"up","job":"db"},"values":[0],"timestamps":[1620474602500]}
+`
+	if imported.String() != want {
+		t.Errorf("imported = %q, want %q", imported.String(), want)
+	}
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/metrics"
+)
+
+// ExemplarStore provides the persisted exemplars of a metric, it is
+// implemented by the Cassandra repository
+type ExemplarStore interface {
+	QueryExemplars(ctx context.Context, metricName string, start, end time.Time) ([]*ingestion.MetricData, error)
+}
+
+// WithExemplarStore sets the store queried by QueryExemplars
+func WithExemplarStore(store ExemplarStore) Option {
+	return func(s *MetricsService) {
+		s.exemplars = store
+	}
+}
+
+// QueryExemplars returns the exemplars of the series matching a selector
+// between start and end, grouped by series
+func (s *MetricsService) QueryExemplars(ctx context.Context, req *metrics.QueryExemplarsRequest) (*metrics.QueryExemplarsResponse, error) {
+	if s.exemplars == nil {
+		return &metrics.QueryExemplarsResponse{}, status.Error(codes.Unimplemented, "no exemplar store configured")
+	}
+
+	matchers, err := promql.ParseSelector(req.Query)
+	if err != nil {
+		return &metrics.QueryExemplarsResponse{}, status.Errorf(codes.InvalidArgument, "invalid selector: %v", err)
+	}
+	name, ok := promql.MetricName(matchers)
+	if !ok {
+		return &metrics.QueryExemplarsResponse{}, status.Error(codes.InvalidArgument, "selector must select a metric name")
+	}
+
+	start, err := parseTimestamp(req.Start)
+	if err != nil {
+		return &metrics.QueryExemplarsResponse{}, status.Errorf(codes.InvalidArgument, "invalid start: %v", err)
+	}
+	end, err := parseTimestamp(req.End)
+	if err != nil {
+		return &metrics.QueryExemplarsResponse{}, status.Errorf(codes.InvalidArgument, "invalid end: %v", err)
+	}
+	if end.Before(start) {
+		return &metrics.QueryExemplarsResponse{}, status.Error(codes.InvalidArgument, "end is before start")
+	}
+
+	stored, err := s.exemplars.QueryExemplars(ctx, name, start, end)
+	if err != nil {
+		log.Printf("Error querying exemplars of %s: %v", name, err)
+		return &metrics.QueryExemplarsResponse{}, err
+	}
+
+	resp := &metrics.QueryExemplarsResponse{}
+	index := make(map[string]*metrics.ExemplarSeries)
+	for _, metric := range stored {
+		labels := make(map[string]string, len(metric.Labels)+1)
+		for key, value := range metric.Labels {
+			labels[key] = value
+		}
+		labels["__name__"] = metric.Name
+		if metric.Exemplar == nil || !promql.MatchLabels(matchers, labels) {
+			continue
+		}
+
+		key := labelsKey(labels)
+		series, ok := index[key]
+		if !ok {
+			series = &metrics.ExemplarSeries{Labels: labels}
+			index[key] = series
+			resp.Series = append(resp.Series, series)
+		}
+		series.Exemplars = append(series.Exemplars, metric.Exemplar)
+	}
+
+	for _, series := range resp.Series {
+		sort.SliceStable(series.Exemplars, func(i, j int) bool { return series.Exemplars[i].Timestamp < series.Exemplars[j].Timestamp })
+	}
+
+	return resp, nil
+}
+
+func hasExemplars(ts *metrics.Timeseries) bool {
+	for _, sample := range ts.Samples {
+		if sample.Exemplar != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteWrite sends series with their exemplars to the remote write endpoint of VictoriaMetrics
+func remoteWrite(ctx context.Context, vmURL string, series []*metrics.Timeseries) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, vmURL+"/api/v1/write", bytes.NewReader(format.MarshalRemoteWrite(series)))
+	if err != nil {
+		return fmt.Errorf("failed to create remote write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send remote write request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote write: %w", responseError(resp))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeExemplarStore struct {
+	exemplars []*ingestion.MetricData
+	start     time.Time
+	end       time.Time
+}
+
+func (f *fakeExemplarStore) QueryExemplars(ctx context.Context, metricName string, start, end time.Time) ([]*ingestion.MetricData, error) {
+	f.start, f.end = start, end
+
+	var result []*ingestion.MetricData
+	for _, metric := range f.exemplars {
+		if metric.Name == metricName {
+			result = append(result, metric)
+		}
+	}
+	return result, nil
+}
+
+func TestMetricsService_QueryExemplars(t *testing.T) {
+	exemplar := func(job string, value float64, timestamp int64) *ingestion.MetricData {
+		return &ingestion.MetricData{
+			Name:   "request_seconds_bucket",
+			Labels: map[string]string{"job": job, "le": "0.5"},
+			Exemplar: &ingestion.Exemplar{
+				Labels:    map[string]string{"trace_id": fmt.Sprintf("t%d", timestamp)},
+				Value:     value,
+				Timestamp: timestamp,
+			},
+		}
+	}
+	store := &fakeExemplarStore{exemplars: []*ingestion.MetricData{
+		exemplar("api", 0.3, 2000),
+		exemplar("web", 0.1, 1000),
+		exemplar("api", 0.2, 1000),
+		{Name: "up", Labels: map[string]string{"job": "api"}, Exemplar: &ingestion.Exemplar{Value: 1}},
+	}}
+	s := NewMetricsService(WithExemplarStore(store))
+
+	tests := []struct {
+		name     string
+		req      *metrics.QueryExemplarsRequest
+		want     []string
+		wantCode codes.Code
+	}{
+		{
+			name: "filters by label and groups by series",
+			req:  &metrics.QueryExemplarsRequest{Query: `request_seconds_bucket{job=~"a.*"}`, Start: "0", End: "10"},
+			want: []string{"map[__name__:request_seconds_bucket job:api le:0.5] [map[trace_id:t1000] 0.2@1000 map[trace_id:t2000] 0.3@2000]"},
+		},
+		{
+			name: "every series",
+			req:  &metrics.QueryExemplarsRequest{Query: `{__name__="request_seconds_bucket"}`, Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:00:10Z"},
+			want: []string{
+				"map[__name__:request_seconds_bucket job:api le:0.5] [map[trace_id:t1000] 0.2@1000 map[trace_id:t2000] 0.3@2000]",
+				"map[__name__:request_seconds_bucket job:web le:0.5] [map[trace_id:t1000] 0.1@1000]",
+			},
+		},
+		{
+			name:     "selector without metric name",
+			req:      &metrics.QueryExemplarsRequest{Query: `{job="api"}`, Start: "0", End: "10"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid selector",
+			req:      &metrics.QueryExemplarsRequest{Query: `up{job=}`, Start: "0", End: "10"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "end before start",
+			req:      &metrics.QueryExemplarsRequest{Query: `up`, Start: "10", End: "0"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.QueryExemplars(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("QueryExemplars() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for _, series := range resp.Series {
+				var exemplars []string
+				for _, e := range series.Exemplars {
+					exemplars = append(exemplars, fmt.Sprintf("%v %g@%d", e.Labels, e.Value, e.Timestamp))
+				}
+				got = append(got, fmt.Sprintf("%v %v", series.Labels, exemplars))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("QueryExemplars() =\n%v\nwant\n%v", got, tt.want)
+			}
+			if !store.end.Equal(time.Unix(10, 0)) {
+				t.Errorf("QueryExemplars() queried until %v, want %v", store.end, time.Unix(10, 0))
+			}
+		})
+	}
+}
+
+func Test_remoteWrite(t *testing.T) {
+	var headers http.Header
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers, path = r.Header, r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	series := []*metrics.Timeseries{{
+		Labels:  map[string]string{"__name__": "up"},
+		Samples: []*metrics.Sample{{Value: 1, Timestamp: 1000, Exemplar: &ingestion.Exemplar{Value: 1}}},
+	}}
+	if err := remoteWrite(context.Background(), server.URL, series); err != nil {
+		t.Fatalf("remoteWrite() error = %v", err)
+	}
+	if path != "/api/v1/write" || headers.Get("Content-Encoding") != "snappy" || headers.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("remoteWrite() sent to %s with headers %v", path, headers)
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+// MetadataSource provides the registered metadata of metrics, it is
+// implemented by the ingestion service
+type MetadataSource interface {
+	GetMetricMetadata(ctx context.Context, req *ingestion.GetMetricMetadataRequest) (*ingestion.GetMetricMetadataResponse, error)
+}
+
+// WithMetadataSource sets the source of the metadata included in query responses
+func WithMetadataSource(source MetadataSource) Option {
+	return func(s *MetricsService) {
+		s.metadata = source
+	}
+}
+
+// attachMetadata adds the registered metadata of every metric in resp.
+// Metrics without metadata are left out.
+func (s *MetricsService) attachMetadata(ctx context.Context, resp *metrics.ReadResponse) {
+	if s.metadata == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, ts := range resp.Timeseries {
+		name := ts.Labels["__name__"]
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		metadataResp, err := s.metadata.GetMetricMetadata(ctx, &ingestion.GetMetricMetadataRequest{MetricName: name})
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				log.Printf("Error getting metadata of %s: %v", name, err)
+			}
+			continue
+		}
+
+		if resp.Metadata == nil {
+			resp.Metadata = make(map[string]*ingestion.MetricMetadata)
+		}
+		resp.Metadata[name] = metadataResp.Metadata
+	}
+}
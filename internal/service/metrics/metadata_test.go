@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeMetadataSource struct {
+	calls int
+}
+
+func (f *fakeMetadataSource) GetMetricMetadata(ctx context.Context, req *ingestion.GetMetricMetadataRequest) (*ingestion.GetMetricMetadataResponse, error) {
+	f.calls++
+	if req.MetricName != "up" {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return &ingestion.GetMetricMetadataResponse{Metadata: &ingestion.MetricMetadata{MetricName: "up", Type: ingestion.MetricType_METRIC_TYPE_GAUGE}}, nil
+}
+
+func TestMetricsService_attachMetadata(t *testing.T) {
+	source := &fakeMetadataSource{}
+	s := NewMetricsService(WithMetadataSource(source))
+
+	resp := &metrics.ReadResponse{Timeseries: []*metrics.TimeseriesData{
+		{Labels: map[string]string{"__name__": "up", "job": "a"}},
+		{Labels: map[string]string{"__name__": "up", "job": "b"}},
+		{Labels: map[string]string{"__name__": "unknown"}},
+		{Labels: map[string]string{"job": "c"}},
+	}}
+	s.attachMetadata(context.Background(), resp)
+
+	if len(resp.Metadata) != 1 || resp.Metadata["up"].GetType() != ingestion.MetricType_METRIC_TYPE_GAUGE {
+		t.Errorf("attachMetadata() metadata = %v, want metadata of up only", resp.Metadata)
+	}
+	if source.calls != 2 {
+		t.Errorf("attachMetadata() looked up metadata %d times, want 2", source.calls)
+	}
+}
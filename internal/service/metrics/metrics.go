@@ -10,83 +10,137 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"time"
 
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yay14/pulse/internal/format"
+	"github.com/yay14/pulse/internal/histogram"
+	"github.com/yay14/pulse/internal/promql"
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/internal/wal"
 	"github.com/yay14/pulse/metrics"
 )
 
 type MetricsService struct {
 	metrics.UnimplementedMetricsServiceServer
+	cache     *QueryCache
+	metadata  MetadataSource
+	exemplars ExemplarStore
+	raw       RawStore
+	engine    *promql.Engine
+	wal       *walState
+	relabel   *relabel.RuleSet
+	limiter   *ratelimit.Limiter
 }
 
+// Option configures a MetricsService
+type Option func(*MetricsService)
+
 // NewIngestionService creates a new IngestionService
-func NewMetricsService() *MetricsService {
-	return &MetricsService{}
+func NewMetricsService(opts ...Option) *MetricsService {
+	s := &MetricsService{cache: NewQueryCache(defaultQueryCacheSize)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-
-// WriteMetrics writes metrics to VictoriaMetrics
+// WriteMetrics writes metrics of the tenant of ctx to VictoriaMetrics, or
+// appends them to the WAL to be replayed to VictoriaMetrics by RunWALReplay.
+// Writes past the rate limit of their source are rejected.
 func (s *MetricsService) WriteMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	samples := 0
+	for _, ts := range req.Timeseries {
+		samples += len(ts.Samples)
+	}
+	tenantID := tenant.FromContext(ctx)
+	if wait := s.limiter.Reserve(tenantID, req.SourceType, req.SourceId, samples, proto.Size(req)); wait > 0 {
+		log.Printf("Rate limiting source %s for %v", req.SourceId, wait)
+		return &metrics.WriteResponse{Status: "Rate limit exceeded"}, ratelimit.Exceeded(ctx, req.SourceId, wait)
+	}
+
+	if dropped := s.relabelTimeseries(req); dropped > 0 {
+		log.Printf("Relabeling dropped %d series", dropped)
+		if len(req.Timeseries) == 0 {
+			return &metrics.WriteResponse{Status: "All series dropped by relabeling"}, nil
+		}
+	}
+	setTenantLabel(req.Timeseries, tenantID)
+
+	if s.wal != nil {
+		return s.appendWAL(req)
+	}
+	return s.sendToVictoriaMetrics(ctx, req)
+}
+
+// sendToVictoriaMetrics writes metrics to VictoriaMetrics
+func (s *MetricsService) sendToVictoriaMetrics(ctx context.Context, req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
 	vmURL := os.Getenv("VICTORIA_METRICS_URL")
-	log.Printf("VictoriaMetrics URL: %s", vmURL)
 
 	// Prepare data for VictoriaMetrics in the correct format
 	var jsonData []string
+	var withExemplars []*metrics.Timeseries
 	for _, ts := range req.Timeseries {
-		// Prepare the metric object
-		metric := map[string]interface{}{
-			"__name__": ts.Labels["__name__"], // Ensure the metric name is included
-		}
-
-		// Add additional labels to the metric object
-		for key, value := range ts.Labels {
-			if key != "__name__" { // Skip the metric name
-				metric[key] = value
+		// Histogram and summary samples are written as separate float series
+		for _, series := range histogram.Expand(ts) {
+			// The JSON import format has no exemplars, they go through remote write
+			if hasExemplars(series) {
+				withExemplars = append(withExemplars, series)
+				continue
 			}
-		}
 
-		// Prepare values and timestamps slices
-		values := make([]float64, len(ts.Samples))
-		timestamps := make([]int64, len(ts.Samples))
-
-		for i, sample := range ts.Samples {
-			values[i] = sample.Value
-			timestamps[i] = sample.Timestamp // Ensure timestamps are in milliseconds
-		}
-
-		// Create the JSON object for the current time series
-		jsonLine := map[string]interface{}{
-			"metric":     metric,
-			"values":     values,
-			"timestamps": timestamps,
-		}
+			// Marshal the timeseries to a JSON line
+			jsonDataLine, err := format.MarshalJSONLine(series)
+			if err != nil {
+				return &metrics.WriteResponse{Status: "Error marshalling data"}, wal.Permanent(err)
+			}
 
-		// Marshal the JSON object to a string
-		jsonDataLine, err := json.Marshal(jsonLine)
-		if err != nil {
-			return &metrics.WriteResponse{Status: "Error marshalling data"}, err
+			// Append the JSON line to the data slice
+			jsonData = append(jsonData, string(jsonDataLine))
 		}
-
-		// Append the JSON line to the data slice
-		jsonData = append(jsonData, string(jsonDataLine))
 	}
 
 	// Send data to VictoriaMetrics
 	for _, line := range jsonData {
-		_, err := http.Post(vmURL+"/api/v1/import", "application/json", bytes.NewReader([]byte(line)))
+		resp, err := http.Post(vmURL+"/api/v1/import", "application/json", bytes.NewReader([]byte(line)))
 		if err != nil {
 			return &metrics.WriteResponse{Status: "Failed to send data to VictoriaMetrics"}, err
 		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return &metrics.WriteResponse{Status: "Failed to send data to VictoriaMetrics"}, responseError(resp)
+		}
+	}
+	if len(withExemplars) > 0 {
+		if err := remoteWrite(ctx, vmURL, withExemplars); err != nil {
+			return &metrics.WriteResponse{Status: "Failed to send data to VictoriaMetrics"}, err
+		}
 	}
 	return &metrics.WriteResponse{Status: "Data sent to VictoriaMetrics successfully"}, nil
 }
 
 func (s *MetricsService) InstantQueryMetrics(ctx context.Context, req *metrics.InstantQueryReadRequest) (*metrics.ReadResponse, error) {
+	if req.Backend == metrics.QueryBackend_QUERY_BACKEND_CASSANDRA {
+		timeseries, err := s.engineQuery(ctx, req.Query, time.Now(), time.Time{}, 0)
+		if err != nil {
+			return &metrics.ReadResponse{}, err
+		}
+		readResponse := &metrics.ReadResponse{Timeseries: timeseries}
+		if req.IncludeMetadata {
+			s.attachMetadata(ctx, readResponse)
+		}
+		return readResponse, nil
+	}
+
 	vmURL := os.Getenv("VICTORIA_METRICS_URL")
 
 	// Send the request to VictoriaMetrics
 	encodedQuery := url.QueryEscape(req.Query)
 
-	queryEndpoint := fmt.Sprintf("%s/api/v1/query?query=%s", vmURL, encodedQuery)
+	queryEndpoint := tenantEndpoint(ctx, fmt.Sprintf("%s/api/v1/query?query=%s", vmURL, encodedQuery))
 	log.Printf("Querying VictoriaMetrics at %s with query: %s", vmURL, queryEndpoint)
 
 	resp, err := http.Get(queryEndpoint)
@@ -113,8 +167,8 @@ func (s *MetricsService) InstantQueryMetrics(ctx context.Context, req *metrics.I
 			} `json:"result"`
 		} `json:"data"`
 		Stats struct {
-			SeriesFetched     string `json: "seriesFetched"`
-			ExecutionTimeMsec int64  `json: "executionTimeMsec"`
+			SeriesFetched     string `json:"seriesFetched"`
+			ExecutionTimeMsec int64  `json:"executionTimeMsec"`
 		} `json:"stats"`
 	}
 
@@ -123,7 +177,7 @@ func (s *MetricsService) InstantQueryMetrics(ctx context.Context, req *metrics.I
 		return &metrics.ReadResponse{}, err
 	}
 
-	log.Printf("Decoded response: %+v", vmResponse)
+	log.Printf("Decoded %d results from VictoriaMetrics", len(vmResponse.Data.Result))
 
 	readResponse := &metrics.ReadResponse{}
 
@@ -146,14 +200,45 @@ func (s *MetricsService) InstantQueryMetrics(ctx context.Context, req *metrics.I
 		readResponse.Timeseries = append(readResponse.Timeseries, timeseries)
 	}
 
-	log.Printf("Final ReadResponse: %+v", readResponse)
+	if req.IncludeMetadata {
+		s.attachMetadata(ctx, readResponse)
+	}
 
 	return readResponse, nil
 }
 
 func (s *MetricsService) RangeQueryMetrics(ctx context.Context, req *metrics.RangeQueryReadRequest) (*metrics.ReadResponse, error) {
+	if req.Backend == metrics.QueryBackend_QUERY_BACKEND_CASSANDRA {
+		timeseries, err := s.engineRangeQuery(ctx, req)
+		if err != nil {
+			return &metrics.ReadResponse{}, err
+		}
+		readResponse := &metrics.ReadResponse{Timeseries: timeseries}
+		if req.IncludeMetadata {
+			s.attachMetadata(ctx, readResponse)
+		}
+		return readResponse, nil
+	}
+
 	vmURL := os.Getenv("VICTORIA_METRICS_URL")
 
+	// Serve range queries from the query cache when the range can be split
+	if s.cache != nil && req.Start != "" && req.End != "" && req.Step != "" {
+		start, end, step, err := parseRange(req.Start, req.End, req.Step)
+		if err == nil {
+			timeseries, err := s.cachedRangeQuery(ctx, vmURL, req.Query, start, end, step)
+			if err != nil {
+				return &metrics.ReadResponse{}, err
+			}
+			readResponse := &metrics.ReadResponse{Timeseries: timeseries}
+			if req.IncludeMetadata {
+				s.attachMetadata(ctx, readResponse)
+			}
+			return readResponse, nil
+		}
+		log.Printf("Bypassing query cache for unparseable range: %v", err)
+	}
+
 	// Encode the query
 	encodedQuery := url.QueryEscape(req.Query)
 
@@ -168,66 +253,34 @@ func (s *MetricsService) RangeQueryMetrics(ctx context.Context, req *metrics.Ran
 		queryEndpoint = fmt.Sprintf("%s/api/v1/query?query=%s", vmURL, encodedQuery)
 	}
 
-	log.Printf("Querying VictoriaMetrics with query: %s", queryEndpoint)
-
-	resp, err := http.Get(queryEndpoint)
+	timeseries, err := queryRange(ctx, tenantEndpoint(ctx, queryEndpoint))
 	if err != nil {
-		log.Printf("Error sending request to VictoriaMetrics: %v", err)
 		return &metrics.ReadResponse{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Unexpected response status: %s", resp.Status)
-		return &metrics.ReadResponse{}, fmt.Errorf("unexpected response status: %s", resp.Status)
+	readResponse := &metrics.ReadResponse{Timeseries: timeseries}
+	if req.IncludeMetadata {
+		s.attachMetadata(ctx, readResponse)
 	}
 
-	var vmResponse struct {
-		Status string `json:"status"`
-		Data   struct {
-			ResultType string `json:"resultType"`
-			Result     []struct {
-				Metric map[string]string `json:"metric"`
-				Values [][]interface{}   `json:"values"` // Array of [timestamp, value]
-			} `json:"result"`
-		} `json:"data"`
-		Stats struct {
-			SeriesFetched     string `json:"seriesFetched"`
-			ExecutionTimeMsec int64  `json:"executionTimeMsec"`
-		} `json:"stats"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&vmResponse); err != nil {
-		log.Printf("Error decoding response body: %v", err)
-		return &metrics.ReadResponse{}, err
-	}
-
-	log.Printf("Decoded response: %+v", vmResponse)
-
-	readResponse := &metrics.ReadResponse{}
-
-	for _, result := range vmResponse.Data.Result {
-		timeseries := &metrics.TimeseriesData{
-			Labels: result.Metric,
-		}
-
-		for _, valuePair := range result.Values {
-			timestamp := int64(valuePair[0].(float64))
-			metricValue := valuePair[1].(string)
+	return readResponse, nil
+}
 
-			sample := &metrics.Sample{
-				Value:     mustParseFloat64(metricValue),
-				Timestamp: timestamp,
-			}
-			timeseries.Samples = append(timeseries.Samples, sample)
-		}
+// queryRange sends a query_range request to VictoriaMetrics and collects the matrix result
+func queryRange(ctx context.Context, queryEndpoint string) ([]*metrics.TimeseriesData, error) {
+	var timeseriesList []*metrics.TimeseriesData
 
-		readResponse.Timeseries = append(readResponse.Timeseries, timeseries)
+	err := streamRange(ctx, queryEndpoint, func(timeseries *metrics.TimeseriesData) error {
+		timeseriesList = append(timeseriesList, timeseries)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("Final ReadResponse: %+v", readResponse)
+	log.Printf("Decoded %d timeseries from VictoriaMetrics", len(timeseriesList))
 
-	return readResponse, nil
+	return timeseriesList, nil
 }
 
 func mustParseFloat64(value string) float64 {
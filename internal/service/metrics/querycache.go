@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/metrics"
+)
+
+const (
+	// defaultQueryCacheSize is the number of range query chunks kept in memory
+	defaultQueryCacheSize = 4096
+
+	// maxCacheFreshness keeps chunks that may still receive late samples out of the cache
+	maxCacheFreshness = 10 * time.Minute
+)
+
+// QueryCache caches the results of step-aligned range query chunks in an LRU.
+// Only chunks that lie entirely in the past are cached, recent data is always
+// fetched from VictoriaMetrics.
+type QueryCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ll      *list.List
+	entries map[string]*list.Element
+	hits    uint64
+	misses  uint64
+
+	now func() time.Time
+}
+
+// QueryCacheStats holds the hit and miss counters of a QueryCache
+type QueryCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+type cacheEntry struct {
+	key        string
+	timeseries []*metrics.TimeseriesData
+}
+
+// queryChunk is an inclusive, step-aligned sub range of a range query
+type queryChunk struct {
+	start time.Time
+	end   time.Time
+}
+
+// NewQueryCache creates a QueryCache holding at most maxSize chunks
+func NewQueryCache(maxSize int) *QueryCache {
+	return &QueryCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Stats returns the current hit and miss counters
+func (c *QueryCache) Stats() QueryCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return QueryCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.ll.Len()}
+}
+
+func (c *QueryCache) get(key string) ([]*metrics.TimeseriesData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).timeseries, true
+}
+
+func (c *QueryCache) put(key string, timeseries []*metrics.TimeseriesData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).timeseries = timeseries
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.ll.PushFront(&cacheEntry{key: key, timeseries: timeseries})
+	for c.ll.Len() > c.maxSize {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// GetQueryCacheStats reports the hit and miss statistics of the range query cache
+func (s *MetricsService) GetQueryCacheStats(ctx context.Context, req *metrics.QueryCacheStatsRequest) (*metrics.QueryCacheStatsResponse, error) {
+	if s.cache == nil {
+		return &metrics.QueryCacheStatsResponse{}, nil
+	}
+
+	stats := s.cache.Stats()
+	return &metrics.QueryCacheStatsResponse{
+		Hits:    stats.Hits,
+		Misses:  stats.Misses,
+		Entries: int64(stats.Entries),
+	}, nil
+}
+
+// cachedRangeQuery answers a range query chunk by chunk. Past chunks are served
+// from the cache when possible, the mutable tail is fetched in a single request.
+func (s *MetricsService) cachedRangeQuery(ctx context.Context, vmURL, query string, start, end time.Time, step time.Duration) ([]*metrics.TimeseriesData, error) {
+	cutoff := s.cache.now().Add(-maxCacheFreshness)
+
+	var results [][]*metrics.TimeseriesData
+	for _, chunk := range splitRange(start, end, step) {
+		if chunk.end.After(cutoff) {
+			// Everything from here on may still change, fetch it in one go
+			timeseries, err := queryRange(ctx, tenantEndpoint(ctx, rangeEndpoint(vmURL, query, chunk.start, end, step)))
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, timeseries)
+			break
+		}
+
+		key := chunkKey(tenant.FromContext(ctx), query, step, chunk)
+		if timeseries, ok := s.cache.get(key); ok {
+			results = append(results, timeseries)
+			continue
+		}
+
+		timeseries, err := queryRange(ctx, tenantEndpoint(ctx, rangeEndpoint(vmURL, query, chunk.start, chunk.end, step)))
+		if err != nil {
+			return nil, err
+		}
+		s.cache.put(key, timeseries)
+		results = append(results, timeseries)
+	}
+
+	return mergeTimeseries(results), nil
+}
+
+// splitRange aligns start and end to step and splits the range into chunks
+// that never cross an hour or day boundary, so they can be reused by later
+// queries over overlapping windows.
+func splitRange(start, end time.Time, step time.Duration) []queryChunk {
+	stepMs := step.Milliseconds()
+	startMs := start.UnixMilli()
+	endMs := end.UnixMilli()
+	startMs -= startMs % stepMs
+	endMs -= endMs % stepMs
+
+	interval := splitInterval(end.Sub(start), step).Milliseconds()
+	if interval <= stepMs {
+		return []queryChunk{{start: time.UnixMilli(startMs), end: time.UnixMilli(endMs)}}
+	}
+
+	var chunks []queryChunk
+	for chunkStart := startMs; chunkStart <= endMs; {
+		// Last step-aligned point before the next interval boundary
+		chunkEnd := (chunkStart/interval+1)*interval - 1
+		chunkEnd -= chunkEnd % stepMs
+		if chunkEnd < chunkStart {
+			chunkEnd = chunkStart
+		}
+		if chunkEnd > endMs {
+			chunkEnd = endMs
+		}
+
+		chunks = append(chunks, queryChunk{start: time.UnixMilli(chunkStart), end: time.UnixMilli(chunkEnd)})
+		chunkStart = chunkEnd + stepMs
+	}
+
+	return chunks
+}
+
+// splitInterval picks day chunks for long ranges or coarse steps and hour chunks otherwise
+func splitInterval(rangeDuration, step time.Duration) time.Duration {
+	if rangeDuration > 24*time.Hour || step >= time.Hour {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+func chunkKey(tenantID, query string, step time.Duration, chunk queryChunk) string {
+	return fmt.Sprintf("%s|%s|%d|%d|%d", tenantID, query, step.Milliseconds(), chunk.start.UnixMilli(), chunk.end.UnixMilli())
+}
+
+func rangeEndpoint(vmURL, query string, start, end time.Time, step time.Duration) string {
+	return fmt.Sprintf("%s/api/v1/query_range?query=%s&start=%s&end=%s&step=%s",
+		vmURL, url.QueryEscape(query), formatSeconds(start), formatSeconds(end), strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+}
+
+func formatSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+// mergeTimeseries joins the per-chunk results into one series per label set
+func mergeTimeseries(results [][]*metrics.TimeseriesData) []*metrics.TimeseriesData {
+	var merged []*metrics.TimeseriesData
+	index := make(map[string]*metrics.TimeseriesData)
+
+	for _, timeseriesList := range results {
+		for _, ts := range timeseriesList {
+			key := labelsKey(ts.Labels)
+			existing, ok := index[key]
+			if !ok {
+				existing = &metrics.TimeseriesData{Labels: ts.Labels}
+				index[key] = existing
+				merged = append(merged, existing)
+			}
+			existing.Samples = append(existing.Samples, ts.Samples...)
+		}
+	}
+
+	return merged
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(labels[key])
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// parseRange parses the start, end and step of a RangeQueryReadRequest.
+// Timestamps may be unix seconds or RFC3339, step a Go duration or seconds.
+func parseRange(start, end, step string) (time.Time, time.Time, time.Duration, error) {
+	startTime, err := parseTimestamp(start)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid start: %w", err)
+	}
+
+	endTime, err := parseTimestamp(end)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid end: %w", err)
+	}
+
+	stepDuration, err := time.ParseDuration(step)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(step, 64)
+		if parseErr != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid step: %w", err)
+		}
+		stepDuration = time.Duration(seconds * float64(time.Second))
+	}
+
+	if stepDuration < time.Millisecond {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("step must be at least 1ms")
+	}
+	if endTime.Before(startTime) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("end is before start")
+	}
+
+	return startTime, endTime, stepDuration, nil
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(seconds * 1000)), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+func Test_splitRange(t *testing.T) {
+	type args struct {
+		start time.Time
+		end   time.Time
+		step  time.Duration
+	}
+	tests := []struct {
+		name string
+		args args
+		want []queryChunk
+	}{
+		{
+			name: "range within one hour",
+			args: args{
+				start: time.Unix(3600, 0),
+				end:   time.Unix(3600+600, 0),
+				step:  time.Minute,
+			},
+			want: []queryChunk{
+				{start: time.Unix(3600, 0), end: time.Unix(3600+600, 0)},
+			},
+		},
+		{
+			name: "range crossing hour boundaries is step aligned",
+			args: args{
+				start: time.Unix(3000+17, 0),
+				end:   time.Unix(7200+1800+5, 0),
+				step:  time.Minute,
+			},
+			want: []queryChunk{
+				{start: time.Unix(3000, 0), end: time.Unix(3540, 0)},
+				{start: time.Unix(3600, 0), end: time.Unix(7140, 0)},
+				{start: time.Unix(7200, 0), end: time.Unix(9000, 0)},
+			},
+		},
+		{
+			name: "step larger than the split interval",
+			args: args{
+				start: time.Unix(0, 0),
+				end:   time.Unix(7*86400, 0),
+				step:  48 * time.Hour,
+			},
+			want: []queryChunk{
+				{start: time.Unix(0, 0), end: time.Unix(6*86400, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRange(tt.args.start, tt.args.end, tt.args.step); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		step      string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantStep  time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      "unix seconds and duration step",
+			start:     "1620474600",
+			end:       "1620478200.5",
+			step:      "15s",
+			wantStart: time.Unix(1620474600, 0),
+			wantEnd:   time.UnixMilli(1620478200500),
+			wantStep:  15 * time.Second,
+		},
+		{
+			name:      "rfc3339 and numeric step",
+			start:     "2021-05-08T12:00:00Z",
+			end:       "2021-05-08T13:00:00Z",
+			step:      "60",
+			wantStart: time.Date(2021, 5, 8, 12, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2021, 5, 8, 13, 0, 0, 0, time.UTC),
+			wantStep:  time.Minute,
+		},
+		{
+			name:    "end before start",
+			start:   "200",
+			end:     "100",
+			step:    "1s",
+			wantErr: true,
+		},
+		{
+			name:    "invalid step",
+			start:   "100",
+			end:     "200",
+			step:    "now",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, step, err := parseRange(tt.start, tt.end, tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) || step != tt.wantStep {
+				t.Errorf("parseRange() = %v, %v, %v, want %v, %v, %v", start, end, step, tt.wantStart, tt.wantEnd, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestQueryCache_Eviction(t *testing.T) {
+	cache := NewQueryCache(2)
+	cache.put("a", nil)
+	cache.put("b", nil)
+
+	// Touch a so that b becomes the least recently used entry
+	if _, ok := cache.get("a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	cache.put("c", nil)
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Errorf("expected c to be cached")
+	}
+
+	want := QueryCacheStats{Hits: 2, Misses: 1, Entries: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("QueryCache.Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMetricsService_RangeQueryMetrics_Cached(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		start, _ := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+		end, _ := strconv.ParseFloat(r.URL.Query().Get("end"), 64)
+		step, _ := strconv.ParseFloat(r.URL.Query().Get("step"), 64)
+
+		values := ""
+		for ts := start; ts <= end; ts += step {
+			if values != "" {
+				values += ","
+			}
+			values += fmt.Sprintf(`[%d,"1"]`, int64(ts))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[%s]}]}}`, values)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	s := NewMetricsService()
+	s.cache.now = func() time.Time { return time.Unix(12000, 0) }
+
+	req := &metrics.RangeQueryReadRequest{Query: "up", Start: "0", End: "14400", Step: "1800"}
+	for i := 0; i < 2; i++ {
+		resp, err := s.RangeQueryMetrics(context.Background(), req)
+		if err != nil {
+			t.Fatalf("MetricsService.RangeQueryMetrics() error = %v", err)
+		}
+		if len(resp.Timeseries) != 1 || len(resp.Timeseries[0].Samples) != 9 {
+			t.Fatalf("MetricsService.RangeQueryMetrics() = %v, want 1 series with 9 samples", resp.Timeseries)
+		}
+	}
+
+	// Three immutable hour chunks are fetched once, the recent tail every time
+	if got := atomic.LoadInt32(&requests); got != 5 {
+		t.Errorf("backend requests = %d, want 5", got)
+	}
+	want := QueryCacheStats{Hits: 3, Misses: 3, Entries: 3}
+	if got := s.cache.Stats(); got != want {
+		t.Errorf("QueryCache.Stats() = %+v, want %+v", got, want)
+	}
+}
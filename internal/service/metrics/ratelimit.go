@@ -0,0 +1,11 @@
+package metrics
+
+import "github.com/yay14/pulse/internal/ratelimit"
+
+// WithRateLimiter limits the samples and bytes every source may write per
+// second, keyed by the source of a WriteRequest
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(s *MetricsService) {
+		s.limiter = limiter
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/ratelimit"
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestMetricsService_WriteMetrics_RateLimited(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Config{SourceTypes: map[string]ratelimit.Limit{"app": {SamplesPerSecond: 2}}})
+	s := NewMetricsService(WithRateLimiter(limiter))
+
+	req := &metrics.WriteRequest{SourceId: "api", SourceType: "app", Timeseries: []*metrics.Timeseries{{
+		Labels:  map[string]string{"__name__": "up"},
+		Samples: []*metrics.Sample{{Value: 1}, {Value: 1}},
+	}}}
+	limiter.Reserve("", "app", "api", 1, 0)
+	_, err := s.WriteMetrics(context.Background(), req)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("WriteMetrics() error = %v, want ResourceExhausted", err)
+	}
+	if wait, ok := ratelimit.RetryAfter(err); !ok || wait <= 0 {
+		t.Errorf("RetryAfter() = %v, %v, want a positive delay", wait, ok)
+	}
+}
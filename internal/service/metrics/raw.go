@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/rollup"
+	"github.com/yay14/pulse/metrics"
+)
+
+// rawResolution is the resolution reported for reads of raw samples
+const rawResolution = "raw"
+
+// RawStore reads raw samples, rollups and how far they were rolled up, it is
+// implemented by the Cassandra repository
+type RawStore interface {
+	ReadRawSamples(ctx context.Context, metricName string, start, end time.Time) ([]rollup.Sample, error)
+	ReadRollups(ctx context.Context, res rollup.Resolution, metricName string, start, end time.Time) ([]rollup.Aggregate, error)
+	RollupCheckpoint(ctx context.Context, res rollup.Resolution) (time.Time, error)
+}
+
+// WithRawStore sets the store read by ReadRaw
+func WithRawStore(store RawStore) Option {
+	return func(s *MetricsService) {
+		s.raw = store
+	}
+}
+
+// ReadRaw reads a metric from Cassandra. With a step, the rollups of the
+// coarsest resolution not exceeding the step are read instead of raw samples,
+// the intervals that are not rolled up yet are aggregated from finer data.
+func (s *MetricsService) ReadRaw(ctx context.Context, req *metrics.RawReadRequest) (*metrics.RawReadResponse, error) {
+	if s.raw == nil {
+		return &metrics.RawReadResponse{}, status.Error(codes.Unimplemented, "no raw store configured")
+	}
+	if req.MetricName == "" {
+		return &metrics.RawReadResponse{}, status.Error(codes.InvalidArgument, "metric name is required")
+	}
+	if _, ok := (&rollup.Aggregate{Count: 1}).Value(req.Aggregate); !ok {
+		return &metrics.RawReadResponse{}, status.Errorf(codes.InvalidArgument, "unknown aggregate %q", req.Aggregate)
+	}
+
+	start, end, step, err := parseRawRange(req.Start, req.End, req.Step)
+	if err != nil {
+		return &metrics.RawReadResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, ok := rollup.PickResolution(step)
+	if !ok {
+		samples, err := s.raw.ReadRawSamples(ctx, req.MetricName, start, end)
+		if err != nil {
+			log.Printf("Error reading raw samples of %s: %v", req.MetricName, err)
+			return &metrics.RawReadResponse{}, err
+		}
+
+		series := newRawSeries(req.MetricName)
+		for _, sample := range samples {
+			if err := series.add(sample.Labels, sample.Value, sample.Timestamp); err != nil {
+				return &metrics.RawReadResponse{}, err
+			}
+		}
+		return &metrics.RawReadResponse{Resolution: rawResolution, Timeseries: series.sorted()}, nil
+	}
+
+	aggs, err := s.readAggregates(ctx, resolutionIndex(res), req.MetricName, start, end)
+	if err != nil {
+		log.Printf("Error reading %s rollups of %s: %v", res.Name, req.MetricName, err)
+		return &metrics.RawReadResponse{}, err
+	}
+
+	series := newRawSeries(req.MetricName)
+	for _, a := range aggs {
+		value, _ := a.Value(req.Aggregate)
+		if err := series.add(a.Labels, value, a.Timestamp); err != nil {
+			return &metrics.RawReadResponse{}, err
+		}
+	}
+	return &metrics.RawReadResponse{Resolution: res.Name, Timeseries: series.sorted()}, nil
+}
+
+// readAggregates returns the aggregates of rollup.Resolutions[i] of a metric
+// between start, inclusive, and end, exclusive. The intervals after the
+// checkpoint of the resolution, which the rollup worker has not written yet,
+// are aggregated from the finer resolutions or the raw samples.
+func (s *MetricsService) readAggregates(ctx context.Context, i int, metricName string, start, end time.Time) ([]rollup.Aggregate, error) {
+	res := rollup.Resolutions[i]
+	checkpoint, err := s.raw.RollupCheckpoint(ctx, res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s checkpoint: %w", res.Name, err)
+	}
+
+	var aggs []rollup.Aggregate
+	if checkpoint.After(start) {
+		rolledUp := end
+		if checkpoint.Before(end) {
+			rolledUp = checkpoint
+		}
+		if aggs, err = s.raw.ReadRollups(ctx, res, metricName, start, rolledUp); err != nil {
+			return nil, err
+		}
+		start = rolledUp
+	}
+	if !start.Before(end) {
+		return aggs, nil
+	}
+
+	if i == 0 {
+		samples, err := s.raw.ReadRawSamples(ctx, metricName, start, end)
+		if err != nil {
+			return nil, err
+		}
+		return append(aggs, rollup.AggregateSamples(samples, res.Step)...), nil
+	}
+	finer, err := s.readAggregates(ctx, i-1, metricName, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return append(aggs, rollup.MergeAggregates(finer, res.Step)...), nil
+}
+
+// resolutionIndex returns the index of a resolution in rollup.Resolutions
+func resolutionIndex(res rollup.Resolution) int {
+	for i, r := range rollup.Resolutions {
+		if r.Name == res.Name {
+			return i
+		}
+	}
+	return 0
+}
+
+// parseRawRange parses the range of a RawReadRequest, the step is optional
+func parseRawRange(start, end, step string) (time.Time, time.Time, time.Duration, error) {
+	if step != "" {
+		return parseRange(start, end, step)
+	}
+
+	startTime, err := parseTimestamp(start)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid start: %w", err)
+	}
+	endTime, err := parseTimestamp(end)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("invalid end: %w", err)
+	}
+	if endTime.Before(startTime) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("end is before start")
+	}
+	return startTime, endTime, 0, nil
+}
+
+// rawSeries groups samples by the JSON labels stored with them
+type rawSeries struct {
+	name  string
+	index map[string]*metrics.TimeseriesData
+	list  []*metrics.TimeseriesData
+}
+
+func newRawSeries(name string) *rawSeries {
+	return &rawSeries{name: name, index: make(map[string]*metrics.TimeseriesData)}
+}
+
+func (r *rawSeries) add(labelsJSON string, value float64, timestamp time.Time) error {
+	ts, ok := r.index[labelsJSON]
+	if !ok {
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
+			return fmt.Errorf("failed to unmarshal labels: %w", err)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels["__name__"] = r.name
+
+		ts = &metrics.TimeseriesData{Labels: labels}
+		r.index[labelsJSON] = ts
+		r.list = append(r.list, ts)
+	}
+	ts.Samples = append(ts.Samples, &metrics.Sample{Value: value, Timestamp: timestamp.UnixMilli()})
+	return nil
+}
+
+// sorted returns the series with their samples ordered by timestamp
+func (r *rawSeries) sorted() []*metrics.TimeseriesData {
+	for _, ts := range r.list {
+		samples := ts.Samples
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+	}
+	return r.list
+}
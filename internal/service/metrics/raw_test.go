@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yay14/pulse/internal/rollup"
+	"github.com/yay14/pulse/metrics"
+)
+
+type fakeRawStore struct {
+	samples     []rollup.Sample
+	rollups     map[string][]rollup.Aggregate
+	checkpoints map[string]time.Time
+}
+
+func (f *fakeRawStore) ReadRawSamples(ctx context.Context, metricName string, start, end time.Time) ([]rollup.Sample, error) {
+	var result []rollup.Sample
+	for _, sample := range f.samples {
+		if !sample.Timestamp.Before(start) && sample.Timestamp.Before(end) {
+			result = append(result, sample)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeRawStore) ReadRollups(ctx context.Context, res rollup.Resolution, metricName string, start, end time.Time) ([]rollup.Aggregate, error) {
+	var result []rollup.Aggregate
+	for _, a := range f.rollups[res.Name] {
+		if !a.Timestamp.Before(start) && a.Timestamp.Before(end) {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeRawStore) RollupCheckpoint(ctx context.Context, res rollup.Resolution) (time.Time, error) {
+	return f.checkpoints[res.Name], nil
+}
+
+func TestMetricsService_ReadRaw(t *testing.T) {
+	store := &fakeRawStore{
+		samples: []rollup.Sample{
+			{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.UnixMilli(2000), Value: 2},
+			{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.UnixMilli(1000), Value: 1},
+			{MetricName: "cpu", Labels: `null`, Timestamp: time.UnixMilli(1000), Value: 3},
+		},
+		rollups: map[string][]rollup.Aggregate{
+			"5m": {{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.UnixMilli(0), Min: 1, Max: 5, Sum: 6, Count: 2, Last: 5}},
+		},
+		checkpoints: map[string]time.Time{"1m": time.Unix(3600, 0), "5m": time.Unix(3600, 0), "1h": time.Unix(3600, 0)},
+	}
+	s := NewMetricsService(WithRawStore(store))
+
+	// The 5m rollups end at 300s, the 1m rollups at 360s and raw samples follow
+	partial := NewMetricsService(WithRawStore(&fakeRawStore{
+		samples: []rollup.Sample{
+			{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.Unix(400, 0), Value: 1},
+			{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.Unix(420, 0), Value: 2},
+		},
+		rollups: map[string][]rollup.Aggregate{
+			"1m": {
+				{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.Unix(240, 0), Min: 9, Max: 9, Sum: 9, Count: 1, Last: 9},
+				{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.Unix(300, 0), Min: 4, Max: 4, Sum: 4, Count: 1, Last: 4},
+			},
+			"5m": {{MetricName: "cpu", Labels: `{"host":"a"}`, Timestamp: time.Unix(0, 0), Min: 1, Max: 5, Sum: 6, Count: 2, Last: 5}},
+		},
+		checkpoints: map[string]time.Time{"1m": time.Unix(360, 0), "5m": time.Unix(300, 0)},
+	}))
+
+	tests := []struct {
+		name           string
+		service        *MetricsService
+		req            *metrics.RawReadRequest
+		wantResolution string
+		want           []string
+		wantCode       codes.Code
+	}{
+		{
+			name:           "raw samples without step",
+			req:            &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "10"},
+			wantResolution: "raw",
+			want:           []string{"map[__name__:cpu host:a] [1@1000 2@2000]", "map[__name__:cpu] [3@1000]"},
+		},
+		{
+			name:           "raw samples below the finest resolution",
+			req:            &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "10", Step: "15s"},
+			wantResolution: "raw",
+			want:           []string{"map[__name__:cpu host:a] [1@1000 2@2000]", "map[__name__:cpu] [3@1000]"},
+		},
+		{
+			name:           "average of the coarsest fitting rollup",
+			req:            &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "3600", Step: "10m"},
+			wantResolution: "5m",
+			want:           []string{"map[__name__:cpu host:a] [3@0]"},
+		},
+		{
+			name:           "max of a rollup",
+			req:            &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "3600", Step: "600", Aggregate: "max"},
+			wantResolution: "5m",
+			want:           []string{"map[__name__:cpu host:a] [5@0]"},
+		},
+		{
+			name:           "intervals after the checkpoint from finer data",
+			service:        partial,
+			req:            &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "600", Step: "5m", Aggregate: "sum"},
+			wantResolution: "5m",
+			want:           []string{"map[__name__:cpu host:a] [6@0 7@300000]"},
+		},
+		{
+			name:     "unknown aggregate",
+			req:      &metrics.RawReadRequest{MetricName: "cpu", Start: "0", End: "10", Aggregate: "median"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "missing metric name",
+			req:      &metrics.RawReadRequest{Start: "0", End: "10"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "end before start",
+			req:      &metrics.RawReadRequest{MetricName: "cpu", Start: "10", End: "0"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service
+			if service == nil {
+				service = s
+			}
+			resp, err := service.ReadRaw(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("ReadRaw() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for _, ts := range resp.Timeseries {
+				var samples []string
+				for _, sample := range ts.Samples {
+					samples = append(samples, fmt.Sprintf("%g@%d", sample.Value, sample.Timestamp))
+				}
+				got = append(got, fmt.Sprintf("%v %v", ts.Labels, samples))
+			}
+			if resp.Resolution != tt.wantResolution || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ReadRaw() = %s %v, want %s %v", resp.Resolution, got, tt.wantResolution, tt.want)
+			}
+		})
+	}
+}
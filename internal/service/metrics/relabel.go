@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/metrics"
+)
+
+// WithRelabeling applies relabel rules to every written series
+func WithRelabeling(rules *relabel.RuleSet) Option {
+	return func(s *MetricsService) {
+		s.relabel = rules
+	}
+}
+
+// relabelTimeseries applies the relabel rules to the series of a write in
+// place, series that are dropped or lose their __name__ label are removed.
+// It returns the number of removed series.
+func (s *MetricsService) relabelTimeseries(req *metrics.WriteRequest) int {
+	rules := s.relabel.Rules()
+	if len(rules) == 0 {
+		return 0
+	}
+
+	kept := req.Timeseries[:0]
+	for _, ts := range req.Timeseries {
+		labels, keep := relabel.Process(ts.Labels, rules)
+		if !keep || labels["__name__"] == "" {
+			continue
+		}
+		ts.Labels = labels
+		kept = append(kept, ts)
+	}
+
+	dropped := len(req.Timeseries) - len(kept)
+	req.Timeseries = kept
+	return dropped
+}
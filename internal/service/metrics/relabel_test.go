@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/yay14/pulse/internal/relabel"
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestMetricsService_relabelTimeseries(t *testing.T) {
+	rules, err := relabel.Compile([]relabel.Config{
+		{SourceLabels: []string{"__name__"}, Regex: "debug_.*", Action: relabel.Drop},
+		{Regex: "pod_(.*)", Replacement: "$1", Action: relabel.LabelMap},
+	})
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	s := NewMetricsService(WithRelabeling(relabel.NewRuleSet(rules)))
+
+	req := &metrics.WriteRequest{Timeseries: []*metrics.Timeseries{
+		{Labels: map[string]string{"__name__": "debug_events"}},
+		{Labels: map[string]string{"__name__": "up", "pod_name": "api-0"}},
+	}}
+	if dropped := s.relabelTimeseries(req); dropped != 1 {
+		t.Errorf("relabelTimeseries() dropped %d series, want 1", dropped)
+	}
+	if len(req.Timeseries) != 1 || req.Timeseries[0].Labels["name"] != "api-0" {
+		t.Errorf("relabelTimeseries() = %v, want up with a name label", req.Timeseries)
+	}
+}
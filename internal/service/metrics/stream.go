@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/yay14/pulse/metrics"
+)
+
+// StreamRangeQuery runs a range query against VictoriaMetrics and sends every
+// resulting timeseries as a separate message. The backend response is decoded
+// incrementally so large exports are never held in memory as a whole.
+func (s *MetricsService) StreamRangeQuery(req *metrics.RangeQueryReadRequest, stream metrics.MetricsService_StreamRangeQueryServer) error {
+	vmURL := os.Getenv("VICTORIA_METRICS_URL")
+
+	if req.Start == "" || req.End == "" || req.Step == "" {
+		return fmt.Errorf("start, end and step are required for a streaming range query")
+	}
+
+	// The Cassandra engine evaluates the whole range before the series are sent
+	if req.Backend == metrics.QueryBackend_QUERY_BACKEND_CASSANDRA {
+		timeseries, err := s.engineRangeQuery(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		for _, ts := range timeseries {
+			if err := stream.Send(ts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	queryEndpoint := fmt.Sprintf("%s/api/v1/query_range?query=%s&start=%s&end=%s&step=%s",
+		vmURL, url.QueryEscape(req.Query), url.QueryEscape(req.Start), url.QueryEscape(req.End), url.QueryEscape(req.Step))
+
+	var sent int
+	err := streamRange(stream.Context(), tenantEndpoint(stream.Context(), queryEndpoint), func(timeseries *metrics.TimeseriesData) error {
+		sent++
+		return stream.Send(timeseries)
+	})
+	if err != nil {
+		log.Printf("Error streaming range query after %d timeseries: %v", sent, err)
+		return err
+	}
+
+	log.Printf("Streamed %d timeseries for query: %s", sent, req.Query)
+	return nil
+}
+
+// streamRange sends a query_range request to VictoriaMetrics and invokes fn for
+// every timeseries of the matrix result as soon as it has been decoded.
+func streamRange(ctx context.Context, queryEndpoint string, fn func(*metrics.TimeseriesData) error) error {
+	log.Printf("Querying VictoriaMetrics with query: %s", queryEndpoint)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, queryEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		log.Printf("Error sending request to VictoriaMetrics: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Unexpected response status: %s", resp.Status)
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return decodeMatrix(resp.Body, fn)
+}
+
+// decodeMatrix walks a Prometheus API response token by token and decodes the
+// entries of data.result one at a time.
+func decodeMatrix(r io.Reader, fn func(*metrics.TimeseriesData) error) error {
+	dec := json.NewDecoder(r)
+
+	var status, errorMessage string
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "status":
+			return dec.Decode(&status)
+		case "error":
+			return dec.Decode(&errorMessage)
+		case "data":
+			return decodeObject(dec, func(key string) error {
+				if key != "result" {
+					return skipValue(dec)
+				}
+				return decodeResult(dec, fn)
+			})
+		default:
+			return skipValue(dec)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	if status != "" && status != "success" {
+		return fmt.Errorf("query failed with status %s: %s", status, errorMessage)
+	}
+	return nil
+}
+
+func decodeResult(dec *json.Decoder, fn func(*metrics.TimeseriesData) error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var result struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"` // Array of [timestamp, value]
+		}
+		if err := dec.Decode(&result); err != nil {
+			return err
+		}
+
+		timeseries := &metrics.TimeseriesData{
+			Labels:  result.Metric,
+			Samples: make([]*metrics.Sample, 0, len(result.Values)),
+		}
+		for _, valuePair := range result.Values {
+			sample, err := parseSample(valuePair)
+			if err != nil {
+				return err
+			}
+			timeseries.Samples = append(timeseries.Samples, sample)
+		}
+
+		if err := fn(timeseries); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// decodeObject reads a JSON object and calls fn for each key. fn must consume the value.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v", token)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var value json.RawMessage
+	return dec.Decode(&value)
+}
+
+func parseSample(valuePair []interface{}) (*metrics.Sample, error) {
+	if len(valuePair) != 2 {
+		return nil, fmt.Errorf("unexpected sample %v", valuePair)
+	}
+
+	timestamp, ok := valuePair[0].(float64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected sample timestamp %v", valuePair[0])
+	}
+	metricValue, ok := valuePair[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected sample value %v", valuePair[1])
+	}
+
+	value, err := strconv.ParseFloat(metricValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sample value %q: %w", metricValue, err)
+	}
+
+	return &metrics.Sample{Value: value, Timestamp: int64(timestamp)}, nil
+}
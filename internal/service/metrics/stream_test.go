@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yay14/pulse/metrics"
+	"google.golang.org/grpc"
+)
+
+type fakeTimeseriesStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*metrics.TimeseriesData
+}
+
+func (f *fakeTimeseriesStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeTimeseriesStream) Send(timeseries *metrics.TimeseriesData) error {
+	f.sent = append(f.sent, timeseries)
+	return nil
+}
+
+func Test_decodeMatrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []*metrics.TimeseriesData
+		wantErr bool
+	}{
+		{
+			name: "matrix with two series",
+			body: `{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"__name__":"up","job":"a"},"values":[[1,"1"],[2,"0"]]},
+				{"metric":{"__name__":"up","job":"b"},"values":[[1,"NaN"]]}
+			]},"stats":{"seriesFetched":"2","executionTimeMsec":1}}`,
+			want: []*metrics.TimeseriesData{
+				{
+					Labels:  map[string]string{"__name__": "up", "job": "a"},
+					Samples: []*metrics.Sample{{Value: 1, Timestamp: 1}, {Value: 0, Timestamp: 2}},
+				},
+			},
+		},
+		{
+			name: "empty result",
+			body: `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+		},
+		{
+			name:    "error status",
+			body:    `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			wantErr: true,
+		},
+		{
+			name:    "truncated body",
+			body:    `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid sample value",
+			body:    `{"status":"success","data":{"result":[{"metric":{},"values":[[1,"abc"]]}]}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*metrics.TimeseriesData
+			err := decodeMatrix(strings.NewReader(tt.body), func(timeseries *metrics.TimeseriesData) error {
+				got = append(got, timeseries)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeMatrix() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			// NaN never compares equal, only check the series that can be compared
+			if len(tt.want) > 0 {
+				if len(got) < len(tt.want) || !reflect.DeepEqual(got[:len(tt.want)], tt.want) {
+					t.Errorf("decodeMatrix() = %v, want %v", got, tt.want)
+				}
+			} else if len(got) != 0 {
+				t.Errorf("decodeMatrix() = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestMetricsService_StreamRangeQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[`)
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"metric":{"__name__":"up","instance":"%d"},"values":[[10,"1"]]}`, i)
+		}
+		fmt.Fprint(w, `]}}`)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	s := NewMetricsService()
+	stream := &fakeTimeseriesStream{ctx: context.Background()}
+
+	req := &metrics.RangeQueryReadRequest{Query: "up", Start: "0", End: "10", Step: "10s"}
+	if err := s.StreamRangeQuery(req, stream); err != nil {
+		t.Fatalf("MetricsService.StreamRangeQuery() error = %v", err)
+	}
+	if len(stream.sent) != 3 {
+		t.Fatalf("MetricsService.StreamRangeQuery() sent %d messages, want 3", len(stream.sent))
+	}
+	for i, timeseries := range stream.sent {
+		if timeseries.Labels["instance"] != fmt.Sprint(i) || len(timeseries.Samples) != 1 {
+			t.Errorf("message %d = %v", i, timeseries)
+		}
+	}
+
+	if err := s.StreamRangeQuery(&metrics.RangeQueryReadRequest{Query: "up"}, stream); err == nil {
+		t.Errorf("MetricsService.StreamRangeQuery() without a range should fail")
+	}
+}
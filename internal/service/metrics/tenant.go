@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/metrics"
+)
+
+// Series of a tenant carry its tenant label in VictoriaMetrics, series of the
+// default tenant carry none. Reads are restricted to the series of the
+// tenant of the request with the extra_label parameter.
+
+// setTenantLabel sets the tenant label of every series, a tenant label sent by
+// the client is replaced so that no tenant can write the series of another
+func setTenantLabel(timeseries []*metrics.Timeseries, tenantID string) {
+	for _, ts := range timeseries {
+		if tenantID == "" {
+			delete(ts.Labels, tenant.LabelName)
+			continue
+		}
+		if ts.Labels == nil {
+			ts.Labels = make(map[string]string, 1)
+		}
+		ts.Labels[tenant.LabelName] = tenantID
+	}
+}
+
+// tenantEndpoint restricts a read endpoint of VictoriaMetrics, which already
+// has query parameters, to the series of the tenant of ctx. The empty label
+// value of the default tenant matches the series without a tenant label.
+func tenantEndpoint(ctx context.Context, endpoint string) string {
+	params := url.Values{"extra_label": {tenant.LabelName + "=" + tenant.FromContext(ctx)}}
+	return endpoint + "&" + params.Encode()
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/internal/tenant"
+	"github.com/yay14/pulse/metrics"
+)
+
+func Test_setTenantLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenantID string
+		labels   map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "tenant label added",
+			tenantID: "acme",
+			labels:   map[string]string{"__name__": "up"},
+			want:     map[string]string{"__name__": "up", "tenant": "acme"},
+		},
+		{
+			name:     "tenant label of the client replaced",
+			tenantID: "acme",
+			labels:   map[string]string{"__name__": "up", "tenant": "globex"},
+			want:     map[string]string{"__name__": "up", "tenant": "acme"},
+		},
+		{
+			name:     "no labels",
+			tenantID: "acme",
+			want:     map[string]string{"tenant": "acme"},
+		},
+		{
+			name:   "default tenant has no tenant label",
+			labels: map[string]string{"__name__": "up", "tenant": "globex"},
+			want:   map[string]string{"__name__": "up"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &metrics.Timeseries{Labels: tt.labels}
+			setTenantLabel([]*metrics.Timeseries{ts}, tt.tenantID)
+			if !reflect.DeepEqual(ts.Labels, tt.want) {
+				t.Errorf("setTenantLabel() labels = %v, want %v", ts.Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsService_TenantQueries(t *testing.T) {
+	var mu sync.Mutex
+	var extraLabels []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		extraLabels = append(extraLabels, r.URL.Query().Get("extra_label"))
+		mu.Unlock()
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	s := NewMetricsService()
+	s.cache.now = func() time.Time { return time.Unix(100000, 0) }
+
+	// A cached range query of one tenant is sent again for the others
+	req := &metrics.RangeQueryReadRequest{Query: "up", Start: "0", End: "3540", Step: "60"}
+	for _, id := range []string{"acme", "acme", "globex", ""} {
+		if _, err := s.RangeQueryMetrics(tenant.NewContext(context.Background(), id), req); err != nil {
+			t.Fatalf("RangeQueryMetrics(%q) error = %v", id, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"tenant=acme", "tenant=globex", "tenant="}
+	if !reflect.DeepEqual(extraLabels, want) {
+		t.Errorf("extra_label of the backend requests = %q, want %q", extraLabels, want)
+	}
+}
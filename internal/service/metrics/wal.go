@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yay14/pulse/internal/wal"
+	"github.com/yay14/pulse/metrics"
+)
+
+// walReaderName is the WAL reader replaying writes to VictoriaMetrics
+const walReaderName = "victoriametrics"
+
+// WithWAL makes WriteMetrics append writes to a write-ahead log and
+// acknowledge them, RunWALReplay writes them to VictoriaMetrics
+func WithWAL(w *wal.WAL) Option {
+	return func(s *MetricsService) {
+		s.wal = &walState{log: w}
+	}
+}
+
+// walState holds the WAL and its reader, which is opened before the first
+// append so that a new reader does not start after it
+type walState struct {
+	log    *wal.WAL
+	once   sync.Once
+	reader *wal.Reader
+	err    error
+}
+
+func (w *walState) openReader() (*wal.Reader, error) {
+	w.once.Do(func() {
+		w.reader, w.err = w.log.Reader(walReaderName, false)
+		if w.err != nil {
+			w.err = fmt.Errorf("failed to open WAL reader: %w", w.err)
+		}
+	})
+	return w.reader, w.err
+}
+
+// responseError returns the error of a failed VictoriaMetrics response, the
+// client errors other than 429 are permanent
+func responseError(resp *http.Response) error {
+	err := fmt.Errorf("unexpected response status: %s", resp.Status)
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return wal.Permanent(err)
+	}
+	return err
+}
+
+// appendWAL appends a write to the WAL, a full WAL is reported as Unavailable
+// so that clients retry
+func (s *MetricsService) appendWAL(req *metrics.WriteRequest) (*metrics.WriteResponse, error) {
+	if _, err := s.wal.openReader(); err != nil {
+		return &metrics.WriteResponse{Status: "Failed to append data to the write-ahead log"}, err
+	}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return &metrics.WriteResponse{Status: "Error marshalling data"}, err
+	}
+	if _, err := s.wal.log.Append(data); err != nil {
+		log.Printf("Error appending write to the WAL: %v", err)
+		if errors.Is(err, wal.ErrFull) {
+			return &metrics.WriteResponse{Status: "Write-ahead log is full"}, status.Error(codes.Unavailable, err.Error())
+		}
+		return &metrics.WriteResponse{Status: "Failed to append data to the write-ahead log"}, err
+	}
+	return &metrics.WriteResponse{Status: "Data accepted"}, nil
+}
+
+// RunWALReplay writes the writes in the WAL to VictoriaMetrics in order until
+// ctx is done, retrying a failed write until VictoriaMetrics accepts it.
+// Writes VictoriaMetrics rejects as invalid are dropped.
+func (s *MetricsService) RunWALReplay(ctx context.Context, backoff time.Duration) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	r, err := s.wal.openReader()
+	if err != nil {
+		return err
+	}
+
+	err = wal.Replay(ctx, r, wal.ReplayConfig{Backoff: backoff}, func(ctx context.Context, data []byte) error {
+		req := &metrics.WriteRequest{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			// Retrying cannot fix a write that does not decode
+			return wal.Permanent(fmt.Errorf("undecodable write: %w", err))
+		}
+		_, err := s.sendToVictoriaMetrics(ctx, req)
+		return err
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/internal/wal"
+	"github.com/yay14/pulse/metrics"
+)
+
+func TestMetricsService_WALReplay(t *testing.T) {
+	var mu sync.Mutex
+	failures := 2
+	var imported int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		imported++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	log, err := wal.Open(wal.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer log.Close()
+	s := NewMetricsService(WithWAL(log))
+
+	// Writes are acknowledged before VictoriaMetrics accepts them
+	for _, value := range []float64{1, 2} {
+		req := &metrics.WriteRequest{Timeseries: []*metrics.Timeseries{{
+			Labels:  map[string]string{"__name__": "up"},
+			Samples: []*metrics.Sample{{Value: value, Timestamp: 1000}},
+		}}}
+		if _, err := s.WriteMetrics(context.Background(), req); err != nil {
+			t.Fatalf("WriteMetrics() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunWALReplay(ctx, time.Millisecond) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := imported
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("RunWALReplay() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if imported != 2 {
+		t.Errorf("VictoriaMetrics imported %d writes, want 2", imported)
+	}
+}
+
+func TestMetricsService_WALReplay_Rejected(t *testing.T) {
+	var mu sync.Mutex
+	var requests, imported int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		imported++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	t.Setenv("VICTORIA_METRICS_URL", server.URL)
+
+	log, err := wal.Open(wal.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer log.Close()
+	s := NewMetricsService(WithWAL(log))
+
+	for _, value := range []float64{1, 2} {
+		req := &metrics.WriteRequest{Timeseries: []*metrics.Timeseries{{
+			Labels:  map[string]string{"__name__": "up"},
+			Samples: []*metrics.Sample{{Value: value, Timestamp: 1000}},
+		}}}
+		if _, err := s.WriteMetrics(context.Background(), req); err != nil {
+			t.Fatalf("WriteMetrics() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.RunWALReplay(ctx, time.Hour) }()
+
+	// The rejected write is dropped instead of being retried after the backoff
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := imported
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("RunWALReplay() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || imported != 1 {
+		t.Errorf("VictoriaMetrics received %d writes and imported %d, want 2 and 1", requests, imported)
+	}
+}
@@ -0,0 +1,159 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yay14/pulse/ingestion"
+	"github.com/yay14/pulse/internal/format"
+)
+
+// quantiles reported for timers, histograms and distributions
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// maxIdleGaugeFlushes is the number of flushes a gauge is kept without
+// updates, so that the gauges of clients that went away do not pile up
+const maxIdleGaugeFlushes = 60
+
+// aggregator accumulates metrics between flushes. Counters, timers and sets
+// are reset on every flush, gauges keep their last value like StatsD does
+// until they are idle for maxIdleGaugeFlushes.
+type aggregator struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	typ    string
+	name   string
+	labels map[string]string
+
+	value  float64             // Counter total or gauge value
+	count  float64             // Number of timer observations, scaled by sample rate
+	sum    float64             // Sum of timer observations, scaled by sample rate
+	values []float64           // Timer observations
+	set    map[string]struct{} // Unique set members
+	dirty  bool                // Updated since the last flush
+	idle   int                 // Flushes since the gauge was last updated
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{entries: make(map[string]*entry)}
+}
+
+// add folds a metric into the aggregate of its name, type and tags
+func (a *aggregator) add(metric Metric) {
+	name := format.SanitizeMetricName(metric.Name)
+	key := entryKey(metric.Type, name, metric.Tags)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.entries[key]
+	if !ok {
+		e = &entry{typ: metric.Type, name: name, labels: metric.Tags}
+		a.entries[key] = e
+	}
+	e.dirty = true
+
+	switch metric.Type {
+	case TypeCounter:
+		e.value += metric.Value / metric.SampleRate
+	case TypeGauge:
+		if metric.Relative {
+			e.value += metric.Value
+		} else {
+			e.value = metric.Value
+		}
+	case TypeTimer, TypeHistogram, TypeDistribution:
+		e.values = append(e.values, metric.Value)
+		e.count += 1 / metric.SampleRate
+		e.sum += metric.Value / metric.SampleRate
+	case TypeSet:
+		if e.set == nil {
+			e.set = make(map[string]struct{})
+		}
+		e.set[metric.SetValue] = struct{}{}
+	}
+}
+
+// flush returns the aggregates of the interval as MetricData and resets them
+func (a *aggregator) flush(timestamp int64) []*ingestion.MetricData {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var metrics []*ingestion.MetricData
+	emit := func(e *entry, name string, value float64, extra ...string) {
+		labels := make(map[string]string, len(e.labels)+len(extra)/2)
+		for key, labelValue := range e.labels {
+			labels[key] = labelValue
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			labels[extra[i]] = extra[i+1]
+		}
+		metrics = append(metrics, &ingestion.MetricData{Name: name, Labels: labels, Value: value, Timestamp: timestamp})
+	}
+
+	for key, e := range a.entries {
+		switch e.typ {
+		case TypeCounter:
+			emit(e, e.name, e.value)
+			delete(a.entries, key)
+		case TypeGauge:
+			if e.dirty {
+				emit(e, e.name, e.value)
+				e.idle = 0
+			} else if e.idle++; e.idle >= maxIdleGaugeFlushes {
+				delete(a.entries, key)
+			}
+		case TypeTimer, TypeHistogram, TypeDistribution:
+			sort.Float64s(e.values)
+			for _, q := range quantiles {
+				emit(e, e.name, quantile(e.values, q), "quantile", strconv.FormatFloat(q, 'g', -1, 64))
+			}
+			emit(e, e.name+"_sum", e.sum)
+			emit(e, e.name+"_count", e.count)
+			emit(e, e.name+"_min", e.values[0])
+			emit(e, e.name+"_max", e.values[len(e.values)-1])
+			delete(a.entries, key)
+		case TypeSet:
+			emit(e, e.name, float64(len(e.set)))
+			delete(a.entries, key)
+		}
+		e.dirty = false
+	}
+
+	return metrics
+}
+
+// quantile returns the nearest-rank quantile of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func entryKey(typ, name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(typ)
+	sb.WriteByte('|')
+	sb.WriteString(name)
+	for _, key := range keys {
+		sb.WriteByte('|')
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(tags[key])
+	}
+	return sb.String()
+}
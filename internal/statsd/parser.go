@@ -0,0 +1,111 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yay14/pulse/internal/format"
+)
+
+// Metric types of the StatsD line protocol
+const (
+	TypeCounter      = "c"
+	TypeGauge        = "g"
+	TypeTimer        = "ms"
+	TypeHistogram    = "h"
+	TypeDistribution = "d"
+	TypeSet          = "s"
+)
+
+// Metric is a single parsed StatsD metric
+type Metric struct {
+	Name       string
+	Type       string
+	Value      float64
+	SetValue   string // Raw value of set metrics
+	Relative   bool   // Gauge value is a delta, written with an explicit sign
+	SampleRate float64
+	Tags       map[string]string
+}
+
+// ParseLine parses a StatsD or DogStatsD line of the form
+// `name:value[:value...]|type[|@rate][|#tag:value,tag]`. DogStatsD events and
+// service checks are ignored and return no metrics.
+func ParseLine(line string) ([]Metric, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil, nil
+	}
+
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return nil, fmt.Errorf("missing metric name or value in %q", line)
+	}
+	name := line[:colon]
+
+	sections := strings.Split(line[colon+1:], "|")
+	if len(sections) < 2 {
+		return nil, fmt.Errorf("missing metric type in %q", line)
+	}
+
+	typ := sections[1]
+	switch typ {
+	case TypeCounter, TypeGauge, TypeTimer, TypeHistogram, TypeDistribution, TypeSet:
+	default:
+		return nil, fmt.Errorf("unknown metric type %q in %q", typ, line)
+	}
+
+	sampleRate := 1.0
+	tags := make(map[string]string)
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			rate, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q in %q", section, line)
+			}
+			sampleRate = rate
+		case strings.HasPrefix(section, "#"):
+			parseTags(section[1:], tags)
+		default:
+			// Unknown extensions such as DogStatsD container ids and timestamps are ignored
+		}
+	}
+
+	// DogStatsD 1.1 packs several values of the same metric into one line
+	var metrics []Metric
+	for _, rawValue := range strings.Split(sections[0], ":") {
+		metric := Metric{Name: name, Type: typ, SampleRate: sampleRate, Tags: tags}
+
+		if typ == TypeSet {
+			metric.SetValue = rawValue
+			metrics = append(metrics, metric)
+			continue
+		}
+
+		value, err := strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in %q", rawValue, line)
+		}
+		metric.Value = value
+		metric.Relative = typ == TypeGauge && (rawValue[0] == '+' || rawValue[0] == '-')
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// parseTags parses DogStatsD tags. Tags without a value get the value "true".
+func parseTags(s string, tags map[string]string) {
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		key, value, found := strings.Cut(tag, ":")
+		if !found {
+			value = "true"
+		}
+		tags[format.SanitizeLabelName(key)] = value
+	}
+}
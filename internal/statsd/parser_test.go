@@ -0,0 +1,80 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []Metric
+		wantErr bool
+	}{
+		{
+			name: "counter with sample rate",
+			line: "page.views:1|c|@0.1",
+			want: []Metric{{Name: "page.views", Type: TypeCounter, Value: 1, SampleRate: 0.1, Tags: map[string]string{}}},
+		},
+		{
+			name: "relative gauge",
+			line: "queue.depth:-3|g",
+			want: []Metric{{Name: "queue.depth", Type: TypeGauge, Value: -3, Relative: true, SampleRate: 1, Tags: map[string]string{}}},
+		},
+		{
+			name: "timer with dogstatsd tags",
+			line: "request.latency:320|ms|#env:prod,service:checkout,canary",
+			want: []Metric{{Name: "request.latency", Type: TypeTimer, Value: 320, SampleRate: 1, Tags: map[string]string{"env": "prod", "service": "checkout", "canary": "true"}}},
+		},
+		{
+			name: "set",
+			line: "users.unique:alice|s",
+			want: []Metric{{Name: "users.unique", Type: TypeSet, SetValue: "alice", SampleRate: 1, Tags: map[string]string{}}},
+		},
+		{
+			name: "dogstatsd multi value distribution",
+			line: "payload.size:10:20|d|#region:eu",
+			want: []Metric{
+				{Name: "payload.size", Type: TypeDistribution, Value: 10, SampleRate: 1, Tags: map[string]string{"region": "eu"}},
+				{Name: "payload.size", Type: TypeDistribution, Value: 20, SampleRate: 1, Tags: map[string]string{"region": "eu"}},
+			},
+		},
+		{
+			name: "dogstatsd event is ignored",
+			line: "_e{5,4}:title|text",
+		},
+		{
+			name:    "unknown type",
+			line:    "a:1|x",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			line:    "a:abc|c",
+			wantErr: true,
+		},
+		{
+			name:    "invalid sample rate",
+			line:    "a:1|c|@2",
+			wantErr: true,
+		},
+		{
+			name:    "missing type",
+			line:    "a:1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+const (
+	defaultFlushInterval = 10 * time.Second
+
+	// maxPacketSize is the largest UDP datagram the listener reads
+	maxPacketSize = 65535
+)
+
+// Ingester receives the aggregated metrics, it is implemented by the ingestion service
+type Ingester interface {
+	IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error)
+}
+
+// Config represents the StatsD listener configuration
+type Config struct {
+	UDPAddr       string // Address of the UDP listener, e.g. :8125
+	TCPAddr       string // Optional address of the TCP listener
+	FlushInterval time.Duration
+	SourceID      string // Source the aggregated metrics are ingested for
+	SourceType    string
+}
+
+// Server receives StatsD and DogStatsD metrics, aggregates them over the flush
+// interval and ingests the aggregates through the ingestion service
+type Server struct {
+	cfg      Config
+	ingester Ingester
+	agg      *aggregator
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+}
+
+// NewServer creates a new StatsD Server
+func NewServer(cfg Config, ingester Ingester) *Server {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	return &Server{cfg: cfg, ingester: ingester, agg: newAggregator()}
+}
+
+// Start binds the listeners and serves them until ctx is cancelled. The
+// remaining aggregates are flushed when ctx is done. A source id is required,
+// the aggregates of every client are ingested for it.
+func (s *Server) Start(ctx context.Context) error {
+	if s.cfg.SourceID == "" {
+		return errors.New("source id is required")
+	}
+
+	var err error
+	if s.udpConn, err = net.ListenPacket("udp", s.cfg.UDPAddr); err != nil {
+		return err
+	}
+	if s.cfg.TCPAddr != "" {
+		if s.tcpListener, err = net.Listen("tcp", s.cfg.TCPAddr); err != nil {
+			s.udpConn.Close()
+			return err
+		}
+		go s.serveTCP()
+	}
+	go s.serveUDP()
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.udpConn.Close()
+				if s.tcpListener != nil {
+					s.tcpListener.Close()
+				}
+				s.flush(context.Background())
+				return
+			case <-ticker.C:
+				s.flush(ctx)
+			}
+		}
+	}()
+
+	log.Printf("Listening for StatsD metrics on %s", s.udpConn.LocalAddr())
+	return nil
+}
+
+// UDPAddr returns the address of the UDP listener
+func (s *Server) UDPAddr() net.Addr {
+	return s.udpConn.LocalAddr()
+}
+
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Error reading StatsD packet: %v", err)
+			continue
+		}
+
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			s.handleLine(string(line))
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Error accepting StatsD connection: %v", err)
+			continue
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				s.handleLine(scanner.Text())
+			}
+		}(conn)
+	}
+}
+
+func (s *Server) handleLine(line string) {
+	metrics, err := ParseLine(line)
+	if err != nil {
+		log.Printf("Dropping StatsD line: %v", err)
+		return
+	}
+	for _, metric := range metrics {
+		s.agg.add(metric)
+	}
+}
+
+// flush ingests the aggregates of the last interval
+func (s *Server) flush(ctx context.Context) {
+	metrics := s.agg.flush(time.Now().UnixMilli())
+	if len(metrics) == 0 {
+		return
+	}
+
+	_, err := s.ingester.IngestData(ctx, &ingestion.IngestDataRequest{
+		SourceId:   s.cfg.SourceID,
+		SourceType: s.cfg.SourceType,
+		Metrics:    metrics,
+	})
+	if err != nil {
+		log.Printf("Failed to ingest %d StatsD metrics: %v", len(metrics), err)
+	}
+}
@@ -0,0 +1,184 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yay14/pulse/ingestion"
+)
+
+type fakeIngester struct {
+	mu       sync.Mutex
+	requests []*ingestion.IngestDataRequest
+}
+
+func (f *fakeIngester) IngestData(ctx context.Context, req *ingestion.IngestDataRequest) (*ingestion.IngestDataResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	return &ingestion.IngestDataResponse{}, nil
+}
+
+func TestAggregator_flush(t *testing.T) {
+	agg := newAggregator()
+	for _, line := range []string{
+		"hits:1|c|@0.5",
+		"hits:2|c",
+		"temp:20|g",
+		"temp:+5|g",
+		"latency:10|ms|#route:/a",
+		"latency:30|ms|#route:/a",
+		"latency:20|ms|#route:/a",
+		"latency:40|ms|@0.5|#route:/b",
+		"users:a|s",
+		"users:b|s",
+		"users:a|s",
+	} {
+		metrics, err := ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) error = %v", line, err)
+		}
+		for _, metric := range metrics {
+			agg.add(metric)
+		}
+	}
+
+	got := make(map[string]float64)
+	for _, metric := range agg.flush(1000) {
+		key := metric.Name
+		if q, ok := metric.Labels["quantile"]; ok {
+			key += "{quantile=" + q + "}"
+		}
+		if route := metric.Labels["route"]; route == "/b" {
+			key += "{route=/b}"
+		} else if metric.Name == "latency" && route != "/a" {
+			t.Errorf("metric %s labels = %v, want route tag", key, metric.Labels)
+		}
+		got[key] = metric.Value
+		if metric.Timestamp != 1000 {
+			t.Errorf("metric %s timestamp = %d, want 1000", key, metric.Timestamp)
+		}
+	}
+
+	want := map[string]float64{
+		"hits":                             4,
+		"temp":                             25,
+		"latency{quantile=0.5}":            20,
+		"latency{quantile=0.9}":            30,
+		"latency{quantile=0.99}":           30,
+		"latency_sum":                      60,
+		"latency_count":                    3,
+		"latency_min":                      10,
+		"latency_max":                      30,
+		"latency{quantile=0.5}{route=/b}":  40,
+		"latency{quantile=0.9}{route=/b}":  40,
+		"latency{quantile=0.99}{route=/b}": 40,
+		"latency_sum{route=/b}":            80,
+		"latency_count{route=/b}":          2,
+		"latency_min{route=/b}":            40,
+		"latency_max{route=/b}":            40,
+		"users":                            2,
+	}
+	if len(got) != len(want) {
+		t.Errorf("flush() = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("flush() %s = %v, want %v", key, got[key], value)
+		}
+	}
+
+	// Gauges are only reported again once they change
+	if metrics := agg.flush(2000); len(metrics) != 0 {
+		t.Errorf("second flush() = %v, want nothing", metrics)
+	}
+	agg.add(Metric{Name: "temp", Type: TypeGauge, Value: 1, Relative: true, SampleRate: 1})
+	if metrics := agg.flush(3000); len(metrics) != 1 || metrics[0].Value != 26 {
+		t.Errorf("third flush() = %v, want temp 26", metrics)
+	}
+
+	// Idle gauges expire, a relative update afterwards starts from zero
+	for i := 0; i < maxIdleGaugeFlushes; i++ {
+		agg.flush(4000)
+	}
+	agg.add(Metric{Name: "temp", Type: TypeGauge, Value: 1, Relative: true, SampleRate: 1})
+	if metrics := agg.flush(5000); len(metrics) != 1 || metrics[0].Value != 1 {
+		t.Errorf("flush() after expiry = %v, want temp 1", metrics)
+	}
+}
+
+func TestServer_UDP(t *testing.T) {
+	ingester := &fakeIngester{}
+	server := NewServer(Config{UDPAddr: "127.0.0.1:0", FlushInterval: time.Hour, SourceID: "legacy", SourceType: "statsd"}, ingester)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := server.Start(ctx); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+
+	conn, err := net.Dial("udp", server.UDPAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("jobs.done:1|c|#queue:mail\njobs.done:2|c|#queue:mail\nbroken")); err != nil {
+		t.Fatalf("conn.Write() error = %v", err)
+	}
+
+	// Wait for the packet to be aggregated before flushing on shutdown
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		server.agg.mu.Lock()
+		n := len(server.agg.entries)
+		server.agg.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		ingester.mu.Lock()
+		n := len(ingester.requests)
+		ingester.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ingester.mu.Lock()
+	defer ingester.mu.Unlock()
+	if len(ingester.requests) != 1 {
+		t.Fatalf("ingested %d requests, want 1", len(ingester.requests))
+	}
+	req := ingester.requests[0]
+	if req.SourceId != "legacy" || req.SourceType != "statsd" {
+		t.Errorf("source = %s/%s, want legacy/statsd", req.SourceId, req.SourceType)
+	}
+
+	var names []string
+	for _, metric := range req.Metrics {
+		names = append(names, metric.Name)
+		if metric.Value != 3 || metric.Labels["queue"] != "mail" {
+			t.Errorf("metric = %v, want jobs_done{queue=mail} 3", metric)
+		}
+	}
+	sort.Strings(names)
+	if len(names) != 1 || names[0] != "jobs_done" {
+		t.Errorf("metrics = %v, want [jobs_done]", names)
+	}
+}
+
+func TestServer_Start_SourceID(t *testing.T) {
+	server := NewServer(Config{UDPAddr: "127.0.0.1:0", SourceType: "statsd"}, &fakeIngester{})
+	if err := server.Start(context.Background()); err == nil {
+		t.Error("Server.Start() error = nil, want an error for a missing source id")
+	}
+}
@@ -0,0 +1,89 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Parse validates the tenant sent with a request. An empty tenant is the
+// default tenant, or rejected with ErrMissing when a tenant is required.
+func Parse(id string, required bool) (string, error) {
+	if id == "" && !required {
+		return "", nil
+	}
+	if err := Validate(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// UnaryServerInterceptor puts the tenant of the x-scope-orgid metadata of a
+// call into its context
+func UnaryServerInterceptor(required bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := incomingContext(ctx, required)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor puts the tenant of the x-scope-orgid metadata of a
+// stream into its context
+func StreamServerInterceptor(required bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := incomingContext(stream.Context(), required)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// incomingContext returns ctx with the tenant of its incoming metadata
+func incomingContext(ctx context.Context, required bool) (context.Context, error) {
+	var id string
+	if values := metadata.ValueFromIncomingContext(ctx, MetadataKey); len(values) > 0 {
+		id = values[0]
+	}
+	id, err := Parse(id, required)
+	if errors.Is(err, ErrMissing) {
+		return nil, status.Errorf(codes.Unauthenticated, "%v, set the %s metadata", err, MetadataKey)
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return NewContext(ctx, id), nil
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// Middleware puts the tenant of the X-Scope-OrgID header of a request into
+// its context before calling next
+func Middleware(required bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := Parse(r.Header.Get(HeaderName), required)
+		if errors.Is(err, ErrMissing) {
+			http.Error(w, err.Error()+", set the "+HeaderName+" header", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
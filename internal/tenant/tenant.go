@@ -0,0 +1,85 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MetadataKey is the gRPC metadata key, Kafka header and, canonicalized,
+	// HTTP header carrying the tenant of a request
+	MetadataKey = "x-scope-orgid"
+
+	// HeaderName is the HTTP header carrying the tenant of a request
+	HeaderName = "X-Scope-OrgID"
+
+	// LabelName is the label holding the tenant of a series in VictoriaMetrics
+	LabelName = "tenant"
+
+	// Separator joins the tenant and the metric name of a stored metric name
+	Separator = "/"
+
+	maxIDLength = 64
+)
+
+var (
+	// ErrMissing is returned for a request without a tenant when one is required
+	ErrMissing = errors.New("tenant is required")
+
+	// ErrInvalidName is returned for a metric name that could address the metrics of another tenant
+	ErrInvalidName = errors.New("metric name must not contain " + Separator)
+)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying a tenant, the empty tenant is the default tenant
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of ctx, the default tenant "" if it has none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Validate checks that a tenant is 1 to 64 letters, digits, underscores and dashes
+func Validate(id string) error {
+	if id == "" {
+		return ErrMissing
+	}
+	if len(id) > maxIDLength {
+		return fmt.Errorf("tenant %q is longer than %d characters", id, maxIDLength)
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return fmt.Errorf("tenant %q contains %q, only letters, digits, _ and - are allowed", id, r)
+		}
+	}
+	return nil
+}
+
+// Qualify returns the name a metric of a tenant is stored under. Metrics of
+// the default tenant keep their name, so data written before tenants existed
+// stays with it.
+func Qualify(id, name string) (string, error) {
+	if strings.Contains(name, Separator) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if id == "" {
+		return name, nil
+	}
+	return id + Separator + name, nil
+}
+
+// Split returns the tenant and metric name of a stored metric name
+func Split(stored string) (id, name string) {
+	if id, name, ok := strings.Cut(stored, Separator); ok {
+		return id, name
+	}
+	return "", stored
+}
@@ -0,0 +1,129 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{id: "acme"},
+		{id: "team_a-01"},
+		{id: "", wantErr: true},
+		{id: "acme/prod", wantErr: true},
+		{id: "a b", wantErr: true},
+		{id: string(make([]byte, 65)), wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.id); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	tests := []struct {
+		id, name string
+		want     string
+		wantErr  bool
+	}{
+		{id: "", name: "up", want: "up"},
+		{id: "acme", name: "up", want: "acme/up"},
+		{id: "", name: "acme/up", wantErr: true},
+		{id: "globex", name: "acme/up", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Qualify(tt.id, tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Qualify(%q, %q) = %q, %v, want %q", tt.id, tt.name, got, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Qualify(%q, %q) error = %v, want ErrInvalidName", tt.id, tt.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		if id, name := Split(got); id != tt.id || name != tt.name {
+			t.Errorf("Split(%q) = %q, %q, want %q, %q", got, id, name, tt.id, tt.name)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		required bool
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "tenant", md: metadata.Pairs(MetadataKey, "acme"), want: "acme"},
+		{name: "default tenant", md: metadata.MD{}},
+		{name: "required", md: metadata.MD{}, required: true, wantCode: codes.Unauthenticated},
+		{name: "invalid", md: metadata.Pairs(MetadataKey, "acme/prod"), wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = FromContext(ctx)
+				return nil, nil
+			}
+			ctx := metadata.NewIncomingContext(NewContext(context.Background(), "spoofed"), tt.md)
+			_, err := UnaryServerInterceptor(tt.required)(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("interceptor error = %v, want %v", err, tt.wantCode)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		required bool
+		want     string
+		wantCode int
+	}{
+		{name: "tenant", header: "acme", want: "acme", wantCode: http.StatusOK},
+		{name: "default tenant", wantCode: http.StatusOK},
+		{name: "required", required: true, wantCode: http.StatusUnauthorized},
+		{name: "invalid", header: "a b", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := Middleware(tt.required, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v2/write", nil)
+			if tt.header != "" {
+				r.Header.Set(HeaderName, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader consumes the records of a WAL in order. Records are delivered at
+// least once: the position is persisted periodically by Commit, records
+// after the persisted position are read again after a crash.
+type Reader struct {
+	w          *WAL
+	name       string
+	dropOnFull bool
+
+	// Guarded by the mutex of the WAL
+	next      uint64 // Index of the next record returned by Next
+	committed uint64 // Index of the first record that was not committed
+	dropped   uint64
+	persisted time.Time
+
+	// Owned by the goroutine calling Next
+	file      *os.File
+	buf       *bufio.Reader
+	fileFirst uint64 // First index of the open segment
+	fileNext  uint64 // Index of the record at the read position of the open segment
+}
+
+// Reader returns the reader with a name, starting at its persisted cursor.
+// A new reader starts after the last appended record. With dropOnFull the
+// records the reader has not committed are dropped when the log is full,
+// otherwise they make Append fail with ErrFull.
+func (w *WAL) Reader(name string, dropOnFull bool) (*Reader, error) {
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return nil, fmt.Errorf("invalid WAL reader name %q", name)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := w.readers[name]; ok {
+		return nil, fmt.Errorf("WAL reader %s is already open", name)
+	}
+
+	r := &Reader{w: w, name: name, dropOnFull: dropOnFull, committed: w.next}
+	data, err := os.ReadFile(r.cursorPath())
+	switch {
+	case err == nil:
+		cursor, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor of WAL reader %s: %w", name, err)
+		}
+		if first := w.segments[0].first; cursor < first {
+			log.Printf("Records %d to %d of WAL reader %s were removed, resuming at %d", cursor, first, name, first)
+			r.dropped += first - cursor
+			cursor = first
+		}
+		if cursor > w.next {
+			log.Printf("Cursor %d of WAL reader %s is after the last record, resuming at %d", cursor, name, w.next)
+			cursor = w.next
+		}
+		r.committed = cursor
+	case os.IsNotExist(err):
+		if err := r.persistLocked(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("failed to read cursor of WAL reader %s: %w", name, err)
+	}
+
+	r.next = r.committed
+	w.readers[name] = r
+	return r, nil
+}
+
+func (r *Reader) cursorPath() string {
+	return filepath.Join(r.w.opts.Dir, r.name+cursorSuffix)
+}
+
+// persistLocked writes the cursor to a temporary file and renames it over the cursor file
+func (r *Reader) persistLocked() error {
+	path := r.cursorPath()
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write cursor of WAL reader %s: %w", r.name, err)
+	}
+	if _, err := f.WriteString(strconv.FormatUint(r.committed, 10)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write cursor of WAL reader %s: %w", r.name, err)
+	}
+	if r.w.opts.Sync != SyncNone {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync cursor of WAL reader %s: %w", r.name, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write cursor of WAL reader %s: %w", r.name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write cursor of WAL reader %s: %w", r.name, err)
+	}
+
+	r.persisted = time.Now()
+	return nil
+}
+
+// Next returns the next record and its index, waiting for an append if the
+// reader is at the end of the log
+func (r *Reader) Next(ctx context.Context) (uint64, []byte, error) {
+	w := r.w
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			r.closeFile()
+			return 0, nil, ErrClosed
+		}
+		if first := w.segments[0].first; r.next < first {
+			r.next = first
+		}
+
+		if r.next < w.next {
+			index := r.next
+			segFirst := w.segments[0].first
+			for _, s := range w.segments {
+				if s.first <= index {
+					segFirst = s.first
+				}
+			}
+			w.mu.Unlock()
+
+			data, err := r.read(segFirst, index)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			w.mu.Lock()
+			// The log may have moved the reader past a dropped segment meanwhile
+			if r.next == index {
+				r.next = index + 1
+			}
+			w.mu.Unlock()
+			return index, data, nil
+		}
+
+		appended := w.appended
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+// read reads the record with an index from the segment starting at segFirst
+func (r *Reader) read(segFirst, index uint64) ([]byte, error) {
+	if r.file == nil || r.fileFirst != segFirst || r.fileNext > index {
+		r.closeFile()
+		f, err := os.Open(r.w.segmentPath(segFirst))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open WAL segment: %w", err)
+		}
+		r.file, r.buf, r.fileFirst, r.fileNext = f, bufio.NewReader(f), segFirst, segFirst
+	}
+
+	for {
+		data, err := readRecord(r.buf)
+		if err != nil {
+			r.closeFile()
+			return nil, fmt.Errorf("failed to read record %d of WAL segment %d: %w", r.fileNext, segFirst, err)
+		}
+		r.fileNext++
+		if r.fileNext > index {
+			return data, nil
+		}
+	}
+}
+
+func (r *Reader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file, r.buf = nil, nil
+	}
+}
+
+// Commit marks the records up to index as consumed. Segments every reader
+// has consumed are removed.
+func (r *Reader) Commit(index uint64) error {
+	w := r.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	if index+1 > r.committed {
+		r.committed = index + 1
+	}
+
+	var err error
+	if w.opts.Sync == SyncAlways || time.Since(r.persisted) >= cursorPersistInterval {
+		err = r.persistLocked()
+	}
+	if removeErr := w.removeConsumedLocked(); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// Skip counts a record that could not be delivered as dropped and commits it
+func (r *Reader) Skip(index uint64) error {
+	r.w.mu.Lock()
+	r.dropped++
+	r.w.mu.Unlock()
+	return r.Commit(index)
+}
+
+// Lag returns the number of appended records the reader has not committed
+func (r *Reader) Lag() uint64 {
+	r.w.mu.Lock()
+	defer r.w.mu.Unlock()
+	return r.w.next - r.committed
+}
+
+// Dropped returns the number of records the reader did not deliver
+func (r *Reader) Dropped() uint64 {
+	r.w.mu.Lock()
+	defer r.w.mu.Unlock()
+	return r.dropped
+}
+
+// ReplayConfig configures Replay
+type ReplayConfig struct {
+	Backoff     time.Duration // Delay before the first retry of a record, doubled up to MaxBackoff, 1s if zero
+	MaxBackoff  time.Duration // 1m if zero
+	MaxAttempts int           // Attempts after which a record is skipped, retried until delivered if zero
+}
+
+// permanentError is an error of a delivery that fails again when retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error of a record that cannot be delivered, such as a
+// record the destination rejects, so that Replay drops it instead of retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether an error was marked by Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Replay delivers the records of a reader in order until ctx is done or the
+// log is closed. A failing record is retried with backoff, holding back the
+// following records, unless its error is permanent. Records that cannot be
+// read, such as those of a segment removed while they were read, are read
+// again with backoff.
+func Replay(ctx context.Context, r *Reader, cfg ReplayConfig, deliver func(ctx context.Context, data []byte) error) error {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	wait := func(backoff time.Duration) error {
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+
+	readBackoff := cfg.Backoff
+	for {
+		index, data, err := r.Next(ctx)
+		if err != nil {
+			if errors.Is(err, ErrClosed) || ctx.Err() != nil {
+				return err
+			}
+			log.Printf("Failed to read WAL reader %s, retrying in %v: %v", r.name, readBackoff, err)
+			if err := wait(readBackoff); err != nil {
+				return err
+			}
+			if readBackoff *= 2; readBackoff > cfg.MaxBackoff {
+				readBackoff = cfg.MaxBackoff
+			}
+			continue
+		}
+		readBackoff = cfg.Backoff
+
+		backoff := cfg.Backoff
+		for attempts := 1; ; attempts++ {
+			err := deliver(ctx, data)
+			if err == nil {
+				if err := r.Commit(index); err != nil {
+					log.Printf("Failed to commit record %d of WAL reader %s: %v", index, r.name, err)
+				}
+				break
+			}
+			if IsPermanent(err) || (cfg.MaxAttempts > 0 && attempts >= cfg.MaxAttempts) {
+				log.Printf("Dropping record %d of WAL reader %s after %d attempts: %v", index, r.name, attempts, err)
+				if err := r.Skip(index); err != nil {
+					log.Printf("Failed to commit record %d of WAL reader %s: %v", index, r.name, err)
+				}
+				break
+			}
+
+			log.Printf("Failed to deliver record %d of WAL reader %s, retrying in %v: %v", index, r.name, backoff, err)
+			if err := wait(backoff); err != nil {
+				return err
+			}
+			if backoff *= 2; backoff > cfg.MaxBackoff {
+				backoff = cfg.MaxBackoff
+			}
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize  = 64 << 20
+	defaultMaxBytes     = 1 << 30
+	defaultSyncInterval = time.Second
+
+	// maxRecordSize bounds a single record, larger lengths are read as corruption
+	maxRecordSize = 256 << 20
+
+	// headerSize is the length and the CRC-32C of the payload preceding every record
+	headerSize = 8
+
+	segmentSuffix = ".wal"
+	cursorSuffix  = ".cursor"
+
+	// cursorPersistInterval bounds how often a cursor is written unless every append is synced
+	cursorPersistInterval = time.Second
+)
+
+var (
+	// ErrFull is returned by Append when the log reached its size limit and
+	// its oldest segment is still needed by a reader
+	ErrFull = errors.New("write-ahead log is full")
+
+	// ErrClosed is returned after the log was closed
+	ErrClosed = errors.New("write-ahead log is closed")
+
+	errCorrupt = errors.New("corrupt record")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SyncPolicy decides when appended records are flushed to disk
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // Sync every append before it is acknowledged
+	SyncInterval SyncPolicy = "interval" // Sync every SyncInterval, a crash loses at most an interval of appends
+	SyncNone     SyncPolicy = "none"     // Leave flushing to the operating system
+)
+
+// ParseSyncPolicy parses a sync policy, an empty string is SyncInterval
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(s); policy {
+	case "":
+		return SyncInterval, nil
+	case SyncAlways, SyncInterval, SyncNone:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy %q", s)
+	}
+}
+
+// Options configures a WAL
+type Options struct {
+	Dir          string
+	SegmentSize  int64         // Size at which a new segment is started, 64MiB if zero
+	MaxBytes     int64         // Limit of the segments on disk, 1GiB if zero
+	Sync         SyncPolicy    // SyncInterval if empty
+	SyncInterval time.Duration // 1s if zero
+}
+
+// Stats reports the state of a WAL
+type Stats struct {
+	Segments   int
+	Bytes      int64
+	FirstIndex uint64 // Index of the oldest record on disk
+	NextIndex  uint64 // Index of the next appended record
+}
+
+type segment struct {
+	first uint64
+	size  int64
+}
+
+// WAL is a write-ahead log of records in segment files. Every record has a
+// sequential index, readers consume the records in order and persist their
+// position in a cursor file, segments are removed once every reader is past them.
+type WAL struct {
+	opts Options
+
+	mu       sync.Mutex
+	segments []segment
+	active   *os.File
+	next     uint64
+	bytes    int64
+	readers  map[string]*Reader
+	appended chan struct{} // Closed and replaced by every append
+	dirty    bool
+	closed   bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the WAL in opts.Dir, creating it if needed. A torn record at
+// the end of the last segment, left by a crash during an append, is truncated.
+func Open(opts Options) (*WAL, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("WAL directory is required")
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+	if opts.MaxBytes < 2*opts.SegmentSize {
+		return nil, fmt.Errorf("WAL size limit %d must be at least two segments of %d bytes", opts.MaxBytes, opts.SegmentSize)
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+	firsts, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		opts:     opts,
+		readers:  make(map[string]*Reader),
+		appended: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for i, first := range firsts {
+		path := w.segmentPath(first)
+		count, valid, size, err := scanSegment(path)
+		if err != nil {
+			return nil, err
+		}
+
+		last := i == len(firsts)-1
+		if valid < size {
+			if !last {
+				return nil, fmt.Errorf("segment %s is corrupt at offset %d", path, valid)
+			}
+			log.Printf("Truncating torn record at offset %d of WAL segment %s", valid, path)
+			if err := os.Truncate(path, valid); err != nil {
+				return nil, fmt.Errorf("failed to truncate WAL segment: %w", err)
+			}
+		}
+		if !last && first+count != firsts[i+1] {
+			return nil, fmt.Errorf("segment %s ends at record %d but the next segment starts at %d", path, first+count, firsts[i+1])
+		}
+
+		w.segments = append(w.segments, segment{first: first, size: valid})
+		w.bytes += valid
+		w.next = first + count
+	}
+
+	// The active segment is never removed, its name keeps the index across restarts
+	if len(w.segments) == 0 {
+		w.segments = []segment{{first: 0}}
+	}
+	if w.active, err = w.openSegment(w.segments[len(w.segments)-1].first); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// listSegments returns the first indexes of the segments in dir in order
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL directory: %w", err)
+	}
+
+	var firsts []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected file %s in WAL directory", name)
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	return firsts, nil
+}
+
+// scanSegment counts the valid records of a segment and returns the offset
+// after the last of them with the size of the file
+func scanSegment(path string) (count uint64, valid, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to stat WAL segment: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, errCorrupt) {
+			return count, valid, info.Size(), nil
+		}
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to read WAL segment %s: %w", path, err)
+		}
+		count++
+		valid += int64(headerSize + len(data))
+	}
+}
+
+// readRecord reads a record, io.EOF is returned at the end of the segment and
+// io.ErrUnexpectedEOF for a partially written record
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	// Records are never empty, zeroed space after a crash is not read as records
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length == 0 || length > maxRecordSize {
+		return nil, errCorrupt
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(data, castagnoli) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errCorrupt
+	}
+	return data, nil
+}
+
+func (w *WAL) segmentPath(first uint64) string {
+	return filepath.Join(w.opts.Dir, fmt.Sprintf("%020d%s", first, segmentSuffix))
+}
+
+func (w *WAL) openSegment(first uint64) (*os.File, error) {
+	f, err := os.OpenFile(w.segmentPath(first), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	if w.opts.Sync != SyncNone {
+		if err := syncDir(w.opts.Dir); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// syncDir makes created and renamed files of a directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	return nil
+}
+
+// Append appends a record and returns its index. With SyncAlways the record
+// is on disk when Append returns.
+func (w *WAL) Append(data []byte) (uint64, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("record is empty")
+	}
+	if len(data) > maxRecordSize {
+		return 0, fmt.Errorf("record of %d bytes exceeds the maximum of %d bytes", len(data), maxRecordSize)
+	}
+	size := int64(headerSize + len(data))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if w.bytes+size > w.opts.MaxBytes {
+		if err := w.reclaimLocked(size); err != nil {
+			return 0, err
+		}
+	}
+	if active := w.segments[len(w.segments)-1]; active.size > 0 && active.size+size > w.opts.SegmentSize {
+		if err := w.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	record := make([]byte, size)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(data, castagnoli))
+	copy(record[headerSize:], data)
+
+	active := &w.segments[len(w.segments)-1]
+	if _, err := w.active.Write(record); err != nil {
+		// Drop a partial record so the next append does not follow it
+		if truncErr := w.active.Truncate(active.size); truncErr != nil {
+			log.Printf("Failed to truncate partial WAL record: %v", truncErr)
+		}
+		return 0, fmt.Errorf("failed to append to WAL: %w", err)
+	}
+	active.size += size
+	w.bytes += size
+
+	if w.opts.Sync == SyncAlways {
+		if err := w.active.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	} else {
+		w.dirty = true
+	}
+
+	index := w.next
+	w.next++
+	close(w.appended)
+	w.appended = make(chan struct{})
+	return index, nil
+}
+
+// rotateLocked seals the active segment and starts a new one at the next index
+func (w *WAL) rotateLocked() error {
+	if w.opts.Sync != SyncNone {
+		if err := w.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL segment: %w", err)
+		}
+	}
+	if err := w.active.Close(); err != nil {
+		return fmt.Errorf("failed to close WAL segment: %w", err)
+	}
+
+	f, err := w.openSegment(w.next)
+	if err != nil {
+		return err
+	}
+	w.active = f
+	w.dirty = false
+	w.segments = append(w.segments, segment{first: w.next})
+	return nil
+}
+
+// reclaimLocked removes the oldest segments until a record of size fits.
+// Readers dropping data on a full log are moved past a removed segment,
+// other readers that still need it make the append fail with ErrFull.
+func (w *WAL) reclaimLocked(size int64) error {
+	for w.bytes+size > w.opts.MaxBytes {
+		if len(w.segments) < 2 {
+			return ErrFull
+		}
+		end := w.segments[1].first
+		for _, r := range w.readers {
+			if r.committed < end && !r.dropOnFull {
+				return ErrFull
+			}
+		}
+
+		for _, r := range w.readers {
+			if r.committed < end {
+				log.Printf("WAL is full, dropping %d records of reader %s", end-r.committed, r.name)
+				r.dropped += end - r.committed
+				r.committed = end
+			}
+			if r.next < end {
+				r.next = end
+			}
+		}
+		if err := w.removeOldestLocked(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeConsumedLocked removes the segments every reader is past
+func (w *WAL) removeConsumedLocked() error {
+	for len(w.segments) > 1 {
+		end := w.segments[1].first
+		for _, r := range w.readers {
+			if r.committed < end {
+				return nil
+			}
+		}
+		if err := w.removeOldestLocked(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) removeOldestLocked() error {
+	oldest := w.segments[0]
+	if err := os.Remove(w.segmentPath(oldest.first)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove WAL segment: %w", err)
+	}
+	w.segments = w.segments[1:]
+	w.bytes -= oldest.size
+	return nil
+}
+
+func (w *WAL) syncLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && !w.closed {
+				if err := w.active.Sync(); err != nil {
+					log.Printf("Failed to sync WAL: %v", err)
+				} else {
+					w.dirty = false
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns the segments and indexes of the log
+func (w *WAL) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{Segments: len(w.segments), Bytes: w.bytes, FirstIndex: w.segments[0].first, NextIndex: w.next}
+}
+
+// Close syncs the log and the cursors of its readers. Blocked readers return ErrClosed.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	close(w.appended)
+
+	var errs []error
+	for _, r := range w.readers {
+		errs = append(errs, r.persistLocked())
+	}
+	if w.opts.Sync != SyncNone {
+		errs = append(errs, w.active.Sync())
+	}
+	errs = append(errs, w.active.Close())
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,396 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T, dir string, opts Options) *WAL {
+	t.Helper()
+	opts.Dir = dir
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	w, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func appendRecords(t *testing.T, w *WAL, records ...string) {
+	t.Helper()
+	for _, record := range records {
+		if _, err := w.Append([]byte(record)); err != nil {
+			t.Fatalf("Append(%q) error = %v", record, err)
+		}
+	}
+}
+
+// readAll reads the records available to a reader without waiting for appends
+func readAll(t *testing.T, r *Reader) []string {
+	t.Helper()
+	var records []string
+	for r.Lag() > uint64(len(records)) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, data, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		records = append(records, string(data))
+	}
+	return records
+}
+
+func TestWAL_AppendAndRead(t *testing.T) {
+	w := openTest(t, t.TempDir(), Options{SegmentSize: 32, MaxBytes: 1024})
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("record-%d", i))
+	}
+	appendRecords(t, w, want...)
+
+	if stats := w.Stats(); stats.Segments < 2 || stats.NextIndex != 10 {
+		t.Errorf("Stats() = %+v, want several segments and 10 records", stats)
+	}
+	if got := readAll(t, r); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+}
+
+func TestWAL_CrashRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+		want    []string
+	}{
+		{
+			name: "torn record",
+			corrupt: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The header of a 100 byte record followed by part of its payload
+				f.Write([]byte{100, 0, 0, 0, 1, 2, 3, 4, 'p', 'a', 'r'})
+				f.Close()
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "zeroed tail",
+			corrupt: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write(make([]byte, 64))
+				f.Close()
+			},
+			want: []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := Open(Options{Dir: dir, Sync: SyncAlways})
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if _, err := w.Reader("sink", false); err != nil {
+				t.Fatalf("Reader() error = %v", err)
+			}
+			appendRecords(t, w, "a", "b", "c")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			tt.corrupt(t, filepath.Join(dir, fmt.Sprintf("%020d.wal", 0)))
+
+			w = openTest(t, dir, Options{})
+			if stats := w.Stats(); stats.NextIndex != uint64(len(tt.want)) {
+				t.Fatalf("recovered %d records, want %d", stats.NextIndex, len(tt.want))
+			}
+
+			// Appends continue after the last valid record
+			appendRecords(t, w, "d")
+			r, err := w.Reader("sink", false)
+			if err != nil {
+				t.Fatalf("Reader() error = %v", err)
+			}
+			want := append(tt.want, "d")
+			if got := readAll(t, r); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("read %v after recovery, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWAL_CorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(Options{Dir: dir, SegmentSize: 16, MaxBytes: 1024, Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := w.Reader("sink", false); err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "first", "second")
+	w.Close()
+
+	path := filepath.Join(dir, fmt.Sprintf("%020d.wal", 0))
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	if _, err := Open(Options{Dir: dir, SegmentSize: 16, MaxBytes: 1024}); err == nil {
+		t.Error("Open() error = nil, want an error for a corrupt sealed segment")
+	}
+}
+
+func TestReader_Cursor(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(Options{Dir: dir, SegmentSize: 32, MaxBytes: 1024, Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "a", "b", "c", "d", "e")
+
+	for i := 0; i < 3; i++ {
+		index, _, err := r.Next(context.Background())
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if err := r.Commit(index); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	w = openTest(t, dir, Options{SegmentSize: 32, MaxBytes: 1024})
+	r, err = w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	if got := readAll(t, r); fmt.Sprint(got) != "[d e]" {
+		t.Errorf("read %v after reopening, want [d e]", got)
+	}
+
+	// A reader opened after the appends only sees later records
+	late, err := w.Reader("late", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "f")
+	if got := readAll(t, late); fmt.Sprint(got) != "[f]" {
+		t.Errorf("new reader read %v, want [f]", got)
+	}
+}
+
+func TestWAL_RemovesConsumedSegments(t *testing.T) {
+	w := openTest(t, t.TempDir(), Options{SegmentSize: 16, MaxBytes: 1024})
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "record-0", "record-1", "record-2", "record-3")
+
+	for i := 0; i < 4; i++ {
+		index, _, err := r.Next(context.Background())
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		r.Commit(index)
+	}
+	if stats := w.Stats(); stats.Segments != 1 || stats.FirstIndex != 3 {
+		t.Errorf("Stats() = %+v, want only the active segment", stats)
+	}
+}
+
+func TestWAL_SizeLimit(t *testing.T) {
+	opts := Options{SegmentSize: 20, MaxBytes: 40}
+
+	t.Run("required reader", func(t *testing.T) {
+		w := openTest(t, t.TempDir(), opts)
+		if _, err := w.Reader("cassandra", false); err != nil {
+			t.Fatalf("Reader() error = %v", err)
+		}
+		appendRecords(t, w, "0123456789", "0123456789")
+		if _, err := w.Append([]byte("0123456789")); !errors.Is(err, ErrFull) {
+			t.Errorf("Append() error = %v, want ErrFull", err)
+		}
+	})
+
+	t.Run("best-effort reader", func(t *testing.T) {
+		w := openTest(t, t.TempDir(), opts)
+		r, err := w.Reader("victoriametrics", true)
+		if err != nil {
+			t.Fatalf("Reader() error = %v", err)
+		}
+		appendRecords(t, w, "record-0..", "record-1..", "record-2..")
+
+		if got := r.Dropped(); got != 1 {
+			t.Errorf("Dropped() = %d, want 1", got)
+		}
+		if got := readAll(t, r); fmt.Sprint(got) != "[record-1.. record-2..]" {
+			t.Errorf("read %v, want the records after the dropped segment", got)
+		}
+	})
+}
+
+func TestReplay(t *testing.T) {
+	w := openTest(t, t.TempDir(), Options{})
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "a", "b", "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	failures := 2
+	var delivered []string
+	err = Replay(ctx, r, ReplayConfig{Backoff: time.Millisecond}, func(ctx context.Context, data []byte) error {
+		if string(data) == "b" && failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		delivered = append(delivered, string(data))
+		if len(delivered) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Replay() error = %v, want context.Canceled", err)
+	}
+	if fmt.Sprint(delivered) != "[a b c]" || r.Lag() != 0 {
+		t.Errorf("delivered %v with lag %d, want [a b c] in order", delivered, r.Lag())
+	}
+}
+
+func TestReplay_MaxAttempts(t *testing.T) {
+	w := openTest(t, t.TempDir(), Options{})
+	r, err := w.Reader("sink", true)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "poison", "ok")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var delivered []string
+	Replay(ctx, r, ReplayConfig{Backoff: time.Millisecond, MaxAttempts: 3}, func(ctx context.Context, data []byte) error {
+		if string(data) == "poison" {
+			return errors.New("rejected")
+		}
+		delivered = append(delivered, string(data))
+		cancel()
+		return nil
+	})
+	if fmt.Sprint(delivered) != "[ok]" || r.Dropped() != 1 {
+		t.Errorf("delivered %v with %d dropped, want [ok] and 1 dropped", delivered, r.Dropped())
+	}
+}
+
+func TestReplay_Permanent(t *testing.T) {
+	w := openTest(t, t.TempDir(), Options{})
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "poison", "ok")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	attempts := 0
+	var delivered []string
+	Replay(ctx, r, ReplayConfig{Backoff: time.Millisecond}, func(ctx context.Context, data []byte) error {
+		if string(data) == "poison" {
+			attempts++
+			return Permanent(errors.New("rejected"))
+		}
+		delivered = append(delivered, string(data))
+		cancel()
+		return nil
+	})
+	if fmt.Sprint(delivered) != "[ok]" || attempts != 1 || r.Dropped() != 1 {
+		t.Errorf("delivered %v after %d attempts with %d dropped, want [ok] after 1 attempt and 1 dropped", delivered, attempts, r.Dropped())
+	}
+}
+
+func TestReplay_ReadError(t *testing.T) {
+	dir := t.TempDir()
+	w := openTest(t, dir, Options{})
+	r, err := w.Reader("sink", false)
+	if err != nil {
+		t.Fatalf("Reader() error = %v", err)
+	}
+	appendRecords(t, w, "a")
+
+	// The segment is missing until the replay has failed to read it
+	path := w.segmentPath(w.segments[0].first)
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { os.Rename(path+".moved", path) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var delivered []string
+	err = Replay(ctx, r, ReplayConfig{Backoff: time.Millisecond}, func(ctx context.Context, data []byte) error {
+		delivered = append(delivered, string(data))
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || fmt.Sprint(delivered) != "[a]" {
+		t.Errorf("Replay() = %v with %v delivered, want context.Canceled with [a]", err, delivered)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for s, want := range map[string]SyncPolicy{"": SyncInterval, "always": SyncAlways, "none": SyncNone} {
+		if got, err := ParseSyncPolicy(s); err != nil || got != want {
+			t.Errorf("ParseSyncPolicy(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error(`ParseSyncPolicy("sometimes") error = nil, want error`)
+	}
+}